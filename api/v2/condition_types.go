@@ -79,4 +79,30 @@ const (
 	// DependencyNotReadyReason represents the fact that
 	// one of the dependencies is not ready.
 	DependencyNotReadyReason string = "DependencyNotReady"
+
+	// VerificationFailedReason represents the fact that the verification of
+	// the authenticity of the chart for the HelmRelease failed.
+	VerificationFailedReason string = "VerificationFailed"
+
+	// ClusterRolloutSucceededReason represents the fact that the Helm release
+	// is ready on all target clusters of the HelmRelease.
+	ClusterRolloutSucceededReason string = "ClusterRolloutSucceeded"
+
+	// ClusterRolloutFailedReason represents the fact that the Helm release
+	// is not ready on one or more target clusters of the HelmRelease.
+	ClusterRolloutFailedReason string = "ClusterRolloutFailed"
+
+	// LockedReason represents the fact that the Helm release for the
+	// HelmRelease is locked by another holder.
+	LockedReason string = "Locked"
+
+	// InsufficientPermissionsReason represents the fact that the Helm release
+	// for the HelmRelease cannot be installed or upgraded, because the
+	// client lacks permissions required by the release.
+	InsufficientPermissionsReason string = "InsufficientPermissions"
+
+	// ManifestTooLargeReason represents the fact that the Helm release for
+	// the HelmRelease cannot be installed or upgraded, because the rendered
+	// manifest exceeds the limits of the controller.
+	ManifestTooLargeReason string = "ManifestTooLarge"
 )
@@ -35,11 +35,17 @@ const (
 	// HelmReleaseFinalizer is set on a HelmRelease when it is first handled by
 	// the controller, and removed when this object is deleted.
 	HelmReleaseFinalizer = "finalizers.fluxcd.io"
+	// OCIArtifactKind is the chartRef kind used to pull a chart directly
+	// from an OCI registry.
+	OCIArtifactKind = "OCIArtifact"
 )
 
 const (
 	// defaultMaxHistory is the default number of Helm release versions to keep.
 	defaultMaxHistory = 5
+	// defaultHealthMonitoringGracePeriod is the default period after a
+	// release during which unhealthy objects trigger a rollback.
+	defaultHealthMonitoringGracePeriod = 10 * time.Minute
 )
 
 // Kustomize Helm PostRenderer specification.
@@ -65,6 +71,10 @@ type PostRenderer struct {
 
 // HelmReleaseSpec defines the desired state of a Helm release.
 // +kubebuilder:validation:XValidation:rule="(has(self.chart) && !has(self.chartRef)) || (!has(self.chart) && has(self.chartRef))", message="either chart or chartRef must be set"
+// +kubebuilder:validation:XValidation:rule="!(has(self.kubeConfig) && has(self.clusters))", message="kubeConfig and clusters are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="!has(self.kubeConfigProvider) || has(self.kubeConfig)", message="kubeConfig must be set when kubeConfigProvider is set"
+// +kubebuilder:validation:XValidation:rule="!(has(self.serviceAccountName) && has(self.impersonate))", message="serviceAccountName and impersonate are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="!has(self.verify) || (has(self.chartRef) && (self.chartRef.kind == 'OCIArtifact' || (self.chartRef.kind == 'OCIRepository' && self.verify.provider != 'helm')))", message="verify requires a chartRef of kind OCIArtifact, or of kind OCIRepository for the cosign and notation providers"
 type HelmReleaseSpec struct {
 	// Chart defines the template of the v1.HelmChart that should be created
 	// for this HelmRelease.
@@ -76,6 +86,12 @@ type HelmReleaseSpec struct {
 	// +optional
 	ChartRef *CrossNamespaceSourceReference `json:"chartRef,omitempty"`
 
+	// Verify contains the configuration to verify the authenticity of the
+	// loaded chart artifact by the controller itself, in addition to any
+	// verification performed by source-controller.
+	// +optional
+	Verify *ChartVerification `json:"verify,omitempty"`
+
 	// Interval at which to reconcile the Helm release.
 	// +kubebuilder:validation:Type=string
 	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
@@ -92,6 +108,32 @@ type HelmReleaseSpec struct {
 	// +optional
 	KubeConfig *meta.KubeConfigReference `json:"kubeConfig,omitempty"`
 
+	// KubeConfigProvider configures the controller to obtain short-lived
+	// credentials for the remote cluster from a provider, instead of using
+	// the static KubeConfig from the Secret referenced in KubeConfig. The
+	// Secret must then contain the PEM encoded CA certificate of the API
+	// server in the 'ca.crt' key. Requires the provider to be enabled on the
+	// controller.
+	// +optional
+	KubeConfigProvider *KubeConfigProvider `json:"kubeConfigProvider,omitempty"`
+
+	// Clusters configures the HelmRelease to be released to multiple remote
+	// clusters, running the release for each target cluster independently.
+	// Mutually exclusive with KubeConfig.
+	// +optional
+	Clusters *ClusterTargets `json:"clusters,omitempty"`
+
+	// Priority of the HelmRelease in the queue of the controller. HelmReleases
+	// with a higher priority are reconciled before those with a lower
+	// priority, for example after a restart of the controller. With fair
+	// queuing, priorities are only compared between the HelmReleases of the
+	// same tenant, unless they are equal to or higher than the critical
+	// priority configured on the controller. Defaults to 0.
+	// +kubebuilder:validation:Minimum=-1000
+	// +kubebuilder:validation:Maximum=1000
+	// +optional
+	Priority *int32 `json:"priority,omitempty"`
+
 	// Suspend tells the controller to suspend reconciliation for this HelmRelease,
 	// it does not apply to already started reconciliations. Defaults to false.
 	// +optional
@@ -146,6 +188,12 @@ type HelmReleaseSpec struct {
 	// +optional
 	ServiceAccountName string `json:"serviceAccountName,omitempty"`
 
+	// Impersonate configures the user, groups and extra fields to impersonate
+	// when reconciling this HelmRelease. The impersonation must be allowed by
+	// the controller. Mutually exclusive with ServiceAccountName.
+	// +optional
+	Impersonate *Impersonation `json:"impersonate,omitempty"`
+
 	// PersistentClient tells the controller to use a persistent Kubernetes
 	// client for this release. When enabled, the client will be reused for the
 	// duration of the reconciliation, instead of being created and destroyed
@@ -179,6 +227,19 @@ type HelmReleaseSpec struct {
 	// +optional
 	Test *Test `json:"test,omitempty"`
 
+	// HealthChecks holds a list of selectors for objects of the Helm release
+	// of which the health is assessed using kstatus after a successful Helm
+	// install or upgrade. When the selected objects do not become healthy
+	// within the timeout of the action, the release is marked as failed.
+	// +optional
+	HealthChecks []HealthCheck `json:"healthChecks,omitempty"`
+
+	// HealthMonitoring holds the configuration for continuously monitoring the
+	// health of the objects selected by HealthChecks after the release has
+	// been made.
+	// +optional
+	HealthMonitoring *HealthMonitoring `json:"healthMonitoring,omitempty"`
+
 	// Rollback holds the configuration for Helm rollback actions for this HelmRelease.
 	// +optional
 	Rollback *Rollback `json:"rollback,omitempty"`
@@ -236,6 +297,15 @@ var (
 	// DriftDetectionDisabledValue is the value used to disable the diffing of
 	// an object using DriftDetectionMetadataKey.
 	DriftDetectionDisabledValue = "disabled"
+
+	// DriftCorrectedAtAnnotation is the annotation set on an object of a
+	// Helm release when its drift has been corrected, recording the time of
+	// the last correction in RFC3339 format.
+	DriftCorrectedAtAnnotation = GroupVersion.Group + "/driftCorrectedAt"
+	// DriftCorrectedFieldsAnnotation is the annotation set on an object of a
+	// Helm release when its drift has been corrected, recording the JSON
+	// Pointer (RFC 6901) paths of the fields reverted by the last correction.
+	DriftCorrectedFieldsAnnotation = GroupVersion.Group + "/driftCorrectedFields"
 )
 
 // IgnoreRule defines a rule to selectively disregard specific changes during
@@ -254,6 +324,52 @@ type IgnoreRule struct {
 	Target *kustomize.Selector `json:"target,omitempty"`
 }
 
+// HealthCheck defines a selection of objects of the Helm release of which the
+// health is assessed.
+type HealthCheck struct {
+	// Target is a selector for specifying Kubernetes objects of the Helm
+	// release of which the health is assessed.
+	// If Target is not set, the health of all Kubernetes objects within the
+	// manifest of the Helm release is assessed.
+	// +optional
+	Target *kustomize.Selector `json:"target,omitempty"`
+}
+
+// HealthMonitoring defines the continuous monitoring of the health of the
+// objects of the Helm release.
+type HealthMonitoring struct {
+	// Enable enables the assessment of the health of the objects selected by
+	// HealthChecks on every reconciliation of an in-sync release. When any of
+	// the objects is not healthy, the HelmRelease is marked as not ready.
+	// +optional
+	Enable bool `json:"enable,omitempty"`
+
+	// Remediate instructs the controller to roll back the release to the
+	// previous healthy release when the objects are found to be unhealthy
+	// within the GracePeriod after the release was deployed. The rollback
+	// counts as an upgrade failure.
+	// +optional
+	Remediate bool `json:"remediate,omitempty"`
+
+	// GracePeriod is the period after the release was deployed during which
+	// unhealthy objects trigger a rollback when Remediate is enabled. After
+	// this period, unhealthy objects only mark the HelmRelease as not ready.
+	// Defaults to '10m0s'.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// GetGracePeriod returns the configured grace period during which unhealthy
+// objects trigger a rollback, or the default.
+func (in HealthMonitoring) GetGracePeriod() time.Duration {
+	if in.GracePeriod == nil {
+		return defaultHealthMonitoringGracePeriod
+	}
+	return in.GracePeriod.Duration
+}
+
 // DriftDetection defines the strategy for performing differential analysis and
 // provides a way to define rules for ignoring specific changes during this
 // process.
@@ -403,6 +519,114 @@ type HelmChartTemplateVerification struct {
 	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
 }
 
+// ChartVerification configures the verification of the authenticity of the
+// chart artifact loaded by the controller.
+type ChartVerification struct {
+	// Provider specifies the technology used to sign the Helm chart.
+	// The helm provider verifies the Helm provenance file of the chart, and
+	// requires the chart to be pulled using an OCIArtifact chartRef. The
+	// cosign and notation providers verify signatures stored in the OCI
+	// registry, and require an OCIArtifact or OCIRepository chartRef.
+	// Charts from a HelmChart can not be verified by the controller, as
+	// source-controller may repackage them; use the verify field of the
+	// HelmChart template instead.
+	// +kubebuilder:validation:Enum=helm;cosign;notation
+	// +kubebuilder:default:=cosign
+	Provider string `json:"provider"`
+
+	// SecretRef specifies the Kubernetes Secret in the same namespace as the
+	// HelmRelease containing the trusted keys. For the helm provider, each
+	// value must be an OpenPGP keyring. For the cosign provider, values with
+	// a '.pub' key suffix must be PEM encoded public keys. For the notation
+	// provider, the 'trustpolicy.json' value must be a Notation trust policy,
+	// and values with a '.crt' or '.pem' key suffix must be PEM encoded root
+	// certificates.
+	// +required
+	SecretRef meta.LocalObjectReference `json:"secretRef"`
+}
+
+// Impersonation defines the user, groups and extra fields to impersonate.
+type Impersonation struct {
+	// User is the name of the user to impersonate.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=253
+	// +required
+	User string `json:"user"`
+
+	// Groups are the groups to impersonate.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// Extra are the extra fields to impersonate, keyed by name.
+	// +optional
+	Extra map[string][]string `json:"extra,omitempty"`
+}
+
+// ClusterFailurePolicy defines how the rollout of a release across multiple
+// clusters proceeds after the release failed for a cluster.
+type ClusterFailurePolicy string
+
+const (
+	// StopClusterFailurePolicy stops the rollout to the remaining clusters
+	// after the release failed for a cluster.
+	StopClusterFailurePolicy ClusterFailurePolicy = "Stop"
+	// ContinueClusterFailurePolicy continues the rollout to the remaining
+	// clusters after the release failed for a cluster.
+	ContinueClusterFailurePolicy ClusterFailurePolicy = "Continue"
+)
+
+// ClusterTargets defines the remote clusters a HelmRelease is released to,
+// and how the release is rolled out across them.
+// +kubebuilder:validation:XValidation:rule="has(self.kubeConfigs) || has(self.selector)", message="either kubeConfigs or selector must be set"
+type ClusterTargets struct {
+	// KubeConfigs holds references to Secrets in the same namespace as the
+	// HelmRelease containing the KubeConfig of a target cluster. The name of
+	// the Secret is used as the name of the cluster. Only static KubeConfigs
+	// are supported, Spec.KubeConfigProvider is not.
+	// +optional
+	KubeConfigs []meta.KubeConfigReference `json:"kubeConfigs,omitempty"`
+
+	// Selector selects Secrets in the same namespace as the HelmRelease by
+	// label, each containing the KubeConfig of a target cluster in the
+	// 'value' or 'value.yaml' key. The name of the Secret is used as the name
+	// of the cluster.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// MaxConcurrency is the maximum number of clusters the release is run
+	// for concurrently. The clusters are processed in the order of
+	// KubeConfigs, followed by the clusters selected by Selector in
+	// alphabetical order. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+
+	// FailurePolicy defines whether the rollout stops or continues with the
+	// remaining clusters after the release failed for a cluster. Defaults to
+	// 'Stop'.
+	// +kubebuilder:validation:Enum=Stop;Continue
+	// +optional
+	FailurePolicy ClusterFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// GetMaxConcurrency returns the configured maximum concurrency, or the
+// default of 1.
+func (in ClusterTargets) GetMaxConcurrency() int {
+	if in.MaxConcurrency < 1 {
+		return 1
+	}
+	return in.MaxConcurrency
+}
+
+// GetFailurePolicy returns the configured failure policy, or the default
+// StopClusterFailurePolicy.
+func (in ClusterTargets) GetFailurePolicy() ClusterFailurePolicy {
+	if in.FailurePolicy == "" {
+		return StopClusterFailurePolicy
+	}
+	return in.FailurePolicy
+}
+
 // Remediation defines a consistent interface for InstallRemediation and
 // UpgradeRemediation.
 // +kubebuilder:object:generate=false
@@ -786,6 +1010,63 @@ type Test struct {
 
 	// Filters is a list of tests to run or exclude from running.
 	Filters *[]Filter `json:"filters,omitempty"`
+
+	// Selector selects the tests to run by the labels of the test hooks.
+	// It is applied in addition to the Filters.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// AnnotationSelector selects the tests to run by the annotations of the
+	// test hooks, using the label selector syntax. It is applied in addition
+	// to the Filters and Selector.
+	// +optional
+	AnnotationSelector *metav1.LabelSelector `json:"annotationSelector,omitempty"`
+
+	// Parallelism is the maximum number of test hooks run concurrently.
+	// Test hooks are still run in order of their weight, only test hooks with
+	// the same weight are run concurrently. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Parallelism int `json:"parallelism,omitempty"`
+
+	// ContinueOnFailure tells the controller to continue running the
+	// remaining test hooks after a test hook failed, instead of stopping at
+	// the first failure. This allows the outcome of every test hook to be
+	// reported.
+	// +optional
+	ContinueOnFailure bool `json:"continueOnFailure,omitempty"`
+
+	// Interval at which the Helm tests are re-run against the current
+	// release, once they have been run after an install or upgrade.
+	// When not set, the tests are only run after an install or upgrade.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// RemediateIntervalFailures tells the controller to remediate the release
+	// according to the active remediation strategy when the Helm tests re-run
+	// at the Interval fail. Defaults to false, in which case the failures are
+	// only reported.
+	// +optional
+	RemediateIntervalFailures bool `json:"remediateIntervalFailures,omitempty"`
+}
+
+// GetParallelism returns the maximum number of test hooks run concurrently.
+func (in Test) GetParallelism() int {
+	if in.Parallelism < 1 {
+		return 1
+	}
+	return in.Parallelism
+}
+
+// GetInterval returns the configured interval at which the Helm tests are
+// re-run, or 0 if not configured.
+func (in Test) GetInterval() time.Duration {
+	if in.Interval == nil {
+		return 0
+	}
+	return in.Interval.Duration
 }
 
 // GetTimeout returns the configured timeout for the Helm test action,
@@ -1023,9 +1304,69 @@ type HelmReleaseStatus struct {
 	// +optional
 	LastHandledResetAt string `json:"lastHandledResetAt,omitempty"`
 
+	// Clusters holds the release status of each target cluster when the
+	// HelmRelease is released to multiple clusters using Spec.Clusters.
+	// +optional
+	Clusters []ClusterReleaseStatus `json:"clusters,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 }
 
+// ClusterReleaseStatus defines the observed state of the release on a
+// single target cluster.
+type ClusterReleaseStatus struct {
+	// Name of the target cluster, equal to the name of the Secret containing
+	// its KubeConfig.
+	// +required
+	Name string `json:"name"`
+
+	// KubeConfig used to connect to the target cluster.
+	// +required
+	KubeConfig meta.KubeConfigReference `json:"kubeConfig"`
+
+	// Conditions holds the conditions of the release on the target cluster.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// StorageNamespace is the namespace of the Helm release storage for the
+	// current release on the target cluster.
+	// +optional
+	StorageNamespace string `json:"storageNamespace,omitempty"`
+
+	// History holds the history of Helm releases performed on the target
+	// cluster.
+	// +optional
+	History Snapshots `json:"history,omitempty"`
+
+	// LastAttemptedReleaseAction is the last release action performed on the
+	// target cluster.
+	// +optional
+	LastAttemptedReleaseAction ReleaseAction `json:"lastAttemptedReleaseAction,omitempty"`
+
+	// Failures is the reconciliation failure count on the target cluster.
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+
+	// InstallFailures is the install failure count on the target cluster.
+	// +optional
+	InstallFailures int64 `json:"installFailures,omitempty"`
+
+	// UpgradeFailures is the upgrade failure count on the target cluster.
+	// +optional
+	UpgradeFailures int64 `json:"upgradeFailures,omitempty"`
+}
+
+// GetCluster returns the release status of the target cluster with the
+// given name, or nil.
+func (in *HelmReleaseStatus) GetCluster(name string) *ClusterReleaseStatus {
+	for i := range in.Clusters {
+		if in.Clusters[i].Name == name {
+			return &in.Clusters[i]
+		}
+	}
+	return nil
+}
+
 // ClearHistory clears the History.
 func (in *HelmReleaseStatus) ClearHistory() {
 	in.History = nil
@@ -1088,6 +1429,21 @@ func (in *HelmRelease) GetDriftDetection() DriftDetection {
 	return *in.Spec.DriftDetection
 }
 
+// GetHealthMonitoring returns the configuration for monitoring the health of
+// the objects of the HelmRelease.
+func (in *HelmRelease) GetHealthMonitoring() HealthMonitoring {
+	if in.Spec.HealthMonitoring == nil {
+		return HealthMonitoring{}
+	}
+	return *in.Spec.HealthMonitoring
+}
+
+// MustMonitorHealth returns true if the health of the objects selected by the
+// health checks of the HelmRelease must be monitored on every reconciliation.
+func (in *HelmRelease) MustMonitorHealth() bool {
+	return in.GetHealthMonitoring().Enable && len(in.Spec.HealthChecks) > 0
+}
+
 // GetInstall returns the configuration for Helm install actions for the
 // HelmRelease.
 func (in *HelmRelease) GetInstall() Install {
@@ -1221,6 +1577,14 @@ func (in HelmRelease) UsePersistentClient() bool {
 	return *in.Spec.PersistentClient
 }
 
+// GetPriority returns the configured Priority, or the default of 0.
+func (in HelmRelease) GetPriority() int32 {
+	if in.Spec.Priority == nil {
+		return 0
+	}
+	return *in.Spec.Priority
+}
+
 // GetDependsOn returns the list of dependencies across-namespaces.
 func (in HelmRelease) GetDependsOn() []meta.NamespacedObjectReference {
 	return in.Spec.DependsOn
@@ -1241,6 +1605,18 @@ func (in *HelmRelease) HasChartRef() bool {
 	return in.Spec.ChartRef != nil
 }
 
+// HasClusters returns true if the HelmRelease is released to multiple
+// clusters using Spec.Clusters.
+func (in *HelmRelease) HasClusters() bool {
+	return in.Spec.Clusters != nil
+}
+
+// HasOCIArtifactRef returns true if the HelmRelease has a ChartRef of kind
+// OCIArtifact.
+func (in *HelmRelease) HasOCIArtifactRef() bool {
+	return in.HasChartRef() && in.Spec.ChartRef.Kind == OCIArtifactKind
+}
+
 // HasChartTemplate returns true if the HelmRelease has a ChartTemplate.
 func (in *HelmRelease) HasChartTemplate() bool {
 	return in.Spec.Chart != nil
@@ -16,6 +16,10 @@ limitations under the License.
 
 package v2
 
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+)
+
 // CrossNamespaceObjectReference contains enough information to let you locate
 // the typed referenced object at cluster level.
 type CrossNamespaceObjectReference struct {
@@ -44,21 +48,24 @@ type CrossNamespaceObjectReference struct {
 
 // CrossNamespaceSourceReference contains enough information to let you locate
 // the typed referenced object at cluster level.
+// +kubebuilder:validation:XValidation:rule="self.kind == 'OCIArtifact' ? has(self.url) : has(self.name)", message="url must be set for kind OCIArtifact, name must be set for other kinds"
+// +kubebuilder:validation:XValidation:rule="self.kind == 'OCIArtifact' || (!has(self.url) && !has(self.digest) && !has(self.secretRef) && !has(self.insecure))", message="url, digest, secretRef and insecure can only be set for kind OCIArtifact"
 type CrossNamespaceSourceReference struct {
 	// APIVersion of the referent.
 	// +optional
 	APIVersion string `json:"apiVersion,omitempty"`
 
-	// Kind of the referent.
-	// +kubebuilder:validation:Enum=OCIRepository;HelmChart
+	// Kind of the referent. The OCIArtifact kind does not refer to an object,
+	// but pulls the chart directly from the OCI registry configured in URL.
+	// +kubebuilder:validation:Enum=OCIRepository;HelmChart;OCIArtifact
 	// +required
 	Kind string `json:"kind"`
 
-	// Name of the referent.
+	// Name of the referent. Required for all kinds except OCIArtifact.
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:MaxLength=253
-	// +required
-	Name string `json:"name"`
+	// +optional
+	Name string `json:"name,omitempty"`
 
 	// Namespace of the referent, defaults to the namespace of the Kubernetes
 	// resource object that contains the reference.
@@ -67,6 +74,32 @@ type CrossNamespaceSourceReference struct {
 	// +kubebuilder:validation:Optional
 	// +optional
 	Namespace string `json:"namespace,omitempty"`
+
+	// URL of the chart in the OCI registry, including the tag, e.g.
+	// 'oci://ghcr.io/stefanprodan/charts/podinfo:6.5.0'.
+	// Only used for kind OCIArtifact.
+	// +kubebuilder:validation:Pattern="^oci://.*$"
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Digest of the OCI manifest to pull, e.g. 'sha256:abc...'. When set,
+	// the chart is pulled by digest and any tag in the URL is ignored.
+	// Only used for kind OCIArtifact.
+	// +kubebuilder:validation:Pattern="^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$"
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// SecretRef holds the name of a Secret of type
+	// 'kubernetes.io/dockerconfigjson' in the same namespace as the
+	// HelmRelease, containing the credentials for the OCI registry.
+	// Only used for kind OCIArtifact.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Insecure allows connecting to a non-TLS HTTP container registry.
+	// Only used for kind OCIArtifact.
+	// +optional
+	Insecure bool `json:"insecure,omitempty"`
 }
 
 // ValuesReference contains a reference to a resource containing Helm values,
@@ -113,3 +146,97 @@ func (in ValuesReference) GetValuesKey() string {
 	}
 	return in.ValuesKey
 }
+
+const (
+	// AWSKubeConfigProvider authenticates to an EKS cluster using the AWS
+	// credentials of the controller, e.g. obtained using IAM Roles for
+	// Service Accounts or EKS Pod Identity.
+	AWSKubeConfigProvider = "aws"
+	// AzureKubeConfigProvider authenticates to an AKS cluster using Azure
+	// Workload Identity of the controller.
+	AzureKubeConfigProvider = "azure"
+	// GCPKubeConfigProvider authenticates to a GKE cluster using the Google
+	// Cloud service account of the controller, e.g. obtained using GKE
+	// Workload Identity.
+	GCPKubeConfigProvider = "gcp"
+	// ExecKubeConfigProvider obtains credentials by running a client-go
+	// credential plugin.
+	ExecKubeConfigProvider = "exec"
+	// ServiceAccountKubeConfigProvider authenticates using a short-lived
+	// token of a ServiceAccount in the namespace of the HelmRelease, which
+	// the remote cluster is expected to trust.
+	ServiceAccountKubeConfigProvider = "serviceaccount"
+)
+
+// KubeConfigProvider configures how short-lived credentials for a remote
+// cluster are obtained.
+// +kubebuilder:validation:XValidation:rule="self.name != 'exec' || has(self.exec)", message="exec must be set for the exec provider"
+// +kubebuilder:validation:XValidation:rule="self.name != 'serviceaccount' || has(self.serviceAccountName)", message="serviceAccountName must be set for the serviceaccount provider"
+type KubeConfigProvider struct {
+	// Name of the provider used to authenticate to the remote cluster.
+	// +kubebuilder:validation:Enum=aws;azure;gcp;exec;serviceaccount
+	// +required
+	Name string `json:"name"`
+
+	// Address of the API server of the remote cluster, e.g.
+	// 'https://example.eks.amazonaws.com'. Must be allowed by the controller.
+	// +kubebuilder:validation:Pattern="^https://.*$"
+	// +required
+	Address string `json:"address"`
+
+	// Cluster is the provider specific identifier of the remote cluster. For
+	// the 'aws' provider, this is the name or ARN of the EKS cluster.
+	// +optional
+	Cluster string `json:"cluster,omitempty"`
+
+	// Exec configures the client-go credential plugin used by the 'exec'
+	// provider. Requires the controller to run with
+	// --insecure-kubeconfig-exec.
+	// +optional
+	Exec *KubeConfigExec `json:"exec,omitempty"`
+
+	// ServiceAccountName is the name of the ServiceAccount in the same
+	// namespace as the HelmRelease a token is requested for by the
+	// 'serviceaccount' provider.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Audiences of the ServiceAccount token requested by the
+	// 'serviceaccount' provider. Defaults to the Address. Must be allowed by
+	// the controller.
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// KubeConfigExec configures a client-go credential plugin.
+type KubeConfigExec struct {
+	// Command to execute.
+	// +required
+	Command string `json:"command"`
+
+	// Args to pass to the command.
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// Env defines additional environment variables to expose to the
+	// command.
+	// +optional
+	Env []KubeConfigExecEnvVar `json:"env,omitempty"`
+
+	// APIVersion of the ExecCredential returned by the command. Defaults to
+	// 'client.authentication.k8s.io/v1'.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+}
+
+// KubeConfigExecEnvVar is an environment variable exposed to a credential
+// plugin.
+type KubeConfigExecEnvVar struct {
+	// Name of the environment variable.
+	// +required
+	Name string `json:"name"`
+
+	// Value of the environment variable.
+	// +required
+	Value string `json:"value"`
+}
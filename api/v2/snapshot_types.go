@@ -65,38 +65,64 @@ func (in Snapshots) Latest() *Snapshot {
 // Unless ignoreTests is true, Snapshots with a test in the "Failed" phase are
 // ignored.
 func (in Snapshots) Previous(ignoreTests bool) *Snapshot {
+	if i := in.previous(ignoreTests, false); i > 0 {
+		return in[i]
+	}
+	return nil
+}
+
+// PreviousHealthy returns the most recent Snapshot before the Latest in the
+// same way as Previous, but also ignores Snapshots which were observed to be
+// Unhealthy.
+func (in Snapshots) PreviousHealthy(ignoreTests bool) *Snapshot {
+	if i := in.previous(ignoreTests, true); i > 0 {
+		return in[i]
+	}
+	return nil
+}
+
+// previous sorts the Snapshots by version, and returns the index of the most
+// recent Snapshot before the Latest that has a status of "deployed" or
+// "superseded". Unless ignoreTests is true, Snapshots with a test in the
+// "Failed" phase are ignored. If healthy is true, Unhealthy Snapshots are
+// ignored. It returns -1 if there is no such Snapshot.
+func (in Snapshots) previous(ignoreTests, healthy bool) int {
 	if len(in) < 2 {
-		return nil
+		return -1
 	}
 	in.SortByVersion()
 	for i := range in[1:] {
 		s := in[i+1]
-		if s.Status == snapshotStatusDeployed || s.Status == snapshotStatusSuperseded {
-			if ignoreTests || !s.HasTestInPhase(snapshotTestPhaseFailed) {
-				return s
-			}
+		if s.Status != snapshotStatusDeployed && s.Status != snapshotStatusSuperseded {
+			continue
+		}
+		if !ignoreTests && s.HasTestInPhase(snapshotTestPhaseFailed) {
+			continue
 		}
+		if healthy && s.Unhealthy {
+			continue
+		}
+		return i + 1
 	}
-	return nil
+	return -1
 }
 
-// Truncate removes all Snapshots up to the Previous deployed Snapshot.
-// If there is no previous-deployed Snapshot, the most recent 5 Snapshots are
-// retained.
+// Truncate removes all Snapshots up to the Previous deployed Snapshot, or up
+// to the PreviousHealthy Snapshot if there is one, to retain the Snapshot an
+// unhealthy release can be rolled back to. If there is no previous-deployed
+// Snapshot, the most recent 5 Snapshots are retained.
 func (in *Snapshots) Truncate(ignoreTests bool) {
 	if in.Len() < 2 {
 		return
 	}
 
-	in.SortByVersion()
-	for i := range (*in)[1:] {
-		s := (*in)[i+1]
-		if s.Status == snapshotStatusDeployed || s.Status == snapshotStatusSuperseded {
-			if ignoreTests || !s.HasTestInPhase(snapshotTestPhaseFailed) {
-				*in = (*in)[:i+2]
-				return
-			}
-		}
+	i := in.previous(ignoreTests, true)
+	if i < 0 {
+		i = in.previous(ignoreTests, false)
+	}
+	if i > 0 {
+		*in = (*in)[:i+1]
+		return
 	}
 
 	if in.Len() > defaultMaxHistory {
@@ -162,6 +188,17 @@ type Snapshot struct {
 	// OCIDigest is the digest of the OCI artifact associated with the release.
 	// +optional
 	OCIDigest string `json:"ociDigest,omitempty"`
+	// ValuesDelta holds the changes of the values of the release compared to
+	// the release it superseded. It is only recorded when enabled on the
+	// controller.
+	// +optional
+	ValuesDelta *ValuesDelta `json:"valuesDelta,omitempty"`
+	// Unhealthy is true if the health monitoring of the HelmRelease found
+	// objects of the release to be unhealthy the last time it checked them
+	// while the release was the latest release. An unhealthy release is not
+	// rolled back to when remediating another unhealthy release.
+	// +optional
+	Unhealthy bool `json:"unhealthy,omitempty"`
 }
 
 // FullReleaseName returns the full name of the release in the format
@@ -196,6 +233,38 @@ func (in *Snapshot) GetTestHooks() map[string]*TestHookStatus {
 	return *in.TestHooks
 }
 
+// HasBeenRetested returns true if any of the TestHooks has previous runs,
+// which indicates the tests have been re-run after the release was first
+// tested.
+func (in *Snapshot) HasBeenRetested() bool {
+	for _, h := range in.GetTestHooks() {
+		if h != nil && len(h.PreviousRuns) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LastTestCompleted returns the most recent time any of the TestHooks was
+// observed to complete, or to start if it did not complete. It returns a zero
+// time if no test hook has been run.
+func (in *Snapshot) LastTestCompleted() metav1.Time {
+	var last metav1.Time
+	for _, h := range in.GetTestHooks() {
+		if h == nil {
+			continue
+		}
+		t := h.LastCompleted
+		if t.IsZero() {
+			t = h.LastStarted
+		}
+		if last.Before(&t) {
+			last = t
+		}
+	}
+	return last
+}
+
 // HasTestInPhase returns true if any of the TestHooks is in the given phase.
 func (in *Snapshot) HasTestInPhase(phase string) bool {
 	if in != nil {
@@ -236,4 +305,66 @@ type TestHookStatus struct {
 	// Phase the test hook was observed to be in.
 	// +optional
 	Phase string `json:"phase,omitempty"`
+	// LogsRef is the reference to the logs of the test hook Pod, collected
+	// by the controller when it completed.
+	// +optional
+	LogsRef *TestLogsReference `json:"logsRef,omitempty"`
+	// PreviousRuns is a rolling history of a limited number of previous
+	// runs of the test hook, most recent first. It is populated when the
+	// tests are re-run at the test interval.
+	// +optional
+	PreviousRuns []TestHookRun `json:"previousRuns,omitempty"`
+}
+
+// TestHookRun holds the result of a previous run of a test hook.
+type TestHookRun struct {
+	// Started is the time the test hook was started.
+	// +optional
+	Started metav1.Time `json:"started,omitempty"`
+	// Completed is the time the test hook completed.
+	// +optional
+	Completed metav1.Time `json:"completed,omitempty"`
+	// Phase the test hook was observed to be in.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+}
+
+// TestLogsReference contains a reference to the key of a ConfigMap or Secret
+// in the Helm storage namespace, holding the logs of a test hook Pod.
+type TestLogsReference struct {
+	// Kind of the referent, either ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	// +required
+	Kind string `json:"kind"`
+	// Namespace of the referent.
+	// +required
+	Namespace string `json:"namespace"`
+	// Name of the referent.
+	// +required
+	Name string `json:"name"`
+	// Key of the logs in the data of the referent.
+	// +required
+	Key string `json:"key"`
+	// Truncated is true if the logs exceeded the maximum size and only the
+	// tail of the logs was stored.
+	// +optional
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ValuesDelta holds the changes of the values of a release compared to the
+// values of a previous release, with sensitive values redacted.
+type ValuesDelta struct {
+	// PreviousVersion is the version of the release the values are compared
+	// to.
+	// +required
+	PreviousVersion int `json:"previousVersion"`
+	// Changes are the values which were added (+), changed (~) or removed
+	// (-), formatted as '<type> <path>: <value>'. Sensitive values are
+	// replaced with '(redacted)'.
+	// +optional
+	Changes []string `json:"changes,omitempty"`
+	// Truncated is true if not all changes are recorded, due to the limits
+	// on the number of changes and their length.
+	// +optional
+	Truncated bool `json:"truncated,omitempty"`
 }
@@ -19,6 +19,9 @@ package v2
 import (
 	"reflect"
 	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestSnapshots_Sort(t *testing.T) {
@@ -167,6 +170,24 @@ func TestSnapshots_Previous(t *testing.T) {
 	}
 }
 
+func TestSnapshots_PreviousHealthy(t *testing.T) {
+	in := Snapshots{
+		{Version: 4, Status: "deployed", Unhealthy: true},
+		{Version: 3, Status: "superseded", Unhealthy: true},
+		{Version: 2, Status: "superseded"},
+		{Version: 1, Status: "superseded"},
+	}
+	if got := in.PreviousHealthy(false); !reflect.DeepEqual(got, &Snapshot{Version: 2, Status: "superseded"}) {
+		t.Errorf("PreviousHealthy() = %v, want version 2", got)
+	}
+	if got := in.Previous(false); !reflect.DeepEqual(got, &Snapshot{Version: 3, Status: "superseded", Unhealthy: true}) {
+		t.Errorf("Previous() = %v, want version 3", got)
+	}
+	if got := in[:2].PreviousHealthy(false); got != nil {
+		t.Errorf("PreviousHealthy() = %v, want nil", got)
+	}
+}
+
 func TestSnapshots_Truncate(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -188,6 +209,32 @@ func TestSnapshots_Truncate(t *testing.T) {
 				{Version: 2, Status: "superseded"},
 			},
 		},
+		{
+			name: "keeps previous healthy snapshot",
+			in: Snapshots{
+				{Version: 1, Status: "superseded"},
+				{Version: 2, Status: "superseded"},
+				{Version: 3, Status: "superseded", Unhealthy: true},
+				{Version: 4, Status: "deployed"},
+			},
+			want: Snapshots{
+				{Version: 4, Status: "deployed"},
+				{Version: 3, Status: "superseded", Unhealthy: true},
+				{Version: 2, Status: "superseded"},
+			},
+		},
+		{
+			name: "keeps previous snapshot without healthy snapshot",
+			in: Snapshots{
+				{Version: 1, Status: "superseded", Unhealthy: true},
+				{Version: 2, Status: "superseded", Unhealthy: true},
+				{Version: 3, Status: "deployed"},
+			},
+			want: Snapshots{
+				{Version: 3, Status: "deployed"},
+				{Version: 2, Status: "superseded", Unhealthy: true},
+			},
+		},
 		{
 			name: "ignores snapshots with failed tests",
 			in: Snapshots{
@@ -296,3 +343,57 @@ func TestSnapshots_Truncate(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapshot_HasBeenRetested(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *Snapshot
+		want bool
+	}{
+		{name: "nil", in: nil, want: false},
+		{name: "untested", in: &Snapshot{}, want: false},
+		{name: "tested", in: &Snapshot{TestHooks: &map[string]*TestHookStatus{
+			"test": {Phase: "Succeeded"},
+			"nil":  nil,
+		}}, want: false},
+		{name: "retested", in: &Snapshot{TestHooks: &map[string]*TestHookStatus{
+			"test":  {Phase: "Succeeded"},
+			"other": {Phase: "Failed", PreviousRuns: []TestHookRun{{Phase: "Succeeded"}}},
+		}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.HasBeenRetested(); got != tt.want {
+				t.Errorf("HasBeenRetested() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshot_LastTestCompleted(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name string
+		in   *Snapshot
+		want metav1.Time
+	}{
+		{name: "nil", in: nil, want: metav1.Time{}},
+		{name: "no hooks", in: &Snapshot{TestHooks: &map[string]*TestHookStatus{}}, want: metav1.Time{}},
+		{name: "most recent completion", in: &Snapshot{TestHooks: &map[string]*TestHookStatus{
+			"a": {LastStarted: metav1.NewTime(now.Add(-3 * time.Minute)), LastCompleted: metav1.NewTime(now.Add(-2 * time.Minute))},
+			"b": {LastStarted: metav1.NewTime(now.Add(-2 * time.Minute)), LastCompleted: metav1.NewTime(now.Add(-time.Minute))},
+			"c": nil,
+		}}, want: metav1.NewTime(now.Add(-time.Minute))},
+		{name: "started without completion", in: &Snapshot{TestHooks: &map[string]*TestHookStatus{
+			"a": {LastStarted: metav1.NewTime(now.Add(-3 * time.Minute)), LastCompleted: metav1.NewTime(now.Add(-2 * time.Minute))},
+			"b": {LastStarted: metav1.NewTime(now)},
+		}}, want: metav1.NewTime(now)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.LastTestCompleted(); !got.Equal(&tt.want) {
+				t.Errorf("LastTestCompleted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -28,6 +28,81 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartVerification) DeepCopyInto(out *ChartVerification) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartVerification.
+func (in *ChartVerification) DeepCopy() *ChartVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterReleaseStatus) DeepCopyInto(out *ClusterReleaseStatus) {
+	*out = *in
+	out.KubeConfig = in.KubeConfig
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make(Snapshots, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(Snapshot)
+				(*in).DeepCopyInto(*out)
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterReleaseStatus.
+func (in *ClusterReleaseStatus) DeepCopy() *ClusterReleaseStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterReleaseStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterTargets) DeepCopyInto(out *ClusterTargets) {
+	*out = *in
+	if in.KubeConfigs != nil {
+		in, out := &in.KubeConfigs, &out.KubeConfigs
+		*out = make([]meta.KubeConfigReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterTargets.
+func (in *ClusterTargets) DeepCopy() *ClusterTargets {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterTargets)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CrossNamespaceObjectReference) DeepCopyInto(out *CrossNamespaceObjectReference) {
 	*out = *in
@@ -46,6 +121,11 @@ func (in *CrossNamespaceObjectReference) DeepCopy() *CrossNamespaceObjectReferen
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CrossNamespaceSourceReference) DeepCopyInto(out *CrossNamespaceSourceReference) {
 	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CrossNamespaceSourceReference.
@@ -95,6 +175,46 @@ func (in *Filter) DeepCopy() *Filter {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheck) DeepCopyInto(out *HealthCheck) {
+	*out = *in
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(kustomize.Selector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheck.
+func (in *HealthCheck) DeepCopy() *HealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthMonitoring) DeepCopyInto(out *HealthMonitoring) {
+	*out = *in
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthMonitoring.
+func (in *HealthMonitoring) DeepCopy() *HealthMonitoring {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthMonitoring)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HelmChartTemplate) DeepCopyInto(out *HelmChartTemplate) {
 	*out = *in
@@ -266,6 +386,11 @@ func (in *HelmReleaseSpec) DeepCopyInto(out *HelmReleaseSpec) {
 	if in.ChartRef != nil {
 		in, out := &in.ChartRef, &out.ChartRef
 		*out = new(CrossNamespaceSourceReference)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Verify != nil {
+		in, out := &in.Verify, &out.Verify
+		*out = new(ChartVerification)
 		**out = **in
 	}
 	out.Interval = in.Interval
@@ -274,6 +399,21 @@ func (in *HelmReleaseSpec) DeepCopyInto(out *HelmReleaseSpec) {
 		*out = new(meta.KubeConfigReference)
 		**out = **in
 	}
+	if in.KubeConfigProvider != nil {
+		in, out := &in.KubeConfigProvider, &out.KubeConfigProvider
+		*out = new(KubeConfigProvider)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = new(ClusterTargets)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int32)
+		**out = **in
+	}
 	if in.DependsOn != nil {
 		in, out := &in.DependsOn, &out.DependsOn
 		*out = make([]meta.NamespacedObjectReference, len(*in))
@@ -289,6 +429,11 @@ func (in *HelmReleaseSpec) DeepCopyInto(out *HelmReleaseSpec) {
 		*out = new(int)
 		**out = **in
 	}
+	if in.Impersonate != nil {
+		in, out := &in.Impersonate, &out.Impersonate
+		*out = new(Impersonation)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.PersistentClient != nil {
 		in, out := &in.PersistentClient, &out.PersistentClient
 		*out = new(bool)
@@ -314,6 +459,18 @@ func (in *HelmReleaseSpec) DeepCopyInto(out *HelmReleaseSpec) {
 		*out = new(Test)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.HealthChecks != nil {
+		in, out := &in.HealthChecks, &out.HealthChecks
+		*out = make([]HealthCheck, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HealthMonitoring != nil {
+		in, out := &in.HealthMonitoring, &out.HealthMonitoring
+		*out = new(HealthMonitoring)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Rollback != nil {
 		in, out := &in.Rollback, &out.Rollback
 		*out = new(Rollback)
@@ -374,6 +531,13 @@ func (in *HelmReleaseStatus) DeepCopyInto(out *HelmReleaseStatus) {
 			}
 		}
 	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]ClusterReleaseStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	out.ReconcileRequestStatus = in.ReconcileRequestStatus
 }
 
@@ -412,6 +576,42 @@ func (in *IgnoreRule) DeepCopy() *IgnoreRule {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Impersonation) DeepCopyInto(out *Impersonation) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Extra != nil {
+		in, out := &in.Extra, &out.Extra
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Impersonation.
+func (in *Impersonation) DeepCopy() *Impersonation {
+	if in == nil {
+		return nil
+	}
+	out := new(Impersonation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Install) DeepCopyInto(out *Install) {
 	*out = *in
@@ -462,6 +662,71 @@ func (in *InstallRemediation) DeepCopy() *InstallRemediation {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeConfigExec) DeepCopyInto(out *KubeConfigExec) {
+	*out = *in
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]KubeConfigExecEnvVar, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeConfigExec.
+func (in *KubeConfigExec) DeepCopy() *KubeConfigExec {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeConfigExec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeConfigExecEnvVar) DeepCopyInto(out *KubeConfigExecEnvVar) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeConfigExecEnvVar.
+func (in *KubeConfigExecEnvVar) DeepCopy() *KubeConfigExecEnvVar {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeConfigExecEnvVar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeConfigProvider) DeepCopyInto(out *KubeConfigProvider) {
+	*out = *in
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(KubeConfigExec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeConfigProvider.
+func (in *KubeConfigProvider) DeepCopy() *KubeConfigProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeConfigProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Kustomize) DeepCopyInto(out *Kustomize) {
 	*out = *in
@@ -555,6 +820,11 @@ func (in *Snapshot) DeepCopyInto(out *Snapshot) {
 			}
 		}
 	}
+	if in.ValuesDelta != nil {
+		in, out := &in.ValuesDelta, &out.ValuesDelta
+		*out = new(ValuesDelta)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Snapshot.
@@ -609,6 +879,21 @@ func (in *Test) DeepCopyInto(out *Test) {
 			copy(*out, *in)
 		}
 	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AnnotationSelector != nil {
+		in, out := &in.AnnotationSelector, &out.AnnotationSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Test.
@@ -621,11 +906,40 @@ func (in *Test) DeepCopy() *Test {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TestHookRun) DeepCopyInto(out *TestHookRun) {
+	*out = *in
+	in.Started.DeepCopyInto(&out.Started)
+	in.Completed.DeepCopyInto(&out.Completed)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestHookRun.
+func (in *TestHookRun) DeepCopy() *TestHookRun {
+	if in == nil {
+		return nil
+	}
+	out := new(TestHookRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestHookStatus) DeepCopyInto(out *TestHookStatus) {
 	*out = *in
 	in.LastStarted.DeepCopyInto(&out.LastStarted)
 	in.LastCompleted.DeepCopyInto(&out.LastCompleted)
+	if in.LogsRef != nil {
+		in, out := &in.LogsRef, &out.LogsRef
+		*out = new(TestLogsReference)
+		**out = **in
+	}
+	if in.PreviousRuns != nil {
+		in, out := &in.PreviousRuns, &out.PreviousRuns
+		*out = make([]TestHookRun, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestHookStatus.
@@ -638,6 +952,21 @@ func (in *TestHookStatus) DeepCopy() *TestHookStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TestLogsReference) DeepCopyInto(out *TestLogsReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestLogsReference.
+func (in *TestLogsReference) DeepCopy() *TestLogsReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TestLogsReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Uninstall) DeepCopyInto(out *Uninstall) {
 	*out = *in
@@ -718,6 +1047,26 @@ func (in *UpgradeRemediation) DeepCopy() *UpgradeRemediation {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValuesDelta) DeepCopyInto(out *ValuesDelta) {
+	*out = *in
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValuesDelta.
+func (in *ValuesDelta) DeepCopy() *ValuesDelta {
+	if in == nil {
+		return nil
+	}
+	out := new(ValuesDelta)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ValuesReference) DeepCopyInto(out *ValuesReference) {
 	*out = *in
@@ -216,7 +216,7 @@ func (in *HelmReleaseSpec) DeepCopyInto(out *HelmReleaseSpec) {
 	if in.ChartRef != nil {
 		in, out := &in.ChartRef, &out.ChartRef
 		*out = new(v2.CrossNamespaceSourceReference)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	out.Interval = in.Interval
 	if in.KubeConfig != nil {
@@ -18,6 +18,9 @@ package acl
 
 import (
 	"fmt"
+	"path"
+	"slices"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -29,8 +32,41 @@ var (
 	// AllowCrossNamespaceRef is a global flag that can be used to allow
 	// cross-namespace references.
 	AllowCrossNamespaceRef = false
+
+	// AllowedImpersonationUsers holds the patterns of users a HelmRelease is
+	// allowed to impersonate.
+	AllowedImpersonationUsers []string
+	// AllowedImpersonationGroups holds the patterns of groups a HelmRelease
+	// is allowed to impersonate.
+	AllowedImpersonationGroups []string
+	// AllowedImpersonationExtraKeys holds the patterns of extra fields a
+	// HelmRelease is allowed to impersonate, in the format
+	// 'keyPattern=valuePattern'. A pattern without a value pattern allows any
+	// value for the matching keys.
+	AllowedImpersonationExtraKeys []string
+
+	// AllowedKubeConfigProviders holds the names of the KubeConfig providers
+	// a HelmRelease is allowed to use. When empty, no provider is allowed.
+	AllowedKubeConfigProviders []string
+	// AllowedKubeConfigAddresses holds the patterns of API server addresses
+	// a HelmRelease is allowed to obtain provider credentials for.
+	AllowedKubeConfigAddresses []string
+	// AllowedKubeConfigClusters holds the patterns of provider specific
+	// cluster identifiers a HelmRelease is allowed to obtain provider
+	// credentials for.
+	AllowedKubeConfigClusters []string
+	// AllowedKubeConfigAudiences holds the patterns of audiences a
+	// HelmRelease is allowed to request ServiceAccount tokens for.
+	AllowedKubeConfigAudiences []string
+	// ControllerServiceAccount is the ServiceAccount of the controller, for
+	// which ServiceAccount tokens are never requested.
+	ControllerServiceAccount types.NamespacedName
 )
 
+// NamespacePlaceholder is replaced with the namespace of the object in the
+// impersonation allowlist patterns.
+const NamespacePlaceholder = "{namespace}"
+
 // AllowsAccessTo returns an error if the object does not allow access to the
 // given reference.
 func AllowsAccessTo(obj client.Object, kind string, ref types.NamespacedName) error {
@@ -41,3 +77,100 @@ func AllowsAccessTo(obj client.Object, kind string, ref types.NamespacedName) er
 	}
 	return nil
 }
+
+// AllowsImpersonation returns an error if the object is not allowed to
+// impersonate the given user, groups and extra fields. A value is allowed
+// if it matches any of the respective allowlist patterns, which use the
+// syntax of path.Match and may contain the NamespacePlaceholder. Every
+// value of an extra field must be allowed for its key.
+func AllowsImpersonation(obj client.Object, user string, groups []string, extra map[string][]string) error {
+	if !matchesAny(obj, AllowedImpersonationUsers, user) {
+		return acl.AccessDeniedError(fmt.Sprintf("impersonation of user '%s' is not allowed", user))
+	}
+	for _, g := range groups {
+		if !matchesAny(obj, AllowedImpersonationGroups, g) {
+			return acl.AccessDeniedError(fmt.Sprintf("impersonation of group '%s' is not allowed", g))
+		}
+	}
+	for k, values := range extra {
+		if !matchesAnyExtra(obj, k, "", false) {
+			return acl.AccessDeniedError(fmt.Sprintf("impersonation of extra field '%s' is not allowed", k))
+		}
+		for _, v := range values {
+			if !matchesAnyExtra(obj, k, v, true) {
+				return acl.AccessDeniedError(fmt.Sprintf("impersonation of extra field '%s' with value '%s' is not allowed", k, v))
+			}
+		}
+	}
+	return nil
+}
+
+// AllowsKubeConfigProvider returns an error if the object is not allowed
+// to obtain credentials from the given KubeConfig provider for the given
+// API server address and provider specific cluster identifier. The address
+// and cluster are allowed if they match any of the respective allowlist
+// patterns, which use the syntax of path.Match and may contain the
+// NamespacePlaceholder. An empty cluster is always allowed.
+func AllowsKubeConfigProvider(obj client.Object, provider, address, cluster string) error {
+	if !slices.Contains(AllowedKubeConfigProviders, provider) {
+		return acl.AccessDeniedError(fmt.Sprintf("KubeConfig provider '%s' is not allowed", provider))
+	}
+	if !matchesAny(obj, AllowedKubeConfigAddresses, address) {
+		return acl.AccessDeniedError(fmt.Sprintf("KubeConfig address '%s' is not allowed", address))
+	}
+	if cluster != "" && !matchesAny(obj, AllowedKubeConfigClusters, cluster) {
+		return acl.AccessDeniedError(fmt.Sprintf("KubeConfig cluster '%s' is not allowed", cluster))
+	}
+	return nil
+}
+
+// AllowsServiceAccountToken returns an error if the object is not allowed
+// to request a token for the given ServiceAccount with the given audiences.
+// Tokens are never allowed for the ControllerServiceAccount, and the
+// audiences must match any of the AllowedKubeConfigAudiences patterns.
+func AllowsServiceAccountToken(obj client.Object, serviceAccount types.NamespacedName, audiences []string) error {
+	if ControllerServiceAccount.Name != "" && serviceAccount == ControllerServiceAccount {
+		return acl.AccessDeniedError(fmt.Sprintf("tokens for the controller ServiceAccount '%s' are not allowed", serviceAccount))
+	}
+	for _, a := range audiences {
+		if !matchesAny(obj, AllowedKubeConfigAudiences, a) {
+			return acl.AccessDeniedError(fmt.Sprintf("ServiceAccount token audience '%s' is not allowed", a))
+		}
+	}
+	return nil
+}
+
+// matchesAny returns true if the value matches any of the patterns, after
+// replacing the NamespacePlaceholder with the namespace of the object.
+func matchesAny(obj client.Object, patterns []string, value string) bool {
+	for _, p := range patterns {
+		if matches(obj, p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyExtra returns true if the extra field key matches the key
+// pattern of any of the AllowedImpersonationExtraKeys, and if checkValue is
+// true, the value matches the value pattern of the same entry.
+func matchesAnyExtra(obj client.Object, key, value string, checkValue bool) bool {
+	for _, p := range AllowedImpersonationExtraKeys {
+		keyPattern, valuePattern, hasValue := strings.Cut(p, "=")
+		if !matches(obj, keyPattern, key) {
+			continue
+		}
+		if !checkValue || !hasValue || matches(obj, valuePattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if the value matches the pattern, after replacing
+// the NamespacePlaceholder with the namespace of the object.
+func matches(obj client.Object, pattern, value string) bool {
+	pattern = strings.ReplaceAll(pattern, NamespacePlaceholder, obj.GetNamespace())
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
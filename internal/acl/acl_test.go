@@ -23,6 +23,8 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/fluxcd/pkg/runtime/acl"
+
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 )
 
@@ -93,3 +95,271 @@ func TestAllowsAccessTo(t *testing.T) {
 		})
 	}
 }
+
+func TestAllowsImpersonation(t *testing.T) {
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-name",
+			Namespace: "team-a",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		users   []string
+		groups  []string
+		extra   []string
+		user    string
+		uGroups []string
+		uExtra  map[string][]string
+		wantErr string
+	}{
+		{
+			name:    "disallow without allowlist",
+			user:    "jane",
+			wantErr: "impersonation of user 'jane' is not allowed",
+		},
+		{
+			name:  "allow user matching pattern",
+			users: []string{"tenant:*"},
+			user:  "tenant:jane",
+		},
+		{
+			name:    "allow user and groups in namespace",
+			users:   []string{"{namespace}:deployer"},
+			groups:  []string{"{namespace}:*", "system:authenticated"},
+			user:    "team-a:deployer",
+			uGroups: []string{"team-a:admins", "system:authenticated"},
+		},
+		{
+			name:    "disallow user of other namespace",
+			users:   []string{"{namespace}:deployer"},
+			user:    "team-b:deployer",
+			wantErr: "impersonation of user 'team-b:deployer' is not allowed",
+		},
+		{
+			name:    "disallow group",
+			users:   []string{"*"},
+			groups:  []string{"{namespace}:*"},
+			user:    "jane",
+			uGroups: []string{"team-a:admins", "system:masters"},
+			wantErr: "impersonation of group 'system:masters' is not allowed",
+		},
+		{
+			name:   "allow extra key",
+			users:  []string{"*"},
+			extra:  []string{"scopes"},
+			user:   "jane",
+			uExtra: map[string][]string{"scopes": {"view"}},
+		},
+		{
+			name:    "disallow extra key",
+			users:   []string{"*"},
+			user:    "jane",
+			uExtra:  map[string][]string{"scopes": {"view"}},
+			wantErr: "impersonation of extra field 'scopes' is not allowed",
+		},
+		{
+			name:   "allow extra values matching pattern",
+			users:  []string{"*"},
+			extra:  []string{"scopes=view", "scopes=deploy:{namespace}"},
+			user:   "jane",
+			uExtra: map[string][]string{"scopes": {"view", "deploy:team-a"}},
+		},
+		{
+			name:    "disallow extra value",
+			users:   []string{"*"},
+			extra:   []string{"scopes=view", "scopes=deploy:{namespace}"},
+			user:    "jane",
+			uExtra:  map[string][]string{"scopes": {"view", "deploy:team-b"}},
+			wantErr: "impersonation of extra field 'scopes' with value 'deploy:team-b' is not allowed",
+		},
+		{
+			name:   "allow any extra value without value pattern",
+			users:  []string{"*"},
+			extra:  []string{"scopes"},
+			user:   "jane",
+			uExtra: map[string][]string{"scopes": {"view", "admin"}},
+		},
+		{
+			name:    "disallow extra key with value pattern of other key",
+			users:   []string{"*"},
+			extra:   []string{"scopes=view"},
+			user:    "jane",
+			uExtra:  map[string][]string{"reason": {"view"}},
+			wantErr: "impersonation of extra field 'reason' is not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curUsers, curGroups, curExtra := AllowedImpersonationUsers, AllowedImpersonationGroups, AllowedImpersonationExtraKeys
+			AllowedImpersonationUsers, AllowedImpersonationGroups, AllowedImpersonationExtraKeys = tt.users, tt.groups, tt.extra
+			t.Cleanup(func() {
+				AllowedImpersonationUsers, AllowedImpersonationGroups, AllowedImpersonationExtraKeys = curUsers, curGroups, curExtra
+			})
+
+			err := AllowsImpersonation(obj, tt.user, tt.uGroups, tt.uExtra)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("AllowsImpersonation() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("AllowsImpersonation() error = %v, want %q", err, tt.wantErr)
+			}
+			if !acl.IsAccessDenied(err) {
+				t.Errorf("AllowsImpersonation() error = %v, want AccessDenied error", err)
+			}
+		})
+	}
+}
+
+func TestAllowsKubeConfigProvider(t *testing.T) {
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-name",
+			Namespace: "team-a",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		providers []string
+		addresses []string
+		clusters  []string
+		provider  string
+		address   string
+		cluster   string
+		wantErr   string
+	}{
+		{
+			name:     "disallow without allowlist",
+			provider: "gcp",
+			address:  "https://10.0.0.1",
+			wantErr:  "KubeConfig provider 'gcp' is not allowed",
+		},
+		{
+			name:      "allow provider and address",
+			providers: []string{"gcp"},
+			addresses: []string{"https://10.0.0.*"},
+			provider:  "gcp",
+			address:   "https://10.0.0.1",
+		},
+		{
+			name:      "disallow address",
+			providers: []string{"gcp"},
+			addresses: []string{"https://10.0.0.*"},
+			provider:  "gcp",
+			address:   "https://kubernetes.default.svc",
+			wantErr:   "KubeConfig address 'https://kubernetes.default.svc' is not allowed",
+		},
+		{
+			name:      "allow cluster in namespace",
+			providers: []string{"aws"},
+			addresses: []string{"https://*.eks.amazonaws.com"},
+			clusters:  []string{"{namespace}-*"},
+			provider:  "aws",
+			address:   "https://example.eks.amazonaws.com",
+			cluster:   "team-a-prod",
+		},
+		{
+			name:      "disallow cluster",
+			providers: []string{"aws"},
+			addresses: []string{"https://*.eks.amazonaws.com"},
+			clusters:  []string{"{namespace}-*"},
+			provider:  "aws",
+			address:   "https://example.eks.amazonaws.com",
+			cluster:   "team-b-prod",
+			wantErr:   "KubeConfig cluster 'team-b-prod' is not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curProviders, curAddresses, curClusters := AllowedKubeConfigProviders, AllowedKubeConfigAddresses, AllowedKubeConfigClusters
+			AllowedKubeConfigProviders, AllowedKubeConfigAddresses, AllowedKubeConfigClusters = tt.providers, tt.addresses, tt.clusters
+			t.Cleanup(func() {
+				AllowedKubeConfigProviders, AllowedKubeConfigAddresses, AllowedKubeConfigClusters = curProviders, curAddresses, curClusters
+			})
+
+			err := AllowsKubeConfigProvider(obj, tt.provider, tt.address, tt.cluster)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("AllowsKubeConfigProvider() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("AllowsKubeConfigProvider() error = %v, want %q", err, tt.wantErr)
+			}
+			if !acl.IsAccessDenied(err) {
+				t.Errorf("AllowsKubeConfigProvider() error = %v, want AccessDenied error", err)
+			}
+		})
+	}
+}
+
+func TestAllowsServiceAccountToken(t *testing.T) {
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-name",
+			Namespace: "team-a",
+		},
+	}
+
+	tests := []struct {
+		name           string
+		audiences      []string
+		serviceAccount types.NamespacedName
+		tAudiences     []string
+		wantErr        string
+	}{
+		{
+			name:           "allow audiences matching pattern",
+			audiences:      []string{"https://*.example.com"},
+			serviceAccount: types.NamespacedName{Namespace: "team-a", Name: "deployer"},
+			tAudiences:     []string{"https://remote.example.com"},
+		},
+		{
+			name:           "disallow audience",
+			audiences:      []string{"https://*.example.com"},
+			serviceAccount: types.NamespacedName{Namespace: "team-a", Name: "deployer"},
+			tAudiences:     []string{"https://kubernetes.default.svc"},
+			wantErr:        "ServiceAccount token audience 'https://kubernetes.default.svc' is not allowed",
+		},
+		{
+			name:           "disallow controller ServiceAccount",
+			audiences:      []string{"*"},
+			serviceAccount: types.NamespacedName{Namespace: "flux-system", Name: "helm-controller"},
+			tAudiences:     []string{"https://remote.example.com"},
+			wantErr:        "tokens for the controller ServiceAccount 'flux-system/helm-controller' are not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curAudiences, curSA := AllowedKubeConfigAudiences, ControllerServiceAccount
+			AllowedKubeConfigAudiences = tt.audiences
+			ControllerServiceAccount = types.NamespacedName{Namespace: "flux-system", Name: "helm-controller"}
+			t.Cleanup(func() {
+				AllowedKubeConfigAudiences, ControllerServiceAccount = curAudiences, curSA
+			})
+
+			err := AllowsServiceAccountToken(obj, tt.serviceAccount, tt.tAudiences)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("AllowsServiceAccountToken() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("AllowsServiceAccountToken() error = %v, want %q", err, tt.wantErr)
+			}
+			if !acl.IsAccessDenied(err) {
+				t.Errorf("AllowsServiceAccountToken() error = %v, want AccessDenied error", err)
+			}
+		})
+	}
+}
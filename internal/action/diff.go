@@ -23,12 +23,15 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	helmaction "helm.sh/helm/v3/pkg/action"
 	helmrelease "helm.sh/helm/v3/pkg/release"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	apierrutil "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
@@ -65,37 +68,15 @@ func Diff(ctx context.Context, config *helmaction.Configuration, rls *helmreleas
 		return nil, fmt.Errorf("failed to normalize release objects: %w", err)
 	}
 
-	var (
-		isNamespacedGVK = map[string]bool{}
-		errs            []error
-	)
 	for _, obj := range objects {
 		// Set the Helm metadata on the object which is normally set by Helm
 		// during object creation.
 		setHelmMetadata(obj, rls)
-
-		// Set the namespace of the object if it is not set.
-		if obj.GetNamespace() == "" {
-			// Manifest does not contain the namespace of the release.
-			// Figure out if the object is namespaced if the namespace is not
-			// explicitly set, and configure the namespace accordingly.
-			objGVK := obj.GetObjectKind().GroupVersionKind().String()
-			if _, ok := isNamespacedGVK[objGVK]; !ok {
-				namespaced, err := apiutil.IsObjectNamespaced(obj, c.Scheme(), c.RESTMapper())
-				if err != nil {
-					errs = append(errs, fmt.Errorf("failed to determine if %s is namespace scoped: %w",
-						obj.GetObjectKind().GroupVersionKind().Kind, err))
-					continue
-				}
-				// Cache the result, so we don't have to do this for every object
-				isNamespacedGVK[objGVK] = namespaced
-			}
-			if isNamespacedGVK[objGVK] {
-				obj.SetNamespace(rls.Namespace)
-			}
-		}
 	}
 
+	// Set the namespace of the objects if it is not set.
+	errs := setDefaultNamespace(c, objects, rls.Namespace)
+
 	// Base configuration for the diffing of the object.
 	diffOpts := []jsondiff.ListOption{
 		jsondiff.FieldOwner(fieldOwner),
@@ -136,8 +117,16 @@ func Diff(ctx context.Context, config *helmaction.Configuration, rls *helmreleas
 }
 
 // ApplyDiff applies the changes described in the provided jsondiff.DiffSet to
-// the Kubernetes cluster.
-func ApplyDiff(ctx context.Context, config *helmaction.Configuration, diffSet jsondiff.DiffSet, fieldOwner string) (*ssa.ChangeSet, error) {
+// the Kubernetes cluster. When configured using WithCorrectionRecord, the
+// correction is recorded on each corrected object using annotations and a
+// Kubernetes Event.
+func ApplyDiff(ctx context.Context, config *helmaction.Configuration, diffSet jsondiff.DiffSet, fieldOwner string, opts ...ApplyDiffOption) (*ssa.ChangeSet, error) {
+	o := &applyDiffOptions{now: time.Now}
+	for _, opt := range opts {
+		opt(o)
+	}
+	now := o.now()
+
 	cfg, err := config.RESTClientGetter.ToRESTConfig()
 	if err != nil {
 		return nil, err
@@ -147,6 +136,11 @@ func ApplyDiff(ctx context.Context, config *helmaction.Configuration, diffSet js
 		return nil, err
 	}
 
+	var recorder record.EventRecorder
+	if o.recordCorrections() {
+		recorder = correctionRecorder(ctx, config, fieldOwner)
+	}
+
 	var toCreate, toPatch sortableDiffs
 	for _, d := range diffSet {
 		switch d.Type {
@@ -165,16 +159,27 @@ func ApplyDiff(ctx context.Context, config *helmaction.Configuration, diffSet js
 	sort.Sort(toCreate)
 	for _, d := range toCreate {
 		obj := d.DesiredObject.DeepCopyObject().(client.Object)
+		if o.recordCorrections() {
+			setCorrectionAnnotations(obj, correctionAnnotations(now, nil))
+		}
 		if err := c.Create(ctx, obj, client.FieldOwner(fieldOwner)); err != nil {
 			errs = append(errs, fmt.Errorf("%s creation failure: %w", diff.ResourceName(obj), err))
 			continue
 		}
 		changeSet.Add(objectToChangeSetEntry(obj, ssa.CreatedAction))
+		if o.recordCorrections() {
+			recordCorrectionEvent(recorder, obj, correctionEventMessage(o.release, fieldOwner, nil))
+		}
 	}
 
 	sort.Sort(toPatch)
 	for _, d := range toPatch {
-		data, err := json.Marshal(d.Patch)
+		p, fields := d.Patch, correctedFields(d.Patch)
+		if o.recordCorrections() {
+			p = withCorrectionAnnotations(d.ClusterObject, p, correctionAnnotations(now, fields))
+		}
+
+		data, err := json.Marshal(p)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("%s patch failure: %w", diff.ResourceName(d.DesiredObject), err))
 			continue
@@ -190,11 +195,48 @@ func ApplyDiff(ctx context.Context, config *helmaction.Configuration, diffSet js
 			continue
 		}
 		changeSet.Add(objectToChangeSetEntry(obj, ssa.ConfiguredAction))
+		if o.recordCorrections() {
+			recordCorrectionEvent(recorder, obj, correctionEventMessage(o.release, fieldOwner, fields))
+		}
 	}
 
 	return changeSet, apierrutil.NewAggregate(errs)
 }
 
+// setDefaultNamespace sets the given namespace on the objects which do not
+// have a namespace set, but are namespace scoped. It returns an error for
+// every object of which the scope could not be determined.
+func setDefaultNamespace(c client.Client, objects []*unstructured.Unstructured, namespace string) []error {
+	var (
+		isNamespacedGVK = map[string]bool{}
+		errs            []error
+	)
+	for _, obj := range objects {
+		if obj.GetNamespace() != "" {
+			continue
+		}
+
+		// Manifest does not contain the namespace of the release.
+		// Figure out if the object is namespaced if the namespace is not
+		// explicitly set, and configure the namespace accordingly.
+		objGVK := obj.GetObjectKind().GroupVersionKind().String()
+		if _, ok := isNamespacedGVK[objGVK]; !ok {
+			namespaced, err := apiutil.IsObjectNamespaced(obj, c.Scheme(), c.RESTMapper())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to determine if %s is namespace scoped: %w",
+					obj.GetObjectKind().GroupVersionKind().Kind, err))
+				continue
+			}
+			// Cache the result, so we don't have to do this for every object
+			isNamespacedGVK[objGVK] = namespaced
+		}
+		if isNamespacedGVK[objGVK] {
+			obj.SetNamespace(namespace)
+		}
+	}
+	return errs
+}
+
 const (
 	appManagedByLabel              = "app.kubernetes.io/managed-by"
 	appManagedByHelm               = "Helm"
@@ -0,0 +1,221 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	extjsondiff "github.com/wI2L/jsondiff"
+	helmaction "helm.sh/helm/v3/pkg/action"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+const (
+	// maxCorrectedFields is the maximum number of reverted fields recorded
+	// on an object, to keep the annotation and Event message within bounds.
+	maxCorrectedFields = 25
+
+	// annotationsPath is the JSON Pointer path to the annotations of an
+	// object.
+	annotationsPath = "/metadata/annotations"
+)
+
+// ApplyDiffOption is an option for ApplyDiff.
+type ApplyDiffOption func(opts *applyDiffOptions)
+
+// applyDiffOptions holds the options for ApplyDiff.
+type applyDiffOptions struct {
+	// release is the name of the Helm release the corrected objects belong
+	// to. When set, the correction is recorded on each corrected object.
+	release string
+	// now returns the time used to record the correction.
+	now func() time.Time
+}
+
+// WithCorrectionRecord configures ApplyDiff to record the correction on each
+// object it corrects. The object is annotated with v2.DriftCorrectedAtAnnotation
+// and v2.DriftCorrectedFieldsAnnotation, and a Kubernetes Event mentioning the
+// given Helm release name is emitted on the object.
+func WithCorrectionRecord(release string) ApplyDiffOption {
+	return func(opts *applyDiffOptions) {
+		opts.release = release
+	}
+}
+
+// recordCorrections returns true if the correction should be recorded on the
+// corrected objects.
+func (o *applyDiffOptions) recordCorrections() bool {
+	return o.release != ""
+}
+
+// correctedFields returns the unique JSON Pointer paths of the fields changed
+// by the given patch, in the order they appear in the patch.
+func correctedFields(patch extjsondiff.Patch) []string {
+	var fields []string
+	seen := make(map[string]struct{}, len(patch))
+	for _, op := range patch {
+		if _, ok := seen[op.Path]; ok {
+			continue
+		}
+		seen[op.Path] = struct{}{}
+		fields = append(fields, op.Path)
+	}
+	return fields
+}
+
+// formatCorrectedFields formats the given fields as a comma-separated list,
+// truncating it to maxCorrectedFields entries.
+func formatCorrectedFields(fields []string) string {
+	if len(fields) <= maxCorrectedFields {
+		return strings.Join(fields, ",")
+	}
+	return fmt.Sprintf("%s,... (%d more)", strings.Join(fields[:maxCorrectedFields], ","),
+		len(fields)-maxCorrectedFields)
+}
+
+// correctionAnnotations returns the annotations recording a correction at the
+// given time, which reverted the given fields.
+func correctionAnnotations(now time.Time, fields []string) map[string]string {
+	annotations := map[string]string{
+		v2.DriftCorrectedAtAnnotation: now.UTC().Format(time.RFC3339),
+	}
+	if len(fields) > 0 {
+		annotations[v2.DriftCorrectedFieldsAnnotation] = formatCorrectedFields(fields)
+	}
+	return annotations
+}
+
+// setCorrectionAnnotations sets the given annotations on the object.
+func setCorrectionAnnotations(obj client.Object, annotations map[string]string) {
+	a := obj.GetAnnotations()
+	if a == nil {
+		a = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		a[k] = v
+	}
+	obj.SetAnnotations(a)
+}
+
+// withCorrectionAnnotations returns a copy of the given patch with operations
+// appended to set the given annotations on the patched object. The cluster
+// object is used to determine if the object has annotations the operations
+// can be added to, or if the annotations must be added as a whole.
+func withCorrectionAnnotations(cluster client.Object, patch extjsondiff.Patch, annotations map[string]string) extjsondiff.Patch {
+	hasAnnotations := cluster != nil && cluster.GetAnnotations() != nil
+	for _, op := range patch {
+		if op.Path != annotationsPath {
+			continue
+		}
+		switch op.Type {
+		case extjsondiff.OperationAdd, extjsondiff.OperationReplace:
+			hasAnnotations = true
+		case extjsondiff.OperationRemove:
+			hasAnnotations = false
+		}
+	}
+
+	patch = slices.Clone(patch)
+	if !hasAnnotations {
+		value := make(map[string]interface{}, len(annotations))
+		for k, v := range annotations {
+			value[k] = v
+		}
+		return append(patch, extjsondiff.Operation{
+			Type:  extjsondiff.OperationAdd,
+			Path:  annotationsPath,
+			Value: value,
+		})
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		patch = append(patch, extjsondiff.Operation{
+			Type:  extjsondiff.OperationAdd,
+			Path:  annotationsPath + "/" + escapePointerToken(k),
+			Value: annotations[k],
+		})
+	}
+	return patch
+}
+
+// escapePointerToken escapes the given JSON Pointer reference token according
+// to RFC 6901.
+func escapePointerToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// correctionEventMessage returns the message of the Event emitted on an object
+// after its drift has been corrected.
+func correctionEventMessage(release, fieldOwner string, fields []string) string {
+	if len(fields) == 0 {
+		return fmt.Sprintf("Object recreated by %s to correct drift of Helm release %s", fieldOwner, release)
+	}
+	return fmt.Sprintf("Drift of Helm release %s corrected by %s, reverted fields: %s", release, fieldOwner,
+		formatCorrectedFields(fields))
+}
+
+// eventRecorderGetter is implemented by RESTClientGetters which provide an
+// EventRecorder for the cluster they target, such as
+// kube.MemoryRESTClientGetter.
+type eventRecorderGetter interface {
+	ToEventRecorder(component string) (record.EventRecorder, error)
+}
+
+// correctionRecorder returns the EventRecorder to emit the Events of
+// corrected objects with, as the field owner. The EventRecorder is obtained
+// from the RESTClientGetter of the configuration, to emit the Events in the
+// cluster of the objects, and to aggregate similar Events over subsequent
+// corrections. It returns nil if no EventRecorder is available, in which case
+// the failure is logged and no Events are emitted.
+func correctionRecorder(ctx context.Context, config *helmaction.Configuration, fieldOwner string) record.EventRecorder {
+	getter, ok := config.RESTClientGetter.(eventRecorderGetter)
+	if !ok {
+		ctrl.LoggerFrom(ctx).Error(fmt.Errorf("unsupported RESTClientGetter %T", config.RESTClientGetter),
+			"unable to emit drift correction events")
+		return nil
+	}
+	recorder, err := getter.ToEventRecorder(fieldOwner)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to emit drift correction events")
+		return nil
+	}
+	return recorder
+}
+
+// recordCorrectionEvent emits a Kubernetes Event with the given message on
+// the corrected object, if an EventRecorder is available.
+func recordCorrectionEvent(recorder record.EventRecorder, obj client.Object, message string) {
+	if recorder == nil {
+		return
+	}
+	recorder.Event(obj, corev1.EventTypeNormal, "DriftCorrected", message)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	extjsondiff "github.com/wI2L/jsondiff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+func Test_correctedFields(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(correctedFields(nil)).To(BeEmpty())
+	g.Expect(correctedFields(extjsondiff.Patch{
+		{Type: extjsondiff.OperationReplace, Path: "/spec/replicas"},
+		{Type: extjsondiff.OperationRemove, Path: "/metadata/labels/foo"},
+		{Type: extjsondiff.OperationReplace, Path: "/spec/replicas"},
+	})).To(Equal([]string{"/spec/replicas", "/metadata/labels/foo"}))
+}
+
+func Test_formatCorrectedFields(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(formatCorrectedFields([]string{"/a", "/b"})).To(Equal("/a,/b"))
+
+	var fields []string
+	for i := 0; i < maxCorrectedFields+2; i++ {
+		fields = append(fields, fmt.Sprintf("/%d", i))
+	}
+	got := formatCorrectedFields(fields)
+	g.Expect(got).To(HavePrefix("/0,/1,"))
+	g.Expect(got).To(HaveSuffix(fmt.Sprintf("/%d,... (2 more)", maxCorrectedFields-1)))
+	g.Expect(strings.Count(got, ",")).To(Equal(maxCorrectedFields))
+}
+
+func Test_correctionAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	g.Expect(correctionAnnotations(now, nil)).To(Equal(map[string]string{
+		v2.DriftCorrectedAtAnnotation: "2024-05-01T10:30:00Z",
+	}))
+	g.Expect(correctionAnnotations(now, []string{"/spec/replicas", "/data/key"})).To(Equal(map[string]string{
+		v2.DriftCorrectedAtAnnotation:     "2024-05-01T10:30:00Z",
+		v2.DriftCorrectedFieldsAnnotation: "/spec/replicas,/data/key",
+	}))
+}
+
+func Test_withCorrectionAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		v2.DriftCorrectedAtAnnotation:     "2024-05-01T10:30:00Z",
+		v2.DriftCorrectedFieldsAnnotation: "/data/key",
+	}
+	replaceData := extjsondiff.Operation{
+		Type:  extjsondiff.OperationReplace,
+		Path:  "/data/key",
+		Value: "value",
+	}
+
+	tests := []struct {
+		name    string
+		cluster *unstructured.Unstructured
+		patch   extjsondiff.Patch
+		want    extjsondiff.Patch
+	}{
+		{
+			name: "adds annotations to existing annotations",
+			cluster: &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{"foo": "bar"},
+				},
+			}},
+			patch: extjsondiff.Patch{replaceData},
+			want: extjsondiff.Patch{
+				replaceData,
+				{
+					Type:  extjsondiff.OperationAdd,
+					Path:  "/metadata/annotations/helm.toolkit.fluxcd.io~1driftCorrectedAt",
+					Value: "2024-05-01T10:30:00Z",
+				},
+				{
+					Type:  extjsondiff.OperationAdd,
+					Path:  "/metadata/annotations/helm.toolkit.fluxcd.io~1driftCorrectedFields",
+					Value: "/data/key",
+				},
+			},
+		},
+		{
+			name:    "adds annotations as a whole to object without annotations",
+			cluster: &unstructured.Unstructured{Object: map[string]interface{}{}},
+			patch:   extjsondiff.Patch{replaceData},
+			want: extjsondiff.Patch{
+				replaceData,
+				{
+					Type: extjsondiff.OperationAdd,
+					Path: "/metadata/annotations",
+					Value: map[string]interface{}{
+						v2.DriftCorrectedAtAnnotation:     "2024-05-01T10:30:00Z",
+						v2.DriftCorrectedFieldsAnnotation: "/data/key",
+					},
+				},
+			},
+		},
+		{
+			name:    "adds annotations to annotations added by patch",
+			cluster: &unstructured.Unstructured{Object: map[string]interface{}{}},
+			patch: extjsondiff.Patch{
+				{
+					Type:  extjsondiff.OperationAdd,
+					Path:  "/metadata/annotations",
+					Value: map[string]interface{}{"foo": "bar"},
+				},
+			},
+			want: extjsondiff.Patch{
+				{
+					Type:  extjsondiff.OperationAdd,
+					Path:  "/metadata/annotations",
+					Value: map[string]interface{}{"foo": "bar"},
+				},
+				{
+					Type:  extjsondiff.OperationAdd,
+					Path:  "/metadata/annotations/helm.toolkit.fluxcd.io~1driftCorrectedAt",
+					Value: "2024-05-01T10:30:00Z",
+				},
+				{
+					Type:  extjsondiff.OperationAdd,
+					Path:  "/metadata/annotations/helm.toolkit.fluxcd.io~1driftCorrectedFields",
+					Value: "/data/key",
+				},
+			},
+		},
+		{
+			name: "adds annotations as a whole after annotations removed by patch",
+			cluster: &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{"foo": "bar"},
+				},
+			}},
+			patch: extjsondiff.Patch{
+				{Type: extjsondiff.OperationRemove, Path: "/metadata/annotations"},
+			},
+			want: extjsondiff.Patch{
+				{Type: extjsondiff.OperationRemove, Path: "/metadata/annotations"},
+				{
+					Type: extjsondiff.OperationAdd,
+					Path: "/metadata/annotations",
+					Value: map[string]interface{}{
+						v2.DriftCorrectedAtAnnotation:     "2024-05-01T10:30:00Z",
+						v2.DriftCorrectedFieldsAnnotation: "/data/key",
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			orig := append(extjsondiff.Patch{}, tt.patch...)
+			got := withCorrectionAnnotations(tt.cluster, tt.patch, annotations)
+			g.Expect(got).To(Equal(tt.want))
+			g.Expect(tt.patch).To(Equal(orig))
+
+			_, err := json.Marshal(got)
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func Test_correctionEventMessage(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(correctionEventMessage("default/podinfo.v2", "helm-controller", nil)).To(Equal(
+		"Object recreated by helm-controller to correct drift of Helm release default/podinfo.v2"))
+	g.Expect(correctionEventMessage("default/podinfo.v2", "helm-controller", []string{"/spec/replicas"})).To(Equal(
+		"Drift of Helm release default/podinfo.v2 corrected by helm-controller, reverted fields: /spec/replicas"))
+}
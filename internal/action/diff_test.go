@@ -470,7 +470,9 @@ func TestApplyDiff(t *testing.T) {
 	})
 
 	// Construct a REST client getter for Helm's action configuration.
-	getter := kube.NewMemoryRESTClientGetter(config)
+	recorders := kube.NewEventRecorderCache(0)
+	t.Cleanup(recorders.Clear)
+	getter := kube.NewMemoryRESTClientGetter(config, kube.WithEventRecorderCache(recorders))
 
 	// Construct a client for to be able to mutate the cluster.
 	c, err := client.New(config, client.Options{})
@@ -483,6 +485,7 @@ func TestApplyDiff(t *testing.T) {
 	tests := []struct {
 		name    string
 		diffSet func(namespace string) jsondiff.DiffSet
+		opts    []ApplyDiffOption
 		expect  func(g *GomegaWithT, namespace string, got *ssa.ChangeSet, err error)
 	}{
 		{
@@ -753,6 +756,108 @@ func TestApplyDiff(t *testing.T) {
 				g.Expect(got.Entries).To(HaveLen(2))
 			},
 		},
+		{
+			name: "records corrections on objects",
+			diffSet: func(namespace string) jsondiff.DiffSet {
+				return jsondiff.DiffSet{
+					{
+						Type: jsondiff.DiffTypeCreate,
+						DesiredObject: &unstructured.Unstructured{
+							Object: map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "Secret",
+								"metadata": map[string]interface{}{
+									"name":      "test-secret",
+									"namespace": namespace,
+								},
+								"stringData": map[string]interface{}{
+									"key": "value",
+								},
+							},
+						},
+					},
+					{
+						Type: jsondiff.DiffTypeUpdate,
+						DesiredObject: &unstructured.Unstructured{
+							Object: map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name":      "test-cm",
+									"namespace": namespace,
+								},
+								"data": map[string]interface{}{
+									"key": "value",
+								},
+							},
+						},
+						ClusterObject: &unstructured.Unstructured{
+							Object: map[string]interface{}{
+								"apiVersion": "v1",
+								"kind":       "ConfigMap",
+								"metadata": map[string]interface{}{
+									"name":      "test-cm",
+									"namespace": namespace,
+								},
+								"data": map[string]interface{}{
+									"key": "changed",
+								},
+							},
+						},
+						Patch: extjsondiff.Patch{
+							{
+								Type:  extjsondiff.OperationReplace,
+								Path:  "/data/key",
+								Value: "value",
+							},
+						},
+					},
+				}
+			},
+			opts: []ApplyDiffOption{WithCorrectionRecord("test/release.v1")},
+			expect: func(g *GomegaWithT, namespace string, got *ssa.ChangeSet, err error) {
+				g.THelper()
+
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(got.Entries).To(HaveLen(2))
+
+				secret := &corev1.Secret{}
+				g.Expect(c.Get(context.TODO(), types.NamespacedName{
+					Namespace: namespace,
+					Name:      "test-secret",
+				}, secret)).To(Succeed())
+				g.Expect(secret.GetAnnotations()).To(HaveKey(v2.DriftCorrectedAtAnnotation))
+				g.Expect(secret.GetAnnotations()).ToNot(HaveKey(v2.DriftCorrectedFieldsAnnotation))
+
+				cm := &corev1.ConfigMap{}
+				g.Expect(c.Get(context.TODO(), types.NamespacedName{
+					Namespace: namespace,
+					Name:      "test-cm",
+				}, cm)).To(Succeed())
+				g.Expect(cm.Data).To(HaveKeyWithValue("key", "value"))
+				g.Expect(cm.GetAnnotations()).To(HaveKey(v2.DriftCorrectedAtAnnotation))
+				g.Expect(cm.GetAnnotations()).To(HaveKeyWithValue(v2.DriftCorrectedFieldsAnnotation, "/data/key"))
+
+				// Events are emitted asynchronously by the EventRecorder.
+				events := &corev1.EventList{}
+				g.Eventually(func() []corev1.Event {
+					g.Expect(c.List(context.TODO(), events, client.InNamespace(namespace))).To(Succeed())
+					return events.Items
+				}, 5*time.Second).Should(HaveLen(2))
+				for _, e := range events.Items {
+					g.Expect(e.Reason).To(Equal("DriftCorrected"))
+					g.Expect(e.Source.Component).To(Equal(testOwner))
+					g.Expect(e.Message).To(ContainSubstring("test/release.v1"))
+					switch e.InvolvedObject.Kind {
+					case "Secret":
+						g.Expect(e.InvolvedObject.UID).To(Equal(secret.UID))
+					case "ConfigMap":
+						g.Expect(e.InvolvedObject.UID).To(Equal(cm.UID))
+						g.Expect(e.Message).To(ContainSubstring("/data/key"))
+					}
+				}
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -786,7 +891,7 @@ func TestApplyDiff(t *testing.T) {
 				}
 			}
 
-			got, err := ApplyDiff(context.Background(), &helmaction.Configuration{RESTClientGetter: getter}, diff, testOwner)
+			got, err := ApplyDiff(context.Background(), &helmaction.Configuration{RESTClientGetter: getter}, diff, testOwner, tt.opts...)
 			tt.expect(g, ns.Name, got, err)
 		})
 	}
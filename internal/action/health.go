@@ -0,0 +1,287 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	apierrutil "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/aggregator"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/clusterreader"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/collector"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/event"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/statusreaders"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/status"
+	"github.com/fluxcd/cli-utils/pkg/object"
+	"github.com/fluxcd/pkg/ssa/jsondiff"
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// healthCheckInterval is the interval at which the status of the objects
+// selected by the health checks is polled.
+const healthCheckInterval = 2 * time.Second
+
+// assessHealth assesses the health of the objects of the given release which
+// are selected by the health checks of the v2.HelmRelease, using kstatus. It
+// returns an error listing the objects which did not become healthy within
+// the timeout, or which failed early. It stops waiting when the context is
+// done, returning its error.
+func assessHealth(ctx context.Context, config *helmaction.Configuration, obj *v2.HelmRelease, rls *helmrelease.Release, timeout time.Duration) error {
+	if len(obj.Spec.HealthChecks) == 0 || rls == nil {
+		return nil
+	}
+
+	c, set, err := healthCheckClientAndObjects(config, obj, rls)
+	if err != nil || len(set) == 0 {
+		return err
+	}
+
+	poller := polling.NewStatusPoller(c, c.RESTMapper(), polling.Options{})
+	if err = waitForSet(ctx, poller, set, timeout); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	return nil
+}
+
+// waitForSet polls the status of the objects in the set until they are all
+// healthy, any of them failed, the timeout expired or the context is done.
+// It mirrors ssa.ResourceManager.WaitForSet with FailFast enabled, which
+// does not accept a context.
+func waitForSet(ctx context.Context, poller *polling.StatusPoller, set object.ObjMetadataSet, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	statusCollector := collector.NewResourceStatusCollector(set)
+	lastStatus := make(map[object.ObjMetadata]*event.ResourceStatus)
+	events := poller.Poll(waitCtx, set, polling.PollOptions{PollInterval: healthCheckInterval})
+	done := statusCollector.ListenWithObserver(events, collector.ObserverFunc(
+		func(statusCollector *collector.ResourceStatusCollector, _ event.Event) {
+			var rss []*event.ResourceStatus
+			var failed bool
+			for _, rs := range statusCollector.ResourceStatuses {
+				if rs == nil {
+					continue
+				}
+				// Skip the errors kstatus emits for every object once the
+				// polling stops.
+				if !errors.Is(rs.Error, context.DeadlineExceeded) && !errors.Is(rs.Error, context.Canceled) {
+					lastStatus[rs.Identifier] = rs
+				}
+				failed = failed || rs.Status == status.FailedStatus
+				rss = append(rss, rs)
+			}
+			if failed || aggregator.AggregateStatus(rss, status.CurrentStatus) == status.CurrentStatus {
+				cancel()
+			}
+		}),
+	)
+	<-done
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if statusCollector.Error != nil {
+		return statusCollector.Error
+	}
+
+	timedOut := errors.Is(waitCtx.Err(), context.DeadlineExceeded)
+	var errs []string
+	for _, id := range set {
+		rs := lastStatus[id]
+		switch {
+		case rs == nil:
+			errs = append(errs, fmt.Sprintf("can't determine status for %s", ssautil.FmtObjMetadata(id)))
+		case rs.Status == status.FailedStatus, timedOut && rs.Status != status.CurrentStatus:
+			msg := fmt.Sprintf("%s status: '%s'", ssautil.FmtObjMetadata(id), rs.Status)
+			if rs.Error != nil {
+				msg += ": " + rs.Error.Error()
+			}
+			errs = append(errs, msg)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	msg := "failed early due to stalled resources"
+	if timedOut {
+		msg = "timeout waiting for"
+	}
+	return fmt.Errorf("%s: [%s]", msg, strings.Join(errs, ", "))
+}
+
+// CheckHealth checks the current health of the objects of the given release
+// which are selected by the health checks of the v2.HelmRelease, using
+// kstatus. Unlike the assessment after an install or upgrade, it does not
+// wait for the objects to become healthy. The objects are read with a LIST
+// request per kind and namespace, instead of a request per object. It
+// returns a sorted description of every object which is not healthy, or an
+// error if the health could not be checked.
+func CheckHealth(ctx context.Context, config *helmaction.Configuration, obj *v2.HelmRelease, rls *helmrelease.Release) ([]string, error) {
+	if len(obj.Spec.HealthChecks) == 0 || rls == nil {
+		return nil, nil
+	}
+
+	c, set, err := healthCheckClientAndObjects(config, obj, rls)
+	if err != nil || len(set) == 0 {
+		return nil, err
+	}
+
+	reader, err := clusterreader.NewCachingClusterReader(c, c.RESTMapper(), set)
+	if err != nil {
+		return nil, err
+	}
+	if err = reader.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read objects: %w", err)
+	}
+
+	statusReader := statusreaders.NewDefaultStatusReader(c.RESTMapper())
+	var unhealthy []string
+	for _, id := range set {
+		rs, err := statusReader.ReadStatus(ctx, reader, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read status of %s: %w", ssautil.FmtObjMetadata(id), err)
+		}
+		if rs.Status == status.CurrentStatus {
+			continue
+		}
+		// Objects of an unknown kind are reported as unhealthy, any other
+		// error to read them prevents the health from being determined.
+		if rs.Error != nil && !apimeta.IsNoMatchError(rs.Error) {
+			return nil, fmt.Errorf("failed to get %s: %w", ssautil.FmtObjMetadata(id), rs.Error)
+		}
+
+		msg := fmt.Sprintf("%s status: '%s'", ssautil.FmtObjMetadata(id), rs.Status)
+		switch {
+		case rs.Error != nil:
+			msg += ": " + rs.Error.Error()
+		case rs.Status != status.NotFoundStatus && rs.Message != "":
+			msg += ": " + rs.Message
+		}
+		unhealthy = append(unhealthy, msg)
+	}
+	sort.Strings(unhealthy)
+	return unhealthy, nil
+}
+
+// healthCheckClientAndObjects returns a client for the cluster of the given
+// configuration, and the metadata of the objects of the given release which
+// are selected by the health checks of the v2.HelmRelease. The client uses
+// the REST mapper of the configuration, to reuse the API resources already
+// discovered for the cluster.
+func healthCheckClientAndObjects(config *helmaction.Configuration, obj *v2.HelmRelease, rls *helmrelease.Release) (client.Client, object.ObjMetadataSet, error) {
+	cfg, err := config.RESTClientGetter.ToRESTConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	mapper, err := config.RESTClientGetter.ToRESTMapper()
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := client.New(cfg, client.Options{Mapper: mapper})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set, err := healthCheckObjects(c, obj.Spec.HealthChecks, rls)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select objects for health check: %w", err)
+	}
+	return c, set, nil
+}
+
+// healthCheckObjects returns the metadata of the objects from the manifest of
+// the given release which are selected by any of the given health checks.
+func healthCheckObjects(c client.Client, checks []v2.HealthCheck, rls *helmrelease.Release) (object.ObjMetadataSet, error) {
+	selectors := make([]*jsondiff.SelectorRegex, 0, len(checks))
+	for _, check := range checks {
+		if check.Target == nil {
+			// Select all objects.
+			selectors = nil
+			break
+		}
+		sr, err := jsondiff.NewSelectorRegex(&jsondiff.Selector{
+			Group:              check.Target.Group,
+			Version:            check.Target.Version,
+			Kind:               check.Target.Kind,
+			Name:               check.Target.Name,
+			Namespace:          check.Target.Namespace,
+			AnnotationSelector: check.Target.AnnotationSelector,
+			LabelSelector:      check.Target.LabelSelector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check target: %w", err)
+		}
+		selectors = append(selectors, sr)
+	}
+
+	objects, err := ssautil.ReadObjects(strings.NewReader(rls.Manifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read objects from release manifest: %w", err)
+	}
+	if errs := setDefaultNamespace(c, objects, rls.Namespace); len(errs) > 0 {
+		return nil, apierrutil.Reduce(apierrutil.Flatten(apierrutil.NewAggregate(errs)))
+	}
+
+	var set object.ObjMetadataSet
+	for _, obj := range objects {
+		if !matchesAny(selectors, obj) {
+			continue
+		}
+		set = append(set, object.UnstructuredToObjMetadata(obj))
+	}
+	return set, nil
+}
+
+// matchesAny returns true if the object matches any of the given selectors,
+// or if no selectors are given.
+func matchesAny(selectors []*jsondiff.SelectorRegex, obj *unstructured.Unstructured) bool {
+	if len(selectors) == 0 {
+		return true
+	}
+	for _, sr := range selectors {
+		if sr.MatchUnstructured(obj) {
+			return true
+		}
+	}
+	return false
+}
+
+// failRelease marks the given release as failed in the Helm storage of the
+// configuration, in the same way Helm does when waiting for the objects of a
+// release fails. It returns the given error, extended with any error which
+// occurred while updating the storage.
+func failRelease(config *helmaction.Configuration, rls *helmrelease.Release, err error) error {
+	rls.SetStatus(helmrelease.StatusFailed, fmt.Sprintf("Release %q failed: %s", rls.Name, err.Error()))
+	if uErr := config.Releases.Update(rls); uErr != nil {
+		return fmt.Errorf("%w: failed to mark release as failed: %s", err, uErr.Error())
+	}
+	return err
+}
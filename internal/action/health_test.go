@@ -0,0 +1,364 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	helmstorage "helm.sh/helm/v3/pkg/storage"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/clusterreader"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling/engine"
+	"github.com/fluxcd/cli-utils/pkg/object"
+	"github.com/fluxcd/pkg/apis/kustomize"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/kube"
+)
+
+const healthCheckManifest = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: other
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: cert
+  namespace: other
+`
+
+func Test_healthCheckObjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), apimeta.RESTScopeNamespace)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).Build()
+	rls := &helmrelease.Release{Name: "release", Namespace: "default", Manifest: healthCheckManifest}
+
+	tests := []struct {
+		name    string
+		checks  []v2.HealthCheck
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "selects all objects without target",
+			checks: []v2.HealthCheck{{}},
+			want: []string{
+				"default_config__ConfigMap",
+				"other_app_apps_Deployment",
+				"other_cert_cert-manager.io_Certificate",
+			},
+		},
+		{
+			name: "selects objects matching any target",
+			checks: []v2.HealthCheck{
+				{Target: &kustomize.Selector{Kind: "Deployment"}},
+				{Target: &kustomize.Selector{Group: "cert-manager.io"}},
+			},
+			want: []string{
+				"other_app_apps_Deployment",
+				"other_cert_cert-manager.io_Certificate",
+			},
+		},
+		{
+			name: "selects objects by name",
+			checks: []v2.HealthCheck{
+				{Target: &kustomize.Selector{Name: "conf.*"}},
+			},
+			want: []string{
+				"default_config__ConfigMap",
+			},
+		},
+		{
+			name: "selects no objects",
+			checks: []v2.HealthCheck{
+				{Target: &kustomize.Selector{Kind: "StatefulSet"}},
+			},
+		},
+		{
+			name: "invalid target",
+			checks: []v2.HealthCheck{
+				{Target: &kustomize.Selector{Name: "(["}},
+			},
+			wantErr: "invalid health check target",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := healthCheckObjects(c, tt.checks, rls)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var ids []string
+			for _, o := range got {
+				ids = append(ids, o.String())
+			}
+			g.Expect(ids).To(Equal(tt.want))
+		})
+	}
+}
+
+func Test_waitForSet(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+
+	widgetGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion, widgetGVK.GroupVersion()})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), apimeta.RESTScopeNamespace)
+	mapper.Add(widgetGVK, apimeta.RESTScopeNamespace)
+
+	widget := &unstructured.Unstructured{}
+	widget.SetGroupVersionKind(widgetGVK)
+	widget.SetNamespace("default")
+	widget.SetName("widget")
+	_ = unstructured.SetNestedSlice(widget.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": "False", "reason": "Progressing"},
+	}, "status", "conditions")
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"}},
+		widget,
+	).Build()
+	// The fake client does not support the field selectors of the default
+	// caching cluster reader.
+	poller := polling.NewStatusPoller(c, mapper, polling.Options{
+		ClusterReaderFactory: engine.ClusterReaderFactoryFunc(clusterreader.NewDirectClusterReader),
+	})
+
+	configID := object.ObjMetadata{Namespace: "default", Name: "config", GroupKind: schema.GroupKind{Kind: "ConfigMap"}}
+	widgetID := object.ObjMetadata{Namespace: "default", Name: "widget", GroupKind: widgetGVK.GroupKind()}
+
+	t.Run("healthy objects", func(t *testing.T) {
+		g := NewWithT(t)
+
+		g.Expect(waitForSet(context.TODO(), poller, object.ObjMetadataSet{configID}, 5*time.Second)).To(Succeed())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := waitForSet(context.TODO(), poller, object.ObjMetadataSet{configID, widgetID}, 3*time.Second)
+		g.Expect(err).To(MatchError(ContainSubstring("timeout waiting for: [Widget/default/widget status: 'InProgress'")))
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		t.Cleanup(cancel)
+
+		start := time.Now()
+		err := waitForSet(ctx, poller, object.ObjMetadataSet{widgetID}, time.Minute)
+		g.Expect(err).To(MatchError(context.DeadlineExceeded))
+		g.Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+	})
+}
+
+func Test_failRelease(t *testing.T) {
+	g := NewWithT(t)
+
+	config := &helmaction.Configuration{Releases: helmstorage.Init(helmdriver.NewMemory())}
+	rls := &helmrelease.Release{
+		Name:      "release",
+		Namespace: "default",
+		Version:   1,
+		Info:      &helmrelease.Info{Status: helmrelease.StatusDeployed},
+	}
+	g.Expect(config.Releases.Create(rls)).To(Succeed())
+
+	err := errors.New("health check failed")
+	g.Expect(failRelease(config, rls, err)).To(MatchError(err))
+
+	got, sErr := config.Releases.Get("release", 1)
+	g.Expect(sErr).ToNot(HaveOccurred())
+	g.Expect(got.Info.Status).To(Equal(helmrelease.StatusFailed))
+	g.Expect(got.Info.Description).To(Equal(`Release "release" failed: health check failed`))
+}
+
+func TestAssessHealth(t *testing.T) {
+	config, cleanup := newTestCluster(t)
+	t.Cleanup(func() {
+		t.Log("Stopping the test environment")
+		if err := cleanup(); err != nil {
+			t.Logf("Failed to stop the test environment: %v", err)
+		}
+	})
+
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		t.Fatalf("Failed to create client for test environment: %v", err)
+	}
+	cfg := &helmaction.Configuration{RESTClientGetter: kube.NewMemoryRESTClientGetter(config)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	ns, err := generateNamespace(ctx, c, "health-action")
+	if err != nil {
+		t.Fatalf("Failed to generate namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Delete(context.Background(), ns); client.IgnoreNotFound(err) != nil {
+			t.Logf("Failed to delete generated namespace: %v", err)
+		}
+	})
+
+	// Without a controller manager, the Deployment never becomes ready.
+	objects := []client.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: ns.Name},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: ns.Name},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "app"}},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "app", Image: "app"}},
+					},
+				},
+			},
+		},
+	}
+	for _, obj := range objects {
+		if err := c.Create(ctx, obj); err != nil {
+			t.Fatalf("Failed to create object: %v", err)
+		}
+	}
+
+	rls := &helmrelease.Release{
+		Name:      "release",
+		Namespace: ns.Name,
+		Manifest: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`,
+	}
+
+	t.Run("without health checks", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{}
+		g.Expect(assessHealth(context.TODO(), cfg, obj, rls, time.Second)).To(Succeed())
+	})
+
+	t.Run("healthy objects", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				HealthChecks: []v2.HealthCheck{{Target: &kustomize.Selector{Kind: "ConfigMap"}}},
+			},
+		}
+		g.Expect(assessHealth(context.TODO(), cfg, obj, rls, 5*time.Second)).To(Succeed())
+	})
+
+	t.Run("unhealthy objects", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				HealthChecks: []v2.HealthCheck{{}},
+			},
+		}
+		err := assessHealth(context.TODO(), cfg, obj, rls, 3*time.Second)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("health check failed"))
+		g.Expect(err.Error()).To(ContainSubstring("Deployment/" + ns.Name + "/app"))
+		g.Expect(err.Error()).ToNot(ContainSubstring("ConfigMap/" + ns.Name + "/config"))
+	})
+
+	t.Run("check health of healthy objects", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				HealthChecks: []v2.HealthCheck{{Target: &kustomize.Selector{Kind: "ConfigMap"}}},
+			},
+		}
+		got, err := CheckHealth(ctx, cfg, obj, rls)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(BeEmpty())
+	})
+
+	t.Run("check health of unhealthy and missing objects", func(t *testing.T) {
+		g := NewWithT(t)
+
+		missing := rls.Manifest + `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: missing
+`
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				HealthChecks: []v2.HealthCheck{{}},
+			},
+		}
+		got, err := CheckHealth(ctx, cfg, obj, &helmrelease.Release{
+			Name:      rls.Name,
+			Namespace: rls.Namespace,
+			Manifest:  missing,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(HaveLen(2))
+		g.Expect(got[0]).To(HavePrefix("ConfigMap/" + ns.Name + "/missing status: 'NotFound'"))
+		g.Expect(got[1]).To(HavePrefix("Deployment/" + ns.Name + "/app status: 'InProgress'"))
+	})
+}
@@ -27,7 +27,6 @@ import (
 
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/helm-controller/internal/features"
-	"github.com/fluxcd/helm-controller/internal/postrender"
 	"github.com/fluxcd/helm-controller/internal/release"
 )
 
@@ -41,7 +40,9 @@ type InstallOption func(action *helmaction.Install)
 // and rollback configuration.
 //
 // It performs the installation according to the spec, which includes installing
-// the CRDs according to the defined policy.
+// the CRDs according to the defined policy. When health checks are configured,
+// the health of the selected objects is assessed after the installation, and
+// the release is marked as failed if they do not become healthy.
 //
 // It does not determine if there is a desire to perform the action, this is
 // expected to be done by the caller. In addition, it does not take note of the
@@ -55,11 +56,22 @@ func Install(ctx context.Context, config *helmaction.Configuration, obj *v2.Helm
 	if err != nil {
 		return nil, err
 	}
+	if err := checkCRDPermissions(install.PostRenderer, policy, chrt); err != nil {
+		return nil, err
+	}
 	if err := applyCRDs(config, policy, chrt, setOriginVisitor(v2.GroupVersion.Group, obj.Namespace, obj.Name)); err != nil {
 		return nil, fmt.Errorf("failed to apply CustomResourceDefinitions: %w", err)
 	}
 
-	return install.RunWithContext(ctx, chrt, vals.AsMap())
+	startRender(install.PostRenderer)
+	rls, err := install.RunWithContext(ctx, chrt, vals.AsMap())
+	if err != nil || install.DryRun {
+		return rls, err
+	}
+	if err = assessHealth(ctx, config, obj, rls, install.Timeout); err != nil {
+		return rls, failRelease(config, rls, err)
+	}
+	return rls, nil
 }
 
 func newInstall(config *helmaction.Configuration, obj *v2.HelmRelease, opts []InstallOption) *helmaction.Install {
@@ -85,7 +97,7 @@ func newInstall(config *helmaction.Configuration, obj *v2.HelmRelease, opts []In
 		install.EnableDNS = allowDNS
 	}
 
-	install.PostRenderer = postrender.BuildPostRenderers(obj)
+	install.PostRenderer = limitedPostRenderer(obj)
 
 	for _, opt := range opts {
 		opt(install)
@@ -0,0 +1,89 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"time"
+
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmpostrender "helm.sh/helm/v3/pkg/postrender"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/metrics"
+	"github.com/fluxcd/helm-controller/internal/postrender"
+)
+
+// WithInstallManifestLimits returns an InstallOption which enforces the
+// given limits on the manifest of the release. Without it, no limits are
+// enforced.
+func WithInstallManifestLimits(limits postrender.ManifestLimits) InstallOption {
+	return func(install *helmaction.Install) {
+		setManifestLimits(install.PostRenderer, limits)
+	}
+}
+
+// WithUpgradeManifestLimits returns an UpgradeOption which enforces the
+// given limits on the manifest of the release. Without it, no limits are
+// enforced.
+func WithUpgradeManifestLimits(limits postrender.ManifestLimits) UpgradeOption {
+	return func(upgrade *helmaction.Upgrade) {
+		setManifestLimits(upgrade.PostRenderer, limits)
+	}
+}
+
+// setManifestLimits sets the limits of the limited post-renderer in the
+// given chain of post-renderers.
+func setManifestLimits(pr helmpostrender.PostRenderer, limits postrender.ManifestLimits) {
+	switch p := pr.(type) {
+	case *postrender.Limited:
+		p.SetLimits(limits)
+	case *permissionCheck:
+		setManifestLimits(p.next, limits)
+	}
+}
+
+// startRender marks the start of rendering the manifest on the limited
+// post-renderer in the given chain of post-renderers. It must be called right
+// before the Helm action is run, so the duration of the render stage excludes
+// e.g. the application of CRDs.
+func startRender(pr helmpostrender.PostRenderer) {
+	switch p := pr.(type) {
+	case *postrender.Limited:
+		p.Start()
+	case *permissionCheck:
+		startRender(p.next)
+	}
+}
+
+// limitedPostRenderer returns the post-renderers of the HelmRelease, wrapped
+// to record the size of the manifest. Limits are enforced once configured
+// with WithInstallManifestLimits or WithUpgradeManifestLimits. As Helm does
+// not post-render hooks, the size and limits exclude the manifests of hooks.
+//
+// It also records the duration of the render and post-render stages, once
+// started with startRender. The duration of the render stage includes the
+// preparation of the action by Helm.
+func limitedPostRenderer(obj *v2.HelmRelease) helmpostrender.PostRenderer {
+	return postrender.NewLimited(postrender.BuildPostRenderers(obj), postrender.ManifestLimits{},
+		func(stage string, size int64, objects int, duration time.Duration) {
+			if duration > 0 {
+				metrics.RecordReleaseRender(obj.GetNamespace(), obj.GetName(), stage, duration)
+			}
+			metrics.RecordReleaseManifest(obj.GetNamespace(), obj.GetName(), stage, size, objects)
+		},
+	)
+}
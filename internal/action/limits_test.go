@@ -0,0 +1,69 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	helmaction "helm.sh/helm/v3/pkg/action"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/postrender"
+)
+
+func TestWithInstallManifestLimits(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\n" +
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n"
+
+	obj := &v2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"}}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		g := NewWithT(t)
+
+		install := newInstall(&helmaction.Configuration{}, obj, nil)
+		_, err := install.PostRenderer.Run(bytes.NewBufferString(manifest))
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("enforces limits", func(t *testing.T) {
+		g := NewWithT(t)
+
+		install := newInstall(&helmaction.Configuration{}, obj, []InstallOption{
+			WithInstallManifestLimits(postrender.ManifestLimits{MaxObjects: 1}),
+		})
+		_, err := install.PostRenderer.Run(bytes.NewBufferString(manifest))
+		var tooLargeErr *postrender.ManifestTooLargeError
+		g.Expect(errors.As(err, &tooLargeErr)).To(BeTrue())
+	})
+
+	t.Run("enforces limits after permission check", func(t *testing.T) {
+		g := NewWithT(t)
+
+		install := newInstall(&helmaction.Configuration{}, obj, []InstallOption{
+			WithInstallPermissionCheck(context.TODO(), &helmaction.Configuration{}, obj, nil),
+			WithInstallManifestLimits(postrender.ManifestLimits{MaxObjects: 1}),
+		})
+		_, err := install.PostRenderer.Run(bytes.NewBufferString(manifest))
+		var tooLargeErr *postrender.ManifestTooLargeError
+		g.Expect(errors.As(err, &tooLargeErr)).To(BeTrue())
+	})
+}
@@ -0,0 +1,404 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	helmpostrender "helm.sh/helm/v3/pkg/postrender"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// Permission is a permission on a Kubernetes resource, as checked using a
+// SelfSubjectAccessReview.
+type Permission struct {
+	// Verb is the Kubernetes API verb, e.g. 'create'.
+	Verb string
+	// Group is the API group of the resource.
+	Group string
+	// Resource is the plural name of the resource, e.g. 'deployments'.
+	Resource string
+	// Namespace of the resource, empty for cluster-scoped resources.
+	Namespace string
+	// Name of the resource, empty if the permission applies to any name.
+	Name string
+}
+
+// String returns a human-readable representation of the Permission, e.g.
+// "create deployments.apps in namespace 'default'".
+func (p Permission) String() string {
+	var b strings.Builder
+	b.WriteString(p.Verb + " " + p.Resource)
+	if p.Group != "" {
+		b.WriteString("." + p.Group)
+	}
+	if p.Name != "" {
+		b.WriteString("/" + p.Name)
+	}
+	if p.Namespace != "" {
+		b.WriteString(" in namespace '" + p.Namespace + "'")
+	}
+	return b.String()
+}
+
+// InsufficientPermissionsError is returned by an install or upgrade with a
+// permission check when permissions required by the release are missing,
+// or could not be confirmed. It is returned before the Helm storage or any
+// resources of the release are modified.
+type InsufficientPermissionsError struct {
+	// Missing are the permissions which are not allowed.
+	Missing []Permission
+	// Err is the error which prevented the permissions from being
+	// confirmed, e.g. a failure to create a SelfSubjectAccessReview.
+	Err error
+}
+
+// Error returns a message listing the missing permissions, or the reason
+// the permissions could not be confirmed.
+func (e *InsufficientPermissionsError) Error() string {
+	if e.Err != nil {
+		return "unable to confirm permissions: " + e.Err.Error()
+	}
+	return "missing permissions to " + formatPermissions(e.Missing)
+}
+
+// Unwrap returns the error which prevented the permissions from being
+// confirmed.
+func (e *InsufficientPermissionsError) Unwrap() error {
+	return e.Err
+}
+
+// maxFormattedPermissions is the maximum number of permissions included in
+// an InsufficientPermissionsError message.
+const maxFormattedPermissions = 10
+
+// formatPermissions returns a comma-separated list of the given permissions,
+// truncated to maxFormattedPermissions.
+func formatPermissions(perms []Permission) string {
+	var s []string
+	for i, p := range perms {
+		if i == maxFormattedPermissions {
+			s = append(s, fmt.Sprintf("and %d more", len(perms)-i))
+			break
+		}
+		s = append(s, p.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// WithInstallPermissionCheck returns an InstallOption which confirms the
+// permissions required by the release are allowed for the user of the given
+// client, before the release is installed. The check is run on the manifest
+// rendered by the install, after any post-renderers.
+//
+// When permissions are missing, or cannot be confirmed because reviewing
+// them fails, the install returns an InsufficientPermissionsError. Failures to
+// determine the required permissions are logged, and left to surface when
+// running the install.
+func WithInstallPermissionCheck(ctx context.Context, config *helmaction.Configuration, obj *v2.HelmRelease,
+	client authorizationv1client.SelfSubjectAccessReviewInterface) InstallOption {
+	return func(install *helmaction.Install) {
+		install.PostRenderer = &permissionCheck{
+			ctx:    ctx,
+			config: config,
+			obj:    obj,
+			client: client,
+			next:   install.PostRenderer,
+		}
+	}
+}
+
+// WithUpgradePermissionCheck returns an UpgradeOption which confirms the
+// permissions required by the release are allowed for the user of the given
+// client, before the release is upgraded. The check is run on the manifest
+// rendered by the upgrade, after any post-renderers.
+//
+// When permissions are missing, or cannot be confirmed because reviewing
+// them fails, the upgrade returns an InsufficientPermissionsError. Failures to
+// determine the required permissions are logged, and left to surface when
+// running the upgrade.
+func WithUpgradePermissionCheck(ctx context.Context, config *helmaction.Configuration, obj *v2.HelmRelease,
+	client authorizationv1client.SelfSubjectAccessReviewInterface) UpgradeOption {
+	return func(upgrade *helmaction.Upgrade) {
+		upgrade.PostRenderer = &permissionCheck{
+			ctx:     ctx,
+			config:  config,
+			obj:     obj,
+			client:  client,
+			upgrade: true,
+			next:    upgrade.PostRenderer,
+		}
+	}
+}
+
+// permissionCheck is a post-renderer which checks the permissions required
+// by the rendered manifest of a release. As Helm does not post-render the
+// manifests of hooks, the permissions required by hooks are not checked.
+type permissionCheck struct {
+	ctx     context.Context
+	config  *helmaction.Configuration
+	obj     *v2.HelmRelease
+	client  authorizationv1client.SelfSubjectAccessReviewInterface
+	upgrade bool
+	next    helmpostrender.PostRenderer
+}
+
+// Run runs the next post-renderer, and checks the permissions required by
+// the resulting manifest.
+func (c *permissionCheck) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if c.next != nil {
+		var err error
+		if renderedManifests, err = c.next.Run(renderedManifests); err != nil {
+			return nil, err
+		}
+	}
+
+	perms, err := RequiredPermissions(c.config, c.obj, renderedManifests.String(), c.upgrade)
+	if err != nil {
+		c.config.Log("skipping permission check: failed to determine required permissions: %s", err)
+		return renderedManifests, nil
+	}
+	if err = c.check(perms); err != nil {
+		return nil, err
+	}
+	return renderedManifests, nil
+}
+
+// checkCRDs checks the permissions required to apply the
+// CustomResourceDefinitions of the chart according to the given policy.
+// It is run before the CustomResourceDefinitions are applied, which happens
+// before the release is rendered.
+func (c *permissionCheck) checkCRDs(policy v2.CRDsPolicy, chrt *helmchart.Chart) error {
+	if len(chrt.CRDObjects()) == 0 {
+		return nil
+	}
+	perms := make(permissionSet)
+	crd := Permission{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"}
+	switch policy {
+	case v2.Create:
+		perms.addPermission(crd, "create")
+	case v2.CreateReplace:
+		perms.addPermission(crd, "create", "update")
+	}
+	return c.check(perms.sorted())
+}
+
+// check returns an InsufficientPermissionsError if any of the given
+// permissions are missing, or if reviewing the permissions fails.
+func (c *permissionCheck) check(perms []Permission) error {
+	missing, err := MissingPermissions(c.ctx, c.client, perms)
+	if err != nil {
+		return &InsufficientPermissionsError{Err: err}
+	}
+	if len(missing) > 0 {
+		return &InsufficientPermissionsError{Missing: missing}
+	}
+	return nil
+}
+
+// checkCRDPermissions checks the permissions required to apply the
+// CustomResourceDefinitions of the chart, if the given post-renderer is a
+// permission check.
+func checkCRDPermissions(pr helmpostrender.PostRenderer, policy v2.CRDsPolicy, chrt *helmchart.Chart) error {
+	if c, ok := pr.(*permissionCheck); ok {
+		return c.checkCRDs(policy, chrt)
+	}
+	return nil
+}
+
+// RequiredPermissions returns the permissions required to install (or
+// upgrade, if upgrade is true) the release of the object with the given
+// rendered manifest. This includes the permissions to manage the resources
+// of the manifest, the release namespace which is created according to the
+// spec of the object, and the Helm storage.
+//
+// The permissions are sorted and do not contain duplicates.
+func RequiredPermissions(config *helmaction.Configuration, obj *v2.HelmRelease, manifest string, upgrade bool) ([]Permission, error) {
+	perms := make(permissionSet)
+
+	// Resources of the release.
+	resources, err := config.KubeClient.Build(bytes.NewBufferString(manifest), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build resources from release manifest: %w", err)
+	}
+	if upgrade {
+		var current helmkube.ResourceList
+		if cur, err := LastRelease(config, obj.GetReleaseName()); err == nil {
+			if current, err = config.KubeClient.Build(bytes.NewBufferString(cur.Manifest), false); err != nil {
+				return nil, fmt.Errorf("failed to build resources from current release manifest: %w", err)
+			}
+		} else if !errors.Is(err, ErrReleaseNotFound) {
+			return nil, err
+		}
+		for _, r := range resources {
+			if current.Get(r) != nil {
+				perms.add(r, "get", "patch")
+				// Forced upgrades replace the resource.
+				if obj.GetUpgrade().Force {
+					perms.add(r, "update")
+				}
+				continue
+			}
+			perms.add(r, "create")
+			// Created resources are deleted when the upgrade fails.
+			if obj.GetUpgrade().CleanupOnFail {
+				perms.add(r, "delete")
+			}
+		}
+		for _, r := range current.Difference(resources) {
+			perms.add(r, "delete")
+		}
+	} else {
+		for _, r := range resources {
+			perms.add(r, "create")
+		}
+	}
+
+	// Release namespace created on install.
+	if !upgrade && obj.Spec.TargetNamespace != "" && obj.GetInstall().CreateNamespace {
+		perms.addPermission(Permission{Resource: "namespaces"}, "create")
+	}
+
+	// Helm storage, of which the release history is listed and read, and
+	// releases are created, updated, and deleted to enforce the history
+	// limit.
+	storage := Permission{Namespace: obj.GetStorageNamespace()}
+	switch config.Releases.Driver.Name() {
+	case helmdriver.SecretsDriverName:
+		storage.Resource = "secrets"
+	case helmdriver.ConfigMapsDriverName:
+		storage.Resource = "configmaps"
+	}
+	if storage.Resource != "" {
+		perms.addPermission(storage, "create", "delete", "get", "list", "update")
+	}
+
+	return perms.sorted(), nil
+}
+
+// maxConcurrentReviews is the maximum number of SelfSubjectAccessReviews
+// created concurrently by MissingPermissions.
+const maxConcurrentReviews = 10
+
+// MissingPermissions returns the permissions which are not allowed for the
+// user of the given client, using SelfSubjectAccessReviews which are created
+// concurrently. The missing permissions are returned in the order given.
+func MissingPermissions(ctx context.Context, client authorizationv1client.SelfSubjectAccessReviewInterface, perms []Permission) ([]Permission, error) {
+	var (
+		allowed = make([]bool, len(perms))
+		errs    = make([]error, len(perms))
+		sem     = make(chan struct{}, maxConcurrentReviews)
+		wg      sync.WaitGroup
+	)
+	for i, p := range perms {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, p Permission) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			review, err := client.Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: p.Namespace,
+						Verb:      p.Verb,
+						Group:     p.Group,
+						Resource:  p.Resource,
+						Name:      p.Name,
+					},
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to review access to %s: %w", p, err)
+				return
+			}
+			allowed[i] = review.Status.Allowed
+		}(i, p)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	var missing []Permission
+	for i, p := range perms {
+		if !allowed[i] {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// permissionSet is a set of Permission.
+type permissionSet map[Permission]struct{}
+
+// add adds the given verbs for the resource to the set. The name of the
+// resource is omitted for the 'create' verb, as the name of an object is
+// not known to the authorizer when it is created.
+func (s permissionSet) add(info *resource.Info, verbs ...string) {
+	p := Permission{
+		Group:    info.Mapping.Resource.Group,
+		Resource: info.Mapping.Resource.Resource,
+		Name:     info.Name,
+	}
+	if info.Mapping.Scope.Name() == apimeta.RESTScopeNameNamespace {
+		p.Namespace = info.Namespace
+	}
+	s.addPermission(p, verbs...)
+}
+
+// addPermission adds the given verbs for the permission to the set.
+func (s permissionSet) addPermission(p Permission, verbs ...string) {
+	for _, v := range verbs {
+		p := p
+		p.Verb = v
+		if v == "create" {
+			p.Name = ""
+		}
+		s[p] = struct{}{}
+	}
+}
+
+// sorted returns the permissions in the set, sorted by their string
+// representation.
+func (s permissionSet) sorted() []Permission {
+	perms := make([]Permission, 0, len(s))
+	for p := range s {
+		perms = append(perms, p)
+	}
+	sort.Slice(perms, func(i, j int) bool {
+		return perms[i].String() < perms[j].String()
+	})
+	return perms
+}
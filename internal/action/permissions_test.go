@@ -0,0 +1,351 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmchartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	helmstorage "helm.sh/helm/v3/pkg/storage"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/testutil"
+)
+
+// buildingKubeClient is a fake Helm kube client which builds resources from
+// manifests using a static REST mapper.
+type buildingKubeClient struct {
+	kubefake.PrintingKubeClient
+	mapper apimeta.RESTMapper
+}
+
+func newBuildingKubeClient() *buildingKubeClient {
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, apimeta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, apimeta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, apimeta.RESTScopeRoot)
+	return &buildingKubeClient{
+		PrintingKubeClient: kubefake.PrintingKubeClient{Out: io.Discard},
+		mapper:             mapper,
+	}
+}
+
+func (c *buildingKubeClient) Build(reader io.Reader, _ bool) (helmkube.ResourceList, error) {
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	var list helmkube.ResourceList
+	for _, doc := range releaseutil.SplitManifests(string(b)) {
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(doc), &obj.Object); err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		gvk := obj.GroupVersionKind()
+		mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, &resource.Info{
+			Mapping:   mapping,
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Object:    obj,
+		})
+	}
+	return list, nil
+}
+
+func TestPermission_String(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Permission{Verb: "create", Group: "apps", Resource: "deployments", Namespace: "default"}.String()).
+		To(Equal("create deployments.apps in namespace 'default'"))
+	g.Expect(Permission{Verb: "patch", Resource: "configmaps", Namespace: "default", Name: "cm"}.String()).
+		To(Equal("patch configmaps/cm in namespace 'default'"))
+	g.Expect(Permission{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"}.String()).
+		To(Equal("create clusterroles.rbac.authorization.k8s.io"))
+}
+
+func TestInsufficientPermissionsError_Error(t *testing.T) {
+	g := NewWithT(t)
+
+	perms := []Permission{
+		{Verb: "create", Resource: "configmaps", Namespace: "default"},
+		{Verb: "delete", Group: "apps", Resource: "deployments", Namespace: "default", Name: "podinfo"},
+	}
+	err := &InsufficientPermissionsError{Missing: perms}
+	g.Expect(err.Error()).To(Equal("missing permissions to " + perms[0].String() + ", " + perms[1].String()))
+
+	var many []Permission
+	for i := 0; i < maxFormattedPermissions+3; i++ {
+		many = append(many, Permission{Verb: "get", Resource: "secrets", Name: fmt.Sprintf("s%d", i)})
+	}
+	err = &InsufficientPermissionsError{Missing: many}
+	g.Expect(err.Error()).To(HaveSuffix(", and 3 more"))
+
+	reviewErr := errors.New("forbidden")
+	err = &InsufficientPermissionsError{Err: reviewErr}
+	g.Expect(err.Error()).To(Equal("unable to confirm permissions: forbidden"))
+	g.Expect(errors.Is(err, reviewErr)).To(BeTrue())
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	const namespace = "apps"
+
+	newConfig := func(driver helmdriver.Driver) *helmaction.Configuration {
+		return &helmaction.Configuration{
+			Releases:     helmstorage.Init(driver),
+			KubeClient:   newBuildingKubeClient(),
+			Capabilities: helmchartutil.DefaultCapabilities,
+			Log:          func(string, ...interface{}) {},
+		}
+	}
+	newObj := func() *v2.HelmRelease {
+		return &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: namespace},
+			Spec:       v2.HelmReleaseSpec{ReleaseName: "release"},
+		}
+	}
+	newCurrent := func(config *helmaction.Configuration) {
+		cur := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+			Name:      "release",
+			Namespace: namespace,
+			Version:   1,
+			Status:    helmrelease.StatusDeployed,
+			Chart:     testutil.BuildChart(),
+		})
+		cur.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n  namespace: apps\n---\n" +
+			"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: removed\n  namespace: apps\n"
+		if err := config.Releases.Create(cur); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n  namespace: apps\n---\n" +
+		"apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: viewer\n"
+
+	t.Run("install", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := newObj()
+		obj.Spec.TargetNamespace = namespace
+		obj.Spec.Install = &v2.Install{CreateNamespace: true}
+
+		got, err := RequiredPermissions(newConfig(helmdriver.NewSecrets(nil)), obj, manifest, false)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(Equal([]Permission{
+			{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+			{Verb: "create", Resource: "configmaps", Namespace: namespace},
+			{Verb: "create", Resource: "namespaces"},
+			{Verb: "create", Resource: "secrets", Namespace: namespace},
+			{Verb: "delete", Resource: "secrets", Namespace: namespace},
+			{Verb: "get", Resource: "secrets", Namespace: namespace},
+			{Verb: "list", Resource: "secrets", Namespace: namespace},
+			{Verb: "update", Resource: "secrets", Namespace: namespace},
+		}))
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		g := NewWithT(t)
+
+		driver := helmdriver.NewMemory()
+		driver.SetNamespace(namespace)
+		config := newConfig(driver)
+		newCurrent(config)
+
+		got, err := RequiredPermissions(config, newObj(), manifest, true)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(Equal([]Permission{
+			{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+			{Verb: "delete", Resource: "configmaps", Namespace: namespace, Name: "removed"},
+			{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: "cm"},
+			{Verb: "patch", Resource: "configmaps", Namespace: namespace, Name: "cm"},
+		}))
+	})
+
+	t.Run("upgrade with force and cleanup on fail", func(t *testing.T) {
+		g := NewWithT(t)
+
+		driver := helmdriver.NewMemory()
+		driver.SetNamespace(namespace)
+		config := newConfig(driver)
+		newCurrent(config)
+
+		obj := newObj()
+		obj.Spec.Upgrade = &v2.Upgrade{Force: true, CleanupOnFail: true}
+
+		got, err := RequiredPermissions(config, obj, manifest, true)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(Equal([]Permission{
+			{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+			{Verb: "delete", Group: "rbac.authorization.k8s.io", Resource: "clusterroles", Name: "viewer"},
+			{Verb: "delete", Resource: "configmaps", Namespace: namespace, Name: "removed"},
+			{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: "cm"},
+			{Verb: "patch", Resource: "configmaps", Namespace: namespace, Name: "cm"},
+			{Verb: "update", Resource: "configmaps", Namespace: namespace, Name: "cm"},
+		}))
+	})
+
+	t.Run("build error", func(t *testing.T) {
+		g := NewWithT(t)
+
+		driver := helmdriver.NewMemory()
+		_, err := RequiredPermissions(newConfig(driver), newObj(), "apiVersion: v1\nkind: Unknown\nmetadata:\n  name: x\n", false)
+		g.Expect(err).To(MatchError(ContainSubstring("failed to build resources")))
+	})
+}
+
+func TestWithInstallPermissionCheck(t *testing.T) {
+	const namespace = "apps"
+
+	newReviews := func(allowed func(*authorizationv1.ResourceAttributes) bool) *fake.Clientset {
+		clientSet := fake.NewSimpleClientset()
+		clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = allowed(review.Spec.ResourceAttributes)
+			return true, review, nil
+		})
+		return clientSet
+	}
+	newConfig := func() *helmaction.Configuration {
+		driver := helmdriver.NewMemory()
+		driver.SetNamespace(namespace)
+		return &helmaction.Configuration{
+			Releases:     helmstorage.Init(driver),
+			KubeClient:   newBuildingKubeClient(),
+			Capabilities: helmchartutil.DefaultCapabilities,
+			Log:          func(string, ...interface{}) {},
+		}
+	}
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: namespace},
+		Spec: v2.HelmReleaseSpec{
+			ReleaseName: "release",
+			Install:     &v2.Install{DisableWait: true, DisableHooks: true},
+		},
+	}
+
+	t.Run("missing permissions", func(t *testing.T) {
+		g := NewWithT(t)
+
+		clientSet := newReviews(func(attrs *authorizationv1.ResourceAttributes) bool {
+			return attrs.Verb != "create"
+		})
+		config := newConfig()
+		_, err := Install(context.TODO(), config, obj, testutil.BuildChart(), nil,
+			WithInstallPermissionCheck(context.TODO(), config, obj, clientSet.AuthorizationV1().SelfSubjectAccessReviews()))
+
+		var permErr *InsufficientPermissionsError
+		g.Expect(errors.As(err, &permErr)).To(BeTrue())
+		g.Expect(permErr.Missing).To(Equal([]Permission{
+			{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		}))
+
+		// The check is run before the release is stored.
+		releases, err := config.Releases.ListReleases()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(releases).To(BeEmpty())
+	})
+
+	t.Run("review failure", func(t *testing.T) {
+		g := NewWithT(t)
+
+		clientSet := fake.NewSimpleClientset()
+		clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("connection refused")
+		})
+		config := newConfig()
+		_, err := Install(context.TODO(), config, obj, testutil.BuildChart(), nil,
+			WithInstallPermissionCheck(context.TODO(), config, obj, clientSet.AuthorizationV1().SelfSubjectAccessReviews()))
+
+		// Permissions which cannot be confirmed are not assumed to be
+		// allowed.
+		var permErr *InsufficientPermissionsError
+		g.Expect(errors.As(err, &permErr)).To(BeTrue())
+		g.Expect(permErr.Missing).To(BeEmpty())
+		g.Expect(permErr.Err).To(MatchError(ContainSubstring("connection refused")))
+
+		releases, err := config.Releases.ListReleases()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(releases).To(BeEmpty())
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		g := NewWithT(t)
+
+		clientSet := newReviews(func(*authorizationv1.ResourceAttributes) bool {
+			return true
+		})
+		config := newConfig()
+		rls, err := Install(context.TODO(), config, obj, testutil.BuildChart(), nil,
+			WithInstallPermissionCheck(context.TODO(), config, obj, clientSet.AuthorizationV1().SelfSubjectAccessReviews()),
+			func(install *helmaction.Install) {
+				// Skip the creation of the resources, which requires a
+				// cluster.
+				install.DryRun = true
+				install.ClientOnly = true
+			})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rls).ToNot(BeNil())
+	})
+}
+
+func TestMissingPermissions(t *testing.T) {
+	g := NewWithT(t)
+
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource == "configmaps"
+		return true, review, nil
+	})
+
+	var perms, want []Permission
+	for i := 0; i < 3*maxConcurrentReviews; i++ {
+		perms = append(perms, Permission{Verb: "create", Resource: "configmaps", Namespace: fmt.Sprintf("ns%d", i)})
+		p := Permission{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles", Name: fmt.Sprintf("cr%d", i)}
+		perms = append(perms, p)
+		want = append(want, p)
+	}
+	got, err := MissingPermissions(context.TODO(), clientSet.AuthorizationV1().SelfSubjectAccessReviews(), perms)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(want))
+}
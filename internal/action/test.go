@@ -38,9 +38,47 @@ type TestOption func(action *helmaction.ReleaseTesting)
 // expected to be done by the caller. In addition, it does not take note of the
 // action result. The caller is expected to listen to this using a
 // storage.ObserveFunc, which provides superior access to Helm storage writes.
+//
+// When test hooks are selected by label or annotation, run concurrently or
+// continue to be run after a failure, the test hooks are run by the
+// controller instead of Helm, in the same way as Helm would.
 func Test(_ context.Context, config *helmaction.Configuration, obj *v2.HelmRelease, opts ...TestOption) (*helmrelease.Release, error) {
 	test := newTest(config, obj, opts)
-	return test.Run(obj.GetReleaseName())
+
+	spec := obj.GetTest()
+	if spec.Selector == nil && spec.AnnotationSelector == nil && spec.GetParallelism() == 1 && !spec.ContinueOnFailure {
+		return test.Run(obj.GetReleaseName())
+	}
+	return runTest(config, test, spec, obj.GetReleaseName())
+}
+
+// runTest runs the Helm test action for the release using a testRunner.
+func runTest(config *helmaction.Configuration, test *helmaction.ReleaseTesting, spec v2.Test, name string) (*helmrelease.Release, error) {
+	if err := config.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+
+	rls, err := config.Releases.Last(name)
+	if err != nil {
+		return rls, err
+	}
+
+	selector, err := newTestSelector(test.Filters, spec)
+	if err != nil {
+		return rls, err
+	}
+	hooks, err := selector.Select(rls)
+	if err != nil {
+		return rls, err
+	}
+
+	runner := &testRunner{
+		config:            config,
+		timeout:           test.Timeout,
+		parallelism:       spec.GetParallelism(),
+		continueOnFailure: spec.ContinueOnFailure,
+	}
+	return rls, runner.Run(rls, hooks)
 }
 
 func newTest(config *helmaction.Configuration, obj *v2.HelmRelease, opts []TestOption) *helmaction.ReleaseTesting {
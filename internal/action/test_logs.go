@@ -0,0 +1,424 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrutil "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+const (
+	// TestLogsKindSecret stores the logs of test hooks in a Secret.
+	TestLogsKindSecret = "Secret"
+	// TestLogsKindConfigMap stores the logs of test hooks in a ConfigMap.
+	TestLogsKindConfigMap = "ConfigMap"
+)
+
+// maxTestLogsSize is the maximum size in bytes of the logs of all test hooks
+// stored in a single object. Kubernetes rejects Secrets and ConfigMaps with
+// more than 1MiB of data, the remainder is left for the keys.
+const maxTestLogsSize = 1<<20 - 64<<10
+
+// TestLogsLabelKey is the label key set on the objects holding the logs of
+// test hooks, with the name of the Helm release as value.
+var TestLogsLabelKey = v2.GroupVersion.Group + "/test-logs"
+
+// TestLogsOptions configures the collection of the logs of test hook Pods.
+type TestLogsOptions struct {
+	// MaxSize is the maximum size in bytes of the logs stored per test hook.
+	// Only the tail of logs exceeding the size is stored. A value of 0
+	// disables the collection of logs. The logs of all test hooks together
+	// are further limited to fit in a single object, see StoreTestLogs.
+	MaxSize int64
+	// Kind is the kind of object to store the logs in, either
+	// TestLogsKindSecret or TestLogsKindConfigMap.
+	Kind string
+}
+
+// Enabled returns true if the collection of logs is enabled.
+func (o TestLogsOptions) Enabled() bool {
+	return o.MaxSize > 0
+}
+
+// Validate returns an error if the options are invalid.
+func (o TestLogsOptions) Validate() error {
+	switch o.Kind {
+	case TestLogsKindSecret, TestLogsKindConfigMap:
+	default:
+		return fmt.Errorf("invalid test logs kind '%s': must be %s or %s", o.Kind, TestLogsKindSecret, TestLogsKindConfigMap)
+	}
+	if o.MaxSize < 0 {
+		return fmt.Errorf("invalid test logs max size %d: must not be negative", o.MaxSize)
+	}
+	return nil
+}
+
+// TestLog holds the (tail of the) logs of a test hook Pod.
+type TestLog struct {
+	// Data is the log data.
+	Data []byte
+	// Truncated is true if the logs exceeded the maximum size.
+	Truncated bool
+}
+
+// TestLogsCollector collects the logs of test hook Pods while a Helm test
+// action runs. As Helm deletes the test hooks according to their delete
+// policy before the action returns, the logs are collected by the Helm
+// Kubernetes client as soon as a Pod completed.
+type TestLogsCollector struct {
+	ctx       context.Context
+	clientSet kubernetes.Interface
+	maxSize   int64
+
+	mu   sync.Mutex
+	logs map[string]*TestLog
+	errs []error
+}
+
+// CollectTestLogs configures the Helm action.Configuration to collect the
+// logs of test hook Pods, and returns the TestLogsCollector holding the logs
+// once the Helm test action ran.
+func CollectTestLogs(ctx context.Context, config *helmaction.Configuration, maxSize int64) (*TestLogsCollector, error) {
+	kubeClient, ok := config.KubeClient.(*helmkube.Client)
+	if !ok {
+		return nil, fmt.Errorf("unsupported Helm Kubernetes client %T", config.KubeClient)
+	}
+	clientSet, err := config.KubernetesClientSet()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Kubernetes client to collect test logs: %w", err)
+	}
+
+	c := &TestLogsCollector{
+		ctx:       ctx,
+		clientSet: clientSet,
+		maxSize:   maxSize,
+		logs:      make(map[string]*TestLog),
+	}
+	config.KubeClient = &testLogsClient{Client: kubeClient, collector: c}
+	return c, nil
+}
+
+// Logs returns the collected logs by test hook name.
+func (c *TestLogsCollector) Logs() map[string]*TestLog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs := make(map[string]*TestLog, len(c.logs))
+	for k, v := range c.logs {
+		logs[k] = v
+	}
+	return logs
+}
+
+// Err returns the errors which occurred while collecting the logs.
+func (c *TestLogsCollector) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return apierrutil.NewAggregate(c.errs)
+}
+
+// collect collects the logs of the given Pods.
+func (c *TestLogsCollector) collect(resources helmkube.ResourceList) {
+	for _, info := range resources {
+		if info.Mapping == nil || info.Mapping.GroupVersionKind.GroupKind() != corev1.SchemeGroupVersion.WithKind("Pod").GroupKind() {
+			continue
+		}
+		log, err := c.podLogs(info.Namespace, info.Name)
+
+		c.mu.Lock()
+		if err != nil {
+			c.errs = append(c.errs, fmt.Errorf("unable to get logs of test hook Pod %s/%s: %w", info.Namespace, info.Name, err))
+		} else {
+			c.logs[info.Name] = log
+		}
+		c.mu.Unlock()
+	}
+}
+
+// podLogs returns the logs of all containers of the Pod, with a header per
+// container if the Pod has multiple containers.
+func (c *TestLogsCollector) podLogs(namespace, name string) (*TestLog, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+	defer cancel()
+
+	pod, err := c.clientSet.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	w := &tailWriter{max: int(c.maxSize)}
+	for _, container := range pod.Spec.Containers {
+		if len(pod.Spec.Containers) > 1 {
+			_, _ = fmt.Fprintf(w, "==> container %s <==\n", container.Name)
+		}
+		if err := c.containerLogs(ctx, w, namespace, name, container.Name); err != nil {
+			return nil, err
+		}
+	}
+	return &TestLog{Data: w.buf, Truncated: w.dropped > 0}, nil
+}
+
+// containerLogs writes the logs of the container to w.
+func (c *TestLogsCollector) containerLogs(ctx context.Context, w io.Writer, namespace, name, container string) error {
+	stream, err := c.clientSet.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: container}).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_, err = io.Copy(w, stream)
+	return err
+}
+
+// testLogsClient is a Helm Kubernetes client which collects the logs of
+// Pods once they are ready, i.e. completed for test hooks.
+type testLogsClient struct {
+	*helmkube.Client
+	collector *TestLogsCollector
+}
+
+// WatchUntilReady waits for the resources to be ready, and collects the logs
+// of any Pod in the resources.
+func (c *testLogsClient) WatchUntilReady(resources helmkube.ResourceList, timeout time.Duration) error {
+	err := c.Client.WatchUntilReady(resources, timeout)
+	c.collector.collect(resources)
+	return err
+}
+
+// tailWriter is an io.Writer which retains the last max bytes written.
+type tailWriter struct {
+	max     int
+	buf     []byte
+	dropped int64
+}
+
+// Write appends p to the buffer, and drops the head of the buffer when it
+// exceeds the maximum size.
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if over := len(w.buf) - w.max; over > 0 {
+		copy(w.buf, w.buf[over:])
+		w.buf = w.buf[:w.max]
+		w.dropped += int64(over)
+	}
+	return len(p), nil
+}
+
+// TestLogsName returns the name of the object holding the logs of the test
+// hooks of the given release version.
+func TestLogsName(releaseName string, version int) string {
+	return fmt.Sprintf("%s.v%d.test-logs", releaseName, version)
+}
+
+// StoreTestLogs stores the logs in an object of the kind configured in the
+// options, in the given (storage) namespace. It returns the references to
+// the stored logs by test hook name.
+//
+// The logs are merged into any existing object of the release version,
+// replacing the logs of test hooks which have been run again, and retaining
+// the others. As all logs are stored in a single object, which Kubernetes
+// limits to 1MiB of data, the given logs exceeding an equal share of the
+// budget left by the retained logs are truncated further to their tail. The
+// budget left by smaller logs is shared among the larger ones.
+//
+// When the Helm storage driver keeps the release in a Secret or ConfigMap,
+// the object is owned by the release object. Causing it to be garbage
+// collected when the release version is removed from the storage, for
+// example due to the max history or an uninstall.
+func StoreTestLogs(ctx context.Context, clientSet kubernetes.Interface, opts TestLogsOptions, driver, namespace, releaseName string,
+	version int, logs map[string]*TestLog) (map[string]*v2.TestLogsReference, error) {
+	if len(logs) == 0 {
+		return nil, nil
+	}
+
+	objMeta := metav1.ObjectMeta{
+		Name:      TestLogsName(releaseName, version),
+		Namespace: namespace,
+		Labels: map[string]string{
+			TestLogsLabelKey: releaseName,
+		},
+	}
+	owner, err := releaseStorageOwner(ctx, clientSet, driver, namespace, releaseName, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Helm storage object of release: %w", err)
+	}
+	if owner != nil {
+		objMeta.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	// Retain the logs of test hooks which have not been run again, so that
+	// their references remain valid.
+	data, exists, err := getTestLogs(ctx, clientSet, opts.Kind, namespace, objMeta.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get test logs from %s %s/%s: %w", opts.Kind, namespace, objMeta.Name, err)
+	}
+	budget := maxTestLogsSize
+	for name, d := range data {
+		if _, ok := logs[name]; ok {
+			delete(data, name)
+			continue
+		}
+		budget -= len(name) + len(d)
+	}
+	if data == nil {
+		data = make(map[string][]byte, len(logs))
+	}
+
+	logs = fitTestLogs(logs, budget)
+	refs := make(map[string]*v2.TestLogsReference, len(logs))
+	for name, log := range logs {
+		data[name] = log.Data
+		refs[name] = &v2.TestLogsReference{
+			Kind:      opts.Kind,
+			Namespace: objMeta.Namespace,
+			Name:      objMeta.Name,
+			Key:       name,
+			Truncated: log.Truncated,
+		}
+	}
+
+	switch opts.Kind {
+	case TestLogsKindConfigMap:
+		cm := &corev1.ConfigMap{ObjectMeta: objMeta, Data: make(map[string]string, len(data))}
+		for k, v := range data {
+			cm.Data[k] = string(v)
+		}
+		client := clientSet.CoreV1().ConfigMaps(namespace)
+		if exists {
+			_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+		} else {
+			_, err = client.Create(ctx, cm, metav1.CreateOptions{})
+		}
+	default:
+		secret := &corev1.Secret{ObjectMeta: objMeta, Type: corev1.SecretTypeOpaque, Data: data}
+		client := clientSet.CoreV1().Secrets(namespace)
+		if exists {
+			_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+		} else {
+			_, err = client.Create(ctx, secret, metav1.CreateOptions{})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store test logs in %s %s/%s: %w", opts.Kind, namespace, objMeta.Name, err)
+	}
+	return refs, nil
+}
+
+// getTestLogs returns the data of the object of the given kind holding test
+// logs, and whether the object exists.
+func getTestLogs(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) (map[string][]byte, bool, error) {
+	switch kind {
+	case TestLogsKindConfigMap:
+		cm, err := clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				err = nil
+			}
+			return nil, false, err
+		}
+		data := make(map[string][]byte, len(cm.Data))
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		return data, true, nil
+	default:
+		secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				err = nil
+			}
+			return nil, false, err
+		}
+		return secret.Data, true, nil
+	}
+}
+
+// fitTestLogs returns the logs with their total size limited to budget
+// bytes. Starting with the smallest logs, each log is given an equal share of
+// the remaining budget, and only the tail of the logs exceeding their share
+// is kept.
+func fitTestLogs(logs map[string]*TestLog, budget int) map[string]*TestLog {
+	names := make([]string, 0, len(logs))
+	for name := range logs {
+		names = append(names, name)
+		budget -= len(name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if a, b := len(logs[names[i]].Data), len(logs[names[j]].Data); a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+
+	fitted := make(map[string]*TestLog, len(logs))
+	for i, name := range names {
+		log := logs[name]
+		share := max(budget/(len(names)-i), 0)
+		if over := len(log.Data) - share; over > 0 {
+			log = &TestLog{Data: log.Data[over:], Truncated: true}
+		}
+		fitted[name] = log
+		budget -= len(log.Data)
+	}
+	return fitted
+}
+
+// releaseStorageOwner returns an OwnerReference to the object holding the
+// release version in the Helm storage, or nil if the driver does not store
+// releases in Kubernetes objects.
+func releaseStorageOwner(ctx context.Context, clientSet kubernetes.Interface, driver, namespace, releaseName string,
+	version int) (*metav1.OwnerReference, error) {
+	// This mirrors the key used by the Helm storage for a release version.
+	key := fmt.Sprintf("sh.helm.release.v1.%s.v%d", releaseName, version)
+
+	var obj metav1.Object
+	var kind string
+	switch driver {
+	case helmdriver.SecretsDriverName:
+		secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, key, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		obj, kind = secret, "Secret"
+	case helmdriver.ConfigMapsDriverName:
+		cm, err := clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, key, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		obj, kind = cm, "ConfigMap"
+	default:
+		return nil, nil
+	}
+	return &metav1.OwnerReference{
+		APIVersion: corev1.SchemeGroupVersion.String(),
+		Kind:       kind,
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+	}, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTestLogsOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    TestLogsOptions
+		wantErr bool
+	}{
+		{name: "secret", opts: TestLogsOptions{MaxSize: 1024, Kind: TestLogsKindSecret}},
+		{name: "configmap", opts: TestLogsOptions{Kind: TestLogsKindConfigMap}},
+		{name: "invalid kind", opts: TestLogsOptions{Kind: "Pod"}, wantErr: true},
+		{name: "negative size", opts: TestLogsOptions{MaxSize: -1, Kind: TestLogsKindSecret}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			err := tt.opts.Validate()
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+		})
+	}
+}
+
+func Test_tailWriter(t *testing.T) {
+	g := NewWithT(t)
+
+	w := &tailWriter{max: 8}
+	_, _ = fmt.Fprint(w, "abc")
+	g.Expect(string(w.buf)).To(Equal("abc"))
+	g.Expect(w.dropped).To(BeZero())
+
+	_, _ = fmt.Fprint(w, "defghij")
+	g.Expect(string(w.buf)).To(Equal("cdefghij"))
+	g.Expect(w.dropped).To(Equal(int64(2)))
+
+	_, _ = fmt.Fprint(w, "0123456789")
+	g.Expect(string(w.buf)).To(Equal("23456789"))
+	g.Expect(w.dropped).To(Equal(int64(12)))
+}
+
+func Test_fitTestLogs(t *testing.T) {
+	g := NewWithT(t)
+
+	logs := map[string]*TestLog{
+		"a": {Data: []byte("12")},
+		"b": {Data: []byte("0123456789")},
+		"c": {Data: []byte("abcdefghij"), Truncated: true},
+	}
+
+	// Within budget, the logs are returned as is.
+	g.Expect(fitTestLogs(logs, 25)).To(Equal(logs))
+
+	// The keys count against the budget, and the budget left by the small
+	// log is shared by the larger ones.
+	fitted := fitTestLogs(logs, 3+2+14)
+	g.Expect(fitted["a"]).To(Equal(&TestLog{Data: []byte("12")}))
+	g.Expect(fitted["b"]).To(Equal(&TestLog{Data: []byte("3456789"), Truncated: true}))
+	g.Expect(fitted["c"]).To(Equal(&TestLog{Data: []byte("defghij"), Truncated: true}))
+	g.Expect(logs["b"].Truncated).To(BeFalse())
+
+	// Without budget, the logs are emptied.
+	fitted = fitTestLogs(logs, 0)
+	g.Expect(fitted["b"].Data).To(BeEmpty())
+	g.Expect(fitted["b"].Truncated).To(BeTrue())
+}
+
+func TestStoreTestLogs(t *testing.T) {
+	logs := map[string]*TestLog{
+		"test-connection": {Data: []byte("connection refused\n"), Truncated: true},
+		"test-ok":         {Data: []byte("ok\n")},
+	}
+
+	t.Run("stores logs in a Secret owned by the release", func(t *testing.T) {
+		g := NewWithT(t)
+
+		clientSet := fake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sh.helm.release.v1.podinfo.v2",
+				Namespace: "storage",
+				UID:       types.UID("release-uid"),
+			},
+		})
+		opts := TestLogsOptions{MaxSize: 1024, Kind: TestLogsKindSecret}
+
+		refs, err := StoreTestLogs(context.TODO(), clientSet, opts, helmdriver.SecretsDriverName, "storage", "podinfo", 2, logs)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refs).To(HaveLen(2))
+		g.Expect(refs["test-connection"].Kind).To(Equal(TestLogsKindSecret))
+		g.Expect(refs["test-connection"].Namespace).To(Equal("storage"))
+		g.Expect(refs["test-connection"].Name).To(Equal("podinfo.v2.test-logs"))
+		g.Expect(refs["test-connection"].Key).To(Equal("test-connection"))
+		g.Expect(refs["test-connection"].Truncated).To(BeTrue())
+		g.Expect(refs["test-ok"].Truncated).To(BeFalse())
+
+		secret, err := clientSet.CoreV1().Secrets("storage").Get(context.TODO(), "podinfo.v2.test-logs", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(secret.Data).To(HaveKeyWithValue("test-connection", []byte("connection refused\n")))
+		g.Expect(secret.Labels).To(HaveKeyWithValue(TestLogsLabelKey, "podinfo"))
+		g.Expect(secret.OwnerReferences).To(HaveLen(1))
+		g.Expect(secret.OwnerReferences[0].Kind).To(Equal("Secret"))
+		g.Expect(secret.OwnerReferences[0].UID).To(Equal(types.UID("release-uid")))
+
+		// Storing the logs of some test hooks again merges them into the
+		// object.
+		refs, err = StoreTestLogs(context.TODO(), clientSet, opts, helmdriver.SecretsDriverName, "storage", "podinfo", 2,
+			map[string]*TestLog{"test-ok": {Data: []byte("retried\n")}})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refs).To(HaveLen(1))
+		g.Expect(refs).To(HaveKey("test-ok"))
+		secret, err = clientSet.CoreV1().Secrets("storage").Get(context.TODO(), "podinfo.v2.test-logs", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(secret.Data).To(Equal(map[string][]byte{
+			"test-connection": []byte("connection refused\n"),
+			"test-ok":         []byte("retried\n"),
+		}))
+		g.Expect(secret.OwnerReferences).To(HaveLen(1))
+	})
+
+	t.Run("stores logs in a ConfigMap without owner", func(t *testing.T) {
+		g := NewWithT(t)
+
+		clientSet := fake.NewSimpleClientset()
+		opts := TestLogsOptions{MaxSize: 1024, Kind: TestLogsKindConfigMap}
+
+		refs, err := StoreTestLogs(context.TODO(), clientSet, opts, helmdriver.MemoryDriverName, "storage", "podinfo", 1, logs)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refs["test-ok"].Kind).To(Equal(TestLogsKindConfigMap))
+
+		cm, err := clientSet.CoreV1().ConfigMaps("storage").Get(context.TODO(), "podinfo.v1.test-logs", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cm.Data).To(HaveKeyWithValue("test-ok", "ok\n"))
+		g.Expect(cm.OwnerReferences).To(BeEmpty())
+
+		// Storing the logs of some test hooks again merges them into the
+		// object.
+		_, err = StoreTestLogs(context.TODO(), clientSet, opts, helmdriver.MemoryDriverName, "storage", "podinfo", 1,
+			map[string]*TestLog{"test-connection": {Data: []byte("retried\n")}})
+		g.Expect(err).ToNot(HaveOccurred())
+		cm, err = clientSet.CoreV1().ConfigMaps("storage").Get(context.TODO(), "podinfo.v1.test-logs", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cm.Data).To(Equal(map[string]string{"test-connection": "retried\n", "test-ok": "ok\n"}))
+	})
+
+	t.Run("fails if release storage object does not exist", func(t *testing.T) {
+		g := NewWithT(t)
+
+		clientSet := fake.NewSimpleClientset()
+		opts := TestLogsOptions{MaxSize: 1024, Kind: TestLogsKindSecret}
+
+		_, err := StoreTestLogs(context.TODO(), clientSet, opts, helmdriver.SecretsDriverName, "storage", "podinfo", 1, logs)
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("no logs", func(t *testing.T) {
+		g := NewWithT(t)
+
+		refs, err := StoreTestLogs(context.TODO(), fake.NewSimpleClientset(), TestLogsOptions{Kind: TestLogsKindSecret},
+			helmdriver.SecretsDriverName, "storage", "podinfo", 1, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(refs).To(BeNil())
+	})
+}
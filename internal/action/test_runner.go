@@ -0,0 +1,308 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	apierrutil "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// testSelector selects the test hooks to run.
+type testSelector struct {
+	include     map[string]struct{}
+	exclude     map[string]struct{}
+	labels      labels.Selector
+	annotations labels.Selector
+}
+
+// newTestSelector returns a testSelector for the name filters of the Helm
+// test action, and the selectors of the given test configuration.
+func newTestSelector(filters map[string][]string, test v2.Test) (*testSelector, error) {
+	s := &testSelector{
+		include: make(map[string]struct{}),
+		exclude: make(map[string]struct{}),
+	}
+	for _, n := range filters[helmaction.IncludeNameFilter] {
+		s.include[n] = struct{}{}
+	}
+	for _, n := range filters[helmaction.ExcludeNameFilter] {
+		s.exclude[n] = struct{}{}
+	}
+
+	var err error
+	if test.Selector != nil {
+		if s.labels, err = metav1.LabelSelectorAsSelector(test.Selector); err != nil {
+			return nil, fmt.Errorf("invalid test selector: %w", err)
+		}
+	}
+	if test.AnnotationSelector != nil {
+		if s.annotations, err = metav1.LabelSelectorAsSelector(test.AnnotationSelector); err != nil {
+			return nil, fmt.Errorf("invalid test annotation selector: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Select returns the test hooks of the release which are selected, in order
+// of their weight and name.
+func (s *testSelector) Select(rls *helmrelease.Release) ([]*helmrelease.Hook, error) {
+	var hooks []*helmrelease.Hook
+	for _, h := range rls.Hooks {
+		if !isTestHook(h) {
+			continue
+		}
+		if _, ok := s.exclude[h.Name]; ok {
+			continue
+		}
+		if _, ok := s.include[h.Name]; len(s.include) > 0 && !ok {
+			continue
+		}
+		if s.labels != nil || s.annotations != nil {
+			var obj metav1.PartialObjectMetadata
+			if err := yaml.Unmarshal([]byte(h.Manifest), &obj); err != nil {
+				return nil, fmt.Errorf("unable to decode metadata of test hook %s: %w", h.Name, err)
+			}
+			if s.labels != nil && !s.labels.Matches(labels.Set(obj.Labels)) {
+				continue
+			}
+			if s.annotations != nil && !s.annotations.Matches(labels.Set(obj.Annotations)) {
+				continue
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].Weight == hooks[j].Weight {
+			return hooks[i].Name < hooks[j].Name
+		}
+		return hooks[i].Weight < hooks[j].Weight
+	})
+	return hooks, nil
+}
+
+// isTestHook returns true if the hook fires on the test event.
+func isTestHook(h *helmrelease.Hook) bool {
+	for _, e := range h.Events {
+		if e == helmrelease.HookTest {
+			return true
+		}
+	}
+	return false
+}
+
+// testRunner runs test hooks the way Helm does, with the addition that test
+// hooks of the same weight can be run concurrently, and that the remaining
+// test hooks can be run after a failure.
+type testRunner struct {
+	config            *helmaction.Configuration
+	timeout           time.Duration
+	parallelism       int
+	continueOnFailure bool
+
+	// mu guards the hooks of the release while it is recorded in the
+	// storage.
+	mu sync.Mutex
+}
+
+// Run runs the given test hooks of the release, and records the results in
+// the storage. It returns an aggregate of the errors of the failed hooks.
+func (r *testRunner) Run(rls *helmrelease.Release, hooks []*helmrelease.Hook) error {
+	var errs []error
+	for i := 0; i < len(hooks); {
+		// Collect the hooks of the same weight.
+		j := i + 1
+		for j < len(hooks) && hooks[j].Weight == hooks[i].Weight {
+			j++
+		}
+		errs = append(errs, r.runConcurrently(rls, hooks[i:j])...)
+		if len(errs) > 0 && !r.continueOnFailure {
+			break
+		}
+		i = j
+	}
+
+	// Like Helm, only delete the hooks with a hook-succeeded delete policy
+	// if all hooks succeeded.
+	if len(errs) == 0 {
+		for _, h := range hooks {
+			if err := r.deleteHookByPolicy(h, helmrelease.HookSucceeded); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.config.Releases.Update(rls); err != nil {
+		errs = append(errs, err)
+	}
+	return apierrutil.NewAggregate(errs)
+}
+
+// runConcurrently runs the hooks with at most parallelism hooks at a time.
+// Unless continueOnFailure is set, no further hooks are started after a
+// hook failed.
+func (r *testRunner) runConcurrently(rls *helmrelease.Release, hooks []*helmrelease.Hook) []error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, max(r.parallelism, 1))
+	)
+	for _, h := range hooks {
+		sem <- struct{}{}
+
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed && !r.continueOnFailure {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(h *helmrelease.Hook) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := r.runHook(rls, h); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+	return errs
+}
+
+// runHook runs a single test hook, mirroring the execution of hooks by Helm.
+func (r *testRunner) runHook(rls *helmrelease.Release, h *helmrelease.Hook) error {
+	r.mu.Lock()
+	if len(h.DeletePolicies) == 0 {
+		h.DeletePolicies = []helmrelease.HookDeletePolicy{helmrelease.HookBeforeHookCreation}
+	}
+	r.mu.Unlock()
+
+	if err := r.deleteHookByPolicy(h, helmrelease.HookBeforeHookCreation); err != nil {
+		return err
+	}
+
+	resources, err := r.config.KubeClient.Build(bytes.NewBufferString(h.Manifest), true)
+	if err != nil {
+		return fmt.Errorf("unable to build kubernetes object for %s hook %s: %w", helmrelease.HookTest, h.Path, err)
+	}
+
+	// Record the time at which the hook was applied to the cluster.
+	r.record(rls, func() {
+		h.LastRun = helmrelease.HookExecution{
+			StartedAt: helmtime.Now(),
+			Phase:     helmrelease.HookPhaseRunning,
+		}
+	})
+	r.setPhase(h, helmrelease.HookPhaseUnknown, false)
+
+	if _, err := r.config.KubeClient.Create(resources); err != nil {
+		r.setPhase(h, helmrelease.HookPhaseFailed, true)
+		return fmt.Errorf("warning: Hook %s %s failed: %w", helmrelease.HookTest, h.Path, err)
+	}
+
+	if err = r.config.KubeClient.WatchUntilReady(resources, r.timeout); err != nil {
+		r.setPhase(h, helmrelease.HookPhaseFailed, true)
+		if err := r.deleteHookByPolicy(h, helmrelease.HookFailed); err != nil {
+			return err
+		}
+		return err
+	}
+	r.setPhase(h, helmrelease.HookPhaseSucceeded, true)
+	return nil
+}
+
+// record runs fn while holding the lock, and records the release in the
+// storage.
+func (r *testRunner) record(rls *helmrelease.Release, fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn()
+	if err := r.config.Releases.Update(rls); err != nil && r.config.Log != nil {
+		r.config.Log("warning: Failed to update release %s: %s", rls.Name, err)
+	}
+}
+
+// setPhase sets the phase of the last run of the hook, and optionally its
+// completion time.
+func (r *testRunner) setPhase(h *helmrelease.Hook, phase helmrelease.HookPhase, completed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if completed {
+		h.LastRun.CompletedAt = helmtime.Now()
+	}
+	h.LastRun.Phase = phase
+}
+
+// deleteHookByPolicy deletes the resources of the hook if it has the given
+// delete policy.
+func (r *testRunner) deleteHookByPolicy(h *helmrelease.Hook, policy helmrelease.HookDeletePolicy) error {
+	// Never delete CustomResourceDefinitions, as this could cause cascading
+	// garbage collection.
+	if h.Kind == "CustomResourceDefinition" {
+		return nil
+	}
+
+	r.mu.Lock()
+	hasPolicy := false
+	for _, p := range h.DeletePolicies {
+		if p == policy {
+			hasPolicy = true
+			break
+		}
+	}
+	r.mu.Unlock()
+	if !hasPolicy {
+		return nil
+	}
+
+	resources, err := r.config.KubeClient.Build(bytes.NewBufferString(h.Manifest), false)
+	if err != nil {
+		return fmt.Errorf("unable to build kubernetes object for deleting hook %s: %w", h.Path, err)
+	}
+	if _, errs := r.config.KubeClient.Delete(resources); len(errs) > 0 {
+		return apierrutil.NewAggregate(errs)
+	}
+	// Wait for the resources to be deleted to avoid conflicts.
+	if kubeClient, ok := r.config.KubeClient.(helmkube.InterfaceExt); ok {
+		if err := kubeClient.WaitForDelete(resources, r.timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
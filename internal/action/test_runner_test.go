@@ -0,0 +1,291 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	helmaction "helm.sh/helm/v3/pkg/action"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	helmstorage "helm.sh/helm/v3/pkg/storage"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/testutil"
+)
+
+// testHookKubeClient is a Helm Kubernetes client which records the test
+// hooks run, and fails the test hooks with the configured names.
+type testHookKubeClient struct {
+	*buildingKubeClient
+
+	fail map[string]bool
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+	run       []string
+	deleted   []string
+}
+
+func (c *testHookKubeClient) WatchUntilReady(resources helmkube.ResourceList, _ time.Duration) error {
+	c.mu.Lock()
+	c.active++
+	c.maxActive = max(c.maxActive, c.active)
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	for _, r := range resources {
+		c.run = append(c.run, r.Name)
+		if c.fail[r.Name] {
+			return fmt.Errorf("pod %s failed", r.Name)
+		}
+	}
+	return nil
+}
+
+func (c *testHookKubeClient) Delete(resources helmkube.ResourceList) (*helmkube.Result, []error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range resources {
+		c.deleted = append(c.deleted, r.Name)
+	}
+	return &helmkube.Result{Deleted: resources}, nil
+}
+
+func testHook(name string, weight int, labels string, policies ...helmrelease.HookDeletePolicy) *helmrelease.Hook {
+	return &helmrelease.Hook{
+		Name:   name,
+		Kind:   "Pod",
+		Path:   "templates/" + name + ".yaml",
+		Weight: weight,
+		Events: []helmrelease.HookEvent{helmrelease.HookTest},
+		Manifest: fmt.Sprintf("apiVersion: v1\nkind: Pod\nmetadata:\n  name: %s\n  namespace: apps\n  labels: {%s}\n",
+			name, labels),
+		DeletePolicies: policies,
+	}
+}
+
+func TestTest_runner(t *testing.T) {
+	const (
+		namespace   = "apps"
+		releaseName = "release"
+	)
+
+	run := func(t *testing.T, hooks []*helmrelease.Hook, fail []string, spec v2.Test) (*testHookKubeClient, *helmrelease.Release, error) {
+		t.Helper()
+		g := NewWithT(t)
+
+		kubeClient := &testHookKubeClient{buildingKubeClient: newBuildingKubeClient(), fail: map[string]bool{}}
+		for _, n := range fail {
+			kubeClient.fail[n] = true
+		}
+		config := &helmaction.Configuration{
+			Releases:   helmstorage.Init(helmdriver.NewMemory()),
+			KubeClient: kubeClient,
+			Log:        func(string, ...interface{}) {},
+		}
+		g.Expect(config.Releases.Create(&helmrelease.Release{
+			Name:      releaseName,
+			Namespace: namespace,
+			Version:   1,
+			Info:      &helmrelease.Info{Status: helmrelease.StatusDeployed},
+			Chart:     testutil.BuildChart(),
+			Hooks:     hooks,
+		})).To(Succeed())
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: releaseName, Namespace: namespace},
+			Spec:       v2.HelmReleaseSpec{ReleaseName: releaseName, Test: &spec},
+		}
+		rls, err := Test(context.TODO(), config, obj)
+
+		stored, sErr := config.Releases.Get(releaseName, 1)
+		g.Expect(sErr).ToNot(HaveOccurred())
+		if rls != nil {
+			g.Expect(stored.Hooks).To(HaveLen(len(rls.Hooks)))
+		}
+		return kubeClient, stored, err
+	}
+	phases := func(rls *helmrelease.Release) map[string]helmrelease.HookPhase {
+		p := make(map[string]helmrelease.HookPhase)
+		for _, h := range rls.Hooks {
+			p[h.Name] = h.LastRun.Phase
+		}
+		return p
+	}
+
+	t.Run("selects test hooks by label and annotation", func(t *testing.T) {
+		g := NewWithT(t)
+
+		annotated := testHook("annotated", 0, "suite: smoke")
+		annotated.Manifest += "  annotations:\n    example.com/tier: fast\n"
+		hooks := []*helmrelease.Hook{
+			testHook("smoke", 0, "suite: smoke"),
+			testHook("integration", 0, "suite: integration"),
+			annotated,
+		}
+
+		_, rls, err := run(t, hooks, nil, v2.Test{
+			Enable: true,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"suite": "smoke"},
+			},
+			AnnotationSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"example.com/tier": "fast"},
+			},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(phases(rls)).To(Equal(map[string]helmrelease.HookPhase{
+			"smoke":       "",
+			"integration": "",
+			"annotated":   helmrelease.HookPhaseSucceeded,
+		}))
+	})
+
+	t.Run("applies name filters with selector", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hooks := []*helmrelease.Hook{
+			testHook("smoke-a", 0, "suite: smoke"),
+			testHook("smoke-b", 0, "suite: smoke"),
+		}
+		_, rls, err := run(t, hooks, nil, v2.Test{
+			Enable:   true,
+			Filters:  &[]v2.Filter{{Name: "smoke-b", Exclude: true}},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"suite": "smoke"}},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(phases(rls)).To(Equal(map[string]helmrelease.HookPhase{
+			"smoke-a": helmrelease.HookPhaseSucceeded,
+			"smoke-b": "",
+		}))
+	})
+
+	t.Run("stops at first failure", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hooks := []*helmrelease.Hook{
+			testHook("a", 0, ""),
+			testHook("b", 1, ""),
+		}
+		client, rls, err := run(t, hooks, []string{"a"}, v2.Test{
+			Enable:   true,
+			Selector: &metav1.LabelSelector{},
+		})
+		g.Expect(err).To(MatchError(ContainSubstring("pod a failed")))
+		g.Expect(client.run).To(Equal([]string{"a"}))
+		g.Expect(phases(rls)).To(Equal(map[string]helmrelease.HookPhase{
+			"a": helmrelease.HookPhaseFailed,
+			"b": "",
+		}))
+	})
+
+	t.Run("continues on failure", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hooks := []*helmrelease.Hook{
+			testHook("a", 0, ""),
+			testHook("b", 1, ""),
+			testHook("c", 2, ""),
+		}
+		client, rls, err := run(t, hooks, []string{"a", "b"}, v2.Test{
+			Enable:            true,
+			ContinueOnFailure: true,
+		})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("pod a failed"))
+		g.Expect(err.Error()).To(ContainSubstring("pod b failed"))
+		g.Expect(client.run).To(Equal([]string{"a", "b", "c"}))
+		g.Expect(phases(rls)).To(Equal(map[string]helmrelease.HookPhase{
+			"a": helmrelease.HookPhaseFailed,
+			"b": helmrelease.HookPhaseFailed,
+			"c": helmrelease.HookPhaseSucceeded,
+		}))
+	})
+
+	t.Run("runs hooks of the same weight concurrently", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hooks := []*helmrelease.Hook{
+			testHook("a", 0, ""),
+			testHook("b", 0, ""),
+			testHook("c", 0, ""),
+			testHook("d", 0, ""),
+			testHook("last", 1, ""),
+		}
+		client, rls, err := run(t, hooks, nil, v2.Test{
+			Enable:      true,
+			Parallelism: 2,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(client.maxActive).To(Equal(2))
+		g.Expect(client.run).To(HaveLen(5))
+		g.Expect(client.run[4]).To(Equal("last"))
+		for _, p := range phases(rls) {
+			g.Expect(p).To(Equal(helmrelease.HookPhaseSucceeded))
+		}
+	})
+
+	t.Run("deletes hooks by policy", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hooks := []*helmrelease.Hook{
+			testHook("succeeded", 0, "", helmrelease.HookSucceeded),
+			testHook("failed", 0, "", helmrelease.HookFailed),
+			testHook("default", 0, ""),
+		}
+		client, _, err := run(t, hooks, []string{"failed"}, v2.Test{
+			Enable:            true,
+			ContinueOnFailure: true,
+		})
+		g.Expect(err).To(HaveOccurred())
+		// The default policy deletes the hook before creation, the hook
+		// with a hook-succeeded policy is retained as a hook failed.
+		g.Expect(client.deleted).To(ConsistOf("default", "failed"))
+
+		client, _, err = run(t, hooks[:1], nil, v2.Test{
+			Enable:      true,
+			Parallelism: 2,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(client.deleted).To(ConsistOf("succeeded"))
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, _, err := run(t, []*helmrelease.Hook{testHook("a", 0, "")}, nil, v2.Test{
+			Enable: true,
+			Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "suite", Operator: "Invalid"},
+			}},
+		})
+		g.Expect(err).To(MatchError(ContainSubstring("invalid test selector")))
+	})
+}
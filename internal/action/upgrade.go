@@ -27,7 +27,6 @@ import (
 
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/helm-controller/internal/features"
-	"github.com/fluxcd/helm-controller/internal/postrender"
 	"github.com/fluxcd/helm-controller/internal/release"
 )
 
@@ -41,7 +40,9 @@ type UpgradeOption func(upgrade *helmaction.Upgrade)
 // and upgrade configuration.
 //
 // It performs the upgrade according to the spec, which includes upgrading the
-// CRDs according to the defined policy.
+// CRDs according to the defined policy. When health checks are configured,
+// the health of the selected objects is assessed after the upgrade, and the
+// release is marked as failed if they do not become healthy.
 //
 // It does not determine if there is a desire to perform the action, this is
 // expected to be done by the caller. In addition, it does not take note of the
@@ -55,11 +56,22 @@ func Upgrade(ctx context.Context, config *helmaction.Configuration, obj *v2.Helm
 	if err != nil {
 		return nil, err
 	}
+	if err := checkCRDPermissions(upgrade.PostRenderer, policy, chrt); err != nil {
+		return nil, err
+	}
 	if err := applyCRDs(config, policy, chrt, setOriginVisitor(v2.GroupVersion.Group, obj.Namespace, obj.Name)); err != nil {
 		return nil, fmt.Errorf("failed to apply CustomResourceDefinitions: %w", err)
 	}
 
-	return upgrade.RunWithContext(ctx, release.ShortenName(obj.GetReleaseName()), chrt, vals.AsMap())
+	startRender(upgrade.PostRenderer)
+	rls, err := upgrade.RunWithContext(ctx, release.ShortenName(obj.GetReleaseName()), chrt, vals.AsMap())
+	if err != nil || upgrade.DryRun {
+		return rls, err
+	}
+	if err = assessHealth(ctx, config, obj, rls, upgrade.Timeout); err != nil {
+		return rls, failRelease(config, rls, err)
+	}
+	return rls, nil
 }
 
 func newUpgrade(config *helmaction.Configuration, obj *v2.HelmRelease, opts []UpgradeOption) *helmaction.Upgrade {
@@ -82,7 +94,7 @@ func newUpgrade(config *helmaction.Configuration, obj *v2.HelmRelease, opts []Up
 		upgrade.EnableDNS = allowDNS
 	}
 
-	upgrade.PostRenderer = postrender.BuildPostRenderers(obj)
+	upgrade.PostRenderer = limitedPostRenderer(obj)
 
 	for _, opt := range opts {
 		opt(upgrade)
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth provides oauth2.TokenSource implementations which obtain
+// short-lived credentials for remote Kubernetes clusters from cloud
+// providers and the Kubernetes TokenRequest API, using the workload identity
+// of the controller.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultHTTPTimeout is the timeout of the HTTP client used to request
+// tokens when no client is configured.
+const DefaultHTTPTimeout = 30 * time.Second
+
+// defaultHTTPClient is used to request tokens when no client is configured.
+var defaultHTTPClient = &http.Client{Timeout: DefaultHTTPTimeout}
+
+// httpClientOrDefault returns the given client, or the default client if it
+// is nil.
+func httpClientOrDefault(c *http.Client) *http.Client {
+	if c == nil {
+		return defaultHTTPClient
+	}
+	return c
+}
+
+// accessTokenResponse is the OAuth 2.0 access token response returned by
+// the Azure and GCP token endpoints.
+type accessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// doAccessTokenRequest performs the given request, and decodes the access
+// token from the response.
+func doAccessTokenRequest(ctx context.Context, c *http.Client, req *http.Request, now time.Time) (*oauth2.Token, error) {
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request to '%s' failed with status %d: %s", req.URL.Host, resp.StatusCode, string(b))
+	}
+
+	var r accessTokenResponse
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if r.AccessToken == "" {
+		return nil, fmt.Errorf("token response from '%s' does not contain an access token", req.URL.Host)
+	}
+	return &oauth2.Token{
+		AccessToken: r.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      now.Add(time.Duration(r.ExpiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// awsTokenPrefix is the prefix of a bearer token accepted by EKS
+	// clusters.
+	awsTokenPrefix = "k8s-aws-v1."
+	// awsClusterIDHeader is the signed header which binds the token to a
+	// specific EKS cluster.
+	awsClusterIDHeader = "x-k8s-aws-id"
+	// awsPresignExpiresHeader is the header used to set X-Amz-Expires in the
+	// presigned URL.
+	awsPresignExpiresHeader = "X-Amz-Expires"
+	// awsPresignExpires is the value of X-Amz-Expires in the presigned URL,
+	// as expected by EKS.
+	awsPresignExpires = "60"
+	// awsTokenLifetime is the duration EKS accepts a token for after it has
+	// been signed, with a margin.
+	awsTokenLifetime = 14 * time.Minute
+)
+
+// AWSTokenSource returns bearer tokens for EKS clusters, which consist of a
+// presigned STS GetCallerIdentity request. The request is signed using the
+// credentials of the default credential chain of the AWS SDK, which
+// includes static credentials, IAM Roles for Service Accounts and EKS Pod
+// Identity credentials from the environment.
+type AWSTokenSource struct {
+	ctx         context.Context
+	cluster     string
+	region      string
+	credentials aws.CredentialsProvider
+	presign     *sts.PresignClient
+	now         func() time.Time
+}
+
+// NewAWSTokenSource returns a new AWSTokenSource for the given EKS cluster,
+// which can either be the name or the ARN of the cluster. When a name is
+// given, the region is taken from the AWS SDK configuration, e.g. the
+// AWS_REGION environment variable.
+func NewAWSTokenSource(ctx context.Context, cluster string) (*AWSTokenSource, error) {
+	s := &AWSTokenSource{
+		ctx:     ctx,
+		cluster: cluster,
+		now:     time.Now,
+	}
+	if strings.HasPrefix(cluster, "arn:") {
+		// arn:<partition>:eks:<region>:<account>:cluster/<name>
+		parts := strings.SplitN(cluster, ":", 6)
+		if len(parts) != 6 || parts[2] != "eks" || !strings.HasPrefix(parts[5], "cluster/") {
+			return nil, fmt.Errorf("invalid EKS cluster ARN '%s'", cluster)
+		}
+		s.region = parts[3]
+		s.cluster = strings.TrimPrefix(parts[5], "cluster/")
+	}
+	if s.cluster == "" {
+		return nil, fmt.Errorf("EKS cluster name is required")
+	}
+
+	loadOpts := []func(*config.LoadOptions) error{
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(DefaultHTTPTimeout)),
+	}
+	if s.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(s.region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("AWS region is not configured: provide a cluster ARN, or set the AWS_REGION environment variable")
+	}
+	s.region = cfg.Region
+	s.credentials = cfg.Credentials
+	s.presign = sts.NewPresignClient(sts.NewFromConfig(cfg))
+	return s, nil
+}
+
+// Token returns a new EKS bearer token.
+func (s *AWSTokenSource) Token() (*oauth2.Token, error) {
+	if s.credentials == nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: no credentials provider configured")
+	}
+	// The credentials are cached by the provider, and retrieved here to
+	// determine their expiry.
+	creds, err := s.credentials.Retrieve(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+
+	now := s.now()
+	presigned, err := s.presign.PresignGetCallerIdentity(s.ctx, &sts.GetCallerIdentityInput{},
+		sts.WithPresignClientFromClientOptions(func(o *sts.Options) {
+			o.APIOptions = append(o.APIOptions,
+				smithyhttp.SetHeaderValue(awsClusterIDHeader, s.cluster),
+				smithyhttp.SetHeaderValue(awsPresignExpiresHeader, awsPresignExpires),
+			)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign STS request: %w", err)
+	}
+
+	expiry := now.Add(awsTokenLifetime)
+	if creds.CanExpire && creds.Expires.Before(expiry) {
+		expiry = creds.Expires
+	}
+	return &oauth2.Token{
+		AccessToken: awsTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned.URL)),
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
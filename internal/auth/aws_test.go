@@ -0,0 +1,264 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// setAWSTestEnv isolates the AWS SDK configuration from the environment of
+// the test, and disables the EC2 instance metadata credentials.
+func setAWSTestEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{
+		"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_SESSION_NAME",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "AWS_PROFILE", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS", "AWS_CA_BUNDLE",
+	} {
+		t.Setenv(env, "")
+	}
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestNewAWSTokenSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		cluster     string
+		region      string
+		wantCluster string
+		wantRegion  string
+		wantErr     string
+	}{
+		{
+			name:        "cluster name with region from environment",
+			cluster:     "my-cluster",
+			region:      "us-west-2",
+			wantCluster: "my-cluster",
+			wantRegion:  "us-west-2",
+		},
+		{
+			name:        "cluster ARN",
+			cluster:     "arn:aws:eks:eu-central-1:123456789012:cluster/my-cluster",
+			region:      "us-west-2",
+			wantCluster: "my-cluster",
+			wantRegion:  "eu-central-1",
+		},
+		{
+			name:    "invalid ARN",
+			cluster: "arn:aws:s3:::bucket",
+			wantErr: "invalid EKS cluster ARN",
+		},
+		{
+			name:    "missing region",
+			cluster: "my-cluster",
+			wantErr: "AWS region is not configured",
+		},
+		{
+			name:    "missing cluster",
+			region:  "us-west-2",
+			wantErr: "EKS cluster name is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			setAWSTestEnv(t)
+			t.Setenv("AWS_REGION", tt.region)
+
+			s, err := NewAWSTokenSource(context.TODO(), tt.cluster)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(s.cluster).To(Equal(tt.wantCluster))
+			g.Expect(s.region).To(Equal(tt.wantRegion))
+		})
+	}
+}
+
+func TestAWSTokenSource_Token(t *testing.T) {
+	decodeToken := func(g *WithT, token string) *url.URL {
+		g.Expect(token).To(HavePrefix(awsTokenPrefix))
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, awsTokenPrefix))
+		g.Expect(err).ToNot(HaveOccurred())
+		u, err := url.Parse(string(b))
+		g.Expect(err).ToNot(HaveOccurred())
+		return u
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t.Run("static credentials", func(t *testing.T) {
+		g := NewWithT(t)
+		setAWSTestEnv(t)
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+		s, err := NewAWSTokenSource(context.TODO(), "arn:aws:eks:us-east-1:123456789012:cluster/my-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		s.now = func() time.Time { return now }
+
+		tok, err := s.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tok.Expiry).To(Equal(now.Add(awsTokenLifetime)))
+
+		u := decodeToken(g, tok.AccessToken)
+		g.Expect(u.Host).To(Equal("sts.us-east-1.amazonaws.com"))
+		q := u.Query()
+		g.Expect(q.Get("Action")).To(Equal("GetCallerIdentity"))
+		g.Expect(q.Get("X-Amz-Credential")).To(HavePrefix("AKID/"))
+		g.Expect(q.Get("X-Amz-Expires")).To(Equal(awsPresignExpires))
+		g.Expect(q.Get("X-Amz-SignedHeaders")).To(ContainSubstring(awsClusterIDHeader))
+		g.Expect(q.Has("X-Amz-Security-Token")).To(BeFalse())
+
+		// The signature is bound to the cluster.
+		other, err := NewAWSTokenSource(context.TODO(), "arn:aws:eks:us-east-1:123456789012:cluster/other-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		otherTok, err := other.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(decodeToken(g, otherTok.AccessToken).Query().Get("X-Amz-Signature")).ToNot(Equal(q.Get("X-Amz-Signature")))
+	})
+
+	t.Run("China partition", func(t *testing.T) {
+		g := NewWithT(t)
+		setAWSTestEnv(t)
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+		s, err := NewAWSTokenSource(context.TODO(), "arn:aws-cn:eks:cn-north-1:123456789012:cluster/my-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		tok, err := s.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(decodeToken(g, tok.AccessToken).Host).To(Equal("sts.cn-north-1.amazonaws.com.cn"))
+	})
+
+	t.Run("web identity credentials", func(t *testing.T) {
+		g := NewWithT(t)
+		setAWSTestEnv(t)
+
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		g.Expect(os.WriteFile(tokenFile, []byte("web-identity-token"), 0o600)).To(Succeed())
+		t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/helm-controller")
+		t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+
+		credsExpiry := now.Add(5 * time.Minute)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			g.Expect(r.ParseForm()).To(Succeed())
+			g.Expect(r.Form.Get("Action")).To(Equal("AssumeRoleWithWebIdentity"))
+			g.Expect(r.Form.Get("RoleArn")).To(Equal("arn:aws:iam::123456789012:role/helm-controller"))
+			g.Expect(r.Form.Get("WebIdentityToken")).To(Equal("web-identity-token"))
+			w.Header().Set("Content-Type", "text/xml")
+			_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIA</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>` + credsExpiry.Format(time.RFC3339) + `</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+		}))
+		t.Cleanup(server.Close)
+		t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+
+		s, err := NewAWSTokenSource(context.TODO(), "arn:aws:eks:us-east-1:123456789012:cluster/my-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		s.now = func() time.Time { return now }
+
+		tok, err := s.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		// The token does not outlive the credentials it is signed with.
+		g.Expect(tok.Expiry).To(BeTemporally("==", credsExpiry))
+
+		u := decodeToken(g, tok.AccessToken)
+		g.Expect(u.Query().Get("X-Amz-Credential")).To(HavePrefix("ASIA/"))
+		g.Expect(u.Query().Get("X-Amz-Security-Token")).To(Equal("session"))
+	})
+
+	t.Run("container credentials", func(t *testing.T) {
+		g := NewWithT(t)
+		setAWSTestEnv(t)
+
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		g.Expect(os.WriteFile(tokenFile, []byte("pod-identity-token"), 0o600)).To(Succeed())
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			g.Expect(r.Header.Get("Authorization")).To(Equal("pod-identity-token"))
+			_, _ = w.Write([]byte(`{"AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"session","Expiration":"2030-01-01T00:00:00Z"}`))
+		}))
+		t.Cleanup(server.Close)
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL)
+		t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+		s, err := NewAWSTokenSource(context.TODO(), "arn:aws:eks:us-east-1:123456789012:cluster/my-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		s.now = func() time.Time { return now }
+
+		tok, err := s.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tok.Expiry).To(Equal(now.Add(awsTokenLifetime)))
+		g.Expect(decodeToken(g, tok.AccessToken).Query().Get("X-Amz-Security-Token")).To(Equal("session"))
+	})
+
+	t.Run("STS error", func(t *testing.T) {
+		g := NewWithT(t)
+		setAWSTestEnv(t)
+
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		g.Expect(os.WriteFile(tokenFile, []byte("web-identity-token"), 0o600)).To(Succeed())
+		t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/helm-controller")
+		t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+		}))
+		t.Cleanup(server.Close)
+		t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+		t.Setenv("AWS_REGION", "us-east-1")
+
+		s, err := NewAWSTokenSource(context.TODO(), "my-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = s.Token()
+		g.Expect(err).To(MatchError(ContainSubstring("StatusCode: 403")))
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		g := NewWithT(t)
+		setAWSTestEnv(t)
+
+		s, err := NewAWSTokenSource(context.TODO(), "arn:aws:eks:us-east-1:123456789012:cluster/my-cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = s.Token()
+		g.Expect(err).To(MatchError(ContainSubstring("failed to get AWS credentials")))
+	})
+}
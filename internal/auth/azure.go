@@ -0,0 +1,134 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// azureDefaultAuthorityHost is the default Microsoft Entra ID authority.
+	azureDefaultAuthorityHost = "https://login.microsoftonline.com/"
+	// azureAKSScope is the scope of the well-known Microsoft Entra ID server
+	// application used by AKS clusters.
+	azureAKSScope = "6dae42f8-4368-4678-94ff-3960e28e3630/.default"
+
+	// The environment variables injected by the Azure Workload Identity
+	// webhook.
+	azureClientIDEnv           = "AZURE_CLIENT_ID"
+	azureTenantIDEnv           = "AZURE_TENANT_ID"
+	azureFederatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+	azureAuthorityHostEnv      = "AZURE_AUTHORITY_HOST"
+)
+
+// AzureTokenSource returns access tokens for AKS clusters by exchanging the
+// federated ServiceAccount token of the workload, as configured by Azure
+// Workload Identity.
+type AzureTokenSource struct {
+	ctx       context.Context
+	client    *http.Client
+	authority string
+	clientID  string
+	tenantID  string
+	tokenFile string
+	now       func() time.Time
+}
+
+// AzureOption configures an AzureTokenSource.
+type AzureOption func(*AzureTokenSource)
+
+// WithAzureHTTPClient configures the HTTP client used to request tokens.
+func WithAzureHTTPClient(c *http.Client) AzureOption {
+	return func(s *AzureTokenSource) {
+		s.client = c
+	}
+}
+
+// WithAzureAuthorityHost configures the Microsoft Entra ID authority, e.g.
+// 'https://login.microsoftonline.com/'.
+func WithAzureAuthorityHost(host string) AzureOption {
+	return func(s *AzureTokenSource) {
+		s.authority = host
+	}
+}
+
+// NewAzureTokenSource returns a new AzureTokenSource configured from the
+// environment variables injected by the Azure Workload Identity webhook.
+// It returns an error if any of them is missing.
+func NewAzureTokenSource(ctx context.Context, opts ...AzureOption) (*AzureTokenSource, error) {
+	s := &AzureTokenSource{
+		ctx:       ctx,
+		authority: azureDefaultAuthorityHost,
+		clientID:  os.Getenv(azureClientIDEnv),
+		tenantID:  os.Getenv(azureTenantIDEnv),
+		tokenFile: os.Getenv(azureFederatedTokenFileEnv),
+		now:       time.Now,
+	}
+	if h := os.Getenv(azureAuthorityHostEnv); h != "" {
+		s.authority = h
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	for _, env := range []struct{ name, value string }{
+		{azureClientIDEnv, s.clientID},
+		{azureTenantIDEnv, s.tenantID},
+		{azureFederatedTokenFileEnv, s.tokenFile},
+	} {
+		if env.value == "" {
+			return nil, fmt.Errorf("Azure Workload Identity is not configured: %s environment variable is not set", env.name)
+		}
+	}
+	return s, nil
+}
+
+// Token exchanges the federated token for a new access token.
+func (s *AzureTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Azure federated token: %w", err)
+	}
+
+	form := url.Values{
+		"client_id":             {s.clientID},
+		"scope":                 {azureAKSScope},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	endpoint := strings.TrimSuffix(s.authority, "/") + "/" + url.PathEscape(s.tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	tok, err := doAccessTokenRequest(s.ctx, httpClientOrDefault(s.client), req, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Azure access token: %w", err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewAzureTokenSource(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Setenv(azureClientIDEnv, "client-id")
+	t.Setenv(azureTenantIDEnv, "")
+	t.Setenv(azureFederatedTokenFileEnv, "/var/run/secrets/azure/tokens/azure-identity-token")
+
+	_, err := NewAzureTokenSource(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring(azureTenantIDEnv + " environment variable is not set")))
+}
+
+func TestAzureTokenSource_Token(t *testing.T) {
+	g := NewWithT(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	g.Expect(os.WriteFile(tokenFile, []byte("federated-token"), 0o600)).To(Succeed())
+	t.Setenv(azureClientIDEnv, "client-id")
+	t.Setenv(azureTenantIDEnv, "tenant-id")
+	t.Setenv(azureFederatedTokenFileEnv, tokenFile)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.URL.Path).To(Equal("/tenant-id/oauth2/v2.0/token"))
+		g.Expect(r.ParseForm()).To(Succeed())
+		g.Expect(r.Form.Get("client_id")).To(Equal("client-id"))
+		g.Expect(r.Form.Get("scope")).To(Equal(azureAKSScope))
+		g.Expect(r.Form.Get("client_assertion")).To(Equal("federated-token"))
+		_, _ = w.Write([]byte(`{"token_type":"Bearer","expires_in":3600,"access_token":"eyJ0eXAi"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	s, err := NewAzureTokenSource(context.TODO(), WithAzureAuthorityHost(server.URL+"/"))
+	g.Expect(err).ToNot(HaveOccurred())
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	tok, err := s.Token()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tok.AccessToken).To(Equal("eyJ0eXAi"))
+	g.Expect(tok.Expiry).To(Equal(now.Add(time.Hour)))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// gcpMetadataHost is the default host of the GCE metadata server.
+	gcpMetadataHost = "metadata.google.internal"
+	// gcpMetadataHostEnv is the environment variable which overrides the
+	// host of the GCE metadata server.
+	gcpMetadataHostEnv = "GCE_METADATA_HOST"
+	// gcpTokenPath is the path of the metadata server endpoint returning an
+	// access token for the default service account of the workload.
+	gcpTokenPath = "/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCPTokenSource returns access tokens for the Google Cloud service account
+// of the workload from the GCE metadata server, as configured by e.g. GKE
+// Workload Identity.
+type GCPTokenSource struct {
+	ctx      context.Context
+	client   *http.Client
+	endpoint string
+	now      func() time.Time
+}
+
+// GCPOption configures a GCPTokenSource.
+type GCPOption func(*GCPTokenSource)
+
+// WithGCPHTTPClient configures the HTTP client used to request tokens.
+func WithGCPHTTPClient(c *http.Client) GCPOption {
+	return func(s *GCPTokenSource) {
+		s.client = c
+	}
+}
+
+// WithGCPEndpoint configures the base URL of the metadata server, e.g.
+// 'http://metadata.google.internal'.
+func WithGCPEndpoint(endpoint string) GCPOption {
+	return func(s *GCPTokenSource) {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// NewGCPTokenSource returns a new GCPTokenSource. The metadata server host
+// defaults to the value of the GCE_METADATA_HOST environment variable, or
+// metadata.google.internal.
+func NewGCPTokenSource(ctx context.Context, opts ...GCPOption) *GCPTokenSource {
+	host := gcpMetadataHost
+	if h := os.Getenv(gcpMetadataHostEnv); h != "" {
+		host = h
+	}
+	s := &GCPTokenSource{
+		ctx:      ctx,
+		endpoint: "http://" + host,
+		now:      time.Now,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Token returns a new access token from the metadata server.
+func (s *GCPTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, s.endpoint+gcpTokenPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	tok, err := doAccessTokenRequest(s.ctx, httpClientOrDefault(s.client), req, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GCP access token: %w", err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestGCPTokenSource_Token(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("returns access token", func(t *testing.T) {
+		g := NewWithT(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			g.Expect(r.URL.Path).To(Equal(gcpTokenPath))
+			g.Expect(r.Header.Get("Metadata-Flavor")).To(Equal("Google"))
+			_, _ = w.Write([]byte(`{"access_token":"ya29.token","expires_in":3599,"token_type":"Bearer"}`))
+		}))
+		t.Cleanup(server.Close)
+
+		s := NewGCPTokenSource(context.TODO(), WithGCPEndpoint(server.URL))
+		s.now = func() time.Time { return now }
+
+		tok, err := s.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tok.AccessToken).To(Equal("ya29.token"))
+		g.Expect(tok.Expiry).To(Equal(now.Add(3599 * time.Second)))
+	})
+
+	t.Run("uses metadata host from environment", func(t *testing.T) {
+		g := NewWithT(t)
+
+		t.Setenv(gcpMetadataHostEnv, "127.0.0.1:8080")
+		g.Expect(NewGCPTokenSource(context.TODO()).endpoint).To(Equal("http://127.0.0.1:8080"))
+	})
+
+	t.Run("returns error on failed request", func(t *testing.T) {
+		g := NewWithT(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		}))
+		t.Cleanup(server.Close)
+
+		_, err := NewGCPTokenSource(context.TODO(), WithGCPEndpoint(server.URL)).Token()
+		g.Expect(err).To(MatchError(ContainSubstring("failed with status 404")))
+	})
+
+	t.Run("returns error on empty token", func(t *testing.T) {
+		g := NewWithT(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"expires_in":3599}`))
+		}))
+		t.Cleanup(server.Close)
+
+		_, err := NewGCPTokenSource(context.TODO(), WithGCPEndpoint(server.URL)).Token()
+		g.Expect(err).To(MatchError(ContainSubstring("does not contain an access token")))
+	})
+}
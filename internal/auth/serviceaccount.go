@@ -0,0 +1,82 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultServiceAccountTokenLifetime is the lifetime of the ServiceAccount
+// tokens requested by a ServiceAccountTokenSource.
+const DefaultServiceAccountTokenLifetime = time.Hour
+
+// ServiceAccountTokenSource returns short-lived tokens of a ServiceAccount
+// using the TokenRequest API. It allows authenticating to remote clusters
+// which trust the issuer of the cluster the controller runs in.
+type ServiceAccountTokenSource struct {
+	ctx            context.Context
+	client         client.Client
+	serviceAccount types.NamespacedName
+	audiences      []string
+	lifetime       time.Duration
+}
+
+// NewServiceAccountTokenSource returns a new ServiceAccountTokenSource for
+// the given ServiceAccount, requesting tokens for the given audiences.
+func NewServiceAccountTokenSource(ctx context.Context, c client.Client, serviceAccount types.NamespacedName, audiences []string) *ServiceAccountTokenSource {
+	return &ServiceAccountTokenSource{
+		ctx:            ctx,
+		client:         c,
+		serviceAccount: serviceAccount,
+		audiences:      audiences,
+		lifetime:       DefaultServiceAccountTokenLifetime,
+	}
+}
+
+// Token requests a new token for the ServiceAccount.
+func (s *ServiceAccountTokenSource) Token() (*oauth2.Token, error) {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.serviceAccount.Namespace,
+			Name:      s.serviceAccount.Name,
+		},
+	}
+	expirationSeconds := int64(s.lifetime.Seconds())
+	tr := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         s.audiences,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+	if err := s.client.SubResource("token").Create(s.ctx, sa, tr); err != nil {
+		return nil, fmt.Errorf("failed to request token for ServiceAccount '%s': %w", s.serviceAccount, err)
+	}
+	return &oauth2.Token{
+		AccessToken: tr.Status.Token,
+		TokenType:   "Bearer",
+		Expiry:      tr.Status.ExpirationTimestamp.Time,
+	}, nil
+}
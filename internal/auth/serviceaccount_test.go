@@ -0,0 +1,74 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestServiceAccountTokenSource_Token(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	t.Run("requests token", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				g.Expect(subResourceName).To(Equal("token"))
+				g.Expect(obj.GetNamespace()).To(Equal("team"))
+				g.Expect(obj.GetName()).To(Equal("deployer"))
+
+				tr := subResource.(*authenticationv1.TokenRequest)
+				g.Expect(tr.Spec.Audiences).To(Equal([]string{"https://remote.example.com"}))
+				g.Expect(*tr.Spec.ExpirationSeconds).To(Equal(int64(3600)))
+				tr.Status.Token = "sa-token"
+				tr.Status.ExpirationTimestamp = metav1.NewTime(expiry)
+				return nil
+			},
+		}).Build()
+
+		s := NewServiceAccountTokenSource(context.TODO(), c, types.NamespacedName{Namespace: "team", Name: "deployer"}, []string{"https://remote.example.com"})
+		tok, err := s.Token()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tok.AccessToken).To(Equal("sa-token"))
+		g.Expect(tok.Expiry).To(BeTemporally("==", expiry))
+	})
+
+	t.Run("returns error", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(context.Context, client.Client, string, client.Object, client.Object, ...client.SubResourceCreateOption) error {
+				return errors.New("forbidden")
+			},
+		}).Build()
+
+		_, err := NewServiceAccountTokenSource(context.TODO(), c, types.NamespacedName{Namespace: "team", Name: "deployer"}, nil).Token()
+		g.Expect(err).To(MatchError("failed to request token for ServiceAccount 'team/deployer': forbidden"))
+	})
+}
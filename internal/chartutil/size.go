@@ -0,0 +1,60 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartutil
+
+import (
+	"encoding/json"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// ChartSize returns the total size in bytes of the files of the chart and
+// its dependencies, as an estimate of the memory held by the loaded chart.
+func ChartSize(chrt *chart.Chart) int64 {
+	if chrt == nil {
+		return 0
+	}
+	var size int64
+	for _, f := range chrt.Raw {
+		size += int64(len(f.Data))
+	}
+	for _, dep := range chrt.Dependencies() {
+		size += ChartSize(dep)
+	}
+	return size
+}
+
+// ValuesSize returns the size in bytes of the JSON encoding of the values, as
+// an estimate of the memory held by the values. It returns 0 if the values
+// can not be encoded.
+func ValuesSize(values chartutil.Values) int64 {
+	var w countingWriter
+	if err := json.NewEncoder(&w).Encode(values); err != nil {
+		return 0
+	}
+	return int64(w)
+}
+
+// countingWriter is an io.Writer which counts the number of bytes written.
+type countingWriter int64
+
+// Write counts the number of bytes in p.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
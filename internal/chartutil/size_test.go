@@ -0,0 +1,55 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartutil
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestChartSize(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ChartSize(nil)).To(BeZero())
+
+	dep := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "dep"},
+		Raw:      []*chart.File{{Name: "Chart.yaml", Data: []byte("name: dep")}},
+	}
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "chart"},
+		Raw: []*chart.File{
+			{Name: "Chart.yaml", Data: []byte("name: chart")},
+			{Name: "values.yaml", Data: []byte("foo: bar")},
+		},
+	}
+	g.Expect(ChartSize(chrt)).To(Equal(int64(19)))
+
+	chrt.AddDependency(dep)
+	g.Expect(ChartSize(chrt)).To(Equal(int64(28)))
+}
+
+func TestValuesSize(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ValuesSize(nil)).To(Equal(int64(len("null\n"))))
+	g.Expect(ValuesSize(chartutil.Values{"foo": "bar"})).To(Equal(int64(len(`{"foo":"bar"}` + "\n"))))
+	g.Expect(ValuesSize(chartutil.Values{"fn": func() {}})).To(BeZero())
+}
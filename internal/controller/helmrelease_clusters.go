@@ -0,0 +1,580 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/chart"
+	helmloader "helm.sh/helm/v3/pkg/chart/loader"
+	helmchartutil "helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apierrutil "k8s.io/apimachinery/pkg/util/errors"
+	kuberecorder "k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aclv1 "github.com/fluxcd/pkg/apis/acl"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/acl"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"github.com/fluxcd/pkg/runtime/jitter"
+	"github.com/fluxcd/pkg/runtime/logger"
+	"github.com/fluxcd/pkg/runtime/patch"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/chartutil"
+	"github.com/fluxcd/helm-controller/internal/digest"
+	interrors "github.com/fluxcd/helm-controller/internal/errors"
+	"github.com/fluxcd/helm-controller/internal/features"
+	intreconcile "github.com/fluxcd/helm-controller/internal/reconcile"
+	"github.com/fluxcd/helm-controller/internal/tracing"
+)
+
+// clusterTarget is a remote cluster a HelmRelease is released to.
+type clusterTarget struct {
+	// name is the name of the cluster, equal to the name of the Secret
+	// containing its KubeConfig.
+	name string
+	// kubeConfig is the reference to the KubeConfig of the cluster.
+	kubeConfig meta.KubeConfigReference
+}
+
+// clusterResult is the result of the release for a clusterTarget.
+type clusterResult struct {
+	status *v2.ClusterReleaseStatus
+	err    error
+}
+
+// reconcileClusters runs the release for each target cluster of a
+// HelmRelease released to multiple clusters, and summarizes the results in
+// the status of the object.
+//
+// The clusters are processed in batches of the configured maximum
+// concurrency, in the order returned by getClusterTargets. With the Stop
+// failure policy, no further batches are started after the release failed
+// for a cluster. The release is uninstalled from clusters that are no longer
+// targeted. The intermediate release state of each cluster is persisted in
+// the status of the object using the patch helper while the rollout is in
+// progress.
+func (r *HelmReleaseReconciler) reconcileClusters(ctx context.Context, patchHelper *patch.SerialPatcher, obj *v2.HelmRelease,
+	loadedChart *chart.Chart, values helmchartutil.Values, revisionDigest string) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	targets, err := r.getClusterTargets(ctx, obj)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, v2.ClusterRolloutFailedReason, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// Uninstall the release from any clusters which are no longer targeted.
+	targeted := make(map[string]struct{}, len(targets))
+	for _, t := range targets {
+		targeted[t.name] = struct{}{}
+	}
+	if err = r.uninstallClusters(ctx, obj, targeted); err != nil {
+		log.Error(err, "failed to uninstall release from clusters which are no longer targeted")
+	}
+
+	// The release state is kept per cluster. If the object was previously
+	// released to a single cluster, we need to uninstall that release first.
+	// If we did not do this, the release would be orphaned.
+	if obj.Status.StorageNamespace != "" || len(obj.Status.History) > 0 {
+		log.Info("release is released to multiple clusters: running uninstall for single cluster release")
+		if err = r.uninstallSingleCluster(ctx, obj); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Reset the failure counts if the chart or values have changed.
+	reason, resetFailures := action.MustResetFailures(obj, loadedChart.Metadata, values)
+	if resetFailures {
+		log.V(logger.DebugLevel).Info(fmt.Sprintf("resetting failure count (%s)", reason))
+	}
+
+	// Set last attempt values.
+	obj.Status.LastAttemptedGeneration = obj.Generation
+	obj.Status.LastAttemptedRevision = loadedChart.Metadata.Version
+	obj.Status.LastAttemptedRevisionDigest = revisionDigest
+	obj.Status.LastAttemptedConfigDigest = chartutil.DigestValues(digest.Canonical, values).String()
+	obj.Status.LastAttemptedValuesChecksum = ""
+	obj.Status.LastReleaseRevision = 0
+
+	// Roll out the release across the clusters.
+	var (
+		results     = make([]clusterResult, len(targets))
+		concurrency = obj.Spec.Clusters.GetMaxConcurrency()
+		patcher     = &clusterPatcher{obj: obj, patchHelper: patchHelper, fieldManager: r.FieldManager}
+		stopped     bool
+	)
+	for start := 0; start < len(targets) && !stopped; start += concurrency {
+		end := min(start+concurrency, len(targets))
+
+		// Copy the objects of the batch before starting the release, as the
+		// status of the object is updated concurrently by the patcher.
+		cObjs := make([]*v2.HelmRelease, end-start)
+		for i := start; i < end; i++ {
+			cObjs[i-start] = clusterObject(obj, targets[i])
+		}
+
+		var wg sync.WaitGroup
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = r.reconcileCluster(ctx, patcher, cObjs[i-start], targets[i], loadedChart, values, resetFailures)
+			}(i)
+		}
+		wg.Wait()
+
+		if obj.Spec.Clusters.GetFailurePolicy() == v2.StopClusterFailurePolicy {
+			for i := start; i < end; i++ {
+				if results[i].err != nil && !errors.Is(results[i].err, intreconcile.ErrMustRequeue) {
+					stopped = true
+					break
+				}
+			}
+		}
+	}
+
+	// Record the results in the status.
+	var (
+		clusters     = make([]v2.ClusterReleaseStatus, 0, len(targets)+len(obj.Status.Clusters))
+		errs         []error
+		terminalOnly = true
+		requeue      bool
+	)
+	for i, t := range targets {
+		res := results[i]
+		if res.status == nil {
+			// The rollout stopped before reaching the cluster.
+			s := v2.ClusterReleaseStatus{Name: t.name, KubeConfig: t.kubeConfig}
+			if prev := obj.Status.GetCluster(t.name); prev != nil {
+				s = *prev.DeepCopy()
+				s.KubeConfig = t.kubeConfig
+			}
+			apimeta.SetStatusCondition(&s.Conditions, metav1.Condition{
+				Type:    meta.ReadyCondition,
+				Status:  metav1.ConditionUnknown,
+				Reason:  v2.ClusterRolloutFailedReason,
+				Message: "rollout stopped after release failed for another cluster",
+			})
+			clusters = append(clusters, s)
+			continue
+		}
+		clusters = append(clusters, *res.status)
+
+		switch {
+		case res.err == nil:
+		case errors.Is(res.err, intreconcile.ErrMustRequeue):
+			requeue = true
+		default:
+			if !interrors.IsOneOf(res.err, intreconcile.ErrExceededMaxRetries, intreconcile.ErrMissingRollbackTarget,
+				intreconcile.ErrManifestTooLarge) && !acl.IsAccessDenied(res.err) {
+				terminalOnly = false
+			}
+			errs = append(errs, fmt.Errorf("cluster '%s': %w", t.name, res.err))
+		}
+	}
+	// Retain the status of clusters which could not be uninstalled from.
+	for _, s := range obj.Status.Clusters {
+		if _, ok := targeted[s.Name]; !ok {
+			clusters = append(clusters, s)
+		}
+	}
+	obj.Status.Clusters = clusters
+	summarizeClusters(obj, len(targets))
+
+	if len(errs) > 0 {
+		err = apierrutil.NewAggregate(errs)
+		if terminalOnly && !requeue {
+			err = reconcile.TerminalError(err)
+		}
+		return ctrl.Result{}, err
+	}
+	if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return jitter.JitteredRequeueInterval(ctrl.Result{RequeueAfter: requeueAfter(obj)}), nil
+}
+
+// reconcileCluster runs the release for the given target cluster.
+//
+// The release is run for cObj, a copy of the object returned by
+// clusterObject with the KubeConfig of the target cluster and the release
+// state of the cluster from the status. Intermediate observations are
+// persisted using the patcher. The resulting release state of the cluster is
+// returned, together with any error.
+func (r *HelmReleaseReconciler) reconcileCluster(ctx context.Context, patcher *clusterPatcher, cObj *v2.HelmRelease,
+	target clusterTarget, loadedChart *chart.Chart, values helmchartutil.Values, resetFailures bool) clusterResult {
+	log := ctrl.LoggerFrom(ctx).WithValues("cluster", target.name)
+	ctx = ctrl.LoggerInto(ctx, log)
+	ctx, span := tracing.Start(ctx, "reconcile cluster", tracing.AttrCluster.String(target.name))
+
+	result := func(err error) clusterResult {
+		if errors.Is(err, intreconcile.ErrMustRequeue) {
+			tracing.End(span, nil)
+		} else {
+			tracing.End(span, err)
+		}
+		return clusterResult{status: clusterStatusFromObject(cObj, target), err: err}
+	}
+
+	getter, err := r.buildRESTClientGetter(ctx, cObj)
+	if err != nil {
+		if acl.IsAccessDenied(err) {
+			conditions.MarkFalse(cObj, meta.ReadyCondition, aclv1.AccessDeniedReason, err.Error())
+			return result(err)
+		}
+		conditions.MarkFalse(cObj, meta.ReadyCondition, "RESTClientError", err.Error())
+		return result(err)
+	}
+
+	// The chart may be mutated while it is released, copy it to allow the
+	// release to run concurrently for multiple clusters.
+	c, err := copyChart(loadedChart)
+	if err != nil {
+		conditions.MarkFalse(cObj, meta.ReadyCondition, "ChartMutateError", err.Error())
+		return result(err)
+	}
+
+	// If the release target configuration has changed, uninstall the
+	// previous release target first.
+	if reason, changed := action.ReleaseTargetChanged(cObj, c.Name()); changed {
+		log.Info(fmt.Sprintf("release target configuration changed (%s): running uninstall for current release", reason))
+		if err = r.reconcileUninstall(ctx, getter, cObj); err != nil && !errors.Is(err, intreconcile.ErrNoLatest) {
+			return result(err)
+		}
+		cObj.Status.ClearHistory()
+		cObj.Status.ClearFailures()
+		cObj.Status.StorageNamespace = ""
+		return result(intreconcile.ErrMustRequeue)
+	}
+
+	cObj.Status.StorageNamespace = cObj.GetStorageNamespace()
+	if resetFailures {
+		cObj.Status.ClearFailures()
+	}
+
+	cfg, err := action.NewConfigFactory(getter,
+		action.WithStorage(action.DefaultStorageDriver, cObj.Status.StorageNamespace),
+		action.WithStorageLog(action.NewDebugLog(log.V(logger.TraceLevel))),
+	)
+	if err != nil {
+		conditions.MarkFalse(cObj, meta.ReadyCondition, "FactoryError", err.Error())
+		return result(err)
+	}
+
+	recorder := &clusterEventRecorder{EventRecorder: r.EventRecorder, cluster: target.name}
+	permissionCheck, _ := features.Enabled(features.PreflightPermissionCheck)
+	err = intreconcile.NewAtomicRelease(nil, cfg, recorder, r.FieldManager,
+		intreconcile.WithPatchFunc(patcher.patchFunc(target)),
+		intreconcile.WithLocker(r.releaseLocker),
+		intreconcile.WithPermissionCheck(permissionCheck),
+		intreconcile.WithManifestLimits(r.manifestLimits.forNamespace(cObj.GetNamespace())),
+		intreconcile.WithValuesDelta(r.valuesDeltaChanges),
+		intreconcile.WithTestLogs(r.testLogs)).Reconcile(ctx, &intreconcile.Request{
+		Object: cObj,
+		Chart:  c,
+		Values: values,
+	})
+	return result(err)
+}
+
+// clusterPatcher persists the intermediate release state of target clusters
+// in the status of the object released to them. It is safe for concurrent
+// use by the releases of the clusters of a batch.
+type clusterPatcher struct {
+	mu           sync.Mutex
+	obj          *v2.HelmRelease
+	patchHelper  *patch.SerialPatcher
+	fieldManager string
+}
+
+// patchFunc returns an intreconcile.PatchFunc for the release to the given
+// target cluster. It records the release state of the object returned by
+// clusterObject in the status of the cluster, and copies its Reconciling
+// condition to the object to report the progress of the rollout, before
+// patching the object.
+func (p *clusterPatcher) patchFunc(target clusterTarget) intreconcile.PatchFunc {
+	return func(ctx context.Context, cObj *v2.HelmRelease) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		s := clusterStatusFromObject(cObj, target).DeepCopy()
+		if prev := p.obj.Status.GetCluster(target.name); prev != nil {
+			*prev = *s
+		} else {
+			p.obj.Status.Clusters = append(p.obj.Status.Clusters, *s)
+		}
+		if c := conditions.Get(cObj, meta.ReconcilingCondition); c != nil {
+			conditions.MarkReconciling(p.obj, c.Reason, "cluster '%s': %s", target.name, c.Message)
+		}
+
+		if p.patchHelper == nil {
+			return nil
+		}
+		return p.patchHelper.Patch(ctx, p.obj, patch.WithOwnedConditions{Conditions: intreconcile.OwnedConditions},
+			patch.WithFieldOwner(p.fieldManager))
+	}
+}
+
+// uninstallClusters uninstalls the release from the clusters in the status
+// of the object which are not in keep, and removes them from the status.
+// Clusters for which the uninstall fails remain in the status, and the
+// errors are returned as an aggregate.
+func (r *HelmReleaseReconciler) uninstallClusters(ctx context.Context, obj *v2.HelmRelease, keep map[string]struct{}) error {
+	var (
+		remaining = make([]v2.ClusterReleaseStatus, 0, len(obj.Status.Clusters))
+		errs      []error
+	)
+	for _, s := range obj.Status.Clusters {
+		if _, ok := keep[s.Name]; ok || s.StorageNamespace == "" {
+			if ok {
+				remaining = append(remaining, s)
+			}
+			continue
+		}
+
+		log := ctrl.LoggerFrom(ctx).WithValues("cluster", s.Name)
+		target := clusterTarget{name: s.Name, kubeConfig: s.KubeConfig}
+		cObj := clusterObject(obj, target)
+
+		getter, err := r.buildRESTClientGetter(ctx, cObj)
+		if err != nil {
+			if apierrors.IsNotFound(err) || acl.IsAccessDenied(err) {
+				// Without the KubeConfig, or with an impersonation which is
+				// not allowed, we cannot uninstall the release.
+				log.Error(err, "skipping Helm release uninstallation")
+				continue
+			}
+			remaining = append(remaining, s)
+			errs = append(errs, fmt.Errorf("cluster '%s': %w", s.Name, err))
+			continue
+		}
+
+		ctx := ctrl.LoggerInto(ctx, log)
+		if err = r.reconcileUninstall(ctx, getter, cObj); err != nil && !errors.Is(err, intreconcile.ErrNoLatest) {
+			remaining = append(remaining, *clusterStatusFromObject(cObj, target))
+			errs = append(errs, fmt.Errorf("cluster '%s': %w", s.Name, err))
+			continue
+		}
+		log.Info("uninstalled Helm release from cluster")
+	}
+	obj.Status.Clusters = remaining
+	return apierrutil.NewAggregate(errs)
+}
+
+// uninstallSingleCluster uninstalls the release of a previous single cluster
+// configuration of the HelmRelease, and clears the single cluster release
+// state from the status once the release has been uninstalled.
+//
+// As Spec.KubeConfig and Spec.Clusters are mutually exclusive, the release is
+// uninstalled from the cluster the controller runs in.
+func (r *HelmReleaseReconciler) uninstallSingleCluster(ctx context.Context, obj *v2.HelmRelease) error {
+	getter, err := r.buildRESTClientGetter(ctx, obj)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, "RESTClientError", err.Error())
+		return err
+	}
+	if err = r.reconcileUninstall(ctx, getter, obj); err != nil && !errors.Is(err, intreconcile.ErrNoLatest) {
+		return err
+	}
+	obj.Status.ClearHistory()
+	obj.Status.ClearFailures()
+	obj.Status.StorageNamespace = ""
+	obj.Status.LastAttemptedReleaseAction = ""
+	return nil
+}
+
+// getClusterTargets returns the target clusters of the HelmRelease, in the
+// order of Spec.Clusters.KubeConfigs, followed by the clusters selected by
+// Spec.Clusters.Selector in alphabetical order. Duplicate clusters are
+// ignored.
+func (r *HelmReleaseReconciler) getClusterTargets(ctx context.Context, obj *v2.HelmRelease) ([]clusterTarget, error) {
+	var (
+		targets []clusterTarget
+		seen    = make(map[string]struct{})
+	)
+	add := func(ref meta.KubeConfigReference) {
+		if _, ok := seen[ref.SecretRef.Name]; ok {
+			return
+		}
+		seen[ref.SecretRef.Name] = struct{}{}
+		targets = append(targets, clusterTarget{name: ref.SecretRef.Name, kubeConfig: ref})
+	}
+
+	for _, ref := range obj.Spec.Clusters.KubeConfigs {
+		add(ref)
+	}
+
+	if obj.Spec.Clusters.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(obj.Spec.Clusters.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster selector: %w", err)
+		}
+		var secrets corev1.SecretList
+		if err = r.Client.List(ctx, &secrets, client.InNamespace(obj.GetNamespace()),
+			client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("failed to list KubeConfig Secrets: %w", err)
+		}
+		sort.Slice(secrets.Items, func(i, j int) bool {
+			return secrets.Items[i].Name < secrets.Items[j].Name
+		})
+		for _, s := range secrets.Items {
+			add(meta.KubeConfigReference{SecretRef: meta.SecretKeyReference{Name: s.Name}})
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("no target clusters found")
+	}
+	return targets, nil
+}
+
+// clusterObject returns a copy of the object configured for the release to
+// the given target cluster, with the release state of the cluster from the
+// status of the object.
+func clusterObject(obj *v2.HelmRelease, target clusterTarget) *v2.HelmRelease {
+	cObj := obj.DeepCopy()
+	cObj.Spec.Clusters = nil
+	kubeConfig := target.kubeConfig
+	cObj.Spec.KubeConfig = &kubeConfig
+	cObj.Spec.KubeConfigProvider = nil
+
+	cObj.Status.Clusters = nil
+	cObj.Status.Conditions = nil
+	cObj.Status.StorageNamespace = ""
+	cObj.Status.History = nil
+	cObj.Status.LastAttemptedReleaseAction = ""
+	cObj.Status.ClearFailures()
+	if s := obj.Status.GetCluster(target.name); s != nil {
+		s = s.DeepCopy()
+		cObj.Status.Conditions = s.Conditions
+		cObj.Status.StorageNamespace = s.StorageNamespace
+		cObj.Status.History = s.History
+		cObj.Status.LastAttemptedReleaseAction = s.LastAttemptedReleaseAction
+		cObj.Status.Failures = s.Failures
+		cObj.Status.InstallFailures = s.InstallFailures
+		cObj.Status.UpgradeFailures = s.UpgradeFailures
+	}
+	return cObj
+}
+
+// clusterStatusFromObject returns the release state of the target cluster
+// from the status of an object returned by clusterObject.
+func clusterStatusFromObject(cObj *v2.HelmRelease, target clusterTarget) *v2.ClusterReleaseStatus {
+	return &v2.ClusterReleaseStatus{
+		Name:                       target.name,
+		KubeConfig:                 target.kubeConfig,
+		Conditions:                 cObj.Status.Conditions,
+		StorageNamespace:           cObj.Status.StorageNamespace,
+		History:                    cObj.Status.History,
+		LastAttemptedReleaseAction: cObj.Status.LastAttemptedReleaseAction,
+		Failures:                   cObj.Status.Failures,
+		InstallFailures:            cObj.Status.InstallFailures,
+		UpgradeFailures:            cObj.Status.UpgradeFailures,
+	}
+}
+
+// summarizeClusters summarizes the Ready conditions of the target clusters
+// into the Ready condition of the object, and removes any conditions which
+// only apply to the release of a single cluster.
+func summarizeClusters(obj *v2.HelmRelease, targets int) {
+	var (
+		ready    int
+		notReady []string
+	)
+	for _, s := range obj.Status.Clusters[:targets] {
+		if apimeta.IsStatusConditionTrue(s.Conditions, meta.ReadyCondition) {
+			ready++
+			continue
+		}
+		msg := "not ready"
+		if c := apimeta.FindStatusCondition(s.Conditions, meta.ReadyCondition); c != nil && c.Message != "" {
+			msg = c.Message
+		}
+		notReady = append(notReady, fmt.Sprintf("%s: %s", s.Name, msg))
+	}
+
+	for _, t := range []string{v2.ReleasedCondition, v2.TestSuccessCondition, v2.RemediatedCondition, meta.HealthyCondition} {
+		conditions.Delete(obj, t)
+	}
+	conditions.Delete(obj, meta.ReconcilingCondition)
+	conditions.Delete(obj, meta.StalledCondition)
+
+	if len(notReady) == 0 {
+		conditions.MarkTrue(obj, meta.ReadyCondition, v2.ClusterRolloutSucceededReason,
+			"Helm release is ready on %d cluster(s)", ready)
+		return
+	}
+	conditions.MarkFalse(obj, meta.ReadyCondition, v2.ClusterRolloutFailedReason,
+		"Helm release is ready on %d/%d cluster(s): %s", ready, targets, strings.Join(notReady, "; "))
+}
+
+// copyChart returns a copy of the chart, loaded from its raw files. Charts
+// without raw files are returned as is.
+func copyChart(c *chart.Chart) (*chart.Chart, error) {
+	if len(c.Raw) == 0 {
+		return c, nil
+	}
+	files := make([]*helmloader.BufferedFile, 0, len(c.Raw))
+	for _, f := range c.Raw {
+		files = append(files, &helmloader.BufferedFile{Name: f.Name, Data: f.Data})
+	}
+	cc, err := helmloader.LoadFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy chart: %w", err)
+	}
+	cc.Metadata.Version = c.Metadata.Version
+	return cc, nil
+}
+
+// clusterEventRecorder is a kuberecorder.EventRecorder which prefixes the
+// message of events with the name of the target cluster.
+type clusterEventRecorder struct {
+	kuberecorder.EventRecorder
+	cluster string
+}
+
+func (r *clusterEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, r.prefix(message))
+}
+
+func (r *clusterEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Event(object, eventtype, reason, r.prefix(fmt.Sprintf(messageFmt, args...)))
+}
+
+func (r *clusterEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", r.prefix(fmt.Sprintf(messageFmt, args...)))
+}
+
+func (r *clusterEventRecorder) prefix(message string) string {
+	return fmt.Sprintf("cluster '%s': %s", r.cluster, message)
+}
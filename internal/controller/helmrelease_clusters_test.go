@@ -0,0 +1,485 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	helmloader "helm.sh/helm/v3/pkg/chart/loader"
+	helmchartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	helmstorage "helm.sh/helm/v3/pkg/storage"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"github.com/fluxcd/pkg/runtime/patch"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/release"
+	"github.com/fluxcd/helm-controller/internal/testutil"
+)
+
+func TestHelmReleaseReconciler_getClusterTargets(t *testing.T) {
+	newSecret := func(name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "mock",
+				Labels:    labels,
+			},
+		}
+	}
+	kubeConfig := func(name, key string) meta.KubeConfigReference {
+		return meta.KubeConfigReference{SecretRef: meta.SecretKeyReference{Name: name, Key: key}}
+	}
+
+	tests := []struct {
+		name     string
+		clusters v2.ClusterTargets
+		secrets  []*corev1.Secret
+		want     []clusterTarget
+		wantErr  string
+	}{
+		{
+			name: "kubeConfigs in order",
+			clusters: v2.ClusterTargets{
+				KubeConfigs: []meta.KubeConfigReference{kubeConfig("b", "value.yaml"), kubeConfig("a", "")},
+			},
+			want: []clusterTarget{
+				{name: "b", kubeConfig: kubeConfig("b", "value.yaml")},
+				{name: "a", kubeConfig: kubeConfig("a", "")},
+			},
+		},
+		{
+			name: "selected Secrets in alphabetical order after kubeConfigs",
+			clusters: v2.ClusterTargets{
+				KubeConfigs: []meta.KubeConfigReference{kubeConfig("c", "")},
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			secrets: []*corev1.Secret{
+				newSecret("b", map[string]string{"env": "prod"}),
+				newSecret("a", map[string]string{"env": "prod"}),
+				newSecret("c", map[string]string{"env": "prod"}),
+				newSecret("d", map[string]string{"env": "staging"}),
+			},
+			want: []clusterTarget{
+				{name: "c", kubeConfig: kubeConfig("c", "")},
+				{name: "a", kubeConfig: kubeConfig("a", "")},
+				{name: "b", kubeConfig: kubeConfig("b", "")},
+			},
+		},
+		{
+			name: "no target clusters",
+			clusters: v2.ClusterTargets{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				},
+			},
+			secrets: []*corev1.Secret{
+				newSecret("a", map[string]string{"env": "staging"}),
+			},
+			wantErr: "no target clusters found",
+		},
+		{
+			name: "invalid selector",
+			clusters: v2.ClusterTargets{
+				Selector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "env", Operator: "invalid"},
+					},
+				},
+			},
+			wantErr: "invalid cluster selector",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := fake.NewClientBuilder().WithScheme(NewTestScheme())
+			for _, s := range tt.secrets {
+				c.WithObjects(s)
+			}
+			r := &HelmReleaseReconciler{Client: c.Build()}
+
+			obj := &v2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "release",
+					Namespace: "mock",
+				},
+				Spec: v2.HelmReleaseSpec{
+					Clusters: &tt.clusters,
+				},
+			}
+
+			got, err := r.getClusterTargets(context.TODO(), obj)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestHelmReleaseReconciler_reconcileClusters(t *testing.T) {
+	t.Run("uninstalls single cluster release before releasing to clusters", func(t *testing.T) {
+		g := NewWithT(t)
+
+		// Create a test namespace for storing the Helm release mock.
+		ns, err := testEnv.CreateNamespace(context.TODO(), "reconcile-clusters")
+		g.Expect(err).ToNot(HaveOccurred())
+		t.Cleanup(func() {
+			_ = testEnv.Delete(context.TODO(), ns)
+		})
+
+		// Create a test Helm release storage mock.
+		rls := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+			Name:      "reconcile-clusters",
+			Namespace: ns.Name,
+			Version:   1,
+			Chart:     testutil.BuildChart(),
+			Status:    helmrelease.StatusDeployed,
+		})
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "reconcile-clusters",
+				Namespace: ns.Name,
+			},
+			Spec: v2.HelmReleaseSpec{
+				Clusters: &v2.ClusterTargets{
+					KubeConfigs: []meta.KubeConfigReference{
+						{SecretRef: meta.SecretKeyReference{Name: "cluster-a"}},
+						{SecretRef: meta.SecretKeyReference{Name: "cluster-b"}},
+					},
+				},
+			},
+			Status: v2.HelmReleaseStatus{
+				StorageNamespace: ns.Name,
+				History: v2.Snapshots{
+					release.ObservedToSnapshot(release.ObserveRelease(rls)),
+				},
+				InstallFailures: 1,
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client:           testEnv.Client,
+			GetClusterConfig: GetTestClusterConfig,
+			EventRecorder:    record.NewFakeRecorder(32),
+		}
+
+		// Store the Helm release mock in the test namespace.
+		getter, err := r.buildRESTClientGetter(context.TODO(), obj)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		cfg, err := action.NewConfigFactory(getter, action.WithStorage(helmdriver.SecretsDriverName, obj.Status.StorageNamespace))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		store := helmstorage.Init(cfg.Driver)
+		g.Expect(store.Create(rls)).To(Succeed())
+
+		res, err := r.reconcileClusters(context.TODO(), nil, obj, testutil.BuildChart(), nil, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(res.Requeue).To(BeTrue())
+
+		// Verify the single cluster release state has been cleared.
+		g.Expect(obj.Status.StorageNamespace).To(BeEmpty())
+		g.Expect(obj.Status.History).To(BeNil())
+		g.Expect(obj.Status.InstallFailures).To(BeZero())
+		g.Expect(obj.Status.Clusters).To(BeEmpty())
+
+		// Verify Helm release has been uninstalled.
+		_, err = store.History(rls.Name)
+		g.Expect(err).To(MatchError(helmdriver.ErrReleaseNotFound))
+	})
+
+	t.Run("retains single cluster release state when uninstall fails", func(t *testing.T) {
+		g := NewWithT(t)
+
+		mockErr := errors.New("mock error")
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "reconcile-clusters",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				Clusters: &v2.ClusterTargets{
+					KubeConfigs: []meta.KubeConfigReference{
+						{SecretRef: meta.SecretKeyReference{Name: "cluster-a"}},
+					},
+				},
+			},
+			Status: v2.HelmReleaseStatus{
+				StorageNamespace: "mock",
+				History: v2.Snapshots{
+					{Name: "reconcile-clusters", Namespace: "mock", Version: 1},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: testEnv.Client,
+			GetClusterConfig: func() (*rest.Config, error) {
+				return nil, mockErr
+			},
+		}
+
+		_, err := r.reconcileClusters(context.TODO(), nil, obj, testutil.BuildChart(), nil, "")
+		g.Expect(err).To(MatchError(mockErr))
+
+		// Verify the single cluster release state has been retained.
+		g.Expect(obj.Status.StorageNamespace).To(Equal("mock"))
+		g.Expect(obj.Status.History.Latest()).ToNot(BeNil())
+		g.Expect(conditions.GetReason(obj, meta.ReadyCondition)).To(Equal("RESTClientError"))
+	})
+}
+
+func Test_clusterObject(t *testing.T) {
+	g := NewWithT(t)
+
+	target := clusterTarget{
+		name: "prod",
+		kubeConfig: meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{Name: "prod"},
+		},
+	}
+	obj := &v2.HelmRelease{
+		Spec: v2.HelmReleaseSpec{
+			Clusters: &v2.ClusterTargets{
+				KubeConfigs: []meta.KubeConfigReference{target.kubeConfig},
+			},
+		},
+		Status: v2.HelmReleaseStatus{
+			LastAttemptedRevision: "1.0.0",
+			Conditions: []metav1.Condition{
+				{Type: meta.ReadyCondition, Status: metav1.ConditionFalse},
+			},
+			Clusters: []v2.ClusterReleaseStatus{
+				{
+					Name:             "prod",
+					KubeConfig:       target.kubeConfig,
+					StorageNamespace: "default",
+					History: v2.Snapshots{
+						{Name: "release", Version: 1},
+					},
+					Conditions: []metav1.Condition{
+						{Type: meta.ReadyCondition, Status: metav1.ConditionTrue},
+					},
+					LastAttemptedReleaseAction: v2.ReleaseActionInstall,
+					InstallFailures:            1,
+				},
+			},
+		},
+	}
+
+	cObj := clusterObject(obj, target)
+	g.Expect(cObj.Spec.Clusters).To(BeNil())
+	g.Expect(cObj.Spec.KubeConfig).To(Equal(&target.kubeConfig))
+	g.Expect(cObj.Status.Clusters).To(BeNil())
+	g.Expect(cObj.Status.LastAttemptedRevision).To(Equal("1.0.0"))
+	g.Expect(conditions.IsTrue(cObj, meta.ReadyCondition)).To(BeTrue())
+	g.Expect(cObj.Status.StorageNamespace).To(Equal("default"))
+	g.Expect(cObj.Status.History).To(HaveLen(1))
+	g.Expect(cObj.Status.InstallFailures).To(Equal(int64(1)))
+
+	// Mutations of the copy must not affect the original object.
+	cObj.Status.History[0].Version = 2
+	g.Expect(obj.Status.Clusters[0].History[0].Version).To(Equal(1))
+
+	g.Expect(*clusterStatusFromObject(cObj, target)).To(Equal(v2.ClusterReleaseStatus{
+		Name:                       "prod",
+		KubeConfig:                 target.kubeConfig,
+		StorageNamespace:           "default",
+		History:                    cObj.Status.History,
+		Conditions:                 cObj.Status.Conditions,
+		LastAttemptedReleaseAction: v2.ReleaseActionInstall,
+		InstallFailures:            1,
+	}))
+
+	// A new cluster starts without release state.
+	cObj = clusterObject(obj, clusterTarget{name: "staging"})
+	g.Expect(cObj.Status.Conditions).To(BeEmpty())
+	g.Expect(cObj.Status.History).To(BeEmpty())
+	g.Expect(cObj.Status.StorageNamespace).To(BeEmpty())
+}
+
+func Test_clusterPatcher(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "release",
+			Namespace: "mock",
+		},
+		Status: v2.HelmReleaseStatus{
+			Clusters: []v2.ClusterReleaseStatus{
+				{Name: "prod", StorageNamespace: "default"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(NewTestScheme()).
+		WithStatusSubresource(&v2.HelmRelease{}).
+		WithObjects(obj.DeepCopy()).
+		Build()
+	p := &clusterPatcher{obj: obj, patchHelper: patch.NewSerialPatcher(obj, c)}
+
+	target := clusterTarget{name: "staging"}
+	cObj := clusterObject(obj, target)
+	cObj.Status.StorageNamespace = "staging"
+	conditions.MarkReconciling(cObj, meta.ProgressingReason, "running 'install' action")
+	g.Expect(p.patchFunc(target)(context.TODO(), cObj)).To(Succeed())
+
+	got := &v2.HelmRelease{}
+	g.Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(obj), got)).To(Succeed())
+	g.Expect(got.Status.Clusters).To(HaveLen(2))
+	g.Expect(got.Status.GetCluster("staging").StorageNamespace).To(Equal("staging"))
+	g.Expect(conditions.GetMessage(got, meta.ReconcilingCondition)).To(Equal("cluster 'staging': running 'install' action"))
+
+	// The status of a cluster is replaced on subsequent patches.
+	cObj.Status.StorageNamespace = "other"
+	g.Expect(p.patchFunc(target)(context.TODO(), cObj)).To(Succeed())
+	g.Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(obj), got)).To(Succeed())
+	g.Expect(got.Status.Clusters).To(HaveLen(2))
+	g.Expect(got.Status.GetCluster("staging").StorageNamespace).To(Equal("other"))
+	g.Expect(got.Status.GetCluster("prod").StorageNamespace).To(Equal("default"))
+}
+
+func Test_summarizeClusters(t *testing.T) {
+	ready := func(name string, status metav1.ConditionStatus, msg string) v2.ClusterReleaseStatus {
+		return v2.ClusterReleaseStatus{
+			Name: name,
+			Conditions: []metav1.Condition{
+				{Type: meta.ReadyCondition, Status: status, Message: msg},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		clusters   []v2.ClusterReleaseStatus
+		targets    int
+		wantStatus metav1.ConditionStatus
+		wantReason string
+		wantMsg    string
+	}{
+		{
+			name:       "all clusters ready",
+			clusters:   []v2.ClusterReleaseStatus{ready("a", metav1.ConditionTrue, ""), ready("b", metav1.ConditionTrue, "")},
+			targets:    2,
+			wantStatus: metav1.ConditionTrue,
+			wantReason: v2.ClusterRolloutSucceededReason,
+			wantMsg:    "Helm release is ready on 2 cluster(s)",
+		},
+		{
+			name:       "cluster not ready",
+			clusters:   []v2.ClusterReleaseStatus{ready("a", metav1.ConditionTrue, ""), ready("b", metav1.ConditionFalse, "install failed")},
+			targets:    2,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: v2.ClusterRolloutFailedReason,
+			wantMsg:    "Helm release is ready on 1/2 cluster(s): b: install failed",
+		},
+		{
+			name:       "ignores clusters which are no longer targeted",
+			clusters:   []v2.ClusterReleaseStatus{ready("a", metav1.ConditionTrue, ""), {Name: "b"}},
+			targets:    1,
+			wantStatus: metav1.ConditionTrue,
+			wantReason: v2.ClusterRolloutSucceededReason,
+			wantMsg:    "Helm release is ready on 1 cluster(s)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &v2.HelmRelease{
+				Status: v2.HelmReleaseStatus{
+					Clusters: tt.clusters,
+					Conditions: []metav1.Condition{
+						{Type: v2.ReleasedCondition, Status: metav1.ConditionTrue},
+						{Type: meta.ReconcilingCondition, Status: metav1.ConditionTrue},
+					},
+				},
+			}
+			summarizeClusters(obj, tt.targets)
+
+			g.Expect(conditions.Has(obj, v2.ReleasedCondition)).To(BeFalse())
+			g.Expect(conditions.Has(obj, meta.ReconcilingCondition)).To(BeFalse())
+			c := apimeta.FindStatusCondition(obj.Status.Conditions, meta.ReadyCondition)
+			g.Expect(c).ToNot(BeNil())
+			g.Expect(c.Status).To(Equal(tt.wantStatus))
+			g.Expect(c.Reason).To(Equal(tt.wantReason))
+			g.Expect(c.Message).To(Equal(tt.wantMsg))
+		})
+	}
+}
+
+func Test_copyChart(t *testing.T) {
+	g := NewWithT(t)
+
+	archive, err := helmchartutil.Save(testutil.BuildChart(), t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	loaded, err := helmloader.Load(archive)
+	g.Expect(err).ToNot(HaveOccurred())
+	loaded.Metadata.Version = "0.1.0+digest"
+
+	got, err := copyChart(loaded)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).ToNot(BeIdenticalTo(loaded))
+	g.Expect(got.Metadata).ToNot(BeIdenticalTo(loaded.Metadata))
+	g.Expect(got.Metadata.Version).To(Equal("0.1.0+digest"))
+	g.Expect(got.Templates).To(HaveLen(len(loaded.Templates)))
+
+	// Charts without raw files are returned as is.
+	c := &chart.Chart{Metadata: &chart.Metadata{Name: "chart"}}
+	got, err = copyChart(c)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(BeIdenticalTo(c))
+}
+
+func Test_clusterEventRecorder(t *testing.T) {
+	g := NewWithT(t)
+
+	recorder := record.NewFakeRecorder(3)
+	r := &clusterEventRecorder{EventRecorder: recorder, cluster: "prod"}
+	obj := &v2.HelmRelease{}
+
+	r.Event(obj, corev1.EventTypeNormal, "Reason", "message")
+	r.Eventf(obj, corev1.EventTypeNormal, "Reason", "message %d", 1)
+	r.AnnotatedEventf(obj, nil, corev1.EventTypeNormal, "Reason", "message %d", 2)
+
+	g.Expect(<-recorder.Events).To(Equal("Normal Reason cluster 'prod': message"))
+	g.Expect(<-recorder.Events).To(Equal("Normal Reason cluster 'prod': message 1"))
+	g.Expect(<-recorder.Events).To(Equal("Normal Reason cluster 'prod': message 2"))
+}
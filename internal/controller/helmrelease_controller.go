@@ -18,8 +18,11 @@ package controller
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -33,6 +36,7 @@ import (
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/rest"
 	kuberecorder "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -42,6 +46,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/Masterminds/semver"
 	aclv1 "github.com/fluxcd/pkg/apis/acl"
@@ -57,6 +62,8 @@ import (
 	"github.com/fluxcd/pkg/runtime/predicates"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
 	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/authn"
+	kauth "github.com/google/go-containerregistry/pkg/authn/kubernetes"
 
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 	intacl "github.com/fluxcd/helm-controller/internal/acl"
@@ -64,13 +71,19 @@ import (
 	"github.com/fluxcd/helm-controller/internal/chartutil"
 	"github.com/fluxcd/helm-controller/internal/digest"
 	interrors "github.com/fluxcd/helm-controller/internal/errors"
+	"github.com/fluxcd/helm-controller/internal/fairqueue"
 	"github.com/fluxcd/helm-controller/internal/features"
 	"github.com/fluxcd/helm-controller/internal/kube"
+	"github.com/fluxcd/helm-controller/internal/lease"
 	"github.com/fluxcd/helm-controller/internal/loader"
+	intmetrics "github.com/fluxcd/helm-controller/internal/metrics"
 	"github.com/fluxcd/helm-controller/internal/postrender"
 	intpredicates "github.com/fluxcd/helm-controller/internal/predicates"
 	intreconcile "github.com/fluxcd/helm-controller/internal/reconcile"
 	"github.com/fluxcd/helm-controller/internal/release"
+	"github.com/fluxcd/helm-controller/internal/shard"
+	"github.com/fluxcd/helm-controller/internal/signature"
+	"github.com/fluxcd/helm-controller/internal/tracing"
 )
 
 // +kubebuilder:rbac:groups=helm.toolkit.fluxcd.io,resources=helmreleases,verbs=get;list;watch;create;update;patch;delete
@@ -81,6 +94,9 @@ import (
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=ocirepositories,verbs=get;list;watch
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=ocirepositories/status,verbs=get
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
 
 // HelmReleaseReconciler reconciles a HelmRelease object.
 type HelmReleaseReconciler struct {
@@ -97,12 +113,37 @@ type HelmReleaseReconciler struct {
 
 	requeueDependency    time.Duration
 	artifactFetchRetries int
+	chartCache           *loader.ChartCache
+	maxChartSize         int64
+	releaseLocker        *lease.Locker
+	discoveryCache       *kube.DiscoveryCache
+	tokenSources         *kube.TokenSourceCache
+	eventRecorders       *kube.EventRecorderCache
+	sharder              shard.Sharder
+	queue                *fairqueue.Queue
+	testLogs             action.TestLogsOptions
+	manifestLimits       ManifestLimitsOptions
+	valuesDeltaChanges   int
 }
 
 type HelmReleaseReconcilerOptions struct {
 	HTTPRetry                 int
 	DependencyRequeueInterval time.Duration
 	RateLimiter               ratelimiter.RateLimiter
+	ChartCache                *loader.ChartCache
+	MaxChartSize              int64
+	ReleaseLocker             *lease.Locker
+	DiscoveryCache            *kube.DiscoveryCache
+	TokenSourceCache          *kube.TokenSourceCache
+	EventRecorderCache        *kube.EventRecorderCache
+	Sharder                   shard.Sharder
+	FairQueue                 *FairQueueOptions
+	TestLogs                  action.TestLogsOptions
+	ManifestLimits            ManifestLimitsOptions
+	// MaxValuesDeltaChanges is the maximum number of changes of the values
+	// recorded in the snapshot of an upgraded release, 0 disables recording
+	// them.
+	MaxValuesDeltaChanges int
 }
 
 var (
@@ -129,8 +170,18 @@ func (r *HelmReleaseReconciler) SetupWithManager(ctx context.Context, mgr ctrl.M
 
 	r.requeueDependency = opts.DependencyRequeueInterval
 	r.artifactFetchRetries = opts.HTTPRetry
-
-	return ctrl.NewControllerManagedBy(mgr).
+	r.chartCache = opts.ChartCache
+	r.maxChartSize = opts.MaxChartSize
+	r.releaseLocker = opts.ReleaseLocker
+	r.discoveryCache = opts.DiscoveryCache
+	r.tokenSources = opts.TokenSourceCache
+	r.eventRecorders = opts.EventRecorderCache
+	r.sharder = opts.Sharder
+	r.testLogs = opts.TestLogs
+	r.manifestLimits = opts.ManifestLimits
+	r.valuesDeltaChanges = opts.MaxValuesDeltaChanges
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&v2.HelmRelease{}, builder.WithPredicates(
 			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}),
 		)).
@@ -144,10 +195,40 @@ func (r *HelmReleaseReconciler) SetupWithManager(ctx context.Context, mgr ctrl.M
 			handler.EnqueueRequestsFromMapFunc(r.requestsForOCIRrepositoryChange),
 			builder.WithPredicates(intpredicates.SourceRevisionChangePredicate{}),
 		).
-		WithOptions(controller.Options{
-			RateLimiter: opts.RateLimiter,
-		}).
-		Complete(r)
+		WithOptions(r.controllerOptions(opts))
+
+	// Invalidate the shared discovery clients of the in-cluster config when
+	// CRDs change, as these may change the API resources of the cluster.
+	// The clients of other clusters rediscover the API resources when a kind
+	// can not be found.
+	if r.discoveryCache != nil {
+		cfg, err := r.GetClusterConfig()
+		if err != nil {
+			return fmt.Errorf("could not get in-cluster REST config: %w", err)
+		}
+		b = b.WatchesMetadata(crdMetadata(), discoveryInvalidator(r.discoveryCache, cfg.Host, time.Now()))
+	}
+
+	// Reconcile the HelmReleases which may have been reassigned to this
+	// replica when the members of the shard group change.
+	if m, ok := r.sharder.(*shard.Membership); ok {
+		b = b.WatchesRawSource(source.Channel(m.Changes(), handler.EnqueueRequestsFromMapFunc(r.requestsForShardChange)))
+	}
+
+	return b.Complete(r)
+}
+
+// controllerOptions returns the controller.Options for the given
+// HelmReleaseReconcilerOptions.
+func (r *HelmReleaseReconciler) controllerOptions(opts HelmReleaseReconcilerOptions) controller.Options {
+	q := r.newQueue(opts.FairQueue)
+	r.queue = q
+	return controller.Options{
+		RateLimiter: opts.RateLimiter,
+		NewQueue: func(name string, rateLimiter ratelimiter.RateLimiter) workqueue.RateLimitingInterface {
+			return q.NewRateLimitingQueue(name, rateLimiter)
+		},
+	}
 }
 
 func (r *HelmReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, retErr error) {
@@ -160,10 +241,21 @@ func (r *HelmReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Skip the object if it is owned by another shard of the controller.
+	if r.sharder != nil && !r.sharder.Owns(obj) {
+		return ctrl.Result{}, nil
+	}
+
 	if !isValidChartRef(obj) {
 		return ctrl.Result{}, reconcile.TerminalError(fmt.Errorf("invalid Chart reference"))
 	}
 
+	// Trace the reconciliation, the span ends after the object is patched.
+	ctx, span := tracing.Start(ctx, "reconcile HelmRelease", tracing.ObjectAttributes(obj)...)
+	defer func() {
+		tracing.End(span, retErr)
+	}()
+
 	// Initialize the patch helper with the current version of the object.
 	patchHelper := patch.NewSerialPatcher(obj, r.Client)
 
@@ -208,6 +300,11 @@ func (r *HelmReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		r.Metrics.RecordDuration(ctx, obj, start)
 	}()
 
+	// Label the object with the shard which owns it.
+	if r.sharder != nil {
+		setShardLabel(obj, r.sharder.Name())
+	}
+
 	// Examine if the object is under deletion.
 	if !obj.ObjectMeta.DeletionTimestamp.IsZero() {
 		return r.reconcileDelete(ctx, obj)
@@ -249,7 +346,10 @@ func (r *HelmReleaseReconciler) reconcileRelease(ctx context.Context, patchHelpe
 	if c := len(obj.Spec.DependsOn); c > 0 {
 		log.Info(fmt.Sprintf("checking %d dependencies", c))
 
-		if err := r.checkDependencies(ctx, obj); err != nil {
+		depCtx, span := tracing.Start(ctx, "check dependencies")
+		err := r.checkDependencies(depCtx, obj)
+		tracing.End(span, err)
+		if err != nil {
 			msg := fmt.Sprintf("dependencies do not meet ready condition (%s): retrying in %s",
 				err.Error(), r.requeueDependency.String())
 			conditions.MarkFalse(obj, meta.ReadyCondition, v2.DependencyNotReadyReason, err.Error())
@@ -268,45 +368,57 @@ func (r *HelmReleaseReconciler) reconcileRelease(ctx context.Context, patchHelpe
 		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
 	}
 
-	// Get the source object containing the HelmChart.
-	source, err := r.getSource(ctx, obj)
-	if err != nil {
-		if acl.IsAccessDenied(err) {
-			conditions.MarkStalled(obj, aclv1.AccessDeniedReason, err.Error())
-			conditions.MarkFalse(obj, meta.ReadyCondition, aclv1.AccessDeniedReason, err.Error())
-			conditions.Delete(obj, meta.ReconcilingCondition)
-			r.Eventf(obj, corev1.EventTypeWarning, aclv1.AccessDeniedReason, err.Error())
+	// Charts referenced using an OCIArtifact chartRef are pulled directly
+	// from the registry, and do not have a source object.
+	var (
+		source sourcev1.Source
+		err    error
+	)
+	if !obj.HasOCIArtifactRef() {
+		// Get the source object containing the HelmChart.
+		sourceCtx, span := tracing.Start(ctx, "get source")
+		source, err = r.getSource(sourceCtx, obj)
+		tracing.End(span, err)
+		if err != nil {
+			if acl.IsAccessDenied(err) {
+				conditions.MarkStalled(obj, aclv1.AccessDeniedReason, err.Error())
+				conditions.MarkFalse(obj, meta.ReadyCondition, aclv1.AccessDeniedReason, err.Error())
+				conditions.Delete(obj, meta.ReconcilingCondition)
+				r.Eventf(obj, corev1.EventTypeWarning, aclv1.AccessDeniedReason, err.Error())
+
+				// Recovering from this is not possible without a restart of the
+				// controller or a change of spec, both triggering a new
+				// reconciliation.
+				return ctrl.Result{}, reconcile.TerminalError(err)
+			}
 
-			// Recovering from this is not possible without a restart of the
-			// controller or a change of spec, both triggering a new
-			// reconciliation.
-			return ctrl.Result{}, reconcile.TerminalError(err)
+			msg := fmt.Sprintf("could not get Source object: %s", err.Error())
+			conditions.MarkFalse(obj, meta.ReadyCondition, v2.ArtifactFailedReason, msg)
+			return ctrl.Result{}, err
+		}
+		// Remove any stale corresponding Ready=False condition with Unknown.
+		if conditions.HasAnyReason(obj, meta.ReadyCondition, aclv1.AccessDeniedReason, v2.ArtifactFailedReason) {
+			conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
 		}
 
-		msg := fmt.Sprintf("could not get Source object: %s", err.Error())
-		conditions.MarkFalse(obj, meta.ReadyCondition, v2.ArtifactFailedReason, msg)
-		return ctrl.Result{}, err
-	}
-	// Remove any stale corresponding Ready=False condition with Unknown.
-	if conditions.HasAnyReason(obj, meta.ReadyCondition, aclv1.AccessDeniedReason, v2.ArtifactFailedReason) {
-		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
-	}
-
-	// Check if the source is ready.
-	if ready, msg := isSourceReady(source); !ready {
-		log.Info(msg)
-		conditions.MarkFalse(obj, meta.ReadyCondition, "SourceNotReady", msg)
-		// Do not requeue immediately, when the artifact is created
-		// the watcher should trigger a reconciliation.
-		return jitter.JitteredRequeueInterval(ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}), errWaitForChart
-	}
-	// Remove any stale corresponding Ready=False condition with Unknown.
-	if conditions.HasAnyReason(obj, meta.ReadyCondition, "SourceNotReady") {
-		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
+		// Check if the source is ready.
+		if ready, msg := isSourceReady(source); !ready {
+			log.Info(msg)
+			conditions.MarkFalse(obj, meta.ReadyCondition, "SourceNotReady", msg)
+			// Do not requeue immediately, when the artifact is created
+			// the watcher should trigger a reconciliation.
+			return jitter.JitteredRequeueInterval(ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}), errWaitForChart
+		}
+		// Remove any stale corresponding Ready=False condition with Unknown.
+		if conditions.HasAnyReason(obj, meta.ReadyCondition, "SourceNotReady") {
+			conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
+		}
 	}
 
 	// Compose values based from the spec and references.
-	values, err := chartutil.ChartValuesFromReferences(ctx, r.Client, obj.Namespace, obj.GetValues(), obj.Spec.ValuesFrom...)
+	valuesCtx, span := tracing.Start(ctx, "compose values")
+	values, err := chartutil.ChartValuesFromReferences(valuesCtx, r.Client, obj.Namespace, obj.GetValues(), obj.Spec.ValuesFrom...)
+	tracing.End(span, err)
 	if err != nil {
 		conditions.MarkFalse(obj, meta.ReadyCondition, "ValuesError", err.Error())
 		r.Eventf(obj, corev1.EventTypeWarning, "ValuesError", err.Error())
@@ -317,10 +429,53 @@ func (r *HelmReleaseReconciler) reconcileRelease(ctx context.Context, patchHelpe
 		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
 	}
 
-	// Load chart from artifact.
-	loadedChart, err := loader.SecureLoadChartFromURL(loader.NewRetryableHTTPClient(ctx, r.artifactFetchRetries), source.GetArtifact().URL, source.GetArtifact().Digest)
+	// Construct the function to verify the authenticity of the chart with.
+	verifyCtx, span := tracing.Start(ctx, "build chart verifier")
+	verifyFunc, err := r.buildChartVerifyFunc(verifyCtx, obj, source)
+	tracing.End(span, err)
 	if err != nil {
-		if errors.Is(err, loader.ErrFileNotFound) {
+		conditions.MarkFalse(obj, meta.ReadyCondition, v2.VerificationFailedReason, err.Error())
+		r.Eventf(obj, corev1.EventTypeWarning, v2.VerificationFailedReason, err.Error())
+		if errors.Is(err, errUnsupportedVerification) {
+			conditions.MarkStalled(obj, v2.VerificationFailedReason, err.Error())
+			conditions.Delete(obj, meta.ReconcilingCondition)
+
+			// Recovering from this is not possible without a change of
+			// spec, triggering a new reconciliation.
+			return ctrl.Result{}, reconcile.TerminalError(err)
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Load chart from artifact, or pull it from the OCI registry.
+	var (
+		loadedChart *chart.Chart
+		ociDigest   string
+	)
+	loadStart := time.Now()
+	loadCtx, span := tracing.Start(ctx, "load chart")
+	if obj.HasOCIArtifactRef() {
+		loadedChart, ociDigest, err = r.loadChartFromOCIArtifact(loadCtx, obj, loader.WithCache(r.chartCache),
+			loader.WithVerifyFunc(verifyFunc), loader.WithMaxChartSize(r.maxChartSize))
+	} else {
+		loadedChart, err = loader.SecureLoadChartFromURL(loader.NewRetryableHTTPClient(loadCtx, r.artifactFetchRetries),
+			source.GetArtifact().URL, source.GetArtifact().Digest, loader.WithCache(r.chartCache),
+			loader.WithVerifyFunc(verifyFunc), loader.WithMaxChartSize(r.maxChartSize))
+	}
+	intmetrics.RecordChartLoad(obj.GetNamespace(), obj.GetName(), time.Since(loadStart))
+	if err == nil {
+		span.SetAttributes(tracing.AttrChartName.String(loadedChart.Name()),
+			tracing.AttrChartVersion.String(loadedChart.Metadata.Version))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		if errors.Is(err, loader.ErrVerification) {
+			conditions.MarkFalse(obj, meta.ReadyCondition, v2.VerificationFailedReason, err.Error())
+			r.Eventf(obj, corev1.EventTypeWarning, v2.VerificationFailedReason, err.Error())
+			return ctrl.Result{}, err
+		}
+
+		if !obj.HasOCIArtifactRef() && errors.Is(err, loader.ErrFileNotFound) {
 			msg := fmt.Sprintf("Source not ready: artifact not found. Retrying in %s", r.requeueDependency.String())
 			conditions.MarkFalse(obj, meta.ReadyCondition, v2.ArtifactFailedReason, msg)
 			log.Info(msg)
@@ -332,24 +487,59 @@ func (r *HelmReleaseReconciler) reconcileRelease(ctx context.Context, patchHelpe
 		return ctrl.Result{}, err
 	}
 	// Remove any stale corresponding Ready=False condition with Unknown.
-	if conditions.HasAnyReason(obj, meta.ReadyCondition, v2.ArtifactFailedReason) {
+	if conditions.HasAnyReason(obj, meta.ReadyCondition, v2.ArtifactFailedReason, v2.VerificationFailedReason) {
 		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
 	}
 
-	ociDigest, err := mutateChartWithSourceRevision(loadedChart, source)
+	if obj.HasOCIArtifactRef() {
+		err = mutateChartWithDigest(loadedChart, ociDigest)
+	} else {
+		ociDigest, err = mutateChartWithSourceRevision(loadedChart, source)
+	}
 	if err != nil {
 		conditions.MarkFalse(obj, meta.ReadyCondition, "ChartMutateError", err.Error())
 		return ctrl.Result{}, err
 	}
 
+	// Record the estimated memory held by the chart and values.
+	intmetrics.RecordReleaseMemoryEstimate(obj.GetNamespace(), obj.GetName(), "chart", chartutil.ChartSize(loadedChart))
+	intmetrics.RecordReleaseMemoryEstimate(obj.GetNamespace(), obj.GetName(), "values", chartutil.ValuesSize(values))
+
+	// Release to multiple clusters, if configured.
+	if obj.HasClusters() {
+		return r.reconcileClusters(ctx, patchHelper, obj, loadedChart, values, ociDigest)
+	}
+
+	// Uninstall the release from the target clusters of any previous
+	// multiple clusters configuration.
+	if len(obj.Status.Clusters) > 0 {
+		if err := r.uninstallClusters(ctx, obj, nil); err != nil {
+			log.Error(err, "failed to uninstall release from clusters which are no longer targeted")
+		}
+	}
+
 	// Build the REST client getter.
-	getter, err := r.buildRESTClientGetter(ctx, obj)
+	getterCtx, span := tracing.Start(ctx, "build REST client getter")
+	getter, err := r.buildRESTClientGetter(getterCtx, obj)
+	tracing.End(span, err)
 	if err != nil {
+		if acl.IsAccessDenied(err) {
+			conditions.MarkStalled(obj, aclv1.AccessDeniedReason, err.Error())
+			conditions.MarkFalse(obj, meta.ReadyCondition, aclv1.AccessDeniedReason, err.Error())
+			conditions.Delete(obj, meta.ReconcilingCondition)
+			r.Eventf(obj, corev1.EventTypeWarning, aclv1.AccessDeniedReason, err.Error())
+
+			// Recovering from this is not possible without a restart of the
+			// controller or a change of spec, both triggering a new
+			// reconciliation.
+			return ctrl.Result{}, reconcile.TerminalError(err)
+		}
+
 		conditions.MarkFalse(obj, meta.ReadyCondition, "RESTClientError", err.Error())
 		return ctrl.Result{}, err
 	}
 	// Remove any stale corresponding Ready=False condition with Unknown.
-	if conditions.HasAnyReason(obj, meta.ReadyCondition, "RESTClientError") {
+	if conditions.HasAnyReason(obj, meta.ReadyCondition, "RESTClientError", aclv1.AccessDeniedReason) {
 		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
 	}
 
@@ -412,7 +602,14 @@ func (r *HelmReleaseReconciler) reconcileRelease(ctx context.Context, patchHelpe
 	}
 
 	// Off we go!
-	if err = intreconcile.NewAtomicRelease(patchHelper, cfg, r.EventRecorder, r.FieldManager).Reconcile(ctx, &intreconcile.Request{
+	permissionCheck, _ := features.Enabled(features.PreflightPermissionCheck)
+	defer recordReleaseInfo(obj)
+	if err = intreconcile.NewAtomicRelease(patchHelper, cfg, r.EventRecorder, r.FieldManager,
+		intreconcile.WithLocker(r.releaseLocker),
+		intreconcile.WithPermissionCheck(permissionCheck),
+		intreconcile.WithManifestLimits(r.manifestLimits.forNamespace(obj.GetNamespace())),
+		intreconcile.WithValuesDelta(r.valuesDeltaChanges),
+		intreconcile.WithTestLogs(r.testLogs)).Reconcile(ctx, &intreconcile.Request{
 		Object: obj,
 		Chart:  loadedChart,
 		Values: values,
@@ -420,12 +617,16 @@ func (r *HelmReleaseReconciler) reconcileRelease(ctx context.Context, patchHelpe
 		if errors.Is(err, intreconcile.ErrMustRequeue) {
 			return ctrl.Result{Requeue: true}, nil
 		}
+		if errors.Is(err, intreconcile.ErrManifestTooLarge) {
+			conditions.MarkStalled(obj, v2.ManifestTooLargeReason, conditions.GetMessage(obj, meta.ReadyCondition))
+			err = reconcile.TerminalError(err)
+		}
 		if interrors.IsOneOf(err, intreconcile.ErrExceededMaxRetries, intreconcile.ErrMissingRollbackTarget) {
 			err = reconcile.TerminalError(err)
 		}
 		return ctrl.Result{}, err
 	}
-	return jitter.JitteredRequeueInterval(ctrl.Result{RequeueAfter: obj.GetRequeueAfter()}), nil
+	return jitter.JitteredRequeueInterval(ctrl.Result{RequeueAfter: requeueAfter(obj)}), nil
 }
 
 // reconcileDelete deletes the v1beta2.HelmChart of the v2.HelmRelease,
@@ -447,6 +648,9 @@ func (r *HelmReleaseReconciler) reconcileDelete(ctx context.Context, obj *v2.Hel
 		// Remove our finalizer from the list.
 		controllerutil.RemoveFinalizer(obj, v2.HelmReleaseFinalizer)
 
+		// Delete the metrics recorded for the object.
+		intmetrics.DeleteRelease(obj.GetNamespace(), obj.GetName())
+
 		// Stop reconciliation as the object is being deleted.
 		return ctrl.Result{}, nil
 	}
@@ -474,6 +678,15 @@ func (r *HelmReleaseReconciler) reconcileReleaseDeletion(ctx context.Context, ob
 		return fmt.Errorf("refusing to uninstall Helm release: deletion timestamp is not set")
 	}
 
+	// Uninstall the release from any target clusters.
+	if len(obj.Status.Clusters) > 0 {
+		if err := r.uninstallClusters(ctx, obj, nil); err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, v2.UninstallFailedReason,
+				"failed to uninstall release from clusters: %s", err.Error())
+			return err
+		}
+	}
+
 	// If the release has not been installed yet, we can skip the uninstallation.
 	if obj.Status.StorageNamespace == "" {
 		ctrl.LoggerFrom(ctx).Info("skipping Helm release uninstallation: no storage namespace configured")
@@ -483,9 +696,10 @@ func (r *HelmReleaseReconciler) reconcileReleaseDeletion(ctx context.Context, ob
 	// Build client getter.
 	getter, err := r.buildRESTClientGetter(ctx, obj)
 	if err != nil {
-		if apierrors.IsNotFound(err) {
-			// Without a Secret reference, we cannot get a REST client
-			// to uninstall the release.
+		if apierrors.IsNotFound(err) || acl.IsAccessDenied(err) {
+			// Without a Secret reference, or with an impersonation which
+			// is not allowed, we cannot get a REST client to uninstall the
+			// release.
 			ctrl.LoggerFrom(ctx).Error(err, "skipping Helm release uninstallation")
 			return nil
 		}
@@ -496,7 +710,8 @@ func (r *HelmReleaseReconciler) reconcileReleaseDeletion(ctx context.Context, ob
 	}
 
 	// Confirm any ServiceAccount used for impersonation exists before
-	// attempting to uninstall.
+	// attempting to uninstall. Impersonation of other users is confirmed to
+	// be allowed while building the REST client getter.
 	// If the ServiceAccount does not exist, for example, because the
 	// namespace is being terminated, we should not attempt to uninstall the
 	// release.
@@ -507,16 +722,8 @@ func (r *HelmReleaseReconciler) reconcileReleaseDeletion(ctx context.Context, ob
 			return err
 		}
 
-		if serviceAccount := cfg.Impersonate.UserName; serviceAccount != "" {
-			i := strings.LastIndex(serviceAccount, ":")
-			if i != -1 {
-				serviceAccount = serviceAccount[i+1:]
-			}
-
-			if err = r.Client.Get(ctx, types.NamespacedName{
-				Namespace: obj.GetNamespace(),
-				Name:      serviceAccount,
-			}, &corev1.ServiceAccount{}); err != nil {
+		if serviceAccount, ok := kube.ServiceAccountFromUsername(cfg.Impersonate.UserName); ok {
+			if err = r.Client.Get(ctx, serviceAccount, &corev1.ServiceAccount{}); err != nil {
 				if client.IgnoreNotFound(err) == nil {
 					// Without a ServiceAccount reference, we cannot confirm
 					// the ServiceAccount exists.
@@ -525,7 +732,7 @@ func (r *HelmReleaseReconciler) reconcileReleaseDeletion(ctx context.Context, ob
 				}
 
 				conditions.MarkFalse(obj, meta.ReadyCondition, v2.UninstallFailedReason,
-					"failed to confirm ServiceAccount '%s' can be used to uninstall release: %s", serviceAccount, err.Error())
+					"failed to confirm ServiceAccount '%s' can be used to uninstall release: %s", serviceAccount.Name, err.Error())
 				return err
 			}
 		}
@@ -668,26 +875,37 @@ func (r *HelmReleaseReconciler) buildRESTClientGetter(ctx context.Context, obj *
 	opts := []kube.Option{
 		kube.WithNamespace(obj.GetReleaseNamespace()),
 		kube.WithClientOptions(r.ClientOpts),
+		kube.WithPersistent(obj.UsePersistentClient()),
+		kube.WithDiscoveryCache(r.discoveryCache),
+		kube.WithEventRecorderCache(r.eventRecorders),
+		kube.WithWrapTransport(tracing.WrapTransport),
+	}
+	if imp := obj.Spec.Impersonate; imp != nil {
+		// The API rejects this combination, but objects may predate the
+		// validation.
+		if obj.Spec.ServiceAccountName != "" {
+			return nil, acl.AccessDeniedError("impersonation is not allowed in combination with serviceAccountName")
+		}
+		if err := intacl.AllowsImpersonation(obj, imp.User, imp.Groups, imp.Extra); err != nil {
+			return nil, err
+		}
+		opts = append(opts, kube.WithImpersonateUser(imp.User, imp.Groups, imp.Extra))
+	} else {
 		// When ServiceAccountName is empty, it will fall back to the configured
 		// default. If this is not configured either, this option will result in
 		// a no-op.
-		kube.WithImpersonate(obj.Spec.ServiceAccountName, obj.GetNamespace()),
-		kube.WithPersistent(obj.UsePersistentClient()),
+		opts = append(opts, kube.WithImpersonate(obj.Spec.ServiceAccountName, obj.GetNamespace()))
 	}
 	if obj.Spec.KubeConfig != nil {
-		secretName := types.NamespacedName{
-			Namespace: obj.GetNamespace(),
-			Name:      obj.Spec.KubeConfig.SecretRef.Name,
-		}
-		var secret corev1.Secret
-		if err := r.Get(ctx, secretName, &secret); err != nil {
-			return nil, fmt.Errorf("could not get KubeConfig secret '%s': %w", secretName, err)
-		}
-		kubeConfig, err := kube.ConfigFromSecret(&secret, obj.Spec.KubeConfig.SecretRef.Key, r.KubeConfigOpts)
+		kubeConfig, ts, err := r.buildKubeConfig(ctx, obj)
 		if err != nil {
 			return nil, err
 		}
-		return kube.NewMemoryRESTClientGetter(kubeConfig, opts...), nil
+		var identity string
+		if ts != nil {
+			identity = tokenSourceCacheKey(obj)
+		}
+		return kube.NewMemoryRESTClientGetter(kubeConfig, append(opts, kube.WithTokenSource(ts, identity))...), nil
 	}
 
 	cfg, err := r.GetClusterConfig()
@@ -746,6 +964,243 @@ func (r *HelmReleaseReconciler) getSourceFromOCIRef(ctx context.Context, obj *v2
 	return &or, nil
 }
 
+// loadChartFromOCIArtifact pulls the chart referenced by the OCIArtifact
+// chartRef of the HelmRelease directly from the OCI registry, using the
+// credentials from the referenced Secret if configured, and plain HTTP if
+// the reference is marked insecure.
+// It returns the loaded chart and the digest of the pulled manifest.
+func (r *HelmReleaseReconciler) loadChartFromOCIArtifact(ctx context.Context, obj *v2.HelmRelease, opts ...loader.LoadOption) (*chart.Chart, string, error) {
+	ref := obj.Spec.ChartRef
+
+	creds, err := r.getRegistryCredentials(ctx, obj)
+	if err != nil {
+		return nil, "", err
+	}
+	opts = append(opts, loader.WithDockerConfigJSON(creds), loader.WithPlainHTTP(ref.Insecure))
+
+	return loader.SecureLoadChartFromOCI(ctx, ref.URL, ref.Digest, opts...)
+}
+
+// getRegistryCredentials returns the Docker config data from the Secret
+// referenced by the OCIArtifact chartRef of the HelmRelease, or nil if no
+// Secret is referenced.
+func (r *HelmReleaseReconciler) getRegistryCredentials(ctx context.Context, obj *v2.HelmRelease) ([]byte, error) {
+	if !obj.HasOCIArtifactRef() || obj.Spec.ChartRef.SecretRef == nil {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.Spec.ChartRef.SecretRef.Name}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("could not get registry credentials Secret '%s': %w", key, err)
+	}
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return nil, fmt.Errorf("registry credentials Secret '%s' does not contain a '%s' key", key, corev1.DockerConfigJsonKey)
+	}
+	return data, nil
+}
+
+// errUnsupportedVerification signals the verification configuration of the
+// HelmRelease can not be used with the kind of its chart source.
+var errUnsupportedVerification = errors.New("unsupported verification configuration")
+
+// chartVerificationSupported returns an error wrapping
+// errUnsupportedVerification if the verification provider of the HelmRelease
+// can not verify charts from its chart source. The Helm provider requires the
+// provenance file pulled with an OCIArtifact chartRef, the other providers
+// require a chart from an OCI registry.
+func chartVerificationSupported(obj *v2.HelmRelease) error {
+	if obj.Spec.Verify == nil || obj.HasOCIArtifactRef() {
+		return nil
+	}
+	if obj.HasChartRef() && obj.Spec.ChartRef.Kind == sourcev1beta2.OCIRepositoryKind &&
+		obj.Spec.Verify.Provider != signature.ProviderHelm {
+		return nil
+	}
+
+	kind := sourcev1.HelmChartKind
+	if obj.HasChartRef() {
+		kind = obj.Spec.ChartRef.Kind
+	}
+	return fmt.Errorf("%w: provider '%s' can not verify charts from a %s", errUnsupportedVerification,
+		obj.Spec.Verify.Provider, kind)
+}
+
+// buildChartVerifyFunc returns a loader.VerifyFunc verifying the authenticity
+// of the chart for the HelmRelease, using the keys from the Secret referenced
+// in the verification configuration. It returns nil if no verification is
+// configured. Signatures are fetched from the OCI registry using the same
+// credentials and transport settings as the chart source. The returned error
+// wraps errUnsupportedVerification if the configuration can not be used with
+// the chart source.
+func (r *HelmReleaseReconciler) buildChartVerifyFunc(ctx context.Context, obj *v2.HelmRelease, source sourcev1.Source) (loader.VerifyFunc, error) {
+	if obj.Spec.Verify == nil {
+		return nil, nil
+	}
+	if err := chartVerificationSupported(obj); err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.Spec.Verify.SecretRef.Name}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("could not get verification Secret '%s': %w", key, err)
+	}
+
+	// Determine the OCI repository the chart originates from, and how to
+	// authenticate to it.
+	var (
+		repository, sourceDigest string
+		opts                     []signature.Option
+	)
+	switch {
+	case obj.HasOCIArtifactRef():
+		repository = ociRepositoryFromURL(obj.Spec.ChartRef.URL)
+		keychain, err := r.registryKeychain(ctx, obj.GetNamespace(), obj.Spec.ChartRef.SecretRef, "")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, signature.WithKeychain(keychain))
+	default:
+		or, ok := source.(*sourcev1beta2.OCIRepository)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected an OCIRepository source, got %T", errUnsupportedVerification, source)
+		}
+		if p := or.Spec.Provider; p != "" && p != sourcev1beta2.GenericOCIProvider {
+			return nil, fmt.Errorf("%w: OCIRepository provider '%s' is not supported", errUnsupportedVerification, p)
+		}
+		repository = ociRepositoryFromURL(or.Spec.URL)
+		if artifact := or.GetArtifact(); artifact != nil {
+			sourceDigest = extractDigest(artifact.Revision)
+		}
+
+		keychain, err := r.registryKeychain(ctx, or.GetNamespace(), or.Spec.SecretRef, or.Spec.ServiceAccountName)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, signature.WithKeychain(keychain), signature.WithPlainHTTP(or.Spec.Insecure))
+		if or.Spec.CertSecretRef != nil {
+			transport, err := r.registryTransport(ctx, or.GetNamespace(), or.Spec.CertSecretRef.Name)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, signature.WithTransport(transport))
+		}
+	}
+
+	verifier, err := signature.NewVerifier(obj.Spec.Verify.Provider, secret.Data, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification configuration: %w", err)
+	}
+
+	return func(chart, provenance []byte, digest string) error {
+		if digest != "" {
+			return verifier.Verify(ctx, &signature.Artifact{
+				Chart:      chart,
+				Provenance: provenance,
+				Repository: repository,
+				Digest:     digest,
+			})
+		}
+
+		// The chart was not pulled by the controller, but downloaded from
+		// the artifact of the OCIRepository. Verify the signature of the
+		// manifest the artifact claims to originate from, and that the
+		// downloaded chart is the chart layer of that manifest.
+		artifact := &signature.Artifact{
+			Chart:      chart,
+			Provenance: provenance,
+			Repository: repository,
+			Digest:     sourceDigest,
+		}
+		if err := verifier.Verify(ctx, artifact); err != nil {
+			return err
+		}
+		return signature.VerifyChartLayer(ctx, artifact, opts...)
+	}, nil
+}
+
+// registryKeychain returns a keychain with the registry credentials from the
+// referenced Docker config Secret, and the image pull Secrets of the named
+// ServiceAccount, in the given namespace.
+func (r *HelmReleaseReconciler) registryKeychain(ctx context.Context, namespace string, secretRef *meta.LocalObjectReference, serviceAccountName string) (authn.Keychain, error) {
+	var pullSecretNames []string
+	if secretRef != nil {
+		pullSecretNames = append(pullSecretNames, secretRef.Name)
+	}
+	if serviceAccountName != "" {
+		sa := &corev1.ServiceAccount{}
+		key := types.NamespacedName{Namespace: namespace, Name: serviceAccountName}
+		if err := r.Client.Get(ctx, key, sa); err != nil {
+			return nil, fmt.Errorf("could not get ServiceAccount '%s': %w", key, err)
+		}
+		for _, ref := range sa.ImagePullSecrets {
+			pullSecretNames = append(pullSecretNames, ref.Name)
+		}
+	}
+
+	pullSecrets := make([]corev1.Secret, 0, len(pullSecretNames))
+	for _, name := range pullSecretNames {
+		secret := corev1.Secret{}
+		key := types.NamespacedName{Namespace: namespace, Name: name}
+		if err := r.Client.Get(ctx, key, &secret); err != nil {
+			return nil, fmt.Errorf("could not get registry credentials Secret '%s': %w", key, err)
+		}
+		pullSecrets = append(pullSecrets, secret)
+	}
+	return kauth.NewFromPullSecrets(ctx, pullSecrets)
+}
+
+// registryTransport returns an HTTP transport configured with the TLS
+// certificate authority and client certificate from the named Secret, using
+// the same keys as the certSecretRef of an OCIRepository.
+func (r *HelmReleaseReconciler) registryTransport(ctx context.Context, namespace, name string) (http.RoundTripper, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("could not get TLS Secret '%s': %w", key, err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca, ok := secret.Data["ca.crt"]; ok {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid 'ca.crt' in TLS Secret '%s'", key)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	certPEM, hasCert := secret.Data[corev1.TLSCertKey]
+	keyPEM, hasKey := secret.Data[corev1.TLSPrivateKeyKey]
+	if hasCert && hasKey {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate in TLS Secret '%s': %w", key, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// ociRepositoryFromURL returns the OCI repository of the given OCI URL,
+// without the scheme, tag or digest.
+func ociRepositoryFromURL(URL string) string {
+	repo := strings.TrimPrefix(URL, "oci://")
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo = repo[:i]
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	return repo
+}
+
 // waitForHistoryCacheSync returns a function that can be used to wait for the
 // cache backing the Kubernetes client to be in sync with the current state of
 // the v2.HelmRelease.
@@ -871,6 +1326,47 @@ func isReady(obj conditions.Getter, artifact *sourcev1.Artifact) (bool, string)
 	}
 }
 
+// requeueAfter returns the duration after which the object must be
+// reconciled again. This is the interval of the object, or the remaining
+// time until the tests of a release must be re-run if this is sooner.
+func requeueAfter(obj *v2.HelmRelease) time.Duration {
+	after := obj.GetRequeueAfter()
+	interval := obj.GetTest().GetInterval()
+	if !obj.GetTest().Enable || interval <= 0 {
+		return after
+	}
+
+	latest := []*v2.Snapshot{obj.Status.History.Latest()}
+	for _, c := range obj.Status.Clusters {
+		latest = append(latest, c.History.Latest())
+	}
+	for _, s := range latest {
+		if len(s.GetTestHooks()) == 0 {
+			continue
+		}
+		last := s.LastTestCompleted()
+		if last.IsZero() {
+			continue
+		}
+		if due := time.Until(last.Add(interval)); due < after {
+			after = max(due, time.Second)
+		}
+	}
+	return after
+}
+
+// recordReleaseInfo records the chart of the latest release of the object,
+// or deletes the record if there is no release.
+func recordReleaseInfo(obj *v2.HelmRelease) {
+	cur := obj.Status.History.Latest()
+	if cur == nil {
+		intmetrics.DeleteReleaseInfo(obj.GetNamespace(), obj.GetName())
+		return
+	}
+	intmetrics.SetReleaseInfo(obj.GetNamespace(), obj.GetName(), cur.Namespace, cur.Name,
+		cur.ChartName, cur.ChartVersion, cur.AppVersion)
+}
+
 func isValidChartRef(obj *v2.HelmRelease) bool {
 	return (obj.HasChartRef() && !obj.HasChartTemplate()) ||
 		(!obj.HasChartRef() && obj.HasChartTemplate())
@@ -879,6 +1375,8 @@ func isValidChartRef(obj *v2.HelmRelease) bool {
 func getNamespacedName(obj *v2.HelmRelease) (types.NamespacedName, error) {
 	namespacedName := types.NamespacedName{}
 	switch {
+	case obj.HasOCIArtifactRef():
+		return namespacedName, fmt.Errorf("chartRef of kind %s does not refer to an object", v2.OCIArtifactKind)
 	case obj.HasChartRef() && !obj.HasChartTemplate():
 		namespacedName.Namespace = obj.Spec.ChartRef.Namespace
 		if namespacedName.Namespace == "" {
@@ -951,6 +1449,25 @@ func mutateChartWithSourceRevision(chart *chart.Chart, source sourcev1.Source) (
 	return ociDigest, nil
 }
 
+// mutateChartWithDigest adds the given OCI digest to the chart version as
+// build metadata, to ensure changes to mutable tags are detected.
+func mutateChartWithDigest(chart *chart.Chart, digest string) error {
+	ver, err := semver.NewVersion(chart.Metadata.Version)
+	if err != nil {
+		return err
+	}
+	sha, err := extractDigestSubString(digest)
+	if err != nil {
+		return err
+	}
+	*ver, err = ver.SetMetadata(sha)
+	if err != nil {
+		return err
+	}
+	chart.Metadata.Version = ver.String()
+	return nil
+}
+
 func extractDigestSubString(revision string) (string, error) {
 	var sha string
 	// expects a revision in the <algorithm>:<digest> format
@@ -63,6 +63,7 @@ import (
 	"github.com/fluxcd/helm-controller/internal/postrender"
 	intreconcile "github.com/fluxcd/helm-controller/internal/reconcile"
 	"github.com/fluxcd/helm-controller/internal/release"
+	"github.com/fluxcd/helm-controller/internal/signature"
 	"github.com/fluxcd/helm-controller/internal/testutil"
 	"github.com/fluxcd/pkg/apis/kustomize"
 )
@@ -2069,6 +2070,87 @@ func TestHelmReleaseReconciler_reconcileReleaseFromOCIRepositorySource(t *testin
 	})
 }
 
+func TestHelmReleaseReconciler_reconcileReleaseFromOCIArtifact(t *testing.T) {
+	t.Run("handles registry credentials Secret get failure", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				ChartRef: &v2.CrossNamespaceSourceReference{
+					Kind:      v2.OCIArtifactKind,
+					URL:       "oci://registry.example.com/charts/podinfo:6.5.0",
+					SecretRef: &meta.LocalObjectReference{Name: "credentials"},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().
+				WithScheme(NewTestScheme()).
+				WithStatusSubresource(&v2.HelmRelease{}).
+				WithObjects(obj).
+				Build(),
+			EventRecorder: record.NewFakeRecorder(32),
+		}
+
+		_, err := r.reconcileRelease(context.TODO(), patch.NewSerialPatcher(obj, r.Client), obj)
+		g.Expect(err).To(HaveOccurred())
+
+		g.Expect(obj.Status.Conditions).To(conditions.MatchConditions([]metav1.Condition{
+			*conditions.TrueCondition(meta.ReconcilingCondition, meta.ProgressingReason, "Fulfilling prerequisites"),
+			*conditions.FalseCondition(meta.ReadyCondition, v2.ArtifactFailedReason, "could not get registry credentials Secret"),
+		}))
+	})
+
+	t.Run("handles registry credentials Secret without Docker config", func(t *testing.T) {
+		g := NewWithT(t)
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "credentials",
+				Namespace: "mock",
+			},
+			Data: map[string][]byte{
+				"username": []byte("user"),
+			},
+		}
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				ChartRef: &v2.CrossNamespaceSourceReference{
+					Kind:      v2.OCIArtifactKind,
+					URL:       "oci://registry.example.com/charts/podinfo:6.5.0",
+					SecretRef: &meta.LocalObjectReference{Name: "credentials"},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().
+				WithScheme(NewTestScheme()).
+				WithStatusSubresource(&v2.HelmRelease{}).
+				WithObjects(secret, obj).
+				Build(),
+			EventRecorder: record.NewFakeRecorder(32),
+		}
+
+		_, err := r.reconcileRelease(context.TODO(), patch.NewSerialPatcher(obj, r.Client), obj)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(corev1.DockerConfigJsonKey))
+
+		g.Expect(conditions.IsFalse(obj, meta.ReadyCondition)).To(BeTrue())
+		g.Expect(conditions.GetReason(obj, meta.ReadyCondition)).To(Equal(v2.ArtifactFailedReason))
+	})
+}
+
 func TestHelmReleaseReconciler_reconcileDelete(t *testing.T) {
 	t.Run("uninstalls Helm release and removes chart", func(t *testing.T) {
 		g := NewWithT(t)
@@ -2537,6 +2619,35 @@ func TestHelmReleaseReconciler_reconcileReleaseDeletion(t *testing.T) {
 		g.Expect(obj.Status.StorageNamespace).To(BeEmpty())
 	})
 
+	t.Run("skip uninstalling Helm release when impersonation is not allowed", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "reconcile-delete",
+				Namespace:         "mock",
+				DeletionTimestamp: &metav1.Time{Time: time.Now()},
+			},
+			Spec: v2.HelmReleaseSpec{
+				Impersonate: &v2.Impersonation{User: "jane"},
+			},
+			Status: v2.HelmReleaseStatus{
+				StorageNamespace: "mock",
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().Build(),
+			GetClusterConfig: func() (*rest.Config, error) {
+				return &rest.Config{Host: "https://failing-mock.local"}, nil
+			},
+		}
+
+		err := r.reconcileReleaseDeletion(context.TODO(), obj)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(conditions.Has(obj, meta.ReadyCondition)).To(BeFalse())
+	})
+
 	t.Run("error when DeletionTimestamp is not set", func(t *testing.T) {
 		g := NewWithT(t)
 
@@ -3097,6 +3208,30 @@ users:
 			},
 			wantErr: "does not contain a 'invalid-key' key",
 		},
+		{
+			name: "error on impersonation which is not allowed",
+			getConfig: func() (*rest.Config, error) {
+				return clientcmd.RESTConfigFromKubeConfig([]byte(kubeCfg))
+			},
+			spec: v2.HelmReleaseSpec{
+				Impersonate: &v2.Impersonation{
+					User:   "jane",
+					Groups: []string{"system:masters"},
+				},
+			},
+			wantErr: "impersonation of user 'jane' is not allowed",
+		},
+		{
+			name: "error on impersonation with ServiceAccount",
+			getConfig: func() (*rest.Config, error) {
+				return clientcmd.RESTConfigFromKubeConfig([]byte(kubeCfg))
+			},
+			spec: v2.HelmReleaseSpec{
+				ServiceAccountName: "deployer",
+				Impersonate:        &v2.Impersonation{User: "jane"},
+			},
+			wantErr: "impersonation is not allowed in combination with serviceAccountName",
+		},
 	}
 
 	for _, tt := range tests {
@@ -3760,3 +3895,258 @@ func Test_TryMutateChartWithSourceRevision(t *testing.T) {
 	}
 
 }
+
+func Test_mutateChartWithDigest(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     string
+		digest      string
+		wantVersion string
+		wantErr     bool
+	}{
+		{
+			name:        "valid version and digest",
+			version:     "1.2.3",
+			digest:      "sha256:9933f58f8bf459eb199d59ebc8a05683f3944e1242d9f5467d99aa2cf08a5370",
+			wantVersion: "1.2.3+9933f58f8bf4",
+		},
+		{
+			name:    "invalid digest",
+			version: "1.2.3",
+			digest:  "9933f58f8bf4",
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			version: "sha:123456",
+			digest:  "sha256:9933f58f8bf459eb199d59ebc8a05683f3944e1242d9f5467d99aa2cf08a5370",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := &chart.Chart{
+				Metadata: &chart.Metadata{
+					Version: tt.version,
+				},
+			}
+
+			err := mutateChartWithDigest(c, tt.digest)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(c.Metadata.Version).To(Equal(tt.wantVersion))
+		})
+	}
+}
+
+func TestHelmReleaseReconciler_buildChartVerifyFunc(t *testing.T) {
+	t.Run("returns nil without verification configuration", func(t *testing.T) {
+		g := NewWithT(t)
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().WithScheme(NewTestScheme()).Build(),
+		}
+		fn, err := r.buildChartVerifyFunc(context.TODO(), &v2.HelmRelease{}, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(fn).To(BeNil())
+	})
+
+	t.Run("error on missing verification Secret", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				ChartRef: &v2.CrossNamespaceSourceReference{
+					Kind: v2.OCIArtifactKind,
+					URL:  "oci://ghcr.io/stefanprodan/charts/podinfo:6.5.0",
+				},
+				Verify: &v2.ChartVerification{
+					Provider:  "cosign",
+					SecretRef: meta.LocalObjectReference{Name: "keys"},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().WithScheme(NewTestScheme()).Build(),
+		}
+		_, err := r.buildChartVerifyFunc(context.TODO(), obj, nil)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("could not get verification Secret 'mock/keys'"))
+	})
+
+	t.Run("error on unsupported chart source", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				ChartRef: &v2.CrossNamespaceSourceReference{
+					Kind: sourcev1.HelmChartKind,
+					Name: "chart",
+				},
+				Verify: &v2.ChartVerification{
+					Provider:  "cosign",
+					SecretRef: meta.LocalObjectReference{Name: "keys"},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().WithScheme(NewTestScheme()).Build(),
+		}
+		_, err := r.buildChartVerifyFunc(context.TODO(), obj, nil)
+		g.Expect(err).To(MatchError(errUnsupportedVerification))
+	})
+
+	t.Run("error on missing OCIRepository credentials", func(t *testing.T) {
+		g := NewWithT(t)
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "keys",
+				Namespace: "mock",
+			},
+			Data: map[string][]byte{
+				"cosign.pub": []byte("invalid"),
+			},
+		}
+		source := &sourcev1beta2.OCIRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "podinfo",
+				Namespace: "source",
+			},
+			Spec: sourcev1beta2.OCIRepositorySpec{
+				URL:       "oci://ghcr.io/stefanprodan/charts/podinfo",
+				SecretRef: &meta.LocalObjectReference{Name: "registry"},
+			},
+		}
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				ChartRef: &v2.CrossNamespaceSourceReference{
+					Kind:      sourcev1beta2.OCIRepositoryKind,
+					Name:      "podinfo",
+					Namespace: "source",
+				},
+				Verify: &v2.ChartVerification{
+					Provider:  "cosign",
+					SecretRef: meta.LocalObjectReference{Name: "keys"},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().WithScheme(NewTestScheme()).WithObjects(secret).Build(),
+		}
+		_, err := r.buildChartVerifyFunc(context.TODO(), obj, source)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("could not get registry credentials Secret 'source/registry'"))
+	})
+
+	t.Run("error on invalid keys", func(t *testing.T) {
+		g := NewWithT(t)
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "keys",
+				Namespace: "mock",
+			},
+			Data: map[string][]byte{
+				"cosign.pub": []byte("invalid"),
+			},
+		}
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "mock",
+			},
+			Spec: v2.HelmReleaseSpec{
+				ChartRef: &v2.CrossNamespaceSourceReference{
+					Kind: v2.OCIArtifactKind,
+					URL:  "oci://ghcr.io/stefanprodan/charts/podinfo:6.5.0",
+				},
+				Verify: &v2.ChartVerification{
+					Provider:  "cosign",
+					SecretRef: meta.LocalObjectReference{Name: "keys"},
+				},
+			},
+		}
+
+		r := &HelmReleaseReconciler{
+			Client: fake.NewClientBuilder().WithScheme(NewTestScheme()).WithObjects(secret).Build(),
+		}
+		_, err := r.buildChartVerifyFunc(context.TODO(), obj, nil)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("invalid verification configuration"))
+	})
+}
+
+func Test_chartVerificationSupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		chartRef *v2.CrossNamespaceSourceReference
+		provider string
+		wantErr  bool
+	}{
+		{name: "cosign with OCIArtifact", chartRef: &v2.CrossNamespaceSourceReference{Kind: v2.OCIArtifactKind}, provider: signature.ProviderCosign},
+		{name: "helm with OCIArtifact", chartRef: &v2.CrossNamespaceSourceReference{Kind: v2.OCIArtifactKind}, provider: signature.ProviderHelm},
+		{name: "notation with OCIRepository", chartRef: &v2.CrossNamespaceSourceReference{Kind: sourcev1beta2.OCIRepositoryKind}, provider: signature.ProviderNotation},
+		{name: "helm with OCIRepository", chartRef: &v2.CrossNamespaceSourceReference{Kind: sourcev1beta2.OCIRepositoryKind}, provider: signature.ProviderHelm, wantErr: true},
+		{name: "cosign with HelmChart", chartRef: &v2.CrossNamespaceSourceReference{Kind: sourcev1.HelmChartKind}, provider: signature.ProviderCosign, wantErr: true},
+		{name: "cosign with chart template", provider: signature.ProviderCosign, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &v2.HelmRelease{
+				Spec: v2.HelmReleaseSpec{
+					ChartRef: tt.chartRef,
+					Verify:   &v2.ChartVerification{Provider: tt.provider},
+				},
+			}
+			err := chartVerificationSupported(obj)
+			if tt.wantErr {
+				g.Expect(err).To(MatchError(errUnsupportedVerification))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func Test_ociRepositoryFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "oci://ghcr.io/stefanprodan/charts/podinfo", want: "ghcr.io/stefanprodan/charts/podinfo"},
+		{url: "oci://ghcr.io/stefanprodan/charts/podinfo:6.5.0", want: "ghcr.io/stefanprodan/charts/podinfo"},
+		{url: "oci://localhost:5000/charts/podinfo:6.5.0", want: "localhost:5000/charts/podinfo"},
+		{url: "oci://localhost:5000/charts/podinfo", want: "localhost:5000/charts/podinfo"},
+		{url: "oci://ghcr.io/charts/podinfo:6.5.0@sha256:9933f58f8bf459eb199d59ebc8a05683f3944e1242d9f5467d99aa2cf08a5370", want: "ghcr.io/charts/podinfo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ociRepositoryFromURL(tt.url)).To(Equal(tt.want))
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	"github.com/fluxcd/helm-controller/internal/kube"
+)
+
+// crdGroupVersionKind is the GroupVersionKind of CustomResourceDefinitions.
+var crdGroupVersionKind = schema.GroupVersionKind{
+	Group:   "apiextensions.k8s.io",
+	Version: "v1",
+	Kind:    "CustomResourceDefinition",
+}
+
+// crdMetadata returns an empty metav1.PartialObjectMetadata for a
+// CustomResourceDefinition, to watch the metadata of CRDs only.
+func crdMetadata() *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(crdGroupVersionKind)
+	return obj
+}
+
+// discoveryInvalidator returns an event handler which invalidates the
+// discovery clients and REST mappers of the given host in the
+// DiscoveryCache on changes to CustomResourceDefinitions. Create events for
+// CRDs created before since (i.e. the initial list of the informer) and
+// periodic resyncs are ignored. The handler never enqueues any requests.
+func discoveryInvalidator(cache *kube.DiscoveryCache, host string, since time.Time) handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(_ context.Context, e event.CreateEvent, _ workqueue.RateLimitingInterface) {
+			if e.Object.GetCreationTimestamp().Time.Before(since) {
+				return
+			}
+			cache.Invalidate(host)
+		},
+		UpdateFunc: func(_ context.Context, e event.UpdateEvent, _ workqueue.RateLimitingInterface) {
+			// Ignore periodic resyncs. Other updates, including those to the
+			// status of the CRD, may change the served API resources.
+			if e.ObjectOld.GetResourceVersion() == e.ObjectNew.GetResourceVersion() {
+				return
+			}
+			cache.Invalidate(host)
+		},
+		DeleteFunc: func(_ context.Context, _ event.DeleteEvent, _ workqueue.RateLimitingInterface) {
+			cache.Invalidate(host)
+		},
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/fairqueue"
+	intmetrics "github.com/fluxcd/helm-controller/internal/metrics"
+)
+
+// FairQueueOptions configures the fair queuing of HelmReleases between
+// tenants.
+type FairQueueOptions struct {
+	// TenantLabel is the label of which the value is the tenant of a
+	// HelmRelease. When empty, the namespace of the HelmRelease is used.
+	TenantLabel string
+	// DefaultLimits are the limits of tenants without specific limits.
+	DefaultLimits fairqueue.Limits
+	// TenantLimits are the limits of specific tenants.
+	TenantLimits map[string]fairqueue.Limits
+	// CriticalPriority is the priority from which HelmReleases are queued
+	// before the HelmReleases of all tenants. When zero or lower, the
+	// priorities are only compared between the HelmReleases of a tenant.
+	CriticalPriority int32
+}
+
+// newQueue returns a new fairqueue.Queue which orders HelmReleases by their
+// priority. If FairQueueOptions are given, the HelmReleases are queued per
+// tenant, otherwise all HelmReleases share a single tenant without limits.
+func (r *HelmReleaseReconciler) newQueue(opts *FairQueueOptions) *fairqueue.Queue {
+	qOpts := []fairqueue.Option{
+		fairqueue.WithPriorityFunc(r.priorityFunc()),
+		fairqueue.WithMetricsProvider(intmetrics.WorkqueueMetricsProvider()),
+	}
+	if opts == nil {
+		return fairqueue.New(func(interface{}) string { return "" }, qOpts...)
+	}
+	qOpts = append(qOpts,
+		fairqueue.WithDefaultLimits(opts.DefaultLimits),
+		fairqueue.WithTenantLimits(opts.TenantLimits),
+	)
+	if opts.CriticalPriority > 0 {
+		qOpts = append(qOpts, fairqueue.WithCriticalPriority(opts.CriticalPriority))
+	}
+	return fairqueue.New(r.tenantFunc(opts.TenantLabel), qOpts...)
+}
+
+// tenantFunc returns a fairqueue.TenantFunc which returns the namespace of
+// the request, or the value of the given label of the HelmRelease if set.
+func (r *HelmReleaseReconciler) tenantFunc(label string) fairqueue.TenantFunc {
+	return func(item interface{}) string {
+		req, ok := item.(reconcile.Request)
+		if !ok {
+			return ""
+		}
+		if label == "" {
+			return req.Namespace
+		}
+
+		obj := &v2.HelmRelease{}
+		if err := r.Get(context.Background(), req.NamespacedName, obj); err != nil {
+			return ""
+		}
+		return obj.GetLabels()[label]
+	}
+}
+
+// priorityFunc returns a fairqueue.PriorityFunc which returns the priority
+// of the HelmRelease of the request.
+func (r *HelmReleaseReconciler) priorityFunc() fairqueue.PriorityFunc {
+	return func(item interface{}) int32 {
+		req, ok := item.(reconcile.Request)
+		if !ok {
+			return 0
+		}
+
+		obj := &v2.HelmRelease{}
+		if err := r.Get(context.Background(), req.NamespacedName, obj); err != nil {
+			return 0
+		}
+		return obj.GetPriority()
+	}
+}
+
+// PauseReconciles stops handing out HelmReleases to the workers of the
+// controller when paused is true, and resumes it otherwise. Reconciliations
+// in progress are not affected. It is a no-op before the controller is set
+// up with the manager.
+func (r *HelmReleaseReconciler) PauseReconciles(paused bool) {
+	if r.queue == nil {
+		return
+	}
+	if paused {
+		r.queue.Pause()
+		return
+	}
+	r.queue.Resume()
+}
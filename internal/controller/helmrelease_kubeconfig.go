@@ -0,0 +1,202 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	runtimeClient "github.com/fluxcd/pkg/runtime/client"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	intacl "github.com/fluxcd/helm-controller/internal/acl"
+	"github.com/fluxcd/helm-controller/internal/auth"
+	"github.com/fluxcd/helm-controller/internal/kube"
+)
+
+const (
+	// kubeConfigCASecretKey is the data key of the CA certificate of the
+	// remote API server in the Secret referenced by the KubeConfig of a
+	// HelmRelease with a KubeConfigProvider.
+	kubeConfigCASecretKey = "ca.crt"
+	// defaultExecAPIVersion is the default API version of the ExecCredential
+	// returned by a credential plugin.
+	defaultExecAPIVersion = "client.authentication.k8s.io/v1"
+)
+
+// buildKubeConfig returns the REST config to connect to the remote cluster
+// configured in the KubeConfig of the object. For providers which obtain
+// short-lived credentials, it also returns the TokenSource the bearer tokens
+// are obtained from.
+func (r *HelmReleaseReconciler) buildKubeConfig(ctx context.Context, obj *v2.HelmRelease) (*rest.Config, oauth2.TokenSource, error) {
+	ref := obj.Spec.KubeConfig
+	secret, err := r.getKubeConfigSecret(ctx, obj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := obj.Spec.KubeConfigProvider
+	if provider == nil {
+		cfg, err := kube.ConfigFromSecret(secret, ref.SecretRef.Key, r.KubeConfigOpts)
+		return cfg, nil, err
+	}
+
+	// Provider credentials are only sent to addresses allowed by the
+	// operator, and only if the API server can be verified against the CA
+	// certificate from the Secret.
+	if err := intacl.AllowsKubeConfigProvider(obj, provider.Name, provider.Address, provider.Cluster); err != nil {
+		return nil, nil, err
+	}
+	caData := secret.Data[kubeConfigCASecretKey]
+	if len(caData) == 0 {
+		return nil, nil, fmt.Errorf("KubeConfig secret '%s/%s' does not contain a '%s' key with data",
+			secret.Namespace, secret.Name, kubeConfigCASecretKey)
+	}
+	cfg := &rest.Config{
+		Host:            provider.Address,
+		TLSClientConfig: rest.TLSClientConfig{CAData: caData},
+	}
+
+	var ts oauth2.TokenSource
+	switch provider.Name {
+	case v2.ExecKubeConfigProvider:
+		if !r.KubeConfigOpts.InsecureExecProvider {
+			return nil, nil, fmt.Errorf("the '%s' KubeConfig provider requires the controller to run with --insecure-kubeconfig-exec", provider.Name)
+		}
+		if provider.Exec == nil {
+			return nil, nil, fmt.Errorf("KubeConfig provider exec is required for the '%s' provider", provider.Name)
+		}
+		cfg.ExecProvider = execConfig(provider.Exec)
+	default:
+		if ts, err = r.kubeConfigTokenSource(ctx, obj); err != nil {
+			return nil, nil, fmt.Errorf("failed to configure '%s' KubeConfig provider: %w", provider.Name, err)
+		}
+	}
+	return runtimeClient.KubeConfig(cfg, r.KubeConfigOpts), ts, nil
+}
+
+// kubeConfigTokenSource returns the TokenSource of the KubeConfigProvider
+// of the object. If the reconciler has a TokenSourceCache, the TokenSource
+// is shared with other HelmReleases with the same provider identity and
+// cluster, so tokens are reused across reconciliations.
+func (r *HelmReleaseReconciler) kubeConfigTokenSource(ctx context.Context, obj *v2.HelmRelease) (oauth2.TokenSource, error) {
+	provider := obj.Spec.KubeConfigProvider
+	if provider.Name == v2.ServiceAccountKubeConfigProvider {
+		if provider.ServiceAccountName == "" {
+			return nil, fmt.Errorf("KubeConfig provider serviceAccountName is required for the '%s' provider", provider.Name)
+		}
+		serviceAccount := types.NamespacedName{Namespace: obj.GetNamespace(), Name: provider.ServiceAccountName}
+		if err := intacl.AllowsServiceAccountToken(obj, serviceAccount, kubeConfigAudiences(provider)); err != nil {
+			return nil, err
+		}
+	}
+	if r.tokenSources == nil {
+		return newKubeConfigTokenSource(ctx, r.Client, obj)
+	}
+	// The cached TokenSource outlives the reconciliation, and can therefore
+	// not use its context.
+	return r.tokenSources.GetOrCreate(tokenSourceCacheKey(obj), func() (oauth2.TokenSource, error) {
+		return newKubeConfigTokenSource(context.Background(), r.Client, obj)
+	})
+}
+
+// newKubeConfigTokenSource returns a new TokenSource for the
+// KubeConfigProvider of the object.
+func newKubeConfigTokenSource(ctx context.Context, c client.Client, obj *v2.HelmRelease) (oauth2.TokenSource, error) {
+	provider := obj.Spec.KubeConfigProvider
+	switch provider.Name {
+	case v2.AWSKubeConfigProvider:
+		return auth.NewAWSTokenSource(ctx, provider.Cluster)
+	case v2.AzureKubeConfigProvider:
+		return auth.NewAzureTokenSource(ctx)
+	case v2.GCPKubeConfigProvider:
+		return auth.NewGCPTokenSource(ctx), nil
+	case v2.ServiceAccountKubeConfigProvider:
+		return auth.NewServiceAccountTokenSource(ctx, c, types.NamespacedName{
+			Namespace: obj.GetNamespace(),
+			Name:      provider.ServiceAccountName,
+		}, kubeConfigAudiences(provider)), nil
+	default:
+		return nil, fmt.Errorf("unsupported KubeConfig provider '%s'", provider.Name)
+	}
+}
+
+// tokenSourceCacheKey returns the key of the TokenSource of the
+// KubeConfigProvider of the object in the TokenSourceCache. The key
+// consists of the provider, the identity tokens are obtained for, and the
+// cluster.
+func tokenSourceCacheKey(obj *v2.HelmRelease) string {
+	provider := obj.Spec.KubeConfigProvider
+	var identity string
+	switch provider.Name {
+	case v2.AWSKubeConfigProvider:
+		// The presigned token is bound to the EKS cluster.
+		identity = provider.Cluster
+	case v2.ServiceAccountKubeConfigProvider:
+		identity = strings.Join(append([]string{obj.GetNamespace(), provider.ServiceAccountName}, kubeConfigAudiences(provider)...), ",")
+	}
+	return strings.Join([]string{provider.Name, identity, provider.Address}, "/")
+}
+
+// kubeConfigAudiences returns the audiences of the ServiceAccount token
+// requested by the serviceaccount provider, defaulting to the address.
+func kubeConfigAudiences(provider *v2.KubeConfigProvider) []string {
+	if len(provider.Audiences) == 0 {
+		return []string{provider.Address}
+	}
+	return provider.Audiences
+}
+
+// getKubeConfigSecret returns the Secret referenced by the KubeConfig of
+// the object.
+func (r *HelmReleaseReconciler) getKubeConfigSecret(ctx context.Context, obj *v2.HelmRelease) (*corev1.Secret, error) {
+	secretName := types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.Spec.KubeConfig.SecretRef.Name,
+	}
+	var secret corev1.Secret
+	if err := r.Get(ctx, secretName, &secret); err != nil {
+		return nil, fmt.Errorf("could not get KubeConfig secret '%s': %w", secretName, err)
+	}
+	return &secret, nil
+}
+
+// execConfig returns the client-go ExecConfig for the given credential
+// plugin configuration.
+func execConfig(exec *v2.KubeConfigExec) *clientcmdapi.ExecConfig {
+	cfg := &clientcmdapi.ExecConfig{
+		Command:         exec.Command,
+		Args:            exec.Args,
+		APIVersion:      exec.APIVersion,
+		InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = defaultExecAPIVersion
+	}
+	for _, e := range exec.Env {
+		cfg.Env = append(cfg.Env, clientcmdapi.ExecEnvVar{Name: e.Name, Value: e.Value})
+	}
+	return cfg
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/acl"
+	runtimeClient "github.com/fluxcd/pkg/runtime/client"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	intacl "github.com/fluxcd/helm-controller/internal/acl"
+	"github.com/fluxcd/helm-controller/internal/auth"
+	"github.com/fluxcd/helm-controller/internal/kube"
+)
+
+func TestHelmReleaseReconciler_buildKubeConfig(t *testing.T) {
+	const namespace = "some-namespace"
+
+	caSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "remote-ca", Namespace: namespace},
+		Data:       map[string][]byte{kubeConfigCASecretKey: []byte("ca-data")},
+	}
+
+	tests := []struct {
+		name       string
+		secretName string
+		provider   *v2.KubeConfigProvider
+		opts       runtimeClient.KubeConfigOptions
+		env        map[string]string
+		wantErr    string
+		assert     func(g *WithT, cfgHost string, caData []byte, hasExec bool, ts any)
+	}{
+		{
+			name:       "provider must be allowed",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.AzureKubeConfigProvider,
+				Address: "https://aks.example.com",
+			},
+			wantErr: "KubeConfig provider 'azure' is not allowed",
+		},
+		{
+			name:       "address must be allowed",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.GCPKubeConfigProvider,
+				Address: "https://kubernetes.default.svc",
+			},
+			wantErr: "KubeConfig address 'https://kubernetes.default.svc' is not allowed",
+		},
+		{
+			name:       "cluster must be allowed",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.AWSKubeConfigProvider,
+				Address: "https://eks.example.com",
+				Cluster: "other-cluster",
+			},
+			wantErr: "KubeConfig cluster 'other-cluster' is not allowed",
+		},
+		{
+			name:       "gcp provider",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.GCPKubeConfigProvider,
+				Address: "https://gke.example.com",
+			},
+			assert: func(g *WithT, host string, caData []byte, hasExec bool, ts any) {
+				g.Expect(host).To(Equal("https://gke.example.com"))
+				g.Expect(caData).To(Equal([]byte("ca-data")))
+				g.Expect(hasExec).To(BeFalse())
+				g.Expect(ts).To(BeAssignableToTypeOf(&auth.GCPTokenSource{}))
+			},
+		},
+		{
+			name:       "Secret without ca.crt",
+			secretName: "kubeconfig",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.GCPKubeConfigProvider,
+				Address: "https://gke.example.com",
+			},
+			wantErr: "does not contain a 'ca.crt' key",
+		},
+		{
+			name:       "aws provider",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.AWSKubeConfigProvider,
+				Address: "https://eks.example.com",
+				Cluster: "arn:aws:eks:us-east-1:123456789012:cluster/my-cluster",
+			},
+			assert: func(g *WithT, host string, caData []byte, hasExec bool, ts any) {
+				g.Expect(ts).To(BeAssignableToTypeOf(&auth.AWSTokenSource{}))
+			},
+		},
+		{
+			name:       "aws provider without region",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.AWSKubeConfigProvider,
+				Address: "https://eks.example.com",
+				Cluster: "my-cluster",
+			},
+			env:     map[string]string{"AWS_REGION": "", "AWS_DEFAULT_REGION": ""},
+			wantErr: "failed to configure 'aws' KubeConfig provider: AWS region is not configured",
+		},
+		{
+			name:       "serviceaccount provider",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:               v2.ServiceAccountKubeConfigProvider,
+				Address:            "https://remote.example.com",
+				ServiceAccountName: "deployer",
+			},
+			assert: func(g *WithT, host string, caData []byte, hasExec bool, ts any) {
+				g.Expect(ts).To(BeAssignableToTypeOf(&auth.ServiceAccountTokenSource{}))
+			},
+		},
+		{
+			name:       "serviceaccount provider audience must be allowed",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:               v2.ServiceAccountKubeConfigProvider,
+				Address:            "https://remote.example.com",
+				ServiceAccountName: "deployer",
+				Audiences:          []string{"https://kubernetes.default.svc"},
+			},
+			wantErr: "ServiceAccount token audience 'https://kubernetes.default.svc' is not allowed",
+		},
+		{
+			name:       "exec provider requires insecure exec",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.ExecKubeConfigProvider,
+				Address: "https://remote.example.com",
+				Exec:    &v2.KubeConfigExec{Command: "credential-helper"},
+			},
+			wantErr: "requires the controller to run with --insecure-kubeconfig-exec",
+		},
+		{
+			name:       "exec provider",
+			secretName: "remote-ca",
+			provider: &v2.KubeConfigProvider{
+				Name:    v2.ExecKubeConfigProvider,
+				Address: "https://remote.example.com",
+				Exec:    &v2.KubeConfigExec{Command: "credential-helper"},
+			},
+			opts: runtimeClient.KubeConfigOptions{InsecureExecProvider: true},
+			assert: func(g *WithT, host string, caData []byte, hasExec bool, ts any) {
+				g.Expect(hasExec).To(BeTrue())
+				g.Expect(ts).To(BeNil())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			allowKubeConfigProviders(t, []string{"aws", "gcp", "exec", "serviceaccount"},
+				[]string{"https://*.example.com"}, []string{"arn:aws:eks:*:*:cluster/*", "my-cluster"})
+
+			r := &HelmReleaseReconciler{
+				Client: fake.NewClientBuilder().WithObjects(caSecret.DeepCopy(), &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "kubeconfig", Namespace: namespace},
+					Data:       map[string][]byte{kube.DefaultKubeConfigSecretKey: []byte("")},
+				}).Build(),
+				KubeConfigOpts: tt.opts,
+			}
+			cfg, ts, err := r.buildKubeConfig(context.TODO(), &v2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: namespace},
+				Spec: v2.HelmReleaseSpec{
+					KubeConfig: &meta.KubeConfigReference{
+						SecretRef: meta.SecretKeyReference{Name: tt.secretName},
+					},
+					KubeConfigProvider: tt.provider,
+				},
+			})
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			tt.assert(g, cfg.Host, cfg.TLSClientConfig.CAData, cfg.ExecProvider != nil, ts)
+		})
+	}
+}
+
+func TestHelmReleaseReconciler_kubeConfigTokenSource(t *testing.T) {
+	g := NewWithT(t)
+
+	allowKubeConfigProviders(t, []string{"serviceaccount"}, []string{"https://remote.example.com"}, nil)
+	curSA := intacl.ControllerServiceAccount
+	intacl.ControllerServiceAccount = types.NamespacedName{Namespace: "default", Name: "helm-controller"}
+	t.Cleanup(func() { intacl.ControllerServiceAccount = curSA })
+
+	r := &HelmReleaseReconciler{
+		Client:       fake.NewClientBuilder().Build(),
+		tokenSources: kube.NewTokenSourceCache(0),
+	}
+	newObj := func(serviceAccountName string) *v2.HelmRelease {
+		return &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+			Spec: v2.HelmReleaseSpec{
+				KubeConfig: &meta.KubeConfigReference{SecretRef: meta.SecretKeyReference{Name: "remote-ca"}},
+				KubeConfigProvider: &v2.KubeConfigProvider{
+					Name:               v2.ServiceAccountKubeConfigProvider,
+					Address:            "https://remote.example.com",
+					ServiceAccountName: serviceAccountName,
+				},
+			},
+		}
+	}
+
+	ts, err := r.kubeConfigTokenSource(context.TODO(), newObj("deployer"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The TokenSource is reused for the same identity and cluster.
+	got, err := r.kubeConfigTokenSource(context.TODO(), newObj("deployer"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(BeIdenticalTo(ts))
+
+	got, err = r.kubeConfigTokenSource(context.TODO(), newObj("other"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).ToNot(BeIdenticalTo(ts))
+	g.Expect(r.tokenSources.Len()).To(Equal(2))
+
+	_, err = r.kubeConfigTokenSource(context.TODO(), newObj(""))
+	g.Expect(err).To(MatchError(ContainSubstring("serviceAccountName is required")))
+
+	// Tokens are never requested for the ServiceAccount of the controller.
+	_, err = r.kubeConfigTokenSource(context.TODO(), newObj("helm-controller"))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(acl.IsAccessDenied(err)).To(BeTrue())
+	g.Expect(r.tokenSources.Len()).To(Equal(2))
+}
+
+func Test_tokenSourceCacheKey(t *testing.T) {
+	g := NewWithT(t)
+
+	key := func(namespace string, provider v2.KubeConfigProvider) string {
+		return tokenSourceCacheKey(&v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+			Spec:       v2.HelmReleaseSpec{KubeConfigProvider: &provider},
+		})
+	}
+
+	aws := v2.KubeConfigProvider{Name: v2.AWSKubeConfigProvider, Address: "https://eks.example.com", Cluster: "a"}
+	g.Expect(key("default", aws)).To(Equal(key("other", aws)))
+	otherCluster := aws
+	otherCluster.Cluster = "b"
+	g.Expect(key("default", otherCluster)).ToNot(Equal(key("default", aws)))
+
+	gcp := v2.KubeConfigProvider{Name: v2.GCPKubeConfigProvider, Address: "https://gke.example.com"}
+	otherAddress := gcp
+	otherAddress.Address = "https://other.example.com"
+	g.Expect(key("default", otherAddress)).ToNot(Equal(key("default", gcp)))
+	azure := gcp
+	azure.Name = v2.AzureKubeConfigProvider
+	g.Expect(key("default", azure)).ToNot(Equal(key("default", gcp)))
+
+	sa := v2.KubeConfigProvider{Name: v2.ServiceAccountKubeConfigProvider, Address: "https://remote.example.com", ServiceAccountName: "deployer"}
+	g.Expect(key("other", sa)).ToNot(Equal(key("default", sa)))
+	otherAudience := sa
+	otherAudience.Audiences = []string{"remote"}
+	g.Expect(key("default", otherAudience)).ToNot(Equal(key("default", sa)))
+}
+
+// allowKubeConfigProviders configures the KubeConfig provider allowlists
+// for the duration of the test. Audiences are allowed for the same patterns
+// as addresses.
+func allowKubeConfigProviders(t *testing.T, providers, addresses, clusters []string) {
+	t.Helper()
+	curProviders, curAddresses := intacl.AllowedKubeConfigProviders, intacl.AllowedKubeConfigAddresses
+	curClusters, curAudiences := intacl.AllowedKubeConfigClusters, intacl.AllowedKubeConfigAudiences
+	intacl.AllowedKubeConfigProviders, intacl.AllowedKubeConfigAddresses = providers, addresses
+	intacl.AllowedKubeConfigClusters, intacl.AllowedKubeConfigAudiences = clusters, addresses
+	t.Cleanup(func() {
+		intacl.AllowedKubeConfigProviders, intacl.AllowedKubeConfigAddresses = curProviders, curAddresses
+		intacl.AllowedKubeConfigClusters, intacl.AllowedKubeConfigAudiences = curClusters, curAudiences
+	})
+}
+
+func Test_execConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	cfg := execConfig(&v2.KubeConfigExec{
+		Command: "credential-helper",
+		Args:    []string{"token"},
+		Env:     []v2.KubeConfigExecEnvVar{{Name: "FOO", Value: "bar"}},
+	})
+	g.Expect(cfg.Command).To(Equal("credential-helper"))
+	g.Expect(cfg.Args).To(Equal([]string{"token"}))
+	g.Expect(cfg.APIVersion).To(Equal(defaultExecAPIVersion))
+	g.Expect(cfg.Env).To(HaveLen(1))
+	g.Expect(cfg.Env[0].Name).To(Equal("FOO"))
+}
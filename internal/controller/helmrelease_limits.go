@@ -0,0 +1,39 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/fluxcd/helm-controller/internal/postrender"
+)
+
+// ManifestLimitsOptions configures the limits enforced on the manifest of
+// the release of a HelmRelease while installing or upgrading.
+type ManifestLimitsOptions struct {
+	// Default are the limits of HelmReleases in namespaces without specific
+	// limits. The zero value disables the limits.
+	Default postrender.ManifestLimits
+	// Namespaces are the limits of HelmReleases in specific namespaces.
+	Namespaces map[string]postrender.ManifestLimits
+}
+
+// forNamespace returns the limits of HelmReleases in the given namespace.
+func (o ManifestLimitsOptions) forNamespace(namespace string) postrender.ManifestLimits {
+	if l, ok := o.Namespaces[namespace]; ok {
+		return l
+	}
+	return o.Default
+}
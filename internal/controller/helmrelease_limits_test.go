@@ -0,0 +1,37 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/helm-controller/internal/postrender"
+)
+
+func TestManifestLimitsOptions_forNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	o := ManifestLimitsOptions{
+		Default:    postrender.ManifestLimits{MaxSize: 1 << 20},
+		Namespaces: map[string]postrender.ManifestLimits{"apps": {MaxObjects: 10}},
+	}
+	g.Expect(o.forNamespace("apps")).To(Equal(postrender.ManifestLimits{MaxObjects: 10}))
+	g.Expect(o.forNamespace("other")).To(Equal(postrender.ManifestLimits{MaxSize: 1 << 20}))
+	g.Expect(ManifestLimitsOptions{}.forNamespace("apps")).To(BeZero())
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/shard"
+)
+
+// requestsForShardChange returns requests for all HelmReleases owned by the
+// shard of this replica, after the members of the shard group changed.
+func (r *HelmReleaseReconciler) requestsForShardChange(ctx context.Context, _ client.Object) []reconcile.Request {
+	var list v2.HelmReleaseList
+	if err := r.List(ctx, &list); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to list HelmReleases for shard group change")
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for i := range list.Items {
+		if r.sharder.Owns(&list.Items[i]) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+	}
+	return reqs
+}
+
+// setShardLabel sets the shard label of the object to the given shard name.
+func setShardLabel(obj client.Object, name string) {
+	labels := obj.GetLabels()
+	if labels[shard.LabelKey] == name {
+		return
+	}
+	if labels == nil {
+		labels = make(map[string]string, 1)
+	}
+	labels[shard.LabelKey] = name
+	obj.SetLabels(labels)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairqueue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits are the limits of a tenant.
+type Limits struct {
+	// MaxConcurrency is the maximum number of items of the tenant processed
+	// concurrently. A value of 0 or less means no limit.
+	MaxConcurrency int
+	// Weight is the relative share of the workers the tenant receives when
+	// multiple tenants have items queued. A value of 0 or less defaults to 1.
+	Weight int
+}
+
+// ParseLimits parses a string in the format '<maxConcurrency>[:<weight>]'
+// into Limits.
+func ParseLimits(s string) (Limits, error) {
+	concurrency, weight, hasWeight := strings.Cut(s, ":")
+	var (
+		l   Limits
+		err error
+	)
+	if l.MaxConcurrency, err = strconv.Atoi(concurrency); err != nil || l.MaxConcurrency < 0 {
+		return Limits{}, fmt.Errorf("invalid maximum concurrency '%s': must be a non-negative integer", concurrency)
+	}
+	if hasWeight {
+		if l.Weight, err = strconv.Atoi(weight); err != nil || l.Weight < 1 {
+			return Limits{}, fmt.Errorf("invalid weight '%s': must be a positive integer", weight)
+		}
+	}
+	return l, nil
+}
+
+// ParseTenantLimits parses a list of strings in the format
+// '<tenant>=<maxConcurrency>[:<weight>]' into Limits per tenant.
+func ParseTenantLimits(values []string) (map[string]Limits, error) {
+	limits := make(map[string]Limits, len(values))
+	for _, v := range values {
+		tenant, l, ok := strings.Cut(v, "=")
+		if !ok || tenant == "" {
+			return nil, fmt.Errorf("invalid tenant limits '%s': must be in the format '<tenant>=<maxConcurrency>[:<weight>]'", v)
+		}
+		parsed, err := ParseLimits(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limits for tenant '%s': %w", tenant, err)
+		}
+		limits[tenant] = parsed
+	}
+	return limits, nil
+}
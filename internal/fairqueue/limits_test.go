@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairqueue
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limits
+		wantErr bool
+	}{
+		{in: "0", want: Limits{}},
+		{in: "4", want: Limits{MaxConcurrency: 4}},
+		{in: "2:3", want: Limits{MaxConcurrency: 2, Weight: 3}},
+		{in: "", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "2:0", wantErr: true},
+		{in: "2:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := ParseLimits(tt.in)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestParseTenantLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	got, err := ParseTenantLimits([]string{"team-a=2", "team-b=4:3"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(map[string]Limits{
+		"team-a": {MaxConcurrency: 2},
+		"team-b": {MaxConcurrency: 4, Weight: 3},
+	}))
+
+	_, err = ParseTenantLimits([]string{"team-a"})
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseTenantLimits([]string{"=2"})
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseTenantLimits([]string{"team-a=x"})
+	g.Expect(err).To(HaveOccurred())
+}
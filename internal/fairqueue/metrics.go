@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairqueue
+
+import (
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// unfinishedWorkUpdatePeriod is the interval at which the unfinished work
+// metrics are updated, equal to that of workqueue.Type.
+const unfinishedWorkUpdatePeriod = 500 * time.Millisecond
+
+// queueMetrics records the workqueue metrics of a Queue, in the same way as
+// workqueue.Type does. The methods must be called with the lock of the
+// Queue held, and are a no-op on a nil queueMetrics.
+type queueMetrics struct {
+	depth                   workqueue.GaugeMetric
+	adds                    workqueue.CounterMetric
+	latency                 workqueue.HistogramMetric
+	workDuration            workqueue.HistogramMetric
+	unfinishedWorkSeconds   workqueue.SettableGaugeMetric
+	longestRunningProcessor workqueue.SettableGaugeMetric
+
+	addTimes             map[interface{}]time.Time
+	processingStartTimes map[interface{}]time.Time
+
+	nowFunc func() time.Time
+}
+
+// newQueueMetrics returns the queueMetrics for the queue with the given name
+// from the MetricsProvider.
+func newQueueMetrics(name string, mp workqueue.MetricsProvider, nowFunc func() time.Time) *queueMetrics {
+	return &queueMetrics{
+		depth:                   mp.NewDepthMetric(name),
+		adds:                    mp.NewAddsMetric(name),
+		latency:                 mp.NewLatencyMetric(name),
+		workDuration:            mp.NewWorkDurationMetric(name),
+		unfinishedWorkSeconds:   mp.NewUnfinishedWorkSecondsMetric(name),
+		longestRunningProcessor: mp.NewLongestRunningProcessorSecondsMetric(name),
+		addTimes:                make(map[interface{}]time.Time),
+		processingStartTimes:    make(map[interface{}]time.Time),
+		nowFunc:                 nowFunc,
+	}
+}
+
+// add records the item was marked as needing processing.
+func (m *queueMetrics) add(item interface{}) {
+	if m == nil {
+		return
+	}
+
+	m.adds.Inc()
+	m.depth.Inc()
+	if _, ok := m.addTimes[item]; !ok {
+		m.addTimes[item] = m.nowFunc()
+	}
+}
+
+// get records the item was handed out to a worker.
+func (m *queueMetrics) get(item interface{}) {
+	if m == nil {
+		return
+	}
+
+	m.depth.Dec()
+	m.processingStartTimes[item] = m.nowFunc()
+	if start, ok := m.addTimes[item]; ok {
+		m.latency.Observe(m.sinceInSeconds(start))
+		delete(m.addTimes, item)
+	}
+}
+
+// done records the item is done processing.
+func (m *queueMetrics) done(item interface{}) {
+	if m == nil {
+		return
+	}
+
+	if start, ok := m.processingStartTimes[item]; ok {
+		m.workDuration.Observe(m.sinceInSeconds(start))
+		delete(m.processingStartTimes, item)
+	}
+}
+
+// updateUnfinishedWork records the total and longest processing time of the
+// items being processed.
+func (m *queueMetrics) updateUnfinishedWork() {
+	if m == nil {
+		return
+	}
+
+	var total, oldest float64
+	for _, start := range m.processingStartTimes {
+		age := m.sinceInSeconds(start)
+		total += age
+		if age > oldest {
+			oldest = age
+		}
+	}
+	m.unfinishedWorkSeconds.Set(total)
+	m.longestRunningProcessor.Set(oldest)
+}
+
+// sinceInSeconds returns the time since start in seconds.
+func (m *queueMetrics) sinceInSeconds(start time.Time) float64 {
+	return m.nowFunc().Sub(start).Seconds()
+}
@@ -0,0 +1,473 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fairqueue provides a work queue which shares the workers of a
+// controller fairly between tenants, with configurable concurrency limits
+// and weights per tenant, and which hands out the items of a tenant with a
+// higher priority first. Items with a critical priority are handed out
+// before the items of other tenants.
+package fairqueue
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/fluxcd/helm-controller/internal/metrics"
+)
+
+// DefaultTenant is the tenant of items for which the TenantFunc returns an
+// empty string.
+const DefaultTenant = "default"
+
+// TenantFunc returns the tenant of the given item.
+type TenantFunc func(item interface{}) string
+
+// PriorityFunc returns the priority of the given item.
+type PriorityFunc func(item interface{}) int32
+
+// Option configures a Queue.
+type Option func(*Queue)
+
+// WithDefaultLimits sets the Limits of tenants without specific limits.
+func WithDefaultLimits(l Limits) Option {
+	return func(q *Queue) {
+		q.defaultLimits = l
+	}
+}
+
+// WithPriorityFunc sets the PriorityFunc to determine the priority of items
+// with. Without it, all items have the same priority.
+func WithPriorityFunc(f PriorityFunc) Option {
+	return func(q *Queue) {
+		q.priorityFunc = f
+	}
+}
+
+// WithCriticalPriority sets the priority from which items are critical.
+// Critical items are handed out before any non-critical items, regardless of
+// the fair order between tenants, and in the order of their priority across
+// tenants. Without it, no items are critical.
+func WithCriticalPriority(priority int32) Option {
+	return func(q *Queue) {
+		q.criticalPriority = &priority
+	}
+}
+
+// WithMetricsProvider sets the workqueue.MetricsProvider to record the
+// workqueue metrics of the queue with, as named by NewRateLimitingQueue.
+// Without it, no workqueue metrics are recorded.
+func WithMetricsProvider(mp workqueue.MetricsProvider) Option {
+	return func(q *Queue) {
+		q.metricsProvider = mp
+	}
+}
+
+// WithTenantLimits sets the Limits of specific tenants.
+func WithTenantLimits(limits map[string]Limits) Option {
+	return func(q *Queue) {
+		q.tenantLimits = limits
+	}
+}
+
+// Queue is a workqueue.Interface which queues the items of every tenant
+// separately, and hands them out to workers in a weighted fair order, while
+// honoring the maximum concurrency of each tenant. This prevents a tenant
+// with many (slow) items from monopolizing the workers.
+//
+// Items of a tenant with a higher priority are handed out before its items
+// with a lower priority. Priorities are not compared across tenants, so a
+// tenant can not claim more than its fair share by raising the priority of
+// its items. Items with the same priority are handed out in the order they
+// were queued. The exception are items with a priority equal to or higher
+// than the critical priority, if configured, which are handed out before the
+// non-critical items of all tenants. They do count towards the share of the
+// tenant.
+//
+// Like workqueue.Type, an item is never processed concurrently, and an item
+// added multiple times before it is processed is only processed once.
+type Queue struct {
+	tenantFunc       TenantFunc
+	priorityFunc     PriorityFunc
+	criticalPriority *int32
+	defaultLimits    Limits
+	tenantLimits     map[string]Limits
+	metricsProvider  workqueue.MetricsProvider
+
+	tenants map[string]*tenant
+	// dirty are the items which need to be processed.
+	dirty map[interface{}]struct{}
+	// processing are the items being processed, and their tenant.
+	processing map[interface{}]string
+	// vtime is the virtual time of the queue, which is the virtual finish
+	// time of the tenant of the last item handed out.
+	vtime float64
+
+	cond         *sync.Cond
+	shuttingDown bool
+	// paused indicates no items are handed out until resumed.
+	paused bool
+
+	// metrics records the workqueue metrics, once named by
+	// NewRateLimitingQueue.
+	metrics *queueMetrics
+
+	nowFunc func() time.Time
+}
+
+// tenant holds the queued items of a tenant.
+type tenant struct {
+	name   string
+	limits Limits
+	items  []queuedItem
+	active int
+	// vtime is the virtual finish time of the tenant, which increments by
+	// the inverse of its weight for every item handed out.
+	vtime float64
+}
+
+// queuedItem is an item with its priority, and the time it was queued.
+type queuedItem struct {
+	item     interface{}
+	priority int32
+	added    time.Time
+}
+
+// New returns a new Queue which determines the tenant of items using the
+// given TenantFunc.
+func New(tenantFunc TenantFunc, opts ...Option) *Queue {
+	q := &Queue{
+		tenantFunc: tenantFunc,
+		tenants:    make(map[string]*tenant),
+		dirty:      make(map[interface{}]struct{}),
+		processing: make(map[interface{}]string),
+		cond:       sync.NewCond(&sync.Mutex{}),
+		nowFunc:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// NewRateLimitingQueue returns a workqueue.RateLimitingInterface with the
+// given name and rate limiter, which is backed by the Queue. If configured,
+// the workqueue metrics of the Queue are recorded under the given name.
+func (q *Queue) NewRateLimitingQueue(name string, rateLimiter workqueue.RateLimiter) workqueue.RateLimitingInterface {
+	if q.metricsProvider != nil && name != "" {
+		q.cond.L.Lock()
+		q.metrics = newQueueMetrics(name, q.metricsProvider, q.nowFunc)
+		q.cond.L.Unlock()
+		go q.updateUnfinishedWorkLoop()
+	}
+	return workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{
+		Name: name,
+		DelayingQueue: workqueue.NewDelayingQueueWithConfig(workqueue.DelayingQueueConfig{
+			Name:            name,
+			MetricsProvider: q.metricsProvider,
+			Queue:           q,
+		}),
+	})
+}
+
+// Add marks the item as needing processing, and queues it for its tenant
+// unless it is already queued or being processed.
+func (q *Queue) Add(item interface{}) {
+	// Determine the tenant and priority before taking the lock, as the
+	// functions may read from a cache.
+	name := q.tenantOf(item)
+	priority := q.priorityOf(item)
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.metrics.add(item)
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+	q.enqueue(item, name, priority)
+	q.cond.Signal()
+}
+
+// Len returns the number of queued items.
+func (q *Queue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return q.queued()
+}
+
+// Get blocks until an item can be processed, and returns it. The item is
+// taken from the tenant with the highest critical priority item, or else
+// the tenant with the lowest virtual finish time, out of the tenants with
+// queued items which have not reached their maximum concurrency. It returns
+// true if the queue is shutting down, and no items are queued.
+func (q *Queue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for {
+		if q.paused && q.shuttingDown {
+			return nil, true
+		}
+		if t, critical := q.next(); t != nil && !q.paused {
+			qi := t.items[0]
+			t.items[0] = queuedItem{}
+			t.items = t.items[1:]
+			t.active++
+			// A critical item is handed out out of the fair order, and
+			// does not advance the virtual time of the queue.
+			if !critical {
+				q.vtime = t.vtime
+			}
+			t.vtime += 1 / float64(t.weight())
+
+			delete(q.dirty, qi.item)
+			q.processing[qi.item] = t.name
+			q.metrics.get(qi.item)
+
+			metrics.RecordFairQueueWait(t.name, q.nowFunc().Sub(qi.added))
+			metrics.SetFairQueueState(t.name, len(t.items), t.active)
+			return qi.item, false
+		}
+		if q.shuttingDown && q.queued() == 0 {
+			return nil, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// Done marks the item as done processing. If it was added again while being
+// processed, it is queued again.
+func (q *Queue) Done(item interface{}) {
+	// Determine the priority before taking the lock, in case the item needs
+	// to be queued again.
+	priority := q.priorityOf(item)
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	name, ok := q.processing[item]
+	if !ok {
+		return
+	}
+	delete(q.processing, item)
+	q.metrics.done(item)
+
+	t := q.tenants[name]
+	t.active--
+	if _, ok := q.dirty[item]; ok {
+		q.enqueue(item, name, priority)
+	}
+	metrics.SetFairQueueState(t.name, len(t.items), t.active)
+	if len(t.items) == 0 && t.active == 0 {
+		delete(q.tenants, name)
+		metrics.DeleteFairQueueTenant(name)
+	}
+	// Wake up all workers, as the tenant may have dropped below its
+	// maximum concurrency.
+	q.cond.Broadcast()
+}
+
+// Pause stops handing out items to workers, without affecting the items
+// being processed. Items can still be added while paused.
+func (q *Queue) Pause() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.paused = true
+}
+
+// Resume resumes handing out items to workers after Pause.
+func (q *Queue) Resume() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.paused = false
+	q.cond.Broadcast()
+}
+
+// ShutDown makes the queue ignore new items, and makes workers return once
+// the queued items have been handed out.
+func (q *Queue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain shuts down the queue, and blocks until all items being
+// processed are done.
+func (q *Queue) ShutDownWithDrain() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.processing) > 0 {
+		q.cond.Wait()
+	}
+}
+
+// ShuttingDown returns true if the queue is shutting down.
+func (q *Queue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return q.shuttingDown
+}
+
+// updateUnfinishedWorkLoop periodically updates the unfinished work metrics
+// until the queue is shutting down.
+func (q *Queue) updateUnfinishedWorkLoop() {
+	t := time.NewTicker(unfinishedWorkUpdatePeriod)
+	defer t.Stop()
+	for range t.C {
+		if !func() bool {
+			q.cond.L.Lock()
+			defer q.cond.L.Unlock()
+
+			if q.shuttingDown {
+				return false
+			}
+			q.metrics.updateUnfinishedWork()
+			return true
+		}() {
+			return
+		}
+	}
+}
+
+// enqueue queues the item for the tenant with the given name, after the
+// queued items with the same or a higher priority. A tenant which becomes
+// backlogged starts at the virtual time of the queue, so it can not claim
+// the share it did not use while idle.
+func (q *Queue) enqueue(item interface{}, name string, priority int32) {
+	t, ok := q.tenants[name]
+	if !ok {
+		t = &tenant{name: name, limits: q.limitsOf(name)}
+		q.tenants[name] = t
+	}
+	if len(t.items) == 0 && t.vtime < q.vtime {
+		t.vtime = q.vtime
+	}
+	i := sort.Search(len(t.items), func(i int) bool {
+		return t.items[i].priority < priority
+	})
+	t.items = slices.Insert(t.items, i, queuedItem{item: item, priority: priority, added: q.nowFunc()})
+	metrics.RecordFairQueueAdd(name)
+	metrics.SetFairQueueState(name, len(t.items), t.active)
+}
+
+// next returns the tenant to hand out the next item for, or nil if no
+// tenant has items which can be processed. This is the tenant of which the
+// next item has the highest critical priority, or else the tenant with the
+// lowest virtual time. It returns true if the next item of the tenant is
+// critical.
+func (q *Queue) next() (*tenant, bool) {
+	var candidates, critical []*tenant
+	for _, t := range q.tenants {
+		if len(t.items) > 0 && (t.limits.MaxConcurrency <= 0 || t.active < t.limits.MaxConcurrency) {
+			candidates = append(candidates, t)
+			if q.isCritical(t.items[0].priority) {
+				critical = append(critical, t)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	if len(critical) > 0 {
+		sort.Slice(critical, func(i, j int) bool {
+			if pi, pj := critical[i].items[0].priority, critical[j].items[0].priority; pi != pj {
+				return pi > pj
+			}
+			return critical[i].before(critical[j])
+		})
+		return critical[0], true
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].before(candidates[j])
+	})
+	return candidates[0], false
+}
+
+// isCritical returns true if the priority is equal to or higher than the
+// critical priority.
+func (q *Queue) isCritical(priority int32) bool {
+	return q.criticalPriority != nil && priority >= *q.criticalPriority
+}
+
+// queued returns the number of queued items.
+func (q *Queue) queued() int {
+	var n int
+	for _, t := range q.tenants {
+		n += len(t.items)
+	}
+	return n
+}
+
+// tenantOf returns the tenant of the item.
+func (q *Queue) tenantOf(item interface{}) string {
+	if name := q.tenantFunc(item); name != "" {
+		return name
+	}
+	return DefaultTenant
+}
+
+// priorityOf returns the priority of the item.
+func (q *Queue) priorityOf(item interface{}) int32 {
+	if q.priorityFunc == nil {
+		return 0
+	}
+	return q.priorityFunc(item)
+}
+
+// limitsOf returns the Limits of the tenant with the given name.
+func (q *Queue) limitsOf(name string) Limits {
+	if l, ok := q.tenantLimits[name]; ok {
+		return l
+	}
+	return q.defaultLimits
+}
+
+// before returns true if the tenant is before the other tenant in the fair
+// order, which is the order of their virtual time.
+func (t *tenant) before(other *tenant) bool {
+	if t.vtime != other.vtime {
+		return t.vtime < other.vtime
+	}
+	return t.name < other.name
+}
+
+// weight returns the weight of the tenant.
+func (t *tenant) weight() int {
+	if t.limits.Weight <= 0 {
+		return 1
+	}
+	return t.limits.Weight
+}
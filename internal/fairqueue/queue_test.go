@@ -0,0 +1,323 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fairqueue
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// tenantPrefix returns the part of the item before the '/' as its tenant.
+func tenantPrefix(item interface{}) string {
+	tenant, _, _ := strings.Cut(item.(string), "/")
+	return tenant
+}
+
+func get(g *WithT, q *Queue) string {
+	item, shutdown := q.Get()
+	g.Expect(shutdown).To(BeFalse())
+	return item.(string)
+}
+
+func TestQueue_Add(t *testing.T) {
+	g := NewWithT(t)
+
+	q := New(tenantPrefix)
+	q.Add("a/1")
+	q.Add("a/1")
+	q.Add("b/1")
+	g.Expect(q.Len()).To(Equal(2))
+
+	g.Expect(get(g, q)).To(Equal("a/1"))
+
+	// Adding an item being processed queues it after it is done.
+	q.Add("a/1")
+	g.Expect(q.Len()).To(Equal(1))
+	q.Done("a/1")
+	g.Expect(q.Len()).To(Equal(2))
+}
+
+func TestQueue_Get(t *testing.T) {
+	t.Run("alternates between tenants", func(t *testing.T) {
+		g := NewWithT(t)
+
+		q := New(tenantPrefix)
+		for _, item := range []string{"a/1", "a/2", "a/3", "a/4", "b/1", "b/2"} {
+			q.Add(item)
+		}
+
+		var got []string
+		for q.Len() > 0 {
+			got = append(got, get(g, q))
+		}
+		g.Expect(got).To(Equal([]string{"a/1", "b/1", "a/2", "b/2", "a/3", "a/4"}))
+	})
+
+	t.Run("does not give credit to idle tenants", func(t *testing.T) {
+		g := NewWithT(t)
+
+		q := New(tenantPrefix)
+		for _, item := range []string{"a/1", "a/2", "a/3", "a/4"} {
+			q.Add(item)
+		}
+		g.Expect(get(g, q)).To(Equal("a/1"))
+		g.Expect(get(g, q)).To(Equal("a/2"))
+
+		q.Add("b/1")
+		q.Add("b/2")
+		var got []string
+		for q.Len() > 0 {
+			got = append(got, get(g, q))
+		}
+		g.Expect(got).To(Equal([]string{"b/1", "a/3", "b/2", "a/4"}))
+	})
+
+	t.Run("honors weights", func(t *testing.T) {
+		g := NewWithT(t)
+
+		q := New(tenantPrefix, WithTenantLimits(map[string]Limits{"a": {Weight: 2}}))
+		for _, item := range []string{"a/1", "a/2", "a/3", "a/4", "b/1", "b/2"} {
+			q.Add(item)
+		}
+
+		var got []string
+		for q.Len() > 0 {
+			got = append(got, get(g, q))
+		}
+		g.Expect(got).To(Equal([]string{"a/1", "b/1", "a/2", "a/3", "b/2", "a/4"}))
+	})
+
+	t.Run("hands out higher priorities of a tenant first", func(t *testing.T) {
+		g := NewWithT(t)
+
+		priorities := map[string]int32{"a/2": 10, "b/2": 100, "b/3": 10}
+		q := New(tenantPrefix, WithPriorityFunc(func(item interface{}) int32 {
+			return priorities[item.(string)]
+		}))
+		for _, item := range []string{"a/1", "a/2", "a/3", "b/1", "b/2", "b/3"} {
+			q.Add(item)
+		}
+
+		var got []string
+		for q.Len() > 0 {
+			got = append(got, get(g, q))
+		}
+		// Priorities do not affect the fair order between tenants.
+		g.Expect(got).To(Equal([]string{"a/2", "b/2", "a/1", "b/3", "a/3", "b/1"}))
+	})
+
+	t.Run("hands out critical priorities across tenants first", func(t *testing.T) {
+		g := NewWithT(t)
+
+		priorities := map[string]int32{"a/2": 10, "b/2": 100, "b/3": 500, "c/1": 100}
+		q := New(tenantPrefix, WithCriticalPriority(100), WithPriorityFunc(func(item interface{}) int32 {
+			return priorities[item.(string)]
+		}))
+		for _, item := range []string{"a/1", "a/2", "a/3", "b/1", "b/2", "b/3", "c/1"} {
+			q.Add(item)
+		}
+
+		var got []string
+		for q.Len() > 0 {
+			got = append(got, get(g, q))
+		}
+		// The critical items are handed out first, in the fair order for
+		// the same priority, after which the tenants which received
+		// critical items have used their share.
+		g.Expect(got).To(Equal([]string{"b/3", "c/1", "b/2", "a/2", "a/1", "a/3", "b/1"}))
+	})
+
+	t.Run("critical priorities honor maximum concurrency", func(t *testing.T) {
+		g := NewWithT(t)
+
+		priorities := map[string]int32{"a/1": 100, "a/2": 100}
+		q := New(tenantPrefix, WithCriticalPriority(100), WithDefaultLimits(Limits{MaxConcurrency: 1}),
+			WithPriorityFunc(func(item interface{}) int32 {
+				return priorities[item.(string)]
+			}))
+		for _, item := range []string{"a/1", "a/2", "b/1"} {
+			q.Add(item)
+		}
+
+		item, _ := q.Get()
+		g.Expect(item).To(Equal("a/1"))
+		item, _ = q.Get()
+		g.Expect(item).To(Equal("b/1"))
+	})
+
+	t.Run("honors maximum concurrency", func(t *testing.T) {
+		g := NewWithT(t)
+
+		q := New(tenantPrefix, WithDefaultLimits(Limits{MaxConcurrency: 1}))
+		q.Add("a/1")
+		q.Add("a/2")
+		g.Expect(get(g, q)).To(Equal("a/1"))
+
+		got := make(chan string)
+		go func() {
+			item, _ := q.Get()
+			got <- item.(string)
+		}()
+		g.Consistently(got, 100*time.Millisecond).ShouldNot(Receive())
+
+		q.Done("a/1")
+		g.Eventually(got).Should(Receive(Equal("a/2")))
+	})
+
+	t.Run("returns on shutdown", func(t *testing.T) {
+		g := NewWithT(t)
+
+		q := New(tenantPrefix)
+		q.Add("a/1")
+		q.ShutDown()
+		g.Expect(q.ShuttingDown()).To(BeTrue())
+
+		q.Add("a/2")
+		g.Expect(get(g, q)).To(Equal("a/1"))
+		_, shutdown := q.Get()
+		g.Expect(shutdown).To(BeTrue())
+	})
+}
+
+func TestQueue_Pause(t *testing.T) {
+	g := NewWithT(t)
+
+	q := New(tenantPrefix)
+	q.Pause()
+	q.Add("a/1")
+	g.Expect(q.Len()).To(Equal(1))
+
+	got := make(chan string)
+	go func() {
+		item, _ := q.Get()
+		got <- item.(string)
+	}()
+	g.Consistently(got, 100*time.Millisecond).ShouldNot(Receive())
+
+	q.Resume()
+	g.Eventually(got).Should(Receive(Equal("a/1")))
+
+	// Workers return on shutdown while paused.
+	q.Pause()
+	q.Add("a/2")
+	q.ShutDown()
+	_, shutdown := q.Get()
+	g.Expect(shutdown).To(BeTrue())
+}
+
+func TestQueue_ShutDownWithDrain(t *testing.T) {
+	g := NewWithT(t)
+
+	q := New(tenantPrefix)
+	q.Add("a/1")
+	g.Expect(get(g, q)).To(Equal("a/1"))
+
+	done := make(chan struct{})
+	go func() {
+		q.ShutDownWithDrain()
+		close(done)
+	}()
+	g.Consistently(done, 100*time.Millisecond).ShouldNot(BeClosed())
+
+	q.Done("a/1")
+	g.Eventually(done).Should(BeClosed())
+}
+
+func TestQueue_NewRateLimitingQueue(t *testing.T) {
+	g := NewWithT(t)
+
+	q := New(tenantPrefix).NewRateLimitingQueue("", nil)
+	q.AddAfter("a/1", 10*time.Millisecond)
+	q.Add("b/1")
+
+	item, _ := q.Get()
+	g.Expect(item).To(Equal("b/1"))
+	item, _ = q.Get()
+	g.Expect(item).To(Equal("a/1"))
+	q.ShutDown()
+}
+
+func TestQueue_WithMetricsProvider(t *testing.T) {
+	g := NewWithT(t)
+
+	mp := &fakeMetricsProvider{}
+	q := New(tenantPrefix, WithMetricsProvider(mp))
+	rq := q.NewRateLimitingQueue("test", nil)
+	t.Cleanup(rq.ShutDown)
+
+	rq.Add("a/1")
+	rq.Add("a/1")
+	rq.Add("b/1")
+	g.Expect(mp.adds.value).To(Equal(float64(2)))
+	g.Expect(mp.depth.value).To(Equal(float64(2)))
+
+	item, _ := rq.Get()
+	g.Expect(mp.depth.value).To(Equal(float64(1)))
+	g.Expect(mp.latency.observations).To(Equal(1))
+
+	// Adding an item being processed counts as an add.
+	rq.Add(item)
+	g.Expect(mp.adds.value).To(Equal(float64(3)))
+	g.Expect(mp.depth.value).To(Equal(float64(2)))
+
+	rq.Done(item)
+	g.Expect(mp.workDuration.observations).To(Equal(1))
+	g.Expect(rq.Len()).To(Equal(2))
+}
+
+// fakeMetric is a workqueue metric which records its value and the number
+// of observations.
+type fakeMetric struct {
+	value        float64
+	observations int
+}
+
+func (m *fakeMetric) Inc()              { m.value++ }
+func (m *fakeMetric) Dec()              { m.value-- }
+func (m *fakeMetric) Set(v float64)     { m.value = v }
+func (m *fakeMetric) Observe(v float64) { m.observations++ }
+
+// fakeMetricsProvider is a workqueue.MetricsProvider of fakeMetrics.
+type fakeMetricsProvider struct {
+	depth, adds, latency, workDuration, unfinished, longestRunning, retries fakeMetric
+}
+
+func (p *fakeMetricsProvider) NewDepthMetric(string) workqueue.GaugeMetric {
+	return &p.depth
+}
+func (p *fakeMetricsProvider) NewAddsMetric(string) workqueue.CounterMetric {
+	return &p.adds
+}
+func (p *fakeMetricsProvider) NewLatencyMetric(string) workqueue.HistogramMetric {
+	return &p.latency
+}
+func (p *fakeMetricsProvider) NewWorkDurationMetric(string) workqueue.HistogramMetric {
+	return &p.workDuration
+}
+func (p *fakeMetricsProvider) NewUnfinishedWorkSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return &p.unfinished
+}
+func (p *fakeMetricsProvider) NewLongestRunningProcessorSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return &p.longestRunning
+}
+func (p *fakeMetricsProvider) NewRetriesMetric(string) workqueue.CounterMetric {
+	return &p.retries
+}
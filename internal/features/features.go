@@ -58,6 +58,18 @@ const (
 	// without the need to upgrade the Helm release. But it can be disabled to
 	// avoid potential abuse of the adoption mechanism.
 	AdoptLegacyReleases = "AdoptLegacyReleases"
+
+	// PreflightPermissionCheck enables checking the permissions required to
+	// install or upgrade a Helm release using SelfSubjectAccessReviews
+	// before running the action, to fail fast when the (impersonated) client
+	// lacks permissions.
+	PreflightPermissionCheck = "PreflightPermissionCheck"
+
+	// DriftCorrectionObjectEvents enables recording the correction of cluster
+	// state drift on each corrected object, by emitting a Kubernetes Event on
+	// the object and annotating it with the time of the correction and the
+	// reverted fields.
+	DriftCorrectionObjectEvents = "DriftCorrectionObjectEvents"
 )
 
 var features = map[string]bool{
@@ -79,6 +91,12 @@ var features = map[string]bool{
 	// AdoptLegacyReleases
 	// opt-out from v0.37
 	AdoptLegacyReleases: true,
+	// PreflightPermissionCheck
+	// opt-in
+	PreflightPermissionCheck: false,
+	// DriftCorrectionObjectEvents
+	// opt-in
+	DriftCorrectionObjectEvents: false,
 }
 
 // FeatureGates contains a list of all supported feature gates and
@@ -26,6 +26,8 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/transport"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/fluxcd/pkg/runtime/client"
@@ -52,6 +54,22 @@ func WithImpersonate(serviceAccount, namespace string) Option {
 	}
 }
 
+// WithImpersonateUser sets the user, groups and extra fields to
+// impersonate. It configures the REST client to impersonate the user, and
+// sets them as the impersonation overrides in the raw KubeConfig.
+func WithImpersonateUser(user string, groups []string, extra map[string][]string) Option {
+	return func(c *MemoryRESTClientGetter) {
+		c.cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: user,
+			Groups:   groups,
+			Extra:    extra,
+		}
+		c.impersonate = user
+		c.impersonateGroups = groups
+		c.impersonateExtra = extra
+	}
+}
+
 // WithClientOptions sets the client options (e.g. QPS and Burst) to use for
 // the client.
 func WithClientOptions(opts client.Options) Option {
@@ -69,6 +87,33 @@ func WithPersistent(persist bool) Option {
 	}
 }
 
+// WithDiscoveryCache sets the DiscoveryCache to retrieve the discovery client
+// and REST mapper from when the client is persistent, sharing them with other
+// clients for the same cluster and identity.
+func WithDiscoveryCache(cache *DiscoveryCache) Option {
+	return func(c *MemoryRESTClientGetter) {
+		c.discoveryCache = cache
+	}
+}
+
+// WithEventRecorderCache sets the EventRecorderCache to retrieve the
+// EventRecorder for the cluster from, sharing it with other clients for the
+// same cluster and identity.
+func WithEventRecorderCache(cache *EventRecorderCache) Option {
+	return func(c *MemoryRESTClientGetter) {
+		c.eventRecorders = cache
+	}
+}
+
+// WithWrapTransport returns a MemoryRESTClientGetter Option that wraps the
+// transport of the REST config with the given function, in addition to any
+// existing wrapper.
+func WithWrapTransport(fn transport.WrapperFunc) Option {
+	return func(c *MemoryRESTClientGetter) {
+		c.cfg.Wrap(fn)
+	}
+}
+
 // MemoryRESTClientGetter is a resource.RESTClientGetter that uses an
 // in-memory REST config, REST mapper, and discovery client.
 // If configured, the client config, REST mapper, and discovery client are
@@ -78,10 +123,31 @@ type MemoryRESTClientGetter struct {
 	namespace string
 	// impersonate is the username to use for the client.
 	impersonate string
+	// impersonateGroups are the groups to use for the client.
+	impersonateGroups []string
+	// impersonateExtra are the extra fields to use for the client.
+	impersonateExtra map[string][]string
 	// persistent indicates whether the client should persist the restMapper,
 	// clientCfg, and discoveryClient. Rather than re-initializing them on
 	// every call, they will be cached and reused.
 	persistent bool
+	// discoveryCache is the shared cache to retrieve the discovery client and
+	// REST mapper from when persistent.
+	discoveryCache *DiscoveryCache
+
+	discoveryEntry   *discoveryCacheEntry
+	discoveryEntryMu sync.Mutex
+
+	// eventRecorders is the shared cache to retrieve the EventRecorder for
+	// the cluster from.
+	eventRecorders *EventRecorderCache
+
+	// tokenSource indicates the client authenticates using a TokenSource,
+	// of which the credentials are not part of the REST config.
+	tokenSource bool
+	// tokenSourceIdentity is the identity of the tokens obtained from the
+	// TokenSource, used to key the DiscoveryCache.
+	tokenSourceIdentity string
 
 	cfg *rest.Config
 
@@ -146,6 +212,15 @@ func (c *MemoryRESTClientGetter) toPersistentDiscoveryClient() (discovery.Cached
 	c.discoveryMu.Lock()
 	defer c.discoveryMu.Unlock()
 
+	if c.discoveryClient == nil && c.discoveryCache != nil {
+		e, err := c.sharedDiscovery()
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			c.discoveryClient = e.discovery
+		}
+	}
 	if c.discoveryClient == nil {
 		discoveryClient, err := c.toDiscoveryClient()
 		if err != nil {
@@ -156,12 +231,65 @@ func (c *MemoryRESTClientGetter) toPersistentDiscoveryClient() (discovery.Cached
 	return c.discoveryClient, nil
 }
 
+// sharedDiscovery returns the entry from the DiscoveryCache for the REST
+// config. The entry is retrieved once, and reused for subsequent calls.
+// It returns nil if the client authenticates using a TokenSource without an
+// identity, as the entry could otherwise be shared with clients with other
+// credentials.
+func (c *MemoryRESTClientGetter) sharedDiscovery() (*discoveryCacheEntry, error) {
+	c.discoveryEntryMu.Lock()
+	defer c.discoveryEntryMu.Unlock()
+
+	if c.tokenSource && c.tokenSourceIdentity == "" {
+		return nil, nil
+	}
+
+	if c.discoveryEntry == nil {
+		config, err := c.ToRESTConfig()
+		if err != nil {
+			return nil, err
+		}
+		e, err := c.discoveryCache.get(config, c.tokenSourceIdentity)
+		if err != nil {
+			return nil, err
+		}
+		c.discoveryEntry = e
+	}
+	return c.discoveryEntry, nil
+}
+
+// ToEventRecorder returns an EventRecorder emitting Events as the given
+// component in the cluster of the REST config, from the EventRecorderCache.
+// It returns an error if no EventRecorderCache is configured, or if the
+// client authenticates using a TokenSource without an identity, as the
+// EventRecorder could otherwise be shared with clients with other
+// credentials.
+func (c *MemoryRESTClientGetter) ToEventRecorder(component string) (record.EventRecorder, error) {
+	if c.eventRecorders == nil {
+		return nil, fmt.Errorf("MemoryRESTClientGetter has no EventRecorderCache")
+	}
+	if c.tokenSource && c.tokenSourceIdentity == "" {
+		return nil, fmt.Errorf("MemoryRESTClientGetter has a TokenSource without identity")
+	}
+	config, err := c.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return c.eventRecorders.get(config, c.tokenSourceIdentity, component)
+}
+
 func (c *MemoryRESTClientGetter) toDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
 	config, err := c.ToRESTConfig()
 	if err != nil {
 		return nil, err
 	}
 
+	return newMemCacheDiscoveryClient(config)
+}
+
+// newMemCacheDiscoveryClient returns a memory cached discovery client for the
+// given REST config.
+func newMemCacheDiscoveryClient(config *rest.Config) (discovery.CachedDiscoveryInterface, error) {
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
 		return nil, err
@@ -182,6 +310,15 @@ func (c *MemoryRESTClientGetter) toPersistentRESTMapper() (meta.RESTMapper, erro
 	c.restMapperMu.Lock()
 	defer c.restMapperMu.Unlock()
 
+	if c.restMapper == nil && c.discoveryCache != nil {
+		e, err := c.sharedDiscovery()
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			c.restMapper = restmapper.NewShortcutExpander(e.mapper, e.discovery, nil)
+		}
+	}
 	if c.restMapper == nil {
 		restMapper, err := c.toRESTMapper()
 		if err != nil {
@@ -230,6 +367,8 @@ func (c *MemoryRESTClientGetter) toRawKubeConfigLoader() clientcmd.ClientConfig
 	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
 	overrides.Context.Namespace = c.namespace
 	overrides.AuthInfo.Impersonate = c.impersonate
+	overrides.AuthInfo.ImpersonateGroups = c.impersonateGroups
+	overrides.AuthInfo.ImpersonateUserExtra = c.impersonateExtra
 
 	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
 }
@@ -18,10 +18,14 @@ package kube
 
 import (
 	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
+	"golang.org/x/oauth2"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/fluxcd/pkg/runtime/client"
@@ -52,6 +56,51 @@ func TestWithImpersonate(t *testing.T) {
 	})
 }
 
+func TestWithImpersonateUser(t *testing.T) {
+	t.Run("sets the user, groups and extra", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := &MemoryRESTClientGetter{
+			cfg: &rest.Config{
+				Host: "https://example.com",
+			},
+		}
+		WithImpersonateUser("jane", []string{"admins"}, map[string][]string{"scopes": {"view"}})(c)
+		g.Expect(c.impersonate).To(Equal("jane"))
+		g.Expect(c.impersonateGroups).To(Equal([]string{"admins"}))
+		g.Expect(c.impersonateExtra).To(Equal(map[string][]string{"scopes": {"view"}}))
+		g.Expect(c.cfg.Impersonate).To(Equal(rest.ImpersonationConfig{
+			UserName: "jane",
+			Groups:   []string{"admins"},
+			Extra:    map[string][]string{"scopes": {"view"}},
+		}))
+	})
+}
+
+func TestWithWrapTransport(t *testing.T) {
+	t.Run("wraps the existing transport wrapper", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var calls []string
+		wrapper := func(name string) transport.WrapperFunc {
+			return func(rt http.RoundTripper) http.RoundTripper {
+				calls = append(calls, name)
+				return rt
+			}
+		}
+
+		c := &MemoryRESTClientGetter{
+			cfg: &rest.Config{
+				Host:          "https://example.com",
+				WrapTransport: wrapper("existing"),
+			},
+		}
+		WithWrapTransport(wrapper("new"))(c)
+		c.cfg.WrapTransport(http.DefaultTransport)
+		g.Expect(calls).To(Equal([]string{"existing", "new"}))
+	})
+}
+
 func TestWithPersistent(t *testing.T) {
 	t.Run("sets persistent flag", func(t *testing.T) {
 		g := NewWithT(t)
@@ -304,3 +353,56 @@ func TestMemoryRESTClientGetter_ToRawKubeConfigLoader(t *testing.T) {
 		g.Expect(c.ToRawKubeConfigLoader()).ToNot(BeIdenticalTo(cc))
 	})
 }
+
+func TestWithDiscoveryCache(t *testing.T) {
+	t.Run("shares the discovery client and REST mapper", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache := NewDiscoveryCache(time.Minute)
+		cfg := &rest.Config{Host: "https://example.com"}
+
+		c1 := NewMemoryRESTClientGetter(cfg, WithPersistent(true), WithDiscoveryCache(cache))
+		c2 := NewMemoryRESTClientGetter(rest.CopyConfig(cfg), WithPersistent(true), WithDiscoveryCache(cache))
+
+		dc1, err := c1.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		dc2, err := c2.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(dc2).To(BeIdenticalTo(dc1))
+
+		_, err = c1.ToRESTMapper()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c1.discoveryEntry).To(BeIdenticalTo(c2.discoveryEntry))
+		g.Expect(cache.Len()).To(Equal(1))
+	})
+
+	t.Run("is ignored for a TokenSource without identity", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache := NewDiscoveryCache(time.Minute)
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+		c := NewMemoryRESTClientGetter(&rest.Config{Host: "https://example.com"},
+			WithPersistent(true), WithDiscoveryCache(cache), WithTokenSource(ts, ""))
+		_, err := c.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = c.ToRESTMapper()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cache.Len()).To(Equal(0))
+
+		c = NewMemoryRESTClientGetter(&rest.Config{Host: "https://example.com"},
+			WithPersistent(true), WithDiscoveryCache(cache), WithTokenSource(ts, "aws/cluster"))
+		_, err = c.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cache.Len()).To(Equal(1))
+	})
+
+	t.Run("is ignored when not persistent", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache := NewDiscoveryCache(time.Minute)
+		c := NewMemoryRESTClientGetter(&rest.Config{Host: "https://example.com"}, WithDiscoveryCache(cache))
+		_, err := c.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cache.Len()).To(Equal(0))
+	})
+}
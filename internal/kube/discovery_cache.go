@@ -0,0 +1,296 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+
+	"github.com/fluxcd/helm-controller/internal/metrics"
+)
+
+// DefaultDiscoveryCacheMaxAge is the default maximum age of a discovery
+// client and REST mapper in the DiscoveryCache.
+const DefaultDiscoveryCacheMaxAge = 30 * time.Minute
+
+// rediscoverInterval is the minimum interval between rediscoveries of the API
+// resources of a cluster due to a kind or resource which can not be mapped.
+const rediscoverInterval = 10 * time.Second
+
+// DiscoveryCache is a controller-wide cache of discovery clients and REST
+// mappers, shared between MemoryRESTClientGetters which target the same
+// cluster with the same identity. This prevents the discovery of the API
+// resources of a cluster for every reconciliation of every HelmRelease
+// targeting it.
+//
+// Entries are keyed by the host, TLS, credentials and impersonation
+// configuration of the REST config, and the identity of any credentials
+// which are not part of the REST config, such as a TokenSource. They are
+// removed after the configured maximum age, and can be invalidated when the
+// API resources of a cluster change.
+type DiscoveryCache struct {
+	maxAge  time.Duration
+	entries map[string]*discoveryCacheEntry
+	mu      sync.Mutex
+
+	// nowFunc returns the current time, and can be overridden in tests.
+	nowFunc func() time.Time
+}
+
+// discoveryCacheEntry is a discovery client and REST mapper for a cluster
+// identity.
+type discoveryCacheEntry struct {
+	host      string
+	created   time.Time
+	discovery discovery.CachedDiscoveryInterface
+	mapper    meta.ResettableRESTMapper
+}
+
+// NewDiscoveryCache returns a new DiscoveryCache with the given maximum age
+// of entries. If maxAge is zero or negative, DefaultDiscoveryCacheMaxAge is
+// used.
+func NewDiscoveryCache(maxAge time.Duration) *DiscoveryCache {
+	if maxAge <= 0 {
+		maxAge = DefaultDiscoveryCacheMaxAge
+	}
+	return &DiscoveryCache{
+		maxAge:  maxAge,
+		entries: make(map[string]*discoveryCacheEntry),
+		nowFunc: time.Now,
+	}
+}
+
+// Invalidate invalidates the discovery clients and REST mappers for the
+// given host, causing them to rediscover the API resources on their next
+// use.
+func (c *DiscoveryCache) Invalidate(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.entries {
+		if e.host == host {
+			e.discovery.Invalidate()
+			e.mapper.Reset()
+		}
+	}
+	metrics.RecordDiscoveryCacheInvalidation()
+}
+
+// Clear removes all entries from the cache, to release the memory held by
+// the discovered API resources.
+func (c *DiscoveryCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*discoveryCacheEntry)
+}
+
+// Len returns the number of entries in the cache.
+func (c *DiscoveryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// get returns the cached entry for the given REST config and credential
+// identity, or creates a new one if there is none, or the existing entry
+// exceeds the maximum age.
+func (c *DiscoveryCache) get(cfg *rest.Config, identity string) (*discoveryCacheEntry, error) {
+	key := discoveryCacheKey(cfg, identity)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.nowFunc()
+	if e, ok := c.entries[key]; ok && now.Sub(e.created) < c.maxAge {
+		metrics.RecordDiscoveryCacheRequest(true)
+		return e, nil
+	}
+	metrics.RecordDiscoveryCacheRequest(false)
+
+	// Remove any expired entries while we hold the lock, to prevent the
+	// cache from growing with identities which are no longer used.
+	for k, e := range c.entries {
+		if now.Sub(e.created) >= c.maxAge {
+			delete(c.entries, k)
+		}
+	}
+
+	discoveryClient, err := newMemCacheDiscoveryClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e := &discoveryCacheEntry{
+		host:      cfg.Host,
+		created:   now,
+		discovery: discoveryClient,
+		mapper: &rediscoveringRESTMapper{
+			ResettableRESTMapper: restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient),
+			lastReset:            now,
+			nowFunc:              c.nowFunc,
+		},
+	}
+	c.entries[key] = e
+	return e, nil
+}
+
+// rediscoveringRESTMapper is a meta.ResettableRESTMapper which rediscovers the
+// API resources of the cluster, and retries once, when a kind or resource can
+// not be mapped. Unlike a DeferredDiscoveryRESTMapper on its own, which does
+// not retry once its memory cached discovery client is populated. This
+// covers API resources added to clusters for which the entries are not
+// invalidated, such as remote clusters. Rediscoveries are limited to one per
+// rediscoverInterval.
+type rediscoveringRESTMapper struct {
+	meta.ResettableRESTMapper
+
+	mu        sync.Mutex
+	lastReset time.Time
+	nowFunc   func() time.Time
+}
+
+// KindFor implements meta.RESTMapper.
+func (m *rediscoveringRESTMapper) KindFor(resource schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	gvk, err := m.ResettableRESTMapper.KindFor(resource)
+	if meta.IsNoMatchError(err) && m.rediscover() {
+		gvk, err = m.ResettableRESTMapper.KindFor(resource)
+	}
+	return gvk, err
+}
+
+// KindsFor implements meta.RESTMapper.
+func (m *rediscoveringRESTMapper) KindsFor(resource schema.GroupVersionResource) ([]schema.GroupVersionKind, error) {
+	gvks, err := m.ResettableRESTMapper.KindsFor(resource)
+	if meta.IsNoMatchError(err) && m.rediscover() {
+		gvks, err = m.ResettableRESTMapper.KindsFor(resource)
+	}
+	return gvks, err
+}
+
+// RESTMapping implements meta.RESTMapper.
+func (m *rediscoveringRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	mapping, err := m.ResettableRESTMapper.RESTMapping(gk, versions...)
+	if meta.IsNoMatchError(err) && m.rediscover() {
+		mapping, err = m.ResettableRESTMapper.RESTMapping(gk, versions...)
+	}
+	return mapping, err
+}
+
+// RESTMappings implements meta.RESTMapper.
+func (m *rediscoveringRESTMapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	mappings, err := m.ResettableRESTMapper.RESTMappings(gk, versions...)
+	if meta.IsNoMatchError(err) && m.rediscover() {
+		mappings, err = m.ResettableRESTMapper.RESTMappings(gk, versions...)
+	}
+	return mappings, err
+}
+
+// rediscover resets the REST mapper, including its discovery client, unless
+// it was reset less than rediscoverInterval ago. It returns true if the REST
+// mapper was reset.
+func (m *rediscoveringRESTMapper) rediscover() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.nowFunc()
+	if now.Sub(m.lastReset) < rediscoverInterval {
+		return false
+	}
+	m.lastReset = now
+	m.ResettableRESTMapper.Reset()
+	metrics.RecordDiscoveryCacheInvalidation()
+	return true
+}
+
+// discoveryCacheKey returns the key of the given REST config, based on the
+// host, TLS configuration, credentials (including exec and auth provider
+// plugins) and impersonation configuration, and the given identity of
+// credentials which are not part of the REST config.
+func discoveryCacheKey(cfg *rest.Config, identity string) string {
+	h := sha256.New()
+	write := func(s ...string) {
+		for _, v := range s {
+			_, _ = io.WriteString(h, v)
+			_, _ = h.Write([]byte{0})
+		}
+	}
+	writeMap := func(m map[string]string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		write(strconv.Itoa(len(keys)))
+		for _, k := range keys {
+			write(k, m[k])
+		}
+	}
+
+	write(cfg.Host, cfg.APIPath)
+	write(cfg.Username, cfg.Password, cfg.BearerToken, cfg.BearerTokenFile)
+	write(identity)
+
+	// The TLS configuration determines the server which is trusted, and
+	// the client certificate which is presented to it.
+	tls := cfg.TLSClientConfig
+	write(strconv.FormatBool(tls.Insecure), tls.ServerName)
+	write(tls.CAFile, string(tls.CAData))
+	write(tls.CertFile, string(tls.CertData), tls.KeyFile, string(tls.KeyData))
+	write(strconv.Itoa(len(tls.NextProtos)))
+	write(tls.NextProtos...)
+
+	if exec := cfg.ExecProvider; exec != nil {
+		write("exec", exec.APIVersion, exec.Command, strconv.Itoa(len(exec.Args)))
+		write(exec.Args...)
+		write(strconv.Itoa(len(exec.Env)))
+		for _, e := range exec.Env {
+			write(e.Name, e.Value)
+		}
+	}
+	if auth := cfg.AuthProvider; auth != nil {
+		write("auth", auth.Name)
+		writeMap(auth.Config)
+	}
+
+	imp := cfg.Impersonate
+	write(imp.UserName, imp.UID)
+	groups := append([]string(nil), imp.Groups...)
+	sort.Strings(groups)
+	write(strconv.Itoa(len(groups)))
+	write(groups...)
+	keys := make([]string, 0, len(imp.Extra))
+	for k := range imp.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write(k, strconv.Itoa(len(imp.Extra[k])))
+		write(imp.Extra[k]...)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestDiscoveryCache_get(t *testing.T) {
+	t.Run("shares entries for the same identity", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewDiscoveryCache(time.Minute)
+		e1, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		e2, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(e2).To(BeIdenticalTo(e1))
+		g.Expect(c.Len()).To(Equal(1))
+	})
+
+	t.Run("separates entries for different identities", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewDiscoveryCache(time.Minute)
+		e1, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		e2, err := c.get(&rest.Config{
+			Host:        "https://example.com",
+			Impersonate: rest.ImpersonationConfig{UserName: "system:serviceaccount:default:foo"},
+		}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(e2).ToNot(BeIdenticalTo(e1))
+		e3, err := c.get(&rest.Config{Host: "https://example.com"}, "aws/cluster")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(e3).ToNot(BeIdenticalTo(e1))
+		g.Expect(c.Len()).To(Equal(3))
+	})
+
+	t.Run("replaces expired entries", func(t *testing.T) {
+		g := NewWithT(t)
+
+		now := time.Now()
+		c := NewDiscoveryCache(time.Minute)
+		c.nowFunc = func() time.Time { return now }
+
+		e1, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = c.get(&rest.Config{Host: "https://other.example.com"}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.Len()).To(Equal(2))
+
+		now = now.Add(time.Minute)
+		e2, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(e2).ToNot(BeIdenticalTo(e1))
+		g.Expect(c.Len()).To(Equal(1))
+	})
+}
+
+func TestDiscoveryCache_Invalidate(t *testing.T) {
+	g := NewWithT(t)
+
+	c := NewDiscoveryCache(time.Minute)
+	e, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c.Invalidate("https://example.com")
+	g.Expect(e.discovery.Fresh()).To(BeFalse())
+	g.Expect(c.Len()).To(Equal(1))
+}
+
+func TestDiscoveryCache_Clear(t *testing.T) {
+	g := NewWithT(t)
+
+	c := NewDiscoveryCache(time.Minute)
+	_, err := c.get(&rest.Config{Host: "https://example.com"}, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.Len()).To(Equal(1))
+
+	c.Clear()
+	g.Expect(c.Len()).To(Equal(0))
+}
+
+func Test_discoveryCacheKey(t *testing.T) {
+	g := NewWithT(t)
+
+	base := &rest.Config{
+		Host: "https://example.com",
+		Impersonate: rest.ImpersonationConfig{
+			UserName: "jane",
+			Groups:   []string{"b", "a"},
+			Extra:    map[string][]string{"scopes": {"view"}},
+		},
+	}
+	key := discoveryCacheKey(base, "")
+
+	reordered := rest.CopyConfig(base)
+	reordered.Impersonate.Groups = []string{"a", "b"}
+	g.Expect(discoveryCacheKey(reordered, "")).To(Equal(key))
+
+	g.Expect(discoveryCacheKey(base, "serviceaccount/default,deployer")).ToNot(Equal(key))
+
+	for name, mutate := range map[string]func(*rest.Config){
+		"host":        func(c *rest.Config) { c.Host = "https://other.example.com" },
+		"token":       func(c *rest.Config) { c.BearerToken = "token" },
+		"insecure":    func(c *rest.Config) { c.TLSClientConfig.Insecure = true },
+		"server name": func(c *rest.Config) { c.TLSClientConfig.ServerName = "other.example.com" },
+		"CA data":     func(c *rest.Config) { c.TLSClientConfig.CAData = []byte("ca") },
+		"CA file":     func(c *rest.Config) { c.TLSClientConfig.CAFile = "/tmp/ca.crt" },
+		"cert data":   func(c *rest.Config) { c.TLSClientConfig.CertData = []byte("cert") },
+		"key data":    func(c *rest.Config) { c.TLSClientConfig.KeyData = []byte("key") },
+		"key file":    func(c *rest.Config) { c.TLSClientConfig.KeyFile = "/tmp/tls.key" },
+		"user":        func(c *rest.Config) { c.Impersonate.UserName = "john" },
+		"groups":      func(c *rest.Config) { c.Impersonate.Groups = []string{"a"} },
+		"extra":       func(c *rest.Config) { c.Impersonate.Extra = nil },
+		"group/extra": func(c *rest.Config) { c.Impersonate.Groups = append(c.Impersonate.Groups, "scopes") },
+		"exec": func(c *rest.Config) {
+			c.ExecProvider = &clientcmdapi.ExecConfig{Command: "aws", Args: []string{"eks", "get-token"}}
+		},
+		"auth provider": func(c *rest.Config) {
+			c.AuthProvider = &clientcmdapi.AuthProviderConfig{Name: "oidc", Config: map[string]string{"client-id": "foo"}}
+		},
+	} {
+		cfg := rest.CopyConfig(base)
+		mutate(cfg)
+		g.Expect(discoveryCacheKey(cfg, "")).ToNot(Equal(key), name)
+	}
+}
+
+func Test_rediscoveringRESTMapper(t *testing.T) {
+	gk := schema.GroupKind{Group: "example.com", Kind: "Widget"}
+
+	t.Run("rediscovers and retries on a kind which can not be mapped", func(t *testing.T) {
+		g := NewWithT(t)
+
+		now := time.Now()
+		delegate := &fakeResettableRESTMapper{known: false}
+		m := &rediscoveringRESTMapper{
+			ResettableRESTMapper: delegate,
+			lastReset:            now,
+			nowFunc:              func() time.Time { return now },
+		}
+
+		// The API resources were discovered less than the interval ago.
+		_, err := m.RESTMapping(gk)
+		g.Expect(meta.IsNoMatchError(err)).To(BeTrue())
+		g.Expect(delegate.resets).To(Equal(0))
+
+		// The kind is added to the cluster after the discovery.
+		now = now.Add(rediscoverInterval)
+		delegate.knownAfterReset = true
+		mapping, err := m.RESTMapping(gk)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(mapping.GroupVersionKind.Kind).To(Equal("Widget"))
+		g.Expect(delegate.resets).To(Equal(1))
+	})
+
+	t.Run("limits rediscoveries of kinds which do not exist", func(t *testing.T) {
+		g := NewWithT(t)
+
+		now := time.Now()
+		delegate := &fakeResettableRESTMapper{}
+		m := &rediscoveringRESTMapper{
+			ResettableRESTMapper: delegate,
+			nowFunc:              func() time.Time { return now },
+		}
+
+		for i := 0; i < 3; i++ {
+			_, err := m.RESTMapping(gk)
+			g.Expect(meta.IsNoMatchError(err)).To(BeTrue())
+		}
+		g.Expect(delegate.resets).To(Equal(1))
+	})
+}
+
+// fakeResettableRESTMapper is a meta.ResettableRESTMapper which only maps
+// kinds once known, and becomes aware of kinds after a reset when
+// knownAfterReset is set.
+type fakeResettableRESTMapper struct {
+	meta.RESTMapper
+	known           bool
+	knownAfterReset bool
+	resets          int
+}
+
+func (m *fakeResettableRESTMapper) RESTMapping(gk schema.GroupKind, _ ...string) (*meta.RESTMapping, error) {
+	if !m.known {
+		return nil, &meta.NoKindMatchError{GroupKind: gk}
+	}
+	return &meta.RESTMapping{GroupVersionKind: gk.WithVersion("v1")}, nil
+}
+
+func (m *fakeResettableRESTMapper) Reset() {
+	m.resets++
+	m.known = m.knownAfterReset
+}
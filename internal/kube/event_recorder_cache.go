@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// DefaultEventRecorderCacheMaxIdle is the default duration after which an
+// EventRecorder which has not been used is removed from the
+// EventRecorderCache.
+const DefaultEventRecorderCacheMaxIdle = 1 * time.Hour
+
+// EventRecorderCache is a controller-wide cache of EventRecorders, shared
+// between the reconciliations of HelmReleases which target the same cluster
+// with the same identity. As an EventRecorder outlives the reconciliation it
+// is used for, similar Events emitted in subsequent reconciliations are
+// aggregated and rate limited, instead of creating an Event for every
+// occurrence.
+//
+// Entries are keyed in the same way as the entries of the DiscoveryCache,
+// and the component emitting the Events. They are removed once they have not
+// been used for the configured maximum idle duration.
+type EventRecorderCache struct {
+	maxIdle time.Duration
+	entries map[string]*eventRecorderCacheEntry
+	mu      sync.Mutex
+
+	// nowFunc returns the current time, and can be overridden in tests.
+	nowFunc func() time.Time
+}
+
+// eventRecorderCacheEntry is an EventRecorder for a cluster identity, and the
+// broadcaster sending its Events to the cluster.
+type eventRecorderCacheEntry struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	lastUsed    time.Time
+}
+
+// NewEventRecorderCache returns a new EventRecorderCache with the given
+// maximum idle duration of entries. If maxIdle is zero or negative,
+// DefaultEventRecorderCacheMaxIdle is used.
+func NewEventRecorderCache(maxIdle time.Duration) *EventRecorderCache {
+	if maxIdle <= 0 {
+		maxIdle = DefaultEventRecorderCacheMaxIdle
+	}
+	return &EventRecorderCache{
+		maxIdle: maxIdle,
+		entries: make(map[string]*eventRecorderCacheEntry),
+		nowFunc: time.Now,
+	}
+}
+
+// Clear shuts down and removes all entries from the cache.
+func (c *EventRecorderCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.entries {
+		e.broadcaster.Shutdown()
+	}
+	c.entries = make(map[string]*eventRecorderCacheEntry)
+}
+
+// Len returns the number of entries in the cache.
+func (c *EventRecorderCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// get returns the cached EventRecorder for the given REST config, credential
+// identity and component, or creates a new one if there is none.
+func (c *EventRecorderCache) get(cfg *rest.Config, identity, component string) (record.EventRecorder, error) {
+	key := discoveryCacheKey(cfg, identity) + "/" + component
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.nowFunc()
+	if e, ok := c.entries[key]; ok {
+		e.lastUsed = now
+		return e.recorder, nil
+	}
+
+	// Remove any idle entries while we hold the lock, to prevent the cache
+	// from growing with identities which are no longer used.
+	for k, e := range c.entries {
+		if now.Sub(e.lastUsed) >= c.maxIdle {
+			e.broadcaster.Shutdown()
+			delete(c.entries, k)
+		}
+	}
+
+	clientSet, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	e := &eventRecorderCacheEntry{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component}),
+		lastUsed:    now,
+	}
+	c.entries[key] = e
+	return e.recorder, nil
+}
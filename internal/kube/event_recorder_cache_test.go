@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+)
+
+func TestEventRecorderCache_get(t *testing.T) {
+	t.Run("shares recorders for the same identity and component", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewEventRecorderCache(time.Minute)
+		t.Cleanup(c.Clear)
+
+		r1, err := c.get(&rest.Config{Host: "https://example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		r2, err := c.get(&rest.Config{Host: "https://example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r2).To(BeIdenticalTo(r1))
+		g.Expect(c.Len()).To(Equal(1))
+	})
+
+	t.Run("separates recorders for different identities and components", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewEventRecorderCache(time.Minute)
+		t.Cleanup(c.Clear)
+
+		r1, err := c.get(&rest.Config{Host: "https://example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		r2, err := c.get(&rest.Config{
+			Host:        "https://example.com",
+			Impersonate: rest.ImpersonationConfig{UserName: "system:serviceaccount:default:foo"},
+		}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r2).ToNot(BeIdenticalTo(r1))
+		r3, err := c.get(&rest.Config{Host: "https://example.com"}, "", "other")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r3).ToNot(BeIdenticalTo(r1))
+		g.Expect(c.Len()).To(Equal(3))
+	})
+
+	t.Run("removes idle recorders", func(t *testing.T) {
+		g := NewWithT(t)
+
+		now := time.Now()
+		c := NewEventRecorderCache(time.Minute)
+		c.nowFunc = func() time.Time { return now }
+		t.Cleanup(c.Clear)
+
+		_, err := c.get(&rest.Config{Host: "https://example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		now = now.Add(30 * time.Second)
+		r1, err := c.get(&rest.Config{Host: "https://other.example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.Len()).To(Equal(2))
+
+		now = now.Add(45 * time.Second)
+		_, err = c.get(&rest.Config{Host: "https://third.example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.Len()).To(Equal(2))
+
+		r2, err := c.get(&rest.Config{Host: "https://other.example.com"}, "", "helm-controller")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r2).To(BeIdenticalTo(r1))
+	})
+}
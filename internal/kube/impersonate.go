@@ -17,8 +17,13 @@ limitations under the License.
 package kube
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 )
 
@@ -45,3 +50,42 @@ func SetImpersonationConfig(cfg *rest.Config, namespace, serviceAccount string)
 	}
 	return ""
 }
+
+// ServiceAccountFromUsername returns the namespace and name of the service
+// account if the given username is a system service account user name.
+func ServiceAccountFromUsername(username string) (types.NamespacedName, bool) {
+	parts := strings.Split(username, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" || parts[2] == "" || parts[3] == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: parts[2], Name: parts[3]}, true
+}
+
+// ServiceAccountFromConfig returns the namespace and name of the service
+// account the provided REST config authenticates as, if it uses a service
+// account token. The subject is read from the token without verifying it.
+func ServiceAccountFromConfig(cfg *rest.Config) (types.NamespacedName, bool) {
+	token := cfg.BearerToken
+	if token == "" && cfg.BearerTokenFile != "" {
+		b, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return types.NamespacedName{}, false
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return types.NamespacedName{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return types.NamespacedName{}, false
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return types.NamespacedName{}, false
+	}
+	return ServiceAccountFromUsername(claims.Subject)
+}
@@ -17,9 +17,13 @@ limitations under the License.
 package kube
 
 import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 )
 
@@ -73,3 +77,63 @@ func TestSetImpersonationConfig(t *testing.T) {
 		g.Expect(cfg.Impersonate.UserName).To(BeEmpty())
 	})
 }
+
+func TestServiceAccountFromUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		want     types.NamespacedName
+		wantOK   bool
+	}{
+		{username: "system:serviceaccount:default:deployer", want: types.NamespacedName{Namespace: "default", Name: "deployer"}, wantOK: true},
+		{username: "system:serviceaccount:default:", wantOK: false},
+		{username: "system:serviceaccounts:default", wantOK: false},
+		{username: "jane", wantOK: false},
+		{username: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, ok := ServiceAccountFromUsername(tt.username)
+			g.Expect(ok).To(Equal(tt.wantOK))
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestServiceAccountFromConfig(t *testing.T) {
+	token := func(sub string) string {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"` + sub + `"}`))
+		return "eyJhbGciOiJSUzI1NiJ9." + payload + ".c2lnbmF0dXJl"
+	}
+
+	t.Run("from bearer token", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, ok := ServiceAccountFromConfig(&rest.Config{BearerToken: token("system:serviceaccount:flux-system:helm-controller")})
+		g.Expect(ok).To(BeTrue())
+		g.Expect(got).To(Equal(types.NamespacedName{Namespace: "flux-system", Name: "helm-controller"}))
+	})
+
+	t.Run("from bearer token file", func(t *testing.T) {
+		g := NewWithT(t)
+
+		f := filepath.Join(t.TempDir(), "token")
+		g.Expect(os.WriteFile(f, []byte(token("system:serviceaccount:flux-system:helm-controller")+"\n"), 0o600)).To(Succeed())
+
+		got, ok := ServiceAccountFromConfig(&rest.Config{BearerTokenFile: f})
+		g.Expect(ok).To(BeTrue())
+		g.Expect(got).To(Equal(types.NamespacedName{Namespace: "flux-system", Name: "helm-controller"}))
+	})
+
+	t.Run("not a service account", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, ok := ServiceAccountFromConfig(&rest.Config{BearerToken: token("jane")})
+		g.Expect(ok).To(BeFalse())
+		_, ok = ServiceAccountFromConfig(&rest.Config{BearerToken: "opaque"})
+		g.Expect(ok).To(BeFalse())
+		_, ok = ServiceAccountFromConfig(&rest.Config{})
+		g.Expect(ok).To(BeFalse())
+	})
+}
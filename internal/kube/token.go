@@ -0,0 +1,155 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultTokenExpiryDelta is the duration before the expiry of a token at
+// which it is considered expired, and a new token is requested from the
+// underlying TokenSource.
+const DefaultTokenExpiryDelta = 2 * time.Minute
+
+// WithTokenSource configures the client to authenticate using bearer tokens
+// obtained from the given TokenSource. Tokens are cached, and automatically
+// refreshed DefaultTokenExpiryDelta before they expire.
+//
+// The identity of the tokens, e.g. the provider and the cluster or
+// ServiceAccount they are issued for, is used to share the discovery client
+// and REST mapper from the DiscoveryCache with other clients with the same
+// identity. If it is empty, the DiscoveryCache is not used.
+func WithTokenSource(ts oauth2.TokenSource, identity string) Option {
+	return func(c *MemoryRESTClientGetter) {
+		if ts == nil {
+			return
+		}
+		SetTokenSource(c.cfg, ts)
+		c.tokenSource = true
+		c.tokenSourceIdentity = identity
+	}
+}
+
+// SetTokenSource configures the provided REST config to authenticate using
+// bearer tokens obtained from the given TokenSource. Any static bearer token
+// in the config is discarded.
+func SetTokenSource(cfg *rest.Config, ts oauth2.TokenSource) {
+	ts = oauth2.ReuseTokenSourceWithExpiry(nil, ts, DefaultTokenExpiryDelta)
+
+	cfg.BearerToken = ""
+	cfg.BearerTokenFile = ""
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &oauth2.Transport{Source: ts, Base: rt}
+	})
+}
+
+// DefaultTokenSourceCacheMaxIdle is the default duration after which a
+// TokenSource which has not been used is removed from the TokenSourceCache.
+const DefaultTokenSourceCacheMaxIdle = 1 * time.Hour
+
+// TokenSourceCache is a controller-wide cache of TokenSources, shared
+// between the reconciliations of HelmReleases which obtain tokens for the
+// same provider identity and cluster. This allows tokens to be reused until
+// they are about to expire, instead of requesting a new token for every
+// reconciliation.
+//
+// Entries are keyed by the caller, and removed once they have not been used
+// for the configured maximum idle duration.
+type TokenSourceCache struct {
+	maxIdle time.Duration
+	entries map[string]*tokenSourceCacheEntry
+	mu      sync.Mutex
+
+	// nowFunc returns the current time, and can be overridden in tests.
+	nowFunc func() time.Time
+}
+
+// tokenSourceCacheEntry is a reusing TokenSource for a provider identity
+// and cluster.
+type tokenSourceCacheEntry struct {
+	ts       oauth2.TokenSource
+	lastUsed time.Time
+}
+
+// NewTokenSourceCache returns a new TokenSourceCache with the given maximum
+// idle duration of entries. If maxIdle is zero or negative,
+// DefaultTokenSourceCacheMaxIdle is used.
+func NewTokenSourceCache(maxIdle time.Duration) *TokenSourceCache {
+	if maxIdle <= 0 {
+		maxIdle = DefaultTokenSourceCacheMaxIdle
+	}
+	return &TokenSourceCache{
+		maxIdle: maxIdle,
+		entries: make(map[string]*tokenSourceCacheEntry),
+		nowFunc: time.Now,
+	}
+}
+
+// GetOrCreate returns the cached TokenSource for the given key, or creates
+// a new one using newFunc if there is none. The returned TokenSource reuses
+// tokens until DefaultTokenExpiryDelta before they expire. As the
+// TokenSource outlives the request it is created for, newFunc should not
+// bind it to a request scoped context.
+func (c *TokenSourceCache) GetOrCreate(key string, newFunc func() (oauth2.TokenSource, error)) (oauth2.TokenSource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.nowFunc()
+	if e, ok := c.entries[key]; ok {
+		e.lastUsed = now
+		return e.ts, nil
+	}
+
+	// Remove any idle entries while we hold the lock, to prevent the cache
+	// from growing with identities which are no longer used.
+	for k, e := range c.entries {
+		if now.Sub(e.lastUsed) >= c.maxIdle {
+			delete(c.entries, k)
+		}
+	}
+
+	ts, err := newFunc()
+	if err != nil {
+		return nil, err
+	}
+	e := &tokenSourceCacheEntry{
+		ts:       oauth2.ReuseTokenSourceWithExpiry(nil, ts, DefaultTokenExpiryDelta),
+		lastUsed: now,
+	}
+	c.entries[key] = e
+	return e.ts, nil
+}
+
+// Clear removes all entries from the cache.
+func (c *TokenSourceCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*tokenSourceCacheEntry)
+}
+
+// Len returns the number of entries in the cache.
+func (c *TokenSourceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/oauth2"
+	"k8s.io/client-go/rest"
+)
+
+// fakeTokenSource returns a new token with the configured lifetime on every
+// call, and records the number of calls.
+type fakeTokenSource struct {
+	mu       sync.Mutex
+	calls    int
+	lifetime time.Duration
+	err      error
+}
+
+func (s *fakeTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.calls++
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", s.calls),
+		Expiry:      time.Now().Add(s.lifetime),
+	}, nil
+}
+
+func (s *fakeTokenSource) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestWithTokenSource(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major":"1","minor":"30","gitVersion":"v1.30.0"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Run("reuses token until it expires", func(t *testing.T) {
+		g := NewWithT(t)
+		headers = nil
+
+		ts := &fakeTokenSource{lifetime: time.Hour}
+		getter := NewMemoryRESTClientGetter(&rest.Config{Host: server.URL, BearerToken: "static"}, WithTokenSource(ts, ""))
+
+		dc, err := getter.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			_, err = dc.ServerVersion()
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+
+		g.Expect(ts.Calls()).To(Equal(1))
+		g.Expect(headers).To(Equal([]string{"Bearer token-1", "Bearer token-1", "Bearer token-1"}))
+	})
+
+	t.Run("refreshes token within expiry delta", func(t *testing.T) {
+		g := NewWithT(t)
+		headers = nil
+
+		// A lifetime shorter than the expiry delta causes every token to be
+		// considered expired on the next request.
+		ts := &fakeTokenSource{lifetime: DefaultTokenExpiryDelta / 2}
+		getter := NewMemoryRESTClientGetter(&rest.Config{Host: server.URL}, WithTokenSource(ts, ""), WithPersistent(true))
+
+		dc, err := getter.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			_, err = dc.ServerVersion()
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+
+		g.Expect(ts.Calls()).To(Equal(3))
+		g.Expect(headers).To(Equal([]string{"Bearer token-1", "Bearer token-2", "Bearer token-3"}))
+	})
+
+	t.Run("returns token source error", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ts := &fakeTokenSource{err: errors.New("token exchange failed")}
+		getter := NewMemoryRESTClientGetter(&rest.Config{Host: server.URL}, WithTokenSource(ts, ""))
+
+		dc, err := getter.ToDiscoveryClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = dc.ServerVersion()
+		g.Expect(err).To(MatchError(ContainSubstring("token exchange failed")))
+	})
+
+	t.Run("ignores nil token source", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cfg := &rest.Config{Host: server.URL, BearerToken: "static"}
+		NewMemoryRESTClientGetter(cfg, WithTokenSource(nil, ""))
+		g.Expect(cfg.BearerToken).To(Equal("static"))
+		g.Expect(cfg.WrapTransport).To(BeNil())
+	})
+}
+
+func TestTokenSourceCache_GetOrCreate(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	c := NewTokenSourceCache(time.Hour)
+	c.nowFunc = func() time.Time { return now }
+
+	ts := &fakeTokenSource{lifetime: time.Hour}
+	newFunc := func() (oauth2.TokenSource, error) { return ts, nil }
+
+	got, err := c.GetOrCreate("a", newFunc)
+	g.Expect(err).ToNot(HaveOccurred())
+	tok, err := got.Token()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tok.AccessToken).To(Equal("token-1"))
+
+	// The cached TokenSource reuses the token.
+	got, err = c.GetOrCreate("a", func() (oauth2.TokenSource, error) {
+		return nil, errors.New("must not be called")
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	tok, err = got.Token()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tok.AccessToken).To(Equal("token-1"))
+	g.Expect(ts.Calls()).To(Equal(1))
+
+	// Errors are returned, and not cached.
+	_, err = c.GetOrCreate("b", func() (oauth2.TokenSource, error) {
+		return nil, errors.New("failed")
+	})
+	g.Expect(err).To(MatchError("failed"))
+	g.Expect(c.Len()).To(Equal(1))
+
+	// Idle entries are removed when a new entry is created.
+	now = now.Add(2 * time.Hour)
+	_, err = c.GetOrCreate("b", newFunc)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.Len()).To(Equal(1))
+
+	c.Clear()
+	g.Expect(c.Len()).To(BeZero())
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lease provides locking of Helm releases using coordination.k8s.io
+// Leases, to allow distinguishing releases which are actively being operated
+// on from releases left in a stale pending state.
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultDuration is the default duration of a Lease held by a Locker.
+const DefaultDuration = 60 * time.Second
+
+// ErrLost signals a Lock was lost while it was held, because the Lease was
+// taken over by another holder, deleted, or could not be renewed before it
+// expired.
+var ErrLost = errors.New("lease lost")
+
+// LockedError is returned by Locker.Acquire when the Lease is held by
+// another holder, and has not expired.
+type LockedError struct {
+	// Holder is the identity of the holder of the Lease.
+	Holder string
+	// RenewTime is the last time the Lease was renewed by the holder.
+	RenewTime time.Time
+}
+
+// Error returns the error message.
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("locked by '%s' (renewed at %s)", e.Holder, e.RenewTime.Format(time.RFC3339))
+}
+
+// Locker acquires Leases on behalf of an identity.
+type Locker struct {
+	client    client.Client
+	namespace string
+	identity  string
+	duration  time.Duration
+}
+
+// NewLocker returns a new Locker which acquires Leases in the given
+// namespace for the given identity using the client. The Leases are renewed
+// at a third of the duration, and considered expired when not renewed within
+// the duration. A duration of 0 or less defaults to DefaultDuration.
+func NewLocker(c client.Client, namespace, identity string, duration time.Duration) *Locker {
+	if duration <= 0 {
+		duration = DefaultDuration
+	}
+	return &Locker{client: c, namespace: namespace, identity: identity, duration: duration}
+}
+
+// Namespace returns the namespace the Locker acquires Leases in. An empty
+// namespace signals the namespace is determined by the key of the Lease.
+func (l *Locker) Namespace() string {
+	return l.namespace
+}
+
+// Identity returns the identity the Locker acquires Leases for.
+func (l *Locker) Identity() string {
+	return l.identity
+}
+
+// Lock is a Lease acquired by a Locker. It is renewed in the background
+// until Release is called, or until it is lost.
+type Lock struct {
+	locker *Locker
+	key    types.NamespacedName
+	stale  bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	lease  *coordinationv1.Lease
+	// lost is closed when the Lock is lost.
+	lost chan struct{}
+}
+
+// Stale returns true if the Lock was acquired by taking over an existing
+// Lease which expired, or which was left behind by the identity of the
+// Locker itself. This signals the previous holder stopped operating on the
+// locked resource without releasing the Lease.
+func (l *Lock) Stale() bool {
+	return l.stale
+}
+
+// Holder returns the identity of the holder of the Lease, which is the
+// identity of the Locker while the Lock is held.
+func (l *Lock) Holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ptr.Deref(l.lease.Spec.HolderIdentity, "")
+}
+
+// Lost returns true if the Lock was lost while it was held. Once lost, the
+// Lease is no longer renewed, and another holder may acquire it.
+func (l *Lock) Lost() bool {
+	select {
+	case <-l.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// Context returns a copy of the parent context which is canceled with
+// ErrLost as cause when the Lock is lost. The returned CancelFunc must be
+// called to release the resources associated with the context.
+func (l *Lock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-l.lost:
+			cancel(fmt.Errorf("%w: '%s'", ErrLost, l.key))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// Acquire acquires the Lease with the given key. It returns a LockedError
+// if the Lease is held by another holder and has not expired.
+func (l *Locker) Acquire(ctx context.Context, key types.NamespacedName) (*Lock, error) {
+	now := metav1.NowMicro()
+
+	lease := &coordinationv1.Lease{}
+	if err := l.client.Get(ctx, key, lease); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get Lease '%s': %w", key, err)
+		}
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(l.identity),
+				LeaseDurationSeconds: ptr.To(l.durationSeconds()),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if err = l.client.Create(ctx, lease); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return nil, &LockedError{Holder: "unknown", RenewTime: now.Time}
+			}
+			return nil, fmt.Errorf("failed to create Lease '%s': %w", key, err)
+		}
+		return l.newLock(key, lease, false), nil
+	}
+
+	holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+	if holder != l.identity && !l.expired(lease, now.Time) {
+		var renewTime time.Time
+		if lease.Spec.RenewTime != nil {
+			renewTime = lease.Spec.RenewTime.Time
+		}
+		return nil, &LockedError{Holder: holder, RenewTime: renewTime}
+	}
+
+	if holder != l.identity {
+		lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+	}
+	lease.Spec.HolderIdentity = ptr.To(l.identity)
+	lease.Spec.LeaseDurationSeconds = ptr.To(l.durationSeconds())
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	if err := l.client.Update(ctx, lease); err != nil {
+		if apierrors.IsConflict(err) {
+			return nil, &LockedError{Holder: "unknown", RenewTime: now.Time}
+		}
+		return nil, fmt.Errorf("failed to update Lease '%s': %w", key, err)
+	}
+	return l.newLock(key, lease, true), nil
+}
+
+// Holder returns the identity of the holder of the Lease with the given key,
+// and whether the Lease is active. An empty identity is returned if the
+// Lease does not exist.
+func (l *Locker) Holder(ctx context.Context, key types.NamespacedName) (string, bool, error) {
+	lease := &coordinationv1.Lease{}
+	if err := l.client.Get(ctx, key, lease); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get Lease '%s': %w", key, err)
+	}
+	return ptr.Deref(lease.Spec.HolderIdentity, ""), !l.expired(lease, time.Now()), nil
+}
+
+// expired returns true if the Lease has not been renewed within its
+// duration.
+func (l *Locker) expired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil {
+		return true
+	}
+	duration := l.duration
+	if lease.Spec.LeaseDurationSeconds != nil && *lease.Spec.LeaseDurationSeconds > 0 {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
+
+// durationSeconds returns the duration of the Lease in seconds, rounded up.
+func (l *Locker) durationSeconds() int32 {
+	return int32(math.Ceil(l.duration.Seconds()))
+}
+
+// newLock returns a new Lock for the acquired Lease, and starts renewing it
+// in the background.
+func (l *Locker) newLock(key types.NamespacedName, lease *coordinationv1.Lease, stale bool) *Lock {
+	ctx, cancel := context.WithCancel(context.Background())
+	lock := &Lock{
+		locker: l,
+		key:    key,
+		stale:  stale,
+		cancel: cancel,
+		lease:  lease,
+		lost:   make(chan struct{}),
+	}
+	lock.wg.Add(1)
+	go lock.renew(ctx)
+	return lock
+}
+
+// renew renews the Lease at a third of the duration until the context is
+// canceled. The Lock is marked as lost, and renewing stops, when the Lease
+// was taken over by another holder or deleted, or when it could not be
+// renewed before it expired.
+func (l *Lock) renew(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.locker.duration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if lost := l.renewOnce(ctx); lost {
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+// renewOnce renews the Lease, and returns true if the Lock was lost.
+func (l *Lock) renewOnce(ctx context.Context) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lease := l.lease.DeepCopy()
+	now := metav1.NowMicro()
+	lease.Spec.RenewTime = &now
+	err := l.locker.client.Update(ctx, lease)
+	if err == nil {
+		l.lease = lease
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	log := ctrl.LoggerFrom(ctx)
+	switch {
+	case apierrors.IsConflict(err), apierrors.IsNotFound(err):
+		// The Lease was modified or deleted by someone else, which means
+		// it is no longer ours.
+		log.Error(err, "lost Lease", "lease", l.key.String())
+		return true
+	case l.locker.expired(l.lease, now.Time):
+		// Another holder may have taken over the expired Lease.
+		log.Error(err, "failed to renew Lease before it expired", "lease", l.key.String())
+		return true
+	default:
+		log.Error(err, "failed to renew Lease", "lease", l.key.String())
+		return false
+	}
+}
+
+// Release stops renewing the Lease, and deletes it. If the Lock was lost,
+// the Lease is left untouched as it may be held by another holder, and an
+// error wrapping ErrLost is returned. It is safe to call Release multiple
+// times.
+func (l *Lock) Release(ctx context.Context) error {
+	l.cancel()
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return nil
+	}
+	lease := l.lease
+	l.lease = nil
+	if l.Lost() {
+		return fmt.Errorf("%w: '%s'", ErrLost, l.key)
+	}
+	if err := l.locker.client.Delete(ctx, lease, client.Preconditions{UID: &lease.UID}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Lease '%s': %w", l.key, err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lease
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var key = types.NamespacedName{Namespace: "default", Name: "helm-release-lock"}
+
+func newTestClient(objs ...client.Object) client.Client {
+	s := runtime.NewScheme()
+	_ = coordinationv1.AddToScheme(s)
+	return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+}
+
+func newTestLease(holder string, renewTime time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(holder),
+			LeaseDurationSeconds: ptr.To(int32(60)),
+			RenewTime:            &metav1.MicroTime{Time: renewTime},
+		},
+	}
+}
+
+func TestLocker_Acquire(t *testing.T) {
+	tests := []struct {
+		name       string
+		lease      *coordinationv1.Lease
+		wantHolder string
+		wantStale  bool
+	}{
+		{
+			name: "creates Lease",
+		},
+		{
+			name:      "takes over expired Lease",
+			lease:     newTestLease("other", time.Now().Add(-2*time.Minute)),
+			wantStale: true,
+		},
+		{
+			name:      "takes over own Lease",
+			lease:     newTestLease("controller", time.Now()),
+			wantStale: true,
+		},
+		{
+			name:       "locked by other holder",
+			lease:      newTestLease("other", time.Now()),
+			wantHolder: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var objs []client.Object
+			if tt.lease != nil {
+				objs = append(objs, tt.lease)
+			}
+			c := newTestClient(objs...)
+			l := NewLocker(c, "", "controller", time.Minute)
+
+			lock, err := l.Acquire(context.TODO(), key)
+			if tt.wantHolder != "" {
+				var lockedErr *LockedError
+				g.Expect(errors.As(err, &lockedErr)).To(BeTrue())
+				g.Expect(lockedErr.Holder).To(Equal(tt.wantHolder))
+				g.Expect(err.Error()).To(ContainSubstring("locked by 'other'"))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(lock.Stale()).To(Equal(tt.wantStale))
+			g.Expect(lock.Holder()).To(Equal("controller"))
+
+			holder, active, err := l.Holder(context.TODO(), key)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(holder).To(Equal("controller"))
+			g.Expect(active).To(BeTrue())
+
+			g.Expect(lock.Release(context.TODO())).To(Succeed())
+			g.Expect(lock.Release(context.TODO())).To(Succeed())
+
+			err = c.Get(context.TODO(), key, &coordinationv1.Lease{})
+			g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	}
+}
+
+func TestLock_renew(t *testing.T) {
+	g := NewWithT(t)
+
+	c := newTestClient()
+	l := NewLocker(c, "", "controller", 300*time.Millisecond)
+
+	lock, err := l.Acquire(context.TODO(), key)
+	g.Expect(err).ToNot(HaveOccurred())
+	defer lock.Release(context.TODO())
+
+	lease := &coordinationv1.Lease{}
+	g.Expect(c.Get(context.TODO(), key, lease)).To(Succeed())
+	acquired := lease.Spec.RenewTime.Time
+
+	g.Eventually(func() bool {
+		if err := c.Get(context.TODO(), key, lease); err != nil {
+			return false
+		}
+		return lease.Spec.RenewTime.After(acquired)
+	}, time.Second, 50*time.Millisecond).Should(BeTrue())
+}
+
+func TestLock_lost(t *testing.T) {
+	g := NewWithT(t)
+
+	c := newTestClient()
+	l := NewLocker(c, "", "controller", 300*time.Millisecond)
+
+	lock, err := l.Acquire(context.TODO(), key)
+	g.Expect(err).ToNot(HaveOccurred())
+	ctx, cancel := lock.Context(context.TODO())
+	defer cancel()
+
+	// Take over the Lease, as another holder would after it expired.
+	lease := &coordinationv1.Lease{}
+	g.Expect(c.Get(context.TODO(), key, lease)).To(Succeed())
+	lease.Spec.HolderIdentity = ptr.To("other")
+	g.Expect(c.Update(context.TODO(), lease)).To(Succeed())
+
+	g.Eventually(lock.Lost, time.Second, 50*time.Millisecond).Should(BeTrue())
+	g.Eventually(ctx.Done(), time.Second).Should(BeClosed())
+	g.Expect(errors.Is(context.Cause(ctx), ErrLost)).To(BeTrue())
+
+	err = lock.Release(context.TODO())
+	g.Expect(errors.Is(err, ErrLost)).To(BeTrue())
+
+	// The Lease of the other holder is left untouched.
+	g.Expect(c.Get(context.TODO(), key, lease)).To(Succeed())
+	g.Expect(ptr.Deref(lease.Spec.HolderIdentity, "")).To(Equal("other"))
+}
+
+func TestLocker_Holder(t *testing.T) {
+	g := NewWithT(t)
+
+	l := NewLocker(newTestClient(), "", "controller", time.Minute)
+	holder, active, err := l.Holder(context.TODO(), key)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(holder).To(BeEmpty())
+	g.Expect(active).To(BeFalse())
+
+	l = NewLocker(newTestClient(newTestLease("other", time.Now().Add(-2*time.Minute))), "", "controller", time.Minute)
+	holder, active, err = l.Holder(context.TODO(), key)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(holder).To(Equal("other"))
+	g.Expect(active).To(BeFalse())
+}
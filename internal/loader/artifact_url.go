@@ -26,12 +26,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	digestlib "github.com/opencontainers/go-digest"
 	_ "github.com/opencontainers/go-digest/blake3"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+
+	"github.com/fluxcd/helm-controller/internal/metrics"
 )
 
 const (
@@ -47,13 +50,94 @@ var (
 	// ErrIntegrity signals a chart loader failed to verify the integrity of
 	// a chart, for example due to a digest mismatch.
 	ErrIntegrity = errors.New("integrity failure")
+	// ErrVerification signals a chart loader failed to verify the
+	// authenticity of a chart using the configured VerifyFunc.
+	ErrVerification = errors.New("verification failed")
+	// ErrChartTooLarge signals a chart archive exceeds the maximum size
+	// configured using WithMaxChartSize.
+	ErrChartTooLarge = errors.New("chart too large")
 )
 
+// VerifyFunc verifies the authenticity of the chart archive data before it
+// is loaded. The provenance data is only provided when available, and the
+// digest is the digest of the OCI manifest when the chart was pulled from
+// an OCI registry.
+type VerifyFunc func(chart, provenance []byte, digest string) error
+
+// LoadOption is a function that configures the loading of a chart.
+type LoadOption func(*loadOptions)
+
+// loadOptions holds the options for loading a chart.
+type loadOptions struct {
+	cache            *ChartCache
+	verify           VerifyFunc
+	maxChartSize     int64
+	dockerConfigJSON []byte
+	plainHTTP        bool
+}
+
+// WithCache configures the ChartCache to look up the chart artifact in
+// before downloading it, and to store the downloaded artifact in.
+// A nil cache is ignored.
+func WithCache(cache *ChartCache) LoadOption {
+	return func(o *loadOptions) {
+		o.cache = cache
+	}
+}
+
+// WithVerifyFunc configures a VerifyFunc to verify the authenticity of the
+// chart archive data with, after its integrity has been verified. Errors
+// returned by the function are wrapped in ErrVerification.
+func WithVerifyFunc(fn VerifyFunc) LoadOption {
+	return func(o *loadOptions) {
+		o.verify = fn
+	}
+}
+
+// WithMaxChartSize configures the maximum size in bytes of the chart archive.
+// Downloads exceeding the size are aborted with ErrChartTooLarge. A value
+// of 0 or less disables the limit.
+func WithMaxChartSize(size int64) LoadOption {
+	return func(o *loadOptions) {
+		o.maxChartSize = size
+	}
+}
+
 // SecureLoadChartFromURL attempts to download a Helm chart from the given URL
 // using the provided client. The retrieved data is verified against the given
-// digest before loading the chart. It returns the loaded chart.Chart, or an
-// error. The error may be of type ErrIntegrity if the integrity check fails.
-func SecureLoadChartFromURL(client *retryablehttp.Client, URL, digest string) (*chart.Chart, error) {
+// digest while the chart is loaded from the response body, and the chart is
+// discarded if the integrity check fails. It returns the loaded chart.Chart,
+// or an error. The error may be of type ErrIntegrity if the integrity check
+// fails, or of type ErrChartTooLarge if the chart exceeds the size configured
+// using WithMaxChartSize.
+//
+// When a ChartCache is configured using WithCache, the artifact is first
+// looked up in the cache. Cached data is verified against the digest in the
+// same way as downloaded data, and evicted from the cache if the integrity
+// check fails. When a VerifyFunc is configured using WithVerifyFunc, it is
+// called before the chart is loaded, and the error may be of type
+// ErrVerification.
+func SecureLoadChartFromURL(client *retryablehttp.Client, URL, digest string, opts ...LoadOption) (*chart.Chart, error) {
+	o := &loadOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.cache != nil {
+		if data, ok := o.cache.Get(digest); ok {
+			var c bytes.Buffer
+			if err := copyAndVerify(digest, bytes.NewReader(data), &c); err == nil {
+				if err = o.verifyChart(c.Bytes(), nil, ""); err != nil {
+					return nil, err
+				}
+				return loader.LoadArchive(&c)
+			}
+			// Corrupt or tampered entry, fall back to downloading the
+			// artifact.
+			o.cache.Remove(digest)
+		}
+	}
+
 	URL, err := overwriteHostname(URL, os.Getenv(envSourceControllerLocalhost))
 	if err != nil {
 		return nil, err
@@ -64,6 +148,7 @@ func SecureLoadChartFromURL(client *retryablehttp.Client, URL, digest string) (*
 		return nil, err
 	}
 
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil || resp != nil && resp.StatusCode != http.StatusOK {
 		if err != nil {
@@ -75,17 +160,92 @@ func SecureLoadChartFromURL(client *retryablehttp.Client, URL, digest string) (*
 		}
 		return nil, fmt.Errorf("failed to download chart from '%s' (status: %s)", URL, resp.Status)
 	}
+	defer resp.Body.Close()
 
-	var c bytes.Buffer
-	if err := copyAndVerify(digest, resp.Body, &c); err != nil {
-		_ = resp.Body.Close()
+	if o.maxChartSize > 0 && resp.ContentLength > o.maxChartSize {
+		return nil, fmt.Errorf("failed to download chart from '%s': %w: size %d exceeds limit of %d bytes",
+			URL, ErrChartTooLarge, resp.ContentLength, o.maxChartSize)
+	}
+
+	body := &sizeLimitedReader{r: resp.Body, max: o.maxChartSize}
+	c, data, err := streamAndVerify(digest, body, o.cache != nil || o.verify != nil)
+	metrics.RecordChartDownload(metrics.ChartSourceArtifact, body.n, time.Since(start), err == nil)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := resp.Body.Close(); err != nil {
+	if o.cache != nil {
+		o.cache.Set(digest, data)
+	}
+	if err := o.verifyChart(data, nil, ""); err != nil {
 		return nil, err
 	}
-	return loader.LoadArchive(&c)
+	return c, nil
+}
+
+// streamAndVerify loads the chart from the reader while computing the digest
+// of the data read. The remainder of the reader is drained after loading, and
+// the chart is only returned if the data matches the digest. When keep is
+// true, the data is returned as well.
+func streamAndVerify(digest string, reader io.Reader, keep bool) (*chart.Chart, []byte, error) {
+	dig, err := digestlib.Parse(digest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse digest '%s': %w", digest, err)
+	}
+
+	verifier := dig.Verifier()
+	r := io.TeeReader(reader, verifier)
+	var buf *bytes.Buffer
+	if keep {
+		buf = &bytes.Buffer{}
+		r = io.TeeReader(r, buf)
+	}
+
+	c, loadErr := loader.LoadArchive(r)
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return nil, nil, fmt.Errorf("failed to copy and verify chart artifact: %w", err)
+	}
+	if !verifier.Verified() {
+		return nil, nil, fmt.Errorf("%w: computed digest doesn't match '%s'", ErrIntegrity, dig)
+	}
+	if loadErr != nil {
+		return nil, nil, loadErr
+	}
+
+	if buf != nil {
+		return c, buf.Bytes(), nil
+	}
+	return c, nil, nil
+}
+
+// sizeLimitedReader counts the bytes read from the underlying reader, and
+// returns an ErrChartTooLarge error once more than max bytes have been read.
+// A max of 0 or less disables the limit.
+type sizeLimitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.max > 0 && l.n > l.max {
+		return n, fmt.Errorf("%w: exceeds limit of %d bytes", ErrChartTooLarge, l.max)
+	}
+	return n, err
+}
+
+// verifyChart verifies the chart archive data using the configured
+// VerifyFunc, if any.
+func (o *loadOptions) verifyChart(chart, provenance []byte, digest string) error {
+	if o.verify == nil {
+		return nil
+	}
+	if err := o.verify(chart, provenance, digest); err != nil {
+		return fmt.Errorf("%w: %w", ErrVerification, err)
+	}
+	return nil
 }
 
 // copyAndVerify copies the contents of reader to writer, and verifies the
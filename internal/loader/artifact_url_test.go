@@ -103,6 +103,92 @@ func TestSecureLoadChartFromURL(t *testing.T) {
 		g.Expect(got).To(BeNil())
 	})
 
+	t.Run("loads Helm chart from cache", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache, err := NewChartCache(WithMaxMemorySize(int64(len(b))))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		got, err := SecureLoadChartFromURL(client, chartURL, digest.String(), WithCache(cache))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+
+		cached, ok := cache.Get(digest.String())
+		g.Expect(ok).To(BeTrue())
+		g.Expect(cached).To(Equal(b))
+
+		// The server is not consulted for cached artifacts.
+		got, err = SecureLoadChartFromURL(client, server.URL+"/invalid.tgz", digest.String(), WithCache(cache))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		g.Expect(got.Name()).To(Equal("chart"))
+	})
+
+	t.Run("ignores corrupt cache entry", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache, err := NewChartCache(WithMaxMemorySize(int64(len(b))))
+		g.Expect(err).ToNot(HaveOccurred())
+		cache.Set(digest.String(), []byte("corrupt"))
+
+		got, err := SecureLoadChartFromURL(client, chartURL, digest.String(), WithCache(cache))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+
+		cached, ok := cache.Get(digest.String())
+		g.Expect(ok).To(BeTrue())
+		g.Expect(cached).To(Equal(b))
+	})
+
+	t.Run("verifies chart using VerifyFunc", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var verified []byte
+		got, err := SecureLoadChartFromURL(client, chartURL, digest.String(), WithVerifyFunc(func(chart, provenance []byte, d string) error {
+			verified = chart
+			g.Expect(provenance).To(BeNil())
+			g.Expect(d).To(BeEmpty())
+			return nil
+		}))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		g.Expect(verified).To(Equal(b))
+
+		got, err = SecureLoadChartFromURL(client, chartURL, digest.String(), WithVerifyFunc(func(_, _ []byte, _ string) error {
+			return errors.New("untrusted")
+		}))
+		g.Expect(errors.Is(err, ErrVerification)).To(BeTrue())
+		g.Expect(err.Error()).To(ContainSubstring("untrusted"))
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("verifies cached chart using VerifyFunc", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache, err := NewChartCache(WithMaxMemorySize(int64(len(b))))
+		g.Expect(err).ToNot(HaveOccurred())
+		cache.Set(digest.String(), b)
+
+		got, err := SecureLoadChartFromURL(client, "http://invalid.com/chart.tgz", digest.String(), WithCache(cache),
+			WithVerifyFunc(func(_, _ []byte, _ string) error {
+				return errors.New("untrusted")
+			}))
+		g.Expect(errors.Is(err, ErrVerification)).To(BeTrue())
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("error on chart exceeding maximum size", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, err := SecureLoadChartFromURL(client, chartURL, digest.String(), WithMaxChartSize(int64(len(b)-1)))
+		g.Expect(errors.Is(err, ErrChartTooLarge)).To(BeTrue())
+		g.Expect(got).To(BeNil())
+
+		got, err = SecureLoadChartFromURL(client, chartURL, digest.String(), WithMaxChartSize(int64(len(b))))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+	})
+
 	t.Run("error on HTTP request failure", func(t *testing.T) {
 		g := NewWithT(t)
 
@@ -113,6 +199,67 @@ func TestSecureLoadChartFromURL(t *testing.T) {
 	})
 }
 
+func Test_streamAndVerify(t *testing.T) {
+	b, err := os.ReadFile("testdata/chart-0.1.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := digestlib.SHA256.FromBytes(b)
+
+	tests := []struct {
+		name     string
+		digest   string
+		in       io.Reader
+		keep     bool
+		wantData bool
+		wantErr  error
+	}{
+		{
+			name:   "loads chart",
+			digest: digest.String(),
+			in:     bytes.NewReader(b),
+		},
+		{
+			name:     "loads chart and returns data",
+			digest:   digest.String(),
+			in:       bytes.NewReader(b),
+			keep:     true,
+			wantData: true,
+		},
+		{
+			name:    "integrity error on trailing data",
+			digest:  digest.String(),
+			in:      io.MultiReader(bytes.NewReader(b), strings.NewReader("trailing")),
+			wantErr: ErrIntegrity,
+		},
+		{
+			name:    "too large",
+			digest:  digest.String(),
+			in:      &sizeLimitedReader{r: bytes.NewReader(b), max: 10},
+			wantErr: ErrChartTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c, data, err := streamAndVerify(tt.digest, tt.in, tt.keep)
+			if tt.wantErr != nil {
+				g.Expect(errors.Is(err, tt.wantErr)).To(BeTrue(), err.Error())
+				g.Expect(c).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(c.Name()).To(Equal("chart"))
+			if tt.wantData {
+				g.Expect(data).To(Equal(b))
+			} else {
+				g.Expect(data).To(BeNil())
+			}
+		})
+	}
+}
+
 func Test_copyAndVerify(t *testing.T) {
 	g := NewWithT(t)
 
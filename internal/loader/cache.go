@@ -0,0 +1,366 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loader
+
+import (
+	"container/list"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	digestlib "github.com/opencontainers/go-digest"
+)
+
+// ChartCache is a content-addressed cache for chart artifacts, keyed on the
+// digest of the artifact. Entries are held in memory up to a maximum size,
+// and can optionally be persisted to a directory (e.g. an emptyDir volume)
+// up to a separate maximum size. When a limit is exceeded, the least recently
+// used entries are evicted first.
+//
+// The cache does not verify the integrity of the data it returns. Callers
+// are expected to verify the data against the digest it was requested with
+// before using it.
+type ChartCache struct {
+	memory *lruIndex
+	disk   *lruIndex
+
+	// path is the directory entries are persisted to. When empty, entries
+	// are only held in memory.
+	path string
+
+	mu sync.Mutex
+}
+
+// ChartCacheOption is a function that configures a ChartCache.
+type ChartCacheOption func(*ChartCache) error
+
+// WithMaxMemorySize sets the maximum size in bytes of the entries held in
+// memory. A size of zero or less disables the in-memory cache.
+func WithMaxMemorySize(size int64) ChartCacheOption {
+	return func(c *ChartCache) error {
+		c.memory = newLRUIndex(size)
+		return nil
+	}
+}
+
+// WithPersistence configures the directory entries are persisted to, and the
+// maximum size in bytes of the entries stored in it. Any existing entries in
+// the directory are indexed, and removed if they exceed the maximum size.
+// A size of zero or less disables persistence.
+func WithPersistence(path string, size int64) ChartCacheOption {
+	return func(c *ChartCache) error {
+		if path == "" || size <= 0 {
+			return nil
+		}
+		if err := os.MkdirAll(path, 0o700); err != nil {
+			return fmt.Errorf("failed to create chart cache directory: %w", err)
+		}
+		c.path = path
+		c.disk = newLRUIndex(size)
+		return c.indexDisk()
+	}
+}
+
+// NewChartCache returns a new ChartCache configured with the provided
+// options. It returns an error if persistence is configured, but the
+// directory can not be used.
+func NewChartCache(opts ...ChartCacheOption) (*ChartCache, error) {
+	c := &ChartCache{
+		memory: newLRUIndex(0),
+		disk:   newLRUIndex(0),
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Get returns the data for the given digest, and true if it was found in
+// the cache. Entries found on disk are promoted to the in-memory cache.
+func (c *ChartCache) Get(digest string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.memory.get(digest); ok {
+		return e.data, true
+	}
+
+	if _, ok := c.disk.get(digest); !ok {
+		return nil, false
+	}
+	path, err := c.pathForDigest(digest)
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.disk.remove(digest)
+		return nil, false
+	}
+	c.memory.add(digest, int64(len(data)), data)
+	return data, true
+}
+
+// Set stores the data for the given digest in the cache. Data exceeding the
+// maximum size of a cache tier is not stored in that tier.
+func (c *ChartCache) Set(digest string, data []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.memory.add(digest, int64(len(data)), data)
+
+	if c.path == "" || int64(len(data)) > c.disk.maxSize {
+		return
+	}
+	if _, ok := c.disk.get(digest); ok {
+		return
+	}
+	path, err := c.pathForDigest(digest)
+	if err != nil {
+		return
+	}
+	if err = writeFileAtomic(path, data); err != nil {
+		return
+	}
+	for _, evicted := range c.disk.add(digest, int64(len(data)), nil) {
+		if p, err := c.pathForDigest(evicted); err == nil {
+			_ = os.Remove(p)
+		}
+	}
+}
+
+// Remove removes the entry for the given digest from the cache.
+func (c *ChartCache) Remove(digest string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.memory.remove(digest)
+	if c.disk.remove(digest) {
+		if path, err := c.pathForDigest(digest); err == nil {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+// Clear removes all entries from the in-memory cache. Any entries persisted
+// to disk are retained.
+func (c *ChartCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.memory = newLRUIndex(c.memory.maxSize)
+}
+
+// Size returns the size in bytes of the entries held in memory, and the
+// entries persisted to disk.
+func (c *ChartCache) Size() (memory, disk int64) {
+	if c == nil {
+		return 0, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.memory.size, c.disk.size
+}
+
+// pathForDigest returns the path the data for the given digest is persisted
+// to. It returns an error if the digest is invalid, which guards against
+// path traversal.
+func (c *ChartCache) pathForDigest(digest string) (string, error) {
+	d, err := digestlib.Parse(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.path, d.Algorithm().String(), d.Encoded()), nil
+}
+
+// indexDisk indexes the entries persisted to disk, in order of their
+// modification time. Entries exceeding the maximum size are removed, as
+// are files which do not represent a valid digest.
+func (c *ChartCache) indexDisk() error {
+	type file struct {
+		digest string
+		path   string
+		info   fs.FileInfo
+	}
+
+	var files []file
+	err := filepath.WalkDir(c.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(c.path, path)
+		if err != nil {
+			return err
+		}
+		algo, encoded := filepath.Split(rel)
+		dig := digestlib.NewDigestFromEncoded(digestlib.Algorithm(filepath.Clean(algo)), encoded)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if dig.Validate() != nil {
+			// Not created by us, or an incomplete write.
+			_ = os.Remove(path)
+			return nil
+		}
+		files = append(files, file{digest: dig.String(), path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index chart cache directory: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, f := range files {
+		if f.info.Size() > c.disk.maxSize {
+			_ = os.Remove(f.path)
+			continue
+		}
+		for _, evicted := range c.disk.add(f.digest, f.info.Size(), nil) {
+			if p, err := c.pathForDigest(evicted); err == nil {
+				_ = os.Remove(p)
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to the given path by writing it to a
+// temporary file first, and renaming it once complete.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// lruEntry is an entry in an lruIndex.
+type lruEntry struct {
+	digest string
+	size   int64
+	data   []byte
+}
+
+// lruIndex is a size bounded index of entries, which evicts the least
+// recently used entries when the maximum size is exceeded.
+// It is not safe for concurrent use.
+type lruIndex struct {
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newLRUIndex(maxSize int64) *lruIndex {
+	return &lruIndex{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the entry for the given digest, and marks it as most recently
+// used.
+func (l *lruIndex) get(digest string) (*lruEntry, bool) {
+	el, ok := l.entries[digest]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruEntry), true
+}
+
+// add adds an entry for the given digest, and returns the digests of any
+// entries evicted to make room for it. Entries larger than the maximum size
+// are ignored.
+func (l *lruIndex) add(digest string, size int64, data []byte) (evicted []string) {
+	if size > l.maxSize {
+		return nil
+	}
+	if el, ok := l.entries[digest]; ok {
+		l.order.MoveToFront(el)
+		return nil
+	}
+	l.entries[digest] = l.order.PushFront(&lruEntry{digest: digest, size: size, data: data})
+	l.size += size
+	for l.size > l.maxSize {
+		el := l.order.Back()
+		e := el.Value.(*lruEntry)
+		l.order.Remove(el)
+		delete(l.entries, e.digest)
+		l.size -= e.size
+		evicted = append(evicted, e.digest)
+	}
+	return evicted
+}
+
+// remove removes the entry for the given digest, and returns true if it
+// existed.
+func (l *lruIndex) remove(digest string) bool {
+	el, ok := l.entries[digest]
+	if !ok {
+		return false
+	}
+	e := el.Value.(*lruEntry)
+	l.order.Remove(el)
+	delete(l.entries, digest)
+	l.size -= e.size
+	return true
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	digestlib "github.com/opencontainers/go-digest"
+)
+
+func TestChartCache_GetSet(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := NewChartCache(WithMaxMemorySize(10))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	foo := digestlib.SHA256.FromString("foo").String()
+	bar := digestlib.SHA256.FromString("bar").String()
+	baz := digestlib.SHA256.FromString("baz").String()
+
+	c.Set(foo, []byte("foo"))
+	c.Set(bar, []byte("bar"))
+
+	got, ok := c.Get(foo)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(got).To(Equal([]byte("foo")))
+
+	// Adding a third and fourth entry exceeds the limit, and evicts the least
+	// recently used entry.
+	c.Set(baz, []byte("baz"))
+	c.Set(digestlib.SHA256.FromString("qux").String(), []byte("qux"))
+
+	_, ok = c.Get(bar)
+	g.Expect(ok).To(BeFalse())
+	_, ok = c.Get(foo)
+	g.Expect(ok).To(BeTrue())
+
+	mem, disk := c.Size()
+	g.Expect(mem).To(BeNumerically("<=", 10))
+	g.Expect(disk).To(BeZero())
+
+	// Entries exceeding the maximum size are not stored.
+	large := digestlib.SHA256.FromString("large").String()
+	c.Set(large, []byte("this is too large"))
+	_, ok = c.Get(large)
+	g.Expect(ok).To(BeFalse())
+
+	c.Remove(foo)
+	_, ok = c.Get(foo)
+	g.Expect(ok).To(BeFalse())
+
+	c.Clear()
+	mem, _ = c.Size()
+	g.Expect(mem).To(BeZero())
+}
+
+func TestChartCache_Disabled(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := NewChartCache()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	foo := digestlib.SHA256.FromString("foo").String()
+	c.Set(foo, []byte("foo"))
+	_, ok := c.Get(foo)
+	g.Expect(ok).To(BeFalse())
+
+	var nilCache *ChartCache
+	nilCache.Set(foo, []byte("foo"))
+	_, ok = nilCache.Get(foo)
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestChartCache_Persistence(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	foo := digestlib.SHA256.FromString("foo").String()
+	bar := digestlib.SHA256.FromString("bar").String()
+
+	c, err := NewChartCache(WithPersistence(dir, 6))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c.Set(foo, []byte("foo"))
+	c.Set(bar, []byte("bar"))
+
+	_, disk := c.Size()
+	g.Expect(disk).To(Equal(int64(6)))
+	g.Expect(filepath.Join(dir, "sha256", digestlib.Digest(foo).Encoded())).To(BeARegularFile())
+
+	// Write an unrelated file, which should be removed while indexing.
+	g.Expect(os.WriteFile(filepath.Join(dir, "sha256", ".tmp-123"), []byte("tmp"), 0o600)).To(Succeed())
+
+	// A new cache picks up the persisted entries.
+	c2, err := NewChartCache(WithMaxMemorySize(100), WithPersistence(dir, 6))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	got, ok := c2.Get(foo)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(got).To(Equal([]byte("foo")))
+	g.Expect(filepath.Join(dir, "sha256", ".tmp-123")).ToNot(BeAnExistingFile())
+
+	// Entries found on disk are promoted to memory.
+	mem, _ := c2.Size()
+	g.Expect(mem).To(Equal(int64(3)))
+
+	// Exceeding the limit removes the least recently used file.
+	baz := digestlib.SHA256.FromString("baz").String()
+	c2.Set(baz, []byte("baz"))
+	g.Expect(filepath.Join(dir, "sha256", digestlib.Digest(bar).Encoded())).ToNot(BeAnExistingFile())
+
+	// Removing an entry removes the file.
+	c2.Remove(foo)
+	g.Expect(filepath.Join(dir, "sha256", digestlib.Digest(foo).Encoded())).ToNot(BeAnExistingFile())
+
+	// A smaller limit evicts existing entries while indexing.
+	c3, err := NewChartCache(WithPersistence(dir, 3))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, disk = c3.Size()
+	g.Expect(disk).To(Equal(int64(3)))
+}
+
+func TestChartCache_InvalidDigest(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	c, err := NewChartCache(WithPersistence(dir, 100))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c.Set("../../escape", []byte("foo"))
+	_, disk := c.Size()
+	g.Expect(disk).To(BeZero())
+
+	entries, err := os.ReadDir(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(BeEmpty())
+}
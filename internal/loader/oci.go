@@ -0,0 +1,398 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	digestlib "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+	orasauth "oras.land/oras-go/pkg/auth"
+	dockerauth "oras.land/oras-go/pkg/auth/docker"
+
+	"github.com/fluxcd/helm-controller/internal/metrics"
+)
+
+// WithDockerConfigJSON configures the registry credentials used to pull a
+// chart from an OCI registry, using the contents of a Docker config file, as
+// found in the ".dockerconfigjson" key of a Secret of type
+// "kubernetes.io/dockerconfigjson".
+func WithDockerConfigJSON(data []byte) LoadOption {
+	return func(o *loadOptions) {
+		o.dockerConfigJSON = data
+	}
+}
+
+// WithPlainHTTP configures the registry client to use plain HTTP instead
+// of HTTPS when pulling a chart from an OCI registry.
+func WithPlainHTTP(plainHTTP bool) LoadOption {
+	return func(o *loadOptions) {
+		o.plainHTTP = plainHTTP
+	}
+}
+
+// SecureLoadChartFromOCI attempts to pull a Helm chart from the given OCI
+// URL (e.g. "oci://registry.example.com/charts/podinfo:6.5.0"). When a
+// digest is provided, the chart is pulled by digest, and the digest of the
+// pulled manifest is verified against it.
+//
+// When a VerifyFunc is configured using WithVerifyFunc, the provenance file
+// of the chart is pulled as well if available, and the function is called
+// before the chart is loaded.
+//
+// When a ChartCache is configured using WithCache, the pulled manifest and
+// layers are stored in the cache, and a chart pulled by digest is first
+// looked up in the cache. Cached data is verified against the digests of
+// the manifest and its layers, and evicted from the cache if the integrity
+// check fails.
+//
+// It returns the loaded chart.Chart and the digest of the pulled manifest,
+// or an error. The error may be of type ErrIntegrity if the integrity check
+// fails, of type ErrVerification if the verification fails, or of type
+// ErrChartTooLarge if the chart exceeds the size configured using
+// WithMaxChartSize. As the registry client buffers the pulled layers in
+// memory, the size limit is enforced using the sizes declared in the
+// manifest before a layer is fetched, and while it is read.
+//
+// As the registry client does not accept a context, the requests to the
+// registry are made using an http.Client which aborts them when the given
+// context is done.
+func SecureLoadChartFromOCI(ctx context.Context, URL, digest string, opts ...LoadOption) (*chart.Chart, string, error) {
+	o := &loadOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ref, err := ociReference(URL, digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if o.cache != nil && digest != "" {
+		if chartData, provData, ok := o.getOCIFromCache(digest); ok {
+			if err = o.verifyChart(chartData, provData, digest); err != nil {
+				return nil, "", err
+			}
+			c, err := loader.LoadArchive(bytes.NewReader(chartData))
+			if err != nil {
+				return nil, "", err
+			}
+			return c, digest, nil
+		}
+	}
+
+	// Always configure a credentials file, to prevent the client from
+	// falling back to credentials found in the environment of the
+	// controller.
+	credentialsFile, err := os.CreateTemp("", "oci-credentials-*.json")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create credentials file: %w", err)
+	}
+	defer os.Remove(credentialsFile.Name())
+	data := o.dockerConfigJSON
+	if len(data) == 0 {
+		data = []byte(`{"auths":{}}`)
+	}
+	if _, err = credentialsFile.Write(data); err != nil {
+		_ = credentialsFile.Close()
+		return nil, "", fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	if err = credentialsFile.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to write credentials file: %w", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &contextTransport{ctx: ctx, base: http.DefaultTransport},
+	}
+	clientOpts := []registry.ClientOption{
+		registry.ClientOptCredentialsFile(credentialsFile.Name()),
+		registry.ClientOptHTTPClient(httpClient),
+	}
+	if o.plainHTTP {
+		clientOpts = append(clientOpts, registry.ClientOptPlainHTTP())
+	}
+	var limitedResolver *sizeLimitedResolver
+	if o.maxChartSize > 0 {
+		if limitedResolver, err = newSizeLimitedResolver(credentialsFile.Name(), httpClient, o.plainHTTP, o.maxChartSize); err != nil {
+			return nil, "", fmt.Errorf("failed to create registry client: %w", err)
+		}
+		clientOpts = append(clientOpts, registry.ClientOptResolver(limitedResolver))
+	}
+	client, err := registry.NewClient(clientOpts...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create registry client: %w", err)
+	}
+
+	pullOpts := []registry.PullOption{registry.PullOptWithChart(true)}
+	if o.verify != nil {
+		pullOpts = append(pullOpts, registry.PullOptWithProv(true), registry.PullOptIgnoreMissingProv(true))
+	}
+	start := time.Now()
+	res, err := client.Pull(ref, pullOpts...)
+	if err != nil {
+		metrics.RecordChartDownload(metrics.ChartSourceOCI, 0, time.Since(start), false)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", fmt.Errorf("failed to pull chart from '%s': %w", ref, ctxErr)
+		}
+		if limitedResolver != nil && limitedResolver.exceeded.Load() {
+			return nil, "", fmt.Errorf("failed to pull chart from '%s': %w: exceeds limit of %d bytes",
+				ref, ErrChartTooLarge, o.maxChartSize)
+		}
+		return nil, "", fmt.Errorf("failed to pull chart from '%s': %w", ref, err)
+	}
+	size := int64(len(res.Chart.Data))
+	metrics.RecordChartDownload(metrics.ChartSourceOCI, size, time.Since(start), true)
+	if o.maxChartSize > 0 && size > o.maxChartSize {
+		return nil, "", fmt.Errorf("failed to pull chart from '%s': %w: size %d exceeds limit of %d bytes",
+			ref, ErrChartTooLarge, size, o.maxChartSize)
+	}
+	if digest != "" && res.Manifest.Digest != digest {
+		return nil, "", fmt.Errorf("%w: manifest digest '%s' does not match '%s'", ErrIntegrity, res.Manifest.Digest, digest)
+	}
+
+	if o.cache != nil {
+		o.cache.Set(res.Manifest.Digest, res.Manifest.Data)
+		o.cache.Set(res.Chart.Digest, res.Chart.Data)
+		if len(res.Prov.Data) > 0 {
+			o.cache.Set(res.Prov.Digest, res.Prov.Data)
+		}
+	}
+
+	if err = o.verifyChart(res.Chart.Data, res.Prov.Data, res.Manifest.Digest); err != nil {
+		return nil, "", err
+	}
+
+	c, err := loader.LoadArchive(bytes.NewReader(res.Chart.Data))
+	if err != nil {
+		return nil, "", err
+	}
+	return c, res.Manifest.Digest, nil
+}
+
+// getOCIFromCache returns the chart and provenance data of the OCI manifest
+// with the given digest from the cache, and true if the manifest and all
+// required layers were found and their integrity was verified. The
+// provenance layer is only required when a VerifyFunc is configured.
+// Entries failing the integrity check are evicted from the cache.
+func (o *loadOptions) getOCIFromCache(digest string) ([]byte, []byte, bool) {
+	manifestData, ok := o.getVerifiedFromCache(digest)
+	if !ok {
+		return nil, nil, false
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		o.cache.Remove(digest)
+		return nil, nil, false
+	}
+
+	var chartData, provData []byte
+	for _, l := range manifest.Layers {
+		switch l.MediaType {
+		case registry.ChartLayerMediaType, registry.LegacyChartLayerMediaType:
+			if chartData, ok = o.getVerifiedFromCache(l.Digest.String()); !ok {
+				return nil, nil, false
+			}
+		case registry.ProvLayerMediaType:
+			if o.verify == nil {
+				continue
+			}
+			if provData, ok = o.getVerifiedFromCache(l.Digest.String()); !ok {
+				return nil, nil, false
+			}
+		}
+	}
+	if chartData == nil {
+		return nil, nil, false
+	}
+	return chartData, provData, true
+}
+
+// getVerifiedFromCache returns the data for the given digest from the cache,
+// and true if it was found and matches the digest. Data not matching the
+// digest is evicted from the cache.
+func (o *loadOptions) getVerifiedFromCache(digest string) ([]byte, bool) {
+	data, ok := o.cache.Get(digest)
+	if !ok {
+		return nil, false
+	}
+	if err := copyAndVerify(digest, bytes.NewReader(data), io.Discard); err != nil {
+		o.cache.Remove(digest)
+		return nil, false
+	}
+	return data, true
+}
+
+// sizeLimitedResolver is a remotes.Resolver refusing to fetch content
+// larger than max bytes. The size declared in the descriptor of the content
+// is checked before it is fetched, and the fetched data is limited to max
+// bytes while it is read.
+type sizeLimitedResolver struct {
+	remotes.Resolver
+	max int64
+	// exceeded is set when content was refused because of its size, as the
+	// registry client does not preserve the error.
+	exceeded atomic.Bool
+}
+
+// newSizeLimitedResolver returns a sizeLimitedResolver using the
+// credentials from the given Docker config file and the given http.Client,
+// in the same way as the default resolver of the Helm registry client.
+func newSizeLimitedResolver(credentialsFile string, httpClient *http.Client, plainHTTP bool, max int64) (*sizeLimitedResolver, error) {
+	authClient, err := dockerauth.NewClientWithDockerFallback(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	opts := []orasauth.ResolverOption{orasauth.WithResolverClient(httpClient)}
+	if plainHTTP {
+		opts = append(opts, orasauth.WithResolverPlainHTTP())
+	}
+	resolver, err := authClient.ResolverWithOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &sizeLimitedResolver{Resolver: resolver, max: max}, nil
+}
+
+// Fetcher returns a remotes.Fetcher for the given reference, enforcing the
+// size limit.
+func (r *sizeLimitedResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	f, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &sizeLimitedFetcher{Fetcher: f, resolver: r}, nil
+}
+
+// sizeLimitedFetcher is the remotes.Fetcher of a sizeLimitedResolver.
+type sizeLimitedFetcher struct {
+	remotes.Fetcher
+	resolver *sizeLimitedResolver
+}
+
+// Fetch returns an ErrChartTooLarge error if the size of the descriptor
+// exceeds the limit, or a reader limited to the limit otherwise.
+func (f *sizeLimitedFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if desc.Size > f.resolver.max {
+		f.resolver.exceeded.Store(true)
+		return nil, fmt.Errorf("%w: size %d of '%s' exceeds limit of %d bytes",
+			ErrChartTooLarge, desc.Size, desc.Digest, f.resolver.max)
+	}
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	return &sizeLimitedReadCloser{
+		sizeLimitedReader: &sizeLimitedReader{r: rc, max: f.resolver.max},
+		Closer:            rc,
+		resolver:          f.resolver,
+	}, nil
+}
+
+// sizeLimitedReadCloser records on the sizeLimitedResolver when its
+// sizeLimitedReader exceeds the limit.
+type sizeLimitedReadCloser struct {
+	*sizeLimitedReader
+	io.Closer
+	resolver *sizeLimitedResolver
+}
+
+func (r *sizeLimitedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.sizeLimitedReader.Read(p)
+	if r.max > 0 && r.n > r.max {
+		r.resolver.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// contextTransport is an http.RoundTripper aborting the requests made
+// through it when ctx is done, in addition to when the context of the
+// request is done.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// RoundTrip executes the request using the base http.RoundTripper, with a
+// context which is canceled when either ctx or the context of the request
+// is done. The context is released once the response body is closed.
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	stop := context.AfterFunc(t.ctx, func() {
+		cancel(context.Cause(t.ctx))
+	})
+	release := func() {
+		stop()
+		cancel(nil)
+	}
+
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &releasingReadCloser{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// releasingReadCloser calls release once it is closed.
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// ociReference returns the registry reference for the given OCI URL,
+// with the scheme removed and the digest appended if provided.
+func ociReference(URL, digest string) (string, error) {
+	if !strings.HasPrefix(URL, registry.OCIScheme+"://") {
+		return "", fmt.Errorf("invalid OCI URL '%s': must start with '%s://'", URL, registry.OCIScheme)
+	}
+	ref := strings.TrimPrefix(URL, registry.OCIScheme+"://")
+	if digest == "" {
+		return ref, nil
+	}
+	if _, err := digestlib.Parse(digest); err != nil {
+		return "", fmt.Errorf("invalid digest '%s': %w", digest, err)
+	}
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	return ref + "@" + digest, nil
+}
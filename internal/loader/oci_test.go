@@ -0,0 +1,380 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	digestlib "github.com/opencontainers/go-digest"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// testRegistry is a minimal read-only OCI registry, serving the manifests
+// and blobs of a single repository.
+type testRegistry struct {
+	repository string
+	username   string
+	password   string
+	manifests  map[string]digestlib.Digest
+	blobs      map[digestlib.Digest][]byte
+	// fetched records the digests of the blobs fetched with a GET request.
+	fetched []digestlib.Digest
+}
+
+func newTestRegistry(t *testing.T, repository, tag string, chartData []byte) (*testRegistry, string, digestlib.Digest) {
+	t.Helper()
+
+	r := &testRegistry{
+		repository: repository,
+		manifests:  map[string]digestlib.Digest{},
+		blobs:      map[digestlib.Digest][]byte{},
+	}
+
+	config := []byte(`{"name":"chart","version":"0.1.0","apiVersion":"v2"}`)
+	configDigest := digestlib.SHA256.FromBytes(config)
+	chartDigest := digestlib.SHA256.FromBytes(chartData)
+	r.blobs[configDigest] = config
+	r.blobs[chartDigest] = chartData
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config": map[string]interface{}{
+			"mediaType": registry.ConfigMediaType,
+			"digest":    configDigest.String(),
+			"size":      len(config),
+		},
+		"layers": []map[string]interface{}{
+			{
+				"mediaType": registry.ChartLayerMediaType,
+				"digest":    chartDigest.String(),
+				"size":      len(chartData),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDigest := digestlib.SHA256.FromBytes(manifest)
+	r.blobs[manifestDigest] = manifest
+	r.manifests[tag] = manifestDigest
+	r.manifests[manifestDigest.String()] = manifestDigest
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	return r, strings.TrimPrefix(server.URL, "http://"), manifestDigest
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.username != "" {
+		if u, p, ok := req.BasicAuth(); !ok || u != r.username || p != r.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	prefix := "/v2/" + r.repository + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	kind, ref, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, prefix), "/")
+
+	var (
+		d           digestlib.Digest
+		ok          bool
+		contentType = "application/octet-stream"
+	)
+	switch kind {
+	case "manifests":
+		d, ok = r.manifests[ref]
+		contentType = "application/vnd.oci.image.manifest.v1+json"
+	case "blobs":
+		d = digestlib.Digest(ref)
+		_, ok = r.blobs[d]
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	data := r.blobs[d]
+	if kind == "blobs" && req.Method == http.MethodGet {
+		r.fetched = append(r.fetched, d)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+}
+
+func TestSecureLoadChartFromOCI(t *testing.T) {
+	g := NewWithT(t)
+
+	b, err := os.ReadFile("testdata/chart-0.1.0.tgz")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	reg, host, manifestDigest := newTestRegistry(t, "charts/chart", "0.1.0", b)
+	chartURL := fmt.Sprintf("oci://%s/charts/chart:0.1.0", host)
+
+	t.Run("loads Helm chart from OCI registry", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, d, err := SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		g.Expect(got.Name()).To(Equal("chart"))
+		g.Expect(got.Metadata.Version).To(Equal("0.1.0"))
+		g.Expect(d).To(Equal(manifestDigest.String()))
+	})
+
+	t.Run("loads Helm chart by digest", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, d, err := SecureLoadChartFromOCI(context.TODO(), chartURL, manifestDigest.String(), WithPlainHTTP(true))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		g.Expect(d).To(Equal(manifestDigest.String()))
+	})
+
+	t.Run("error on unknown digest", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, digestlib.SHA256.FromString("invalid").String(), WithPlainHTTP(true))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("loads Helm chart by digest from cache", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cache, err := NewChartCache(WithMaxMemorySize(1 << 20))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, manifestDigest.String(), WithPlainHTTP(true), WithCache(cache))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		_, ok := cache.Get(manifestDigest.String())
+		g.Expect(ok).To(BeTrue())
+
+		blobs := reg.blobs
+		reg.blobs = map[digestlib.Digest][]byte{}
+		t.Cleanup(func() {
+			reg.blobs = blobs
+		})
+
+		got, d, err := SecureLoadChartFromOCI(context.TODO(), chartURL, manifestDigest.String(), WithPlainHTTP(true), WithCache(cache))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		g.Expect(got.Name()).To(Equal("chart"))
+		g.Expect(d).To(Equal(manifestDigest.String()))
+
+		chartDigest := digestlib.SHA256.FromBytes(b).String()
+		cache.Remove(chartDigest)
+		cache.Set(chartDigest, []byte("tampered"))
+		got, _, err = SecureLoadChartFromOCI(context.TODO(), chartURL, manifestDigest.String(), WithPlainHTTP(true), WithCache(cache))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(got).To(BeNil())
+		_, ok = cache.Get(chartDigest)
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("error on chart exceeding maximum size", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true), WithMaxChartSize(10))
+		g.Expect(errors.Is(err, ErrChartTooLarge)).To(BeTrue())
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("does not fetch chart layer exceeding maximum size", func(t *testing.T) {
+		g := NewWithT(t)
+
+		reg.fetched = nil
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true), WithMaxChartSize(int64(len(b)-1)))
+		g.Expect(errors.Is(err, ErrChartTooLarge)).To(BeTrue())
+		g.Expect(got).To(BeNil())
+		g.Expect(reg.fetched).ToNot(ContainElement(digestlib.SHA256.FromBytes(b)))
+
+		got, _, err = SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true), WithMaxChartSize(int64(len(b))))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+	})
+
+	t.Run("error on invalid URL", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), "https://"+host+"/charts/chart:0.1.0", "")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("must start with 'oci://'"))
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("authenticates using Docker config", func(t *testing.T) {
+		g := NewWithT(t)
+
+		reg.username, reg.password = "user", "pass"
+		t.Cleanup(func() {
+			reg.username, reg.password = "", ""
+		})
+
+		_, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true))
+		g.Expect(err).To(HaveOccurred())
+
+		config := fmt.Sprintf(`{"auths":{%q:{"auth":%q}}}`, host, base64.StdEncoding.EncodeToString([]byte("user:pass")))
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true), WithDockerConfigJSON([]byte(config)))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+	})
+
+	t.Run("verifies chart using VerifyFunc", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var verifiedDigest string
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true), WithVerifyFunc(func(chart, provenance []byte, d string) error {
+			g.Expect(chart).To(Equal(b))
+			g.Expect(provenance).To(BeEmpty())
+			verifiedDigest = d
+			return nil
+		}))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).ToNot(BeNil())
+		g.Expect(verifiedDigest).To(Equal(manifestDigest.String()))
+
+		got, _, err = SecureLoadChartFromOCI(context.TODO(), chartURL, "", WithPlainHTTP(true), WithVerifyFunc(func(_, _ []byte, _ string) error {
+			return errors.New("untrusted")
+		}))
+		g.Expect(errors.Is(err, ErrVerification)).To(BeTrue())
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("error on digest mismatch", func(t *testing.T) {
+		g := NewWithT(t)
+
+		other := digestlib.SHA256.FromString("other")
+		reg.manifests[other.String()] = manifestDigest
+		t.Cleanup(func() {
+			delete(reg.manifests, other.String())
+		})
+
+		got, _, err := SecureLoadChartFromOCI(context.TODO(), chartURL, other.String(), WithPlainHTTP(true))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(got).To(BeNil())
+	})
+
+	t.Run("error on canceled context", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		got, _, err := SecureLoadChartFromOCI(ctx, chartURL, "", WithPlainHTTP(true))
+		g.Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		g.Expect(got).To(BeNil())
+	})
+}
+
+func Test_contextTransport(t *testing.T) {
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("aborts request when context is done", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		client := &http.Client{Transport: &contextTransport{ctx: ctx, base: http.DefaultTransport}}
+
+		errCh := make(chan error, 1)
+		go func() {
+			res, err := client.Get(srv.URL)
+			if err == nil {
+				_ = res.Body.Close()
+			}
+			errCh <- err
+		}()
+		cancel()
+
+		var err error
+		g.Eventually(errCh).Should(Receive(&err))
+		g.Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+	})
+
+	t.Run("returns error without request when context is done", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		client := &http.Client{Transport: &contextTransport{ctx: ctx, base: http.DefaultTransport}}
+
+		_, err := client.Get(srv.URL)
+		g.Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+	})
+}
+
+func Test_ociReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		digest  string
+		want    string
+		wantErr bool
+	}{
+		{name: "tag", url: "oci://example.com/charts/foo:1.0.0", want: "example.com/charts/foo:1.0.0"},
+		{name: "tag and digest", url: "oci://example.com/charts/foo:1.0.0", digest: digestlib.SHA256.FromString("foo").String(),
+			want: "example.com/charts/foo:1.0.0@" + digestlib.SHA256.FromString("foo").String()},
+		{name: "replaces digest", url: "oci://example.com/charts/foo@sha256:abc", digest: digestlib.SHA256.FromString("foo").String(),
+			want: "example.com/charts/foo@" + digestlib.SHA256.FromString("foo").String()},
+		{name: "invalid scheme", url: "https://example.com/charts/foo", wantErr: true},
+		{name: "invalid digest", url: "oci://example.com/charts/foo", digest: "invalid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := ociReference(tt.url, tt.digest)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics provides the Prometheus metrics of the controller, in
+// addition to the metrics recorded by the runtime helpers.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	crtlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ChartSourceArtifact is the source label value for charts downloaded
+	// from a source-controller artifact.
+	ChartSourceArtifact = "artifact"
+	// ChartSourceOCI is the source label value for charts pulled from an
+	// OCI registry.
+	ChartSourceOCI = "oci"
+
+	// OutcomeSuccess is the outcome label value of a successful action.
+	OutcomeSuccess = "success"
+	// OutcomeFailure is the outcome label value of a failed action.
+	OutcomeFailure = "failure"
+)
+
+var (
+	chartDownloadDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_chart_download_duration_seconds",
+			Help:    "The duration in seconds of chart downloads.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"source", "success"},
+	)
+	chartDownloadSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_chart_download_size_bytes",
+			Help:    "The size in bytes of downloaded chart archives.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+		[]string{"source", "success"},
+	)
+	discoveryCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gotk_discovery_cache_requests_total",
+			Help: "The number of requests for a shared discovery client, by whether it was served from the cache.",
+		},
+		[]string{"hit"},
+	)
+	fairQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_fair_queue_depth",
+			Help: "The number of queued HelmReleases per tenant of the fair queue.",
+		},
+		[]string{"tenant"},
+	)
+	fairQueueActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_fair_queue_active",
+			Help: "The number of HelmReleases being reconciled per tenant of the fair queue.",
+		},
+		[]string{"tenant"},
+	)
+	fairQueueAdds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gotk_fair_queue_adds_total",
+			Help: "The number of HelmReleases queued per tenant of the fair queue.",
+		},
+		[]string{"tenant"},
+	)
+	fairQueueWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_fair_queue_wait_duration_seconds",
+			Help:    "The duration in seconds HelmReleases are queued per tenant of the fair queue.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 15),
+		},
+		[]string{"tenant"},
+	)
+	memoryPressureTier = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gotk_memory_pressure_tier",
+			Help: "The memory pressure tier of the controller: 0 (normal), 1 (soft) or 2 (hard).",
+		},
+	)
+	releaseMemoryEstimate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_release_memory_estimate_bytes",
+			Help: "The estimated memory in bytes held by the chart, values and manifests of the last release attempt of a HelmRelease.",
+		},
+		[]string{"namespace", "name", "stage"},
+	)
+	releaseManifestObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_release_manifest_objects",
+			Help: "The number of objects in the manifests of the last release attempt of a HelmRelease.",
+		},
+		[]string{"namespace", "name", "stage"},
+	)
+	releaseActionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_release_action_duration_seconds",
+			Help:    "The duration in seconds of Helm actions run for a HelmRelease, by action and outcome.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+		},
+		[]string{"namespace", "name", "action", "outcome"},
+	)
+	releaseActions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gotk_release_actions_total",
+			Help: "The number of Helm actions run for a HelmRelease, by action and outcome.",
+		},
+		[]string{"namespace", "name", "action", "outcome"},
+	)
+	releaseActionFailing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_release_action_failing",
+			Help: "Whether the last run of a Helm action for a HelmRelease failed (1) or succeeded (0).",
+		},
+		[]string{"namespace", "name", "action"},
+	)
+	releaseRemediations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gotk_release_remediations_total",
+			Help: "The number of remediations of a HelmRelease, by remediation action.",
+		},
+		[]string{"namespace", "name", "action"},
+	)
+	releaseDriftDetections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gotk_release_drift_detections_total",
+			Help: "The number of times the cluster state of a HelmRelease was detected to have drifted.",
+		},
+		[]string{"namespace", "name"},
+	)
+	chartLoadDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_chart_load_duration_seconds",
+			Help:    "The duration in seconds of loading the chart of a HelmRelease, including its download.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"namespace", "name"},
+	)
+	releaseRenderDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gotk_release_render_duration_seconds",
+			Help:    "The duration in seconds of rendering (stage=render) and post-rendering (stage=post-render) the manifest of a HelmRelease.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"namespace", "name", "stage"},
+	)
+	releaseInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gotk_release_info",
+			Help: "Information about the current release of a HelmRelease, the value is always 1.",
+		},
+		[]string{"namespace", "name", "release_namespace", "release_name", "chart", "chart_version", "app_version"},
+	)
+	discoveryCacheInvalidations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gotk_discovery_cache_invalidations_total",
+			Help: "The number of invalidations of shared discovery clients due to API resource changes.",
+		},
+	)
+)
+
+func init() {
+	crtlmetrics.Registry.MustRegister(chartDownloadDuration, chartDownloadSize,
+		discoveryCacheRequests, discoveryCacheInvalidations,
+		fairQueueDepth, fairQueueActive, fairQueueAdds, fairQueueWaitDuration,
+		memoryPressureTier, releaseMemoryEstimate, releaseManifestObjects,
+		releaseActionDuration, releaseActions, releaseActionFailing, releaseRemediations,
+		releaseDriftDetections, chartLoadDuration, releaseRenderDuration, releaseInfo)
+}
+
+// RecordChartDownload records the duration and the number of bytes read of
+// a chart download from the given source.
+func RecordChartDownload(source string, size int64, duration time.Duration, success bool) {
+	s := strconv.FormatBool(success)
+	chartDownloadDuration.WithLabelValues(source, s).Observe(duration.Seconds())
+	chartDownloadSize.WithLabelValues(source, s).Observe(float64(size))
+}
+
+// RecordDiscoveryCacheRequest records a request for a shared discovery
+// client. A hit means the discovery of the API resources of a cluster was
+// saved.
+func RecordDiscoveryCacheRequest(hit bool) {
+	discoveryCacheRequests.WithLabelValues(strconv.FormatBool(hit)).Inc()
+}
+
+// RecordDiscoveryCacheInvalidation records the invalidation of shared
+// discovery clients.
+func RecordDiscoveryCacheInvalidation() {
+	discoveryCacheInvalidations.Inc()
+}
+
+// RecordFairQueueAdd records an item queued for the tenant of the fair
+// queue.
+func RecordFairQueueAdd(tenant string) {
+	fairQueueAdds.WithLabelValues(tenant).Inc()
+}
+
+// RecordFairQueueWait records the duration an item of the tenant was queued
+// before it was handed out to a worker.
+func RecordFairQueueWait(tenant string, duration time.Duration) {
+	fairQueueWaitDuration.WithLabelValues(tenant).Observe(duration.Seconds())
+}
+
+// SetFairQueueState sets the number of queued and active items of the tenant
+// of the fair queue.
+func SetFairQueueState(tenant string, depth, active int) {
+	fairQueueDepth.WithLabelValues(tenant).Set(float64(depth))
+	fairQueueActive.WithLabelValues(tenant).Set(float64(active))
+}
+
+// DeleteFairQueueTenant deletes the gauges of a tenant of the fair queue
+// which has no queued or active items.
+func DeleteFairQueueTenant(tenant string) {
+	fairQueueDepth.DeleteLabelValues(tenant)
+	fairQueueActive.DeleteLabelValues(tenant)
+}
+
+// SetMemoryPressureTier sets the memory pressure tier of the controller.
+func SetMemoryPressureTier(tier int) {
+	memoryPressureTier.Set(float64(tier))
+}
+
+// RecordReleaseMemoryEstimate records the estimated memory in bytes held at
+// a stage of a release attempt of the HelmRelease, e.g. the loaded chart.
+func RecordReleaseMemoryEstimate(namespace, name, stage string, size int64) {
+	releaseMemoryEstimate.WithLabelValues(namespace, name, stage).Set(float64(size))
+}
+
+// RecordReleaseManifest records the size in bytes and the number of objects
+// of the manifest at a stage of a release attempt of the HelmRelease.
+func RecordReleaseManifest(namespace, name, stage string, size int64, objects int) {
+	releaseMemoryEstimate.WithLabelValues(namespace, name, stage).Set(float64(size))
+	releaseManifestObjects.WithLabelValues(namespace, name, stage).Set(float64(objects))
+}
+
+// RecordReleaseAction records the duration and outcome of a Helm action run
+// for the HelmRelease, and whether the action is failing.
+func RecordReleaseAction(namespace, name, action string, duration time.Duration, success bool) {
+	outcome, failing := OutcomeSuccess, 0.0
+	if !success {
+		outcome, failing = OutcomeFailure, 1.0
+	}
+	releaseActionDuration.WithLabelValues(namespace, name, action, outcome).Observe(duration.Seconds())
+	releaseActions.WithLabelValues(namespace, name, action, outcome).Inc()
+	releaseActionFailing.WithLabelValues(namespace, name, action).Set(failing)
+}
+
+// RecordRemediation records a remediation of the HelmRelease using the given
+// action.
+func RecordRemediation(namespace, name, action string) {
+	releaseRemediations.WithLabelValues(namespace, name, action).Inc()
+}
+
+// RecordDriftDetection records the detection of drift of the cluster state
+// of the HelmRelease.
+func RecordDriftDetection(namespace, name string) {
+	releaseDriftDetections.WithLabelValues(namespace, name).Inc()
+}
+
+// RecordChartLoad records the duration of loading the chart of the
+// HelmRelease.
+func RecordChartLoad(namespace, name string, duration time.Duration) {
+	chartLoadDuration.WithLabelValues(namespace, name).Observe(duration.Seconds())
+}
+
+// RecordReleaseRender records the duration of a stage of rendering the
+// manifest of the HelmRelease.
+func RecordReleaseRender(namespace, name, stage string, duration time.Duration) {
+	releaseRenderDuration.WithLabelValues(namespace, name, stage).Observe(duration.Seconds())
+}
+
+// SetReleaseInfo sets the information about the current release of the
+// HelmRelease, replacing any previous information.
+func SetReleaseInfo(namespace, name, releaseNamespace, releaseName, chart, chartVersion, appVersion string) {
+	releaseInfo.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "name": name})
+	releaseInfo.WithLabelValues(namespace, name, releaseNamespace, releaseName, chart, chartVersion, appVersion).Set(1)
+}
+
+// DeleteReleaseInfo deletes the information about the current release of
+// the HelmRelease.
+func DeleteReleaseInfo(namespace, name string) {
+	releaseInfo.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "name": name})
+}
+
+// DeleteRelease deletes the metrics recorded for the HelmRelease.
+func DeleteRelease(namespace, name string) {
+	labels := prometheus.Labels{"namespace": namespace, "name": name}
+	releaseMemoryEstimate.DeletePartialMatch(labels)
+	releaseManifestObjects.DeletePartialMatch(labels)
+	releaseActionDuration.DeletePartialMatch(labels)
+	releaseActions.DeletePartialMatch(labels)
+	releaseActionFailing.DeletePartialMatch(labels)
+	releaseRemediations.DeletePartialMatch(labels)
+	releaseDriftDetections.DeletePartialMatch(labels)
+	chartLoadDuration.DeletePartialMatch(labels)
+	releaseRenderDuration.DeletePartialMatch(labels)
+	releaseInfo.DeletePartialMatch(labels)
+}
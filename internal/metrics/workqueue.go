@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+	crtlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	workqueueMetrics     *workqueueMetricsProvider
+	workqueueMetricsOnce sync.Once
+)
+
+// WorkqueueMetricsProvider returns a workqueue.MetricsProvider which records
+// to the workqueue metrics registered by controller-runtime, for queues
+// which are not created by workqueue.NewWithConfig.
+func WorkqueueMetricsProvider() workqueue.MetricsProvider {
+	workqueueMetricsOnce.Do(func() {
+		workqueueMetrics = newWorkqueueMetricsProvider()
+	})
+	return workqueueMetrics
+}
+
+// workqueueMetricsProvider is a workqueue.MetricsProvider for the workqueue
+// metrics of controller-runtime.
+type workqueueMetricsProvider struct {
+	depth                   *prometheus.GaugeVec
+	adds                    *prometheus.CounterVec
+	latency                 *prometheus.HistogramVec
+	workDuration            *prometheus.HistogramVec
+	unfinished              *prometheus.GaugeVec
+	longestRunningProcessor *prometheus.GaugeVec
+	retries                 *prometheus.CounterVec
+}
+
+// newWorkqueueMetricsProvider returns a workqueueMetricsProvider with the
+// collectors registered by controller-runtime. The collectors are declared
+// identical to those of controller-runtime, so registering them returns the
+// already registered collectors.
+func newWorkqueueMetricsProvider() *workqueueMetricsProvider {
+	return &workqueueMetricsProvider{
+		depth: registerOrExisting(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.DepthKey,
+			Help:      "Current depth of workqueue",
+		}, []string{"name"})),
+		adds: registerOrExisting(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.AddsKey,
+			Help:      "Total number of adds handled by workqueue",
+		}, []string{"name"})),
+		latency: registerOrExisting(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.QueueLatencyKey,
+			Help:      "How long in seconds an item stays in workqueue before being requested",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 12),
+		}, []string{"name"})),
+		workDuration: registerOrExisting(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.WorkDurationKey,
+			Help:      "How long in seconds processing an item from workqueue takes.",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 12),
+		}, []string{"name"})),
+		unfinished: registerOrExisting(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.UnfinishedWorkKey,
+			Help: "How many seconds of work has been done that " +
+				"is in progress and hasn't been observed by work_duration. Large " +
+				"values indicate stuck threads. One can deduce the number of stuck " +
+				"threads by observing the rate at which this increases.",
+		}, []string{"name"})),
+		longestRunningProcessor: registerOrExisting(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.LongestRunningProcessorKey,
+			Help: "How many seconds has the longest running " +
+				"processor for workqueue been running.",
+		}, []string{"name"})),
+		retries: registerOrExisting(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: crtlmetrics.WorkQueueSubsystem,
+			Name:      crtlmetrics.RetriesKey,
+			Help:      "Total number of retries handled by workqueue",
+		}, []string{"name"})),
+	}
+}
+
+// registerOrExisting registers the collector, and returns the already
+// registered collector if an identical collector was registered before.
+func registerOrExisting[T prometheus.Collector](c T) T {
+	if err := crtlmetrics.Registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+	}
+	return c
+}
+
+func (p *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return p.depth.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return p.adds.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return p.latency.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return p.workDuration.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.unfinished.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.longestRunningProcessor.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return p.retries.WithLabelValues(name)
+}
@@ -14,17 +14,21 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package oomwatch provides a way to detect near OOM conditions.
+// Package oomwatch provides a way to detect near OOM conditions, and to
+// respond to them in tiers.
 package oomwatch
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -53,6 +57,49 @@ const (
 	MemoryCurrentFile = "memory.current"
 )
 
+// Tier is the memory pressure tier of the system.
+type Tier int32
+
+const (
+	// TierNormal is the tier when the memory usage is below the soft
+	// threshold.
+	TierNormal Tier = iota
+	// TierSoft is the tier when the memory usage is at or above the soft
+	// threshold, but below the (hard) threshold.
+	TierSoft
+	// TierHard is the tier when the memory usage is at or above the
+	// (hard) threshold, at which the context of the Watcher is canceled.
+	TierHard
+)
+
+// String returns the name of the tier.
+func (t Tier) String() string {
+	switch t {
+	case TierNormal:
+		return "normal"
+	case TierSoft:
+		return "soft"
+	case TierHard:
+		return "hard"
+	default:
+		return fmt.Sprintf("Tier(%d)", int32(t))
+	}
+}
+
+// SoftThresholdHysteresis is the number of percentage points the memory
+// usage must drop below the soft threshold before returning to TierNormal,
+// to prevent flapping between tiers when the usage hovers around the soft
+// threshold. It is capped at half of the soft threshold.
+const SoftThresholdHysteresis uint8 = 5
+
+// ErrMemoryPressure is returned by ReadyzCheck when the memory usage is at or
+// above the soft threshold.
+var ErrMemoryPressure = errors.New("memory usage is near OOM")
+
+// TierFunc is called with the new tier when the memory pressure tier of the
+// system changes.
+type TierFunc func(Tier)
+
 // Watcher can be used to detect near OOM conditions.
 type Watcher struct {
 	// memoryMax is the maximum amount of memory that can be used by the system.
@@ -62,6 +109,9 @@ type Watcher struct {
 	// memoryUsagePercentThreshold is the threshold at which the system is
 	// considered to be near OOM.
 	memoryUsagePercentThreshold uint8
+	// memoryUsagePercentSoftThreshold is the threshold at which the system
+	// is considered to be under memory pressure. A value of 0 disables it.
+	memoryUsagePercentSoftThreshold uint8
 	// interval is the interval at which to check for OOM.
 	interval time.Duration
 	// logger is the logger to use.
@@ -73,6 +123,13 @@ type Watcher struct {
 	cancel context.CancelFunc
 	// once is used to ensure that Watch is only called once.
 	once sync.Once
+
+	// tier is the current memory pressure tier.
+	tier atomic.Int32
+	// tierFuncs are called when the tier changes.
+	tierFuncs []TierFunc
+	// tierFuncsMu guards tierFuncs.
+	tierFuncsMu sync.Mutex
 }
 
 // New returns a new Watcher with the given configuration. If the provided
@@ -111,6 +168,44 @@ func New(memoryMaxPath, memoryCurrentPath string, memoryUsagePercentThreshold ui
 	}, nil
 }
 
+// SetSoftThreshold sets the memory usage percentage at which the system is
+// considered to be under memory pressure, which must be lower than the
+// threshold at which the context is canceled. When the memory usage is at or
+// above the soft threshold, the functions registered with OnTierChange are
+// called with TierSoft, and ReadyzCheck returns an error, until the memory
+// usage drops SoftThresholdHysteresis below the soft threshold. A value of 0
+// disables the soft threshold.
+func (w *Watcher) SetSoftThreshold(threshold uint8) error {
+	if threshold != 0 && threshold >= w.memoryUsagePercentThreshold {
+		return fmt.Errorf("memory usage percent soft threshold must be lower than %d, got %d",
+			w.memoryUsagePercentThreshold, threshold)
+	}
+	w.memoryUsagePercentSoftThreshold = threshold
+	return nil
+}
+
+// OnTierChange registers a function which is called when the memory pressure
+// tier changes. It can be called before or after Watch.
+func (w *Watcher) OnTierChange(f TierFunc) {
+	w.tierFuncsMu.Lock()
+	defer w.tierFuncsMu.Unlock()
+	w.tierFuncs = append(w.tierFuncs, f)
+}
+
+// Tier returns the current memory pressure tier.
+func (w *Watcher) Tier() Tier {
+	return Tier(w.tier.Load())
+}
+
+// ReadyzCheck is a readiness check which fails when the memory usage is at
+// or above the soft threshold.
+func (w *Watcher) ReadyzCheck(_ *http.Request) error {
+	if t := w.Tier(); t != TierNormal {
+		return fmt.Errorf("%w (tier: %s)", ErrMemoryPressure, t)
+	}
+	return nil
+}
+
 // Watch returns a context that is canceled when the system reaches the
 // configured memory usage threshold. Calling Watch multiple times will return
 // the same context.
@@ -146,15 +241,53 @@ func (w *Watcher) watchForNearOOM(ctx context.Context) {
 			if currentPercentage >= float64(w.memoryUsagePercentThreshold) {
 				w.logger.Info(fmt.Sprintf("Memory usage is near OOM (%s/%s), shutting down",
 					formatSize(current), formatSize(w.memoryMax)))
+				w.setTier(TierHard)
 				w.cancel()
 				return
 			}
+			if w.memoryUsagePercentSoftThreshold > 0 {
+				tier := w.Tier()
+				switch {
+				case currentPercentage >= float64(w.memoryUsagePercentSoftThreshold):
+					tier = TierSoft
+				case currentPercentage < w.softResumeThreshold():
+					tier = TierNormal
+				}
+				if prev := w.setTier(tier); prev != tier {
+					w.logger.Info(fmt.Sprintf("Memory pressure tier changed from %s to %s (%s/%s)",
+						prev, tier, formatSize(current), formatSize(w.memoryMax)))
+				}
+			}
 			w.logger.V(2).Info(fmt.Sprintf("Current memory usage %s/%s (%.2f%% out of %d%%)",
 				formatSize(current), formatSize(w.memoryMax), currentPercentage, w.memoryUsagePercentThreshold))
 		}
 	}
 }
 
+// softResumeThreshold returns the memory usage percentage below which the
+// system returns from TierSoft to TierNormal.
+func (w *Watcher) softResumeThreshold() float64 {
+	hysteresis := min(SoftThresholdHysteresis, w.memoryUsagePercentSoftThreshold/2)
+	return float64(w.memoryUsagePercentSoftThreshold - hysteresis)
+}
+
+// setTier sets the current tier, and calls the registered functions if it
+// changed. It returns the previous tier.
+func (w *Watcher) setTier(tier Tier) Tier {
+	prev := Tier(w.tier.Swap(int32(tier)))
+	if prev == tier {
+		return prev
+	}
+
+	w.tierFuncsMu.Lock()
+	funcs := append([]TierFunc(nil), w.tierFuncs...)
+	w.tierFuncsMu.Unlock()
+	for _, f := range funcs {
+		f(tier)
+	}
+	return prev
+}
+
 // discoverCgroupPaths attempts to automatically discover the cgroup v1 and v2
 // paths for the max and current memory files when they are not provided. It
 // returns the discovered and/or provided max and current paths.
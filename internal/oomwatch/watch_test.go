@@ -285,6 +285,74 @@ func TestWatcher_watchForNearOOM(t *testing.T) {
 	})
 }
 
+func TestWatcher_SetSoftThreshold(t *testing.T) {
+	g := NewWithT(t)
+
+	w := &Watcher{memoryUsagePercentThreshold: 95}
+	g.Expect(w.SetSoftThreshold(80)).To(Succeed())
+	g.Expect(w.memoryUsagePercentSoftThreshold).To(Equal(uint8(80)))
+	g.Expect(w.SetSoftThreshold(0)).To(Succeed())
+	g.Expect(w.SetSoftThreshold(95)).To(HaveOccurred())
+}
+
+func TestWatcher_softResumeThreshold(t *testing.T) {
+	g := NewWithT(t)
+
+	w := &Watcher{memoryUsagePercentSoftThreshold: 80}
+	g.Expect(w.softResumeThreshold()).To(Equal(float64(75)))
+	w.memoryUsagePercentSoftThreshold = 6
+	g.Expect(w.softResumeThreshold()).To(Equal(float64(3)))
+}
+
+func TestWatcher_tiers(t *testing.T) {
+	g := NewWithT(t)
+
+	mockMemoryCurrent := filepath.Join(t.TempDir(), MemoryCurrentFile)
+	g.Expect(os.WriteFile(mockMemoryCurrent, []byte("850000000"), 0o640)).To(Succeed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	w := &Watcher{
+		memoryMax:                       uint64(1000000000),
+		memoryCurrentPath:               mockMemoryCurrent,
+		memoryUsagePercentThreshold:     95,
+		memoryUsagePercentSoftThreshold: 80,
+		interval:                        10 * time.Millisecond,
+		logger:                          logr.Discard(),
+		ctx:                             ctx,
+		cancel:                          cancel,
+	}
+	tiers := make(chan Tier, 10)
+	w.OnTierChange(func(tier Tier) {
+		tiers <- tier
+	})
+	g.Expect(w.ReadyzCheck(nil)).To(Succeed())
+
+	innerCtx, innerCancel := context.WithCancel(context.Background())
+	t.Cleanup(innerCancel)
+	go w.watchForNearOOM(innerCtx)
+
+	g.Eventually(tiers).Should(Receive(Equal(TierSoft)))
+	g.Expect(w.Tier()).To(Equal(TierSoft))
+	g.Expect(w.ReadyzCheck(nil)).To(MatchError(ErrMemoryPressure))
+	g.Expect(ctx.Err()).ToNot(HaveOccurred())
+
+	// The tier does not change until the usage drops below the soft
+	// threshold minus the hysteresis.
+	g.Expect(os.WriteFile(mockMemoryCurrent, []byte("770000000"), 0o640)).To(Succeed())
+	g.Consistently(tiers, 100*time.Millisecond).ShouldNot(Receive())
+	g.Expect(w.Tier()).To(Equal(TierSoft))
+
+	g.Expect(os.WriteFile(mockMemoryCurrent, []byte("740000000"), 0o640)).To(Succeed())
+	g.Eventually(tiers).Should(Receive(Equal(TierNormal)))
+	g.Expect(w.ReadyzCheck(nil)).To(Succeed())
+
+	g.Expect(os.WriteFile(mockMemoryCurrent, []byte("950000000"), 0o640)).To(Succeed())
+	g.Eventually(tiers).Should(Receive(Equal(TierHard)))
+	g.Eventually(ctx.Done()).Should(BeClosed())
+}
+
 func Test_discoverCgroupPaths(t *testing.T) {
 	t.Run("discovers memory max path", func(t *testing.T) {
 		paths := []string{
@@ -0,0 +1,209 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postrender
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	helmpostrender "helm.sh/helm/v3/pkg/postrender"
+)
+
+const (
+	// StageRender is the stage of the manifest rendered from the chart
+	// templates.
+	StageRender = "render"
+	// StagePostRender is the stage of the manifest after post-rendering.
+	StagePostRender = "post-render"
+)
+
+// ManifestLimits are the limits of a manifest.
+type ManifestLimits struct {
+	// MaxSize is the maximum size in bytes of the manifest. A value of 0
+	// disables the limit.
+	MaxSize int64
+	// MaxObjects is the maximum number of objects in the manifest. A value
+	// of 0 disables the limit.
+	MaxObjects int
+}
+
+// ParseManifestLimits parses a string in the format
+// '<maxSize>[:<maxObjects>]' into ManifestLimits.
+func ParseManifestLimits(s string) (ManifestLimits, error) {
+	size, objects, hasObjects := strings.Cut(s, ":")
+	var (
+		l   ManifestLimits
+		err error
+	)
+	if l.MaxSize, err = strconv.ParseInt(size, 10, 64); err != nil || l.MaxSize < 0 {
+		return ManifestLimits{}, fmt.Errorf("invalid maximum size '%s': must be a non-negative integer", size)
+	}
+	if hasObjects {
+		if l.MaxObjects, err = strconv.Atoi(objects); err != nil || l.MaxObjects < 0 {
+			return ManifestLimits{}, fmt.Errorf("invalid maximum objects '%s': must be a non-negative integer", objects)
+		}
+	}
+	return l, nil
+}
+
+// ParseNamespaceManifestLimits parses a list of strings in the format
+// '<namespace>=<maxSize>[:<maxObjects>]' into ManifestLimits per namespace.
+func ParseNamespaceManifestLimits(values []string) (map[string]ManifestLimits, error) {
+	limits := make(map[string]ManifestLimits, len(values))
+	for _, v := range values {
+		namespace, l, ok := strings.Cut(v, "=")
+		if !ok || namespace == "" {
+			return nil, fmt.Errorf("invalid manifest limits '%s': must be in the format '<namespace>=<maxSize>[:<maxObjects>]'", v)
+		}
+		parsed, err := ParseManifestLimits(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest limits for namespace '%s': %w", namespace, err)
+		}
+		limits[namespace] = parsed
+	}
+	return limits, nil
+}
+
+// ManifestTooLargeError is returned by Limited when a manifest exceeds the
+// ManifestLimits.
+type ManifestTooLargeError struct {
+	// Stage is the stage at which the limits were exceeded.
+	Stage string
+	// Size is the size in bytes of the manifest.
+	Size int64
+	// Objects is the number of objects in the manifest.
+	Objects int
+	// Limits are the exceeded limits.
+	Limits ManifestLimits
+}
+
+// Error returns the error message.
+func (e *ManifestTooLargeError) Error() string {
+	if e.Limits.MaxSize > 0 && e.Size > e.Limits.MaxSize {
+		return fmt.Sprintf("%s manifest size of %d bytes exceeds the limit of %d bytes", e.Stage, e.Size, e.Limits.MaxSize)
+	}
+	return fmt.Sprintf("%s manifest with %d objects exceeds the limit of %d objects", e.Stage, e.Objects, e.Limits.MaxObjects)
+}
+
+// ObserveFunc is called with the size in bytes and number of objects of the
+// manifest at a stage, and the duration of the stage. The duration is zero
+// if Limited.Start was not called.
+type ObserveFunc func(stage string, size int64, objects int, duration time.Duration)
+
+// Limited is a Helm PostRenderer which enforces ManifestLimits on the
+// manifest before and after running the wrapped PostRenderer. This allows
+// failing a release with an excessive manifest, before it is held in memory
+// multiple times while being applied and stored.
+type Limited struct {
+	next    helmpostrender.PostRenderer
+	limits  ManifestLimits
+	observe ObserveFunc
+	// last is the time the previous stage ended, or the time rendering
+	// started for the first stage.
+	last time.Time
+}
+
+// SetLimits sets the limits enforced by the Limited.
+func (l *Limited) SetLimits(limits ManifestLimits) {
+	l.limits = limits
+}
+
+// Start marks the start of rendering the manifest, from which the duration
+// of the render stage is observed. It should be called right before the Helm
+// action is run.
+func (l *Limited) Start() {
+	l.last = time.Now()
+}
+
+// NewLimited returns a new Limited which runs the given PostRenderer, which
+// may be nil. The ObserveFunc, if not nil, is called for every stage.
+func NewLimited(next helmpostrender.PostRenderer, limits ManifestLimits, observe ObserveFunc) *Limited {
+	return &Limited{next: next, limits: limits, observe: observe}
+}
+
+// Run checks the rendered manifests against the limits, runs the wrapped
+// PostRenderer, and checks the result against the limits.
+func (l *Limited) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if err := l.check(StageRender, renderedManifests); err != nil {
+		return nil, err
+	}
+	if l.next == nil {
+		return renderedManifests, nil
+	}
+
+	modifiedManifests, err := l.next.Run(renderedManifests)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.check(StagePostRender, modifiedManifests); err != nil {
+		return nil, err
+	}
+	return modifiedManifests, nil
+}
+
+// check observes the size and number of objects of the manifest, and
+// returns a ManifestTooLargeError if they exceed the limits.
+func (l *Limited) check(stage string, manifest *bytes.Buffer) error {
+	size := int64(manifest.Len())
+	objects := CountObjects(manifest.Bytes())
+	if l.observe != nil {
+		var duration time.Duration
+		if !l.last.IsZero() {
+			now := time.Now()
+			duration, l.last = now.Sub(l.last), now
+		}
+		l.observe(stage, size, objects, duration)
+	}
+
+	if (l.limits.MaxSize > 0 && size > l.limits.MaxSize) ||
+		(l.limits.MaxObjects > 0 && objects > l.limits.MaxObjects) {
+		return &ManifestTooLargeError{Stage: stage, Size: size, Objects: objects, Limits: l.limits}
+	}
+	return nil
+}
+
+// CountObjects returns the number of YAML documents in the manifest which
+// contain more than comments and whitespace, without decoding them.
+func CountObjects(manifest []byte) int {
+	var (
+		objects int
+		content bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(manifest)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "---" || strings.HasPrefix(line, "--- ") {
+			if content {
+				objects++
+			}
+			content = false
+			continue
+		}
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			content = true
+		}
+	}
+	if content {
+		objects++
+	}
+	return objects
+}
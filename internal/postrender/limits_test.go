@@ -0,0 +1,204 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postrender
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	helmpostrender "helm.sh/helm/v3/pkg/postrender"
+)
+
+func TestLimited_Run(t *testing.T) {
+	tests := []struct {
+		name        string
+		next        helmpostrender.PostRenderer
+		limits      ManifestLimits
+		wantStage   string
+		wantErr     bool
+		wantObjects map[string]int
+	}{
+		{
+			name:        "no limits",
+			wantObjects: map[string]int{StageRender: 2},
+		},
+		{
+			name:        "within limits",
+			next:        NewOriginLabels("helm.toolkit.fluxcd.io", "namespace", "name"),
+			limits:      ManifestLimits{MaxSize: 1 << 20, MaxObjects: 2},
+			wantObjects: map[string]int{StageRender: 2, StagePostRender: 2},
+		},
+		{
+			name:        "exceeds size",
+			limits:      ManifestLimits{MaxSize: 10},
+			wantErr:     true,
+			wantStage:   StageRender,
+			wantObjects: map[string]int{StageRender: 2},
+		},
+		{
+			name:        "exceeds objects",
+			limits:      ManifestLimits{MaxObjects: 1},
+			wantErr:     true,
+			wantStage:   StageRender,
+			wantObjects: map[string]int{StageRender: 2},
+		},
+		{
+			name:        "exceeds size after post-render",
+			next:        NewOriginLabels("helm.toolkit.fluxcd.io", "namespace", "name"),
+			limits:      ManifestLimits{MaxSize: int64(len(mixedResourceMock)) + 1},
+			wantErr:     true,
+			wantStage:   StagePostRender,
+			wantObjects: map[string]int{StageRender: 2, StagePostRender: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			observed := map[string]int{}
+			l := NewLimited(tt.next, tt.limits, func(stage string, _ int64, objects int, _ time.Duration) {
+				observed[stage] = objects
+			})
+
+			got, err := l.Run(bytes.NewBufferString(mixedResourceMock))
+			g.Expect(observed).To(Equal(tt.wantObjects))
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(got).To(BeNil())
+
+				var tooLargeErr *ManifestTooLargeError
+				g.Expect(errors.As(err, &tooLargeErr)).To(BeTrue())
+				g.Expect(tooLargeErr.Stage).To(Equal(tt.wantStage))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).ToNot(BeNil())
+		})
+	}
+}
+
+func TestLimited_Start(t *testing.T) {
+	g := NewWithT(t)
+
+	durations := map[string]time.Duration{}
+	l := NewLimited(NewOriginLabels("helm.toolkit.fluxcd.io", "namespace", "name"), ManifestLimits{},
+		func(stage string, _ int64, _ int, duration time.Duration) {
+			durations[stage] = duration
+		})
+
+	// Without a start, the durations are not observed.
+	_, err := l.Run(bytes.NewBufferString(mixedResourceMock))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(durations).To(HaveKeyWithValue(StageRender, time.Duration(0)))
+	g.Expect(durations).To(HaveKeyWithValue(StagePostRender, time.Duration(0)))
+
+	l.Start()
+	time.Sleep(10 * time.Millisecond)
+	_, err = l.Run(bytes.NewBufferString(mixedResourceMock))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(durations[StageRender]).To(BeNumerically(">=", 10*time.Millisecond))
+	g.Expect(durations[StagePostRender]).To(BeNumerically(">", 0))
+}
+
+func TestCountObjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     int
+	}{
+		{name: "empty", manifest: "", want: 0},
+		{name: "single", manifest: "kind: ConfigMap\n", want: 1},
+		{name: "multiple", manifest: mixedResourceMock, want: 2},
+		{
+			name:     "leading separator and source comments",
+			manifest: "---\n# Source: chart/templates/a.yaml\nkind: A\n---\n# Source: chart/templates/b.yaml\nkind: B\n",
+			want:     2,
+		},
+		{
+			name:     "empty documents",
+			manifest: "---\n---\n# Source: chart/templates/empty.yaml\n\n---\nkind: A\n---\n",
+			want:     1,
+		},
+		{name: "separator with comment", manifest: "kind: A\n--- # comment\nkind: B", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(CountObjects([]byte(tt.manifest))).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestManifestTooLargeError_Error(t *testing.T) {
+	g := NewWithT(t)
+
+	err := &ManifestTooLargeError{Stage: StageRender, Size: 20, Objects: 1, Limits: ManifestLimits{MaxSize: 10}}
+	g.Expect(err.Error()).To(Equal("render manifest size of 20 bytes exceeds the limit of 10 bytes"))
+
+	err = &ManifestTooLargeError{Stage: StagePostRender, Size: 5, Objects: 3, Limits: ManifestLimits{MaxObjects: 2}}
+	g.Expect(err.Error()).To(Equal("post-render manifest with 3 objects exceeds the limit of 2 objects"))
+}
+
+func TestParseManifestLimits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ManifestLimits
+		wantErr bool
+	}{
+		{in: "0", want: ManifestLimits{}},
+		{in: "1048576", want: ManifestLimits{MaxSize: 1 << 20}},
+		{in: "0:100", want: ManifestLimits{MaxObjects: 100}},
+		{in: "", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "1:-1", wantErr: true},
+		{in: "1:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := ParseManifestLimits(tt.in)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestParseNamespaceManifestLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	got, err := ParseNamespaceManifestLimits([]string{"team-a=1024", "team-b=0:10"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(map[string]ManifestLimits{
+		"team-a": {MaxSize: 1024},
+		"team-b": {MaxObjects: 10},
+	}))
+
+	_, err = ParseNamespaceManifestLimits([]string{"team-a"})
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseNamespaceManifestLimits([]string{"=2"})
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseNamespaceManifestLimits([]string{"team-a=x"})
+	g.Expect(err).To(HaveOccurred())
+}
@@ -18,6 +18,8 @@ package reconcile
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
@@ -26,6 +28,8 @@ import (
 	"helm.sh/helm/v3/pkg/kube"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
@@ -40,7 +44,10 @@ import (
 	"github.com/fluxcd/helm-controller/internal/diff"
 	"github.com/fluxcd/helm-controller/internal/digest"
 	interrors "github.com/fluxcd/helm-controller/internal/errors"
+	"github.com/fluxcd/helm-controller/internal/lease"
+	"github.com/fluxcd/helm-controller/internal/metrics"
 	"github.com/fluxcd/helm-controller/internal/postrender"
+	"github.com/fluxcd/helm-controller/internal/tracing"
 )
 
 // OwnedConditions is a list of Condition types owned by the HelmRelease object.
@@ -48,6 +55,7 @@ var OwnedConditions = []string{
 	v2.ReleasedCondition,
 	v2.RemediatedCondition,
 	v2.TestSuccessCondition,
+	meta.HealthyCondition,
 	meta.ReconcilingCondition,
 	meta.ReadyCondition,
 	meta.StalledCondition,
@@ -69,9 +77,21 @@ var (
 	// and cannot be acted upon.
 	ErrUnknownReleaseStatus = errors.New("unknown release status")
 
+	// ErrReleaseLocked is returned when the release is locked by another
+	// holder, or is in a pending state which may still be operated on.
+	ErrReleaseLocked = errors.New("release locked")
+
 	// ErrUnknownRemediationStrategy is returned when the remediation strategy
 	// is unknown.
 	ErrUnknownRemediationStrategy = errors.New("unknown remediation strategy")
+
+	// ErrInsufficientPermissions is returned when the client lacks
+	// permissions required to install or upgrade the release.
+	ErrInsufficientPermissions = errors.New("insufficient permissions")
+
+	// ErrManifestTooLarge is returned when the manifest of the release
+	// exceeds the manifest limits.
+	ErrManifestTooLarge = errors.New("manifest too large")
 )
 
 // AtomicRelease is an ActionReconciler which implements an atomic release
@@ -109,22 +129,114 @@ var (
 // documentation.
 type AtomicRelease struct {
 	patchHelper   *patch.SerialPatcher
+	patchFunc     PatchFunc
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
 	strategy      releaseStrategy
 	fieldManager  string
+	locker        *lease.Locker
+
+	// permissionCheck enables the check of the permissions required by an
+	// install or upgrade before running the action.
+	permissionCheck bool
+	// manifestLimits are the limits enforced on the manifest of the release
+	// by an install or upgrade.
+	manifestLimits postrender.ManifestLimits
+	// maxValuesDeltaChanges is the maximum number of changes of the values
+	// recorded in the snapshot of an upgraded release.
+	maxValuesDeltaChanges int
+
+	// testLogs configures the collection of the logs of test hook Pods.
+	testLogs action.TestLogsOptions
+
+	// lock is the release lock held while running actions, acquired before
+	// running the first action.
+	lock *lease.Lock
+}
+
+// PatchFunc persists the intermediate observation of the given object.
+type PatchFunc func(ctx context.Context, obj *v2.HelmRelease) error
+
+// AtomicReleaseOption configures an AtomicRelease.
+type AtomicReleaseOption func(*AtomicRelease)
+
+// WithLocker configures the AtomicRelease to hold a Lease for the release
+// using the given lease.Locker while running actions. A release with a
+// pending state is then only unlocked if the Lease of the previous holder
+// expired, or if the release has been pending for longer than the timeout
+// of the pending action.
+func WithLocker(locker *lease.Locker) AtomicReleaseOption {
+	return func(r *AtomicRelease) {
+		r.locker = locker
+	}
+}
+
+// WithPermissionCheck configures the AtomicRelease to confirm the client
+// has the permissions required by the release using SelfSubjectAccessReviews,
+// while running an install or upgrade. The check is run on the manifest
+// rendered by the action, before anything is modified. When permissions are
+// missing, or cannot be confirmed, ErrInsufficientPermissions is returned
+// without counting towards the failures of the release.
+func WithPermissionCheck(enabled bool) AtomicReleaseOption {
+	return func(r *AtomicRelease) {
+		r.permissionCheck = enabled
+	}
+}
+
+// WithManifestLimits configures the AtomicRelease to enforce the given
+// limits on the manifest of the release while running an install or
+// upgrade. When the limits are exceeded, ErrManifestTooLarge is returned.
+func WithManifestLimits(limits postrender.ManifestLimits) AtomicReleaseOption {
+	return func(r *AtomicRelease) {
+		r.manifestLimits = limits
+	}
+}
+
+// WithValuesDelta configures the AtomicRelease to record at most maxChanges
+// changes of the values in the snapshot of a release which superseded
+// another release. A value of 0 disables recording the values delta.
+func WithValuesDelta(maxChanges int) AtomicReleaseOption {
+	return func(r *AtomicRelease) {
+		r.maxValuesDeltaChanges = maxChanges
+	}
+}
+
+// WithTestLogs configures the AtomicRelease to collect the logs of test hook
+// Pods while running Helm tests, and to store them according to the given
+// options.
+func WithTestLogs(opts action.TestLogsOptions) AtomicReleaseOption {
+	return func(r *AtomicRelease) {
+		r.testLogs = opts
+	}
+}
+
+// WithPatchFunc configures the AtomicRelease to persist intermediate
+// observations using the given PatchFunc instead of the patch helper. This
+// allows the caller to persist observations of an object which is not the
+// object patched by the patch helper, e.g. a copy of it for the release to a
+// single cluster.
+func WithPatchFunc(fn PatchFunc) AtomicReleaseOption {
+	return func(r *AtomicRelease) {
+		r.patchFunc = fn
+	}
 }
 
 // NewAtomicRelease returns a new AtomicRelease reconciler configured with the
-// provided values.
-func NewAtomicRelease(patchHelper *patch.SerialPatcher, cfg *action.ConfigFactory, recorder record.EventRecorder, fieldManager string) *AtomicRelease {
-	return &AtomicRelease{
+// provided values. The patch helper may be nil, in which case intermediate
+// observations are not persisted, and the caller is solely responsible for
+// persisting the Request.Object result.
+func NewAtomicRelease(patchHelper *patch.SerialPatcher, cfg *action.ConfigFactory, recorder record.EventRecorder, fieldManager string, opts ...AtomicReleaseOption) *AtomicRelease {
+	r := &AtomicRelease{
 		patchHelper:   patchHelper,
 		eventRecorder: recorder,
 		configFactory: cfg,
 		strategy:      &cleanReleaseStrategy{},
 		fieldManager:  fieldManager,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // releaseStrategy defines the continue-stop behavior of the reconcile loop.
@@ -158,9 +270,34 @@ func (cleanReleaseStrategy) MustStop(current ReconcilerType, _ ReconcilerTypeSet
 	}
 }
 
-func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) error {
+func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) (retErr error) {
+	ctx, span := tracing.Start(ctx, "atomic release", tracing.AttrChartName.String(req.Chart.Name()),
+		tracing.AttrChartVersion.String(req.Chart.Metadata.Version))
+	defer func() {
+		// Requeueing to continue is not a failure of the release.
+		if errors.Is(retErr, ErrMustRequeue) {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, retErr)
+	}()
+
 	log := ctrl.LoggerFrom(ctx).V(logger.InfoLevel)
 
+	// Release the release lock once done, if it was acquired to run
+	// actions.
+	defer func() {
+		if r.lock == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.lock.Release(ctx); err != nil {
+			log.Error(err, "failed to release Helm release lock")
+		}
+		r.lock = nil
+	}()
+
 	var (
 		previous ReconcilerTypeSet
 		next     ActionReconciler
@@ -174,7 +311,7 @@ func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) error {
 				// last observation before returning. If the patch fails, we
 				// log the error and return the original context cancellation
 				// error.
-				if err := r.patchHelper.Patch(ctx, req.Object, patch.WithOwnedConditions{Conditions: OwnedConditions}, patch.WithFieldOwner(r.fieldManager)); err != nil {
+				if err := r.patch(ctx, req.Object); err != nil {
 					log.Error(err, "failed to patch HelmRelease after context cancellation")
 				}
 				cancel()
@@ -183,7 +320,10 @@ func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) error {
 		default:
 			// Determine the current state of the Helm release.
 			log.V(logger.DebugLevel).Info("determining current state of Helm release")
-			state, err := DetermineReleaseState(ctx, r.configFactory, req)
+			stateCtx, stateSpan := tracing.Start(ctx, "determine release state")
+			state, err := DetermineReleaseState(stateCtx, r.configFactory, req)
+			stateSpan.SetAttributes(tracing.AttrReleaseStatus.String(state.Status.String()))
+			tracing.End(stateSpan, err)
 			if err != nil {
 				conditions.MarkFalse(req.Object, meta.ReadyCondition, "StateError", fmt.Sprintf("Could not determine release state: %s", err.Error()))
 				return fmt.Errorf("cannot determine release state: %w", err)
@@ -239,6 +379,22 @@ func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) error {
 				return nil
 			}
 
+			// Hold the release lock while running actions, if configured.
+			if err = r.acquireLock(ctx, req, next); err != nil {
+				return err
+			}
+
+			// Confirm the client is allowed to perform the release, before
+			// the action modifies anything which would otherwise fail halfway.
+			switch a := next.(type) {
+			case *Install:
+				a.permissionCheck, a.manifestLimits = r.permissionCheck, r.manifestLimits
+				a.maxValuesDeltaChanges = r.maxValuesDeltaChanges
+			case *Upgrade:
+				a.permissionCheck, a.manifestLimits = r.permissionCheck, r.manifestLimits
+				a.maxValuesDeltaChanges = r.maxValuesDeltaChanges
+			}
+
 			// Mark the release as reconciling before we attempt to run the action.
 			// This to show continuous progress, as Helm actions can be long-running.
 			reconcilingMsg := fmt.Sprintf("Running '%s' action with timeout of %s",
@@ -254,13 +410,32 @@ func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) error {
 			}
 
 			// Patch the object to reflect the new condition.
-			if err = r.patchHelper.Patch(ctx, req.Object, patch.WithOwnedConditions{Conditions: OwnedConditions}, patch.WithFieldOwner(r.fieldManager)); err != nil {
+			if err = r.patch(ctx, req.Object); err != nil {
 				return err
 			}
 
 			// Run the action sub-reconciler.
 			log.Info(fmt.Sprintf("running '%s' action with timeout of %s", next.Name(), timeoutForAction(next, req.Object).String()))
-			if err = next.Reconcile(ctx, req); err != nil {
+			failures, start := req.Object.Status.Failures, time.Now()
+			actionCtx, actionSpan := tracing.Start(ctx, "helm "+next.Name(),
+				tracing.AttrAction.String(next.Name()), tracing.AttrActionType.String(string(next.Type())))
+			cancelAction := func() {}
+			if r.lock != nil {
+				// Stop the action when the lock is lost, as another holder
+				// may start operating on the release.
+				actionCtx, cancelAction = r.lock.Context(actionCtx)
+			}
+			err = next.Reconcile(actionCtx, req)
+			cancelAction()
+			tracing.End(actionSpan, err)
+			recordActionMetrics(req.Object, next, time.Since(start), err == nil && failures == req.Object.Status.Failures)
+			if lockErr := r.checkLock(req, next); lockErr != nil {
+				return lockErr
+			}
+			if err != nil {
+				if preventedErr := r.actionPrevented(req, next, err); preventedErr != nil {
+					return preventedErr
+				}
 				if conditions.IsReady(req.Object) {
 					conditions.MarkFalse(req.Object, meta.ReadyCondition, "ReconcileError", err.Error())
 				}
@@ -294,13 +469,116 @@ func (r *AtomicRelease) Reconcile(ctx context.Context, req *Request) error {
 			previous = append(previous, next.Type())
 
 			// Patch the release to reflect progress.
-			if err = r.patchHelper.Patch(ctx, req.Object, patch.WithOwnedConditions{Conditions: OwnedConditions}, patch.WithFieldOwner(r.fieldManager)); err != nil {
+			if err = r.patch(ctx, req.Object); err != nil {
 				return err
 			}
 		}
 	}
 }
 
+// recordActionMetrics records the duration and outcome of the action run by
+// the given ActionReconciler. Remediation actions are in addition counted as
+// remediations of the object.
+func recordActionMetrics(obj *v2.HelmRelease, next ActionReconciler, duration time.Duration, success bool) {
+	if d, ok := next.(*CorrectClusterDrift); ok && d.err != nil {
+		// Failures to correct drift do not count towards the failures of
+		// the object, but are still failures of the action.
+		success = false
+	}
+	metrics.RecordReleaseAction(obj.GetNamespace(), obj.GetName(), next.Name(), duration, success)
+	if next.Type() == ReconcilerTypeRemediate {
+		metrics.RecordRemediation(obj.GetNamespace(), obj.GetName(), next.Name())
+	}
+}
+
+// acquireLock acquires the release lock before running the next action, if
+// a locker is configured and the lock is not held yet. If the next action is
+// an Unlock, it is informed whether the lock was taken over from a stale
+// holder.
+func (r *AtomicRelease) acquireLock(ctx context.Context, req *Request, next ActionReconciler) error {
+	if r.locker == nil {
+		return nil
+	}
+	if r.lock != nil {
+		if err := r.checkLock(req, next); err != nil {
+			return err
+		}
+	}
+	if r.lock == nil {
+		lock, err := r.locker.Acquire(ctx, releaseLockKey(req.Object, r.locker.Namespace()))
+		if err != nil {
+			var lockedErr *lease.LockedError
+			if errors.As(err, &lockedErr) {
+				msg := fmt.Sprintf(fmtReleaseLocked, req.Object.GetReleaseNamespace(), req.Object.GetReleaseName(), lockedErr.Error())
+				conditions.MarkFalse(req.Object, meta.ReadyCondition, v2.LockedReason, msg)
+				r.eventRecorder.Event(req.Object, corev1.EventTypeWarning, v2.LockedReason, msg)
+				return fmt.Errorf("%w: %s", ErrReleaseLocked, lockedErr.Error())
+			}
+			return err
+		}
+		r.lock = lock
+	}
+	if unlock, ok := next.(*Unlock); ok {
+		unlock.staleLock = r.lock.Stale()
+		unlock.lockHolder = r.lock.Holder()
+	}
+	return nil
+}
+
+// actionPrevented returns an error wrapping ErrManifestTooLarge or
+// ErrInsufficientPermissions if the given error of the action signals it was
+// prevented from running, and marks the object as not ready and emits an
+// event with the corresponding reason. A manifest exceeding the limits is not
+// accepted on a retry until the chart or values change, and missing
+// permissions, or permissions which could not be confirmed, are reported
+// before the action modifies anything. It returns nil for any other error.
+func (r *AtomicRelease) actionPrevented(req *Request, next ActionReconciler, err error) error {
+	var (
+		tooLargeErr *postrender.ManifestTooLargeError
+		permErr     *action.InsufficientPermissionsError
+		reason      string
+		sentinel    error
+	)
+	switch {
+	case errors.As(err, &tooLargeErr):
+		reason, sentinel, err = v2.ManifestTooLargeReason, ErrManifestTooLarge, tooLargeErr
+	case errors.As(err, &permErr):
+		reason, sentinel, err = v2.InsufficientPermissionsReason, ErrInsufficientPermissions, permErr
+	default:
+		return nil
+	}
+	msg := fmt.Sprintf(fmtActionPrevented, next.Name(), req.Object.GetReleaseNamespace(), req.Object.GetReleaseName(), err.Error())
+	conditions.MarkFalse(req.Object, meta.ReadyCondition, reason, msg)
+	r.eventRecorder.Event(req.Object, corev1.EventTypeWarning, reason, msg)
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// checkLock returns an error wrapping ErrReleaseLocked and lease.ErrLost if
+// the release lock was lost while it was held, and marks the object as not
+// ready. It is a no-op if no lock is held.
+func (r *AtomicRelease) checkLock(req *Request, next ActionReconciler) error {
+	if r.lock == nil || !r.lock.Lost() {
+		return nil
+	}
+	msg := fmt.Sprintf(fmtReleaseLockLost, req.Object.GetReleaseNamespace(), req.Object.GetReleaseName(), next.Name())
+	conditions.MarkFalse(req.Object, meta.ReadyCondition, v2.LockedReason, msg)
+	r.eventRecorder.Event(req.Object, corev1.EventTypeWarning, v2.LockedReason, msg)
+	return fmt.Errorf("%w: %w", ErrReleaseLocked, lease.ErrLost)
+}
+
+// patch persists the current observation of the object using the patch
+// helper, or the PatchFunc if configured. It is a no-op if neither is
+// configured.
+func (r *AtomicRelease) patch(ctx context.Context, obj *v2.HelmRelease) error {
+	if r.patchFunc != nil {
+		return r.patchFunc(ctx, obj)
+	}
+	if r.patchHelper == nil {
+		return nil
+	}
+	return r.patchHelper.Patch(ctx, obj, patch.WithOwnedConditions{Conditions: OwnedConditions}, patch.WithFieldOwner(r.fieldManager))
+}
+
 // actionForState determines the next action to run based on the current state.
 func (r *AtomicRelease) actionForState(ctx context.Context, req *Request, state ReleaseState) (ActionReconciler, error) {
 	log := ctrl.LoggerFrom(ctx)
@@ -326,6 +604,15 @@ func (r *AtomicRelease) actionForState(ctx context.Context, req *Request, state
 		}
 		req.Object.Status.History.Truncate(ignoreFailures)
 
+		// Mark the objects of the release as healthy, as they would otherwise
+		// have resulted in ReleaseStatusUnhealthy.
+		if req.Object.MustMonitorHealth() {
+			cur := req.Object.Status.History.Latest()
+			cur.Unhealthy = false
+			conditions.MarkTrue(req.Object, meta.HealthyCondition, meta.SucceededReason, fmtReleaseHealthy,
+				cur.FullReleaseName(), cur.VersionedChartName())
+		}
+
 		if forceRequested {
 			log.Info(msgWithReason("forcing upgrade for in-sync release", "force requested through annotation"))
 			return NewUpgrade(r.configFactory, r.eventRecorder), nil
@@ -381,6 +668,7 @@ func (r *AtomicRelease) actionForState(ctx context.Context, req *Request, state
 
 		return NewUpgrade(r.configFactory, r.eventRecorder), nil
 	case ReleaseStatusDrifted:
+		metrics.RecordDriftDetection(req.Object.GetNamespace(), req.Object.GetName())
 		log.Info(msgWithReason("detected changes in cluster state", diff.SummarizeDiffSetBrief(state.Diff)))
 		for _, change := range state.Diff {
 			switch change.Type {
@@ -403,6 +691,20 @@ func (r *AtomicRelease) actionForState(ctx context.Context, req *Request, state
 		}
 
 		return nil, nil
+	case ReleaseStatusUnhealthy:
+		log.Info(msgWithReason("release objects are unhealthy", state.Reason))
+
+		// Record the health on the snapshot, to prevent it from being
+		// rolled back to when remediating a later unhealthy release.
+		cur := req.Object.Status.History.Latest()
+		cur.Unhealthy = true
+		msg := fmt.Sprintf(fmtReleaseUnhealthy, cur.FullReleaseName(), cur.VersionedChartName(), state.Reason)
+		conditions.MarkFalse(req.Object, meta.HealthyCondition, meta.HealthCheckFailedReason, msg)
+		r.eventRecorder.AnnotatedEventf(req.Object, eventMeta(cur.ChartVersion, cur.ConfigDigest,
+			addAppVersion(cur.AppVersion), addOCIDigest(cur.OCIDigest)), corev1.EventTypeWarning,
+			meta.HealthCheckFailedReason, msg)
+
+		return r.remediationForUnhealthy(ctx, req, cur)
 	case ReleaseStatusUntested:
 		log.Info(msgWithReason("release has not been tested", state.Reason))
 
@@ -420,7 +722,15 @@ func (r *AtomicRelease) actionForState(ctx context.Context, req *Request, state
 			replaceCondition(req.Object, v2.RemediatedCondition, v2.ReleasedCondition, v2.UpgradeSucceededReason, msg, metav1.ConditionTrue)
 		}
 
-		return NewTest(r.configFactory, r.eventRecorder), nil
+		test := NewTest(r.configFactory, r.eventRecorder)
+		test.testLogs = r.testLogs
+		return test, nil
+	case ReleaseStatusTestDue:
+		log.Info(msgWithReason("test interval has elapsed", state.Reason))
+
+		test := NewTest(r.configFactory, r.eventRecorder)
+		test.testLogs = r.testLogs
+		return test, nil
 	case ReleaseStatusFailed:
 		log.Info(msgWithReason("release is in a failed state", state.Reason))
 
@@ -534,6 +844,116 @@ func timeoutForAction(action ActionReconciler, obj *v2.HelmRelease) time.Duratio
 	}
 }
 
+// selfSubjectAccessReviews returns the client used to check the permissions
+// of the Helm actions run with the given ConfigFactory.
+func selfSubjectAccessReviews(cfg *action.ConfigFactory) (authorizationv1client.SelfSubjectAccessReviewInterface, error) {
+	clientSet, err := cfg.KubeClient.Factory.KubernetesClientSet()
+	if err != nil {
+		return nil, err
+	}
+	return clientSet.AuthorizationV1().SelfSubjectAccessReviews(), nil
+}
+
+// fmtActionPrevented is the message format for an action which was prevented
+// from running, due to e.g. a manifest exceeding the limits or missing
+// permissions.
+const fmtActionPrevented = "Cannot %s Helm release %s/%s: %s"
+
+// remediationForUnhealthy returns a RollbackRemediation for a release of which
+// the objects are unhealthy, when configured and the release was deployed
+// within the grace period of the health monitoring. The release is rolled
+// back to the most recent previous release which was not observed to be
+// unhealthy. The unhealthy release is counted as an upgrade failure. When
+// the release must not or cannot be remediated, it returns nil.
+func (r *AtomicRelease) remediationForUnhealthy(ctx context.Context, req *Request, cur *v2.Snapshot) (ActionReconciler, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	monitoring := req.Object.GetHealthMonitoring()
+	if !monitoring.Remediate {
+		return nil, nil
+	}
+
+	// A release which is the result of a remediation must not be remediated
+	// again, as this would roll back to the release which was remediated.
+	if conditions.Has(req.Object, v2.RemediatedCondition) {
+		log.Info(msgWithReason("not remediating unhealthy release", "release is the result of a remediation"))
+		return nil, nil
+	}
+
+	if deployed := cur.LastDeployed.Time; time.Since(deployed) > monitoring.GetGracePeriod() {
+		log.Info(msgWithReason("not remediating unhealthy release",
+			fmt.Sprintf("deployed at %s, outside of grace period", deployed.Format(time.RFC3339))))
+		return nil, nil
+	}
+
+	// Verify the previous release is still in storage and unmodified before
+	// instructing to roll back to it.
+	remediation := req.Object.GetUpgrade().GetRemediation()
+	prev := req.Object.Status.History.PreviousHealthy(remediation.MustIgnoreTestFailures(req.Object.GetTest().IgnoreFailures))
+	if prev == nil {
+		log.Info(msgWithReason("not remediating unhealthy release", "no previous healthy release to roll back to"))
+		return nil, nil
+	}
+	if _, err := action.VerifySnapshot(r.configFactory.Build(nil), prev); err != nil {
+		if interrors.IsOneOf(err, action.ErrReleaseNotFound, action.ErrReleaseDisappeared, action.ErrReleaseNotObserved, action.ErrReleaseDigest) {
+			log.Info(msgWithReason("unable to verify previous release in storage to roll back to", err.Error()))
+			return nil, nil
+		}
+
+		// This may be a temporary error, return it to retry.
+		return nil, fmt.Errorf("cannot verify previous release to roll back to: %w", err)
+	}
+
+	// Count the unhealthy release as an upgrade failure, to ensure the
+	// upgrade is not retried beyond the configured retries.
+	req.Object.Status.Failures++
+	remediation.IncrementFailureCount(req.Object)
+
+	rollback := NewRollbackRemediation(r.configFactory, r.eventRecorder)
+	rollback.toHealthy = true
+	return rollback, nil
+}
+
+// fmtReleaseUnhealthy is the message format for a release of which the
+// objects are unhealthy.
+const fmtReleaseUnhealthy = "Health check failed for release %s with chart %s: %s"
+
+// fmtReleaseHealthy is the message format for a release of which the objects
+// are healthy.
+const fmtReleaseHealthy = "Health check passed for release %s with chart %s"
+
+// fmtReleaseLocked is the message format for a release locked by another
+// holder.
+const fmtReleaseLocked = "Helm release %s/%s is %s"
+
+// fmtReleaseLockLost is the message format for a release of which the lock
+// was lost while running an action.
+const fmtReleaseLockLost = "Lost the lock of Helm release %s/%s while running '%s' action"
+
+// releaseLockKey returns the key of the Lease used to lock the release of
+// the given object. The name is unique for the target cluster, storage
+// namespace and name of the release. As the name of a KubeConfig Secret is
+// only unique within the namespace of the object, the target cluster is
+// identified by both. The Lease is held in the given namespace, or in the
+// storage namespace of the release if empty.
+func releaseLockKey(obj *v2.HelmRelease, namespace string) types.NamespacedName {
+	var cluster string
+	if ref := obj.Spec.KubeConfig; ref != nil {
+		cluster = obj.GetNamespace() + "/" + ref.SecretRef.Name
+		if p := obj.Spec.KubeConfigProvider; p != nil {
+			cluster = p.Address
+		}
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{cluster, obj.GetStorageNamespace(), obj.GetReleaseName()}, "/")))
+	if namespace == "" {
+		namespace = obj.GetStorageNamespace()
+	}
+	return types.NamespacedName{
+		Namespace: namespace,
+		Name:      "helm-release-" + hex.EncodeToString(h[:])[:16],
+	}
+}
+
 // replaceCondition replaces existing target condition with replacement
 // condition, if present, for the given values, retaining the
 // LastTransitionTime.
@@ -18,20 +18,25 @@ package reconcile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	. "github.com/onsi/gomega"
 	extjsondiff "github.com/wI2L/jsondiff"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	helmchart "helm.sh/helm/v3/pkg/chart"
 	helmrelease "helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/releaseutil"
 	helmstorage "helm.sh/helm/v3/pkg/storage"
 	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -43,11 +48,14 @@ import (
 
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/chartutil"
 	"github.com/fluxcd/helm-controller/internal/digest"
 	"github.com/fluxcd/helm-controller/internal/kube"
+	"github.com/fluxcd/helm-controller/internal/lease"
 	"github.com/fluxcd/helm-controller/internal/postrender"
 	"github.com/fluxcd/helm-controller/internal/release"
 	"github.com/fluxcd/helm-controller/internal/testutil"
+	"github.com/fluxcd/helm-controller/internal/tracing"
 )
 
 func TestReleaseStrategy_CleanRelease_MustContinue(t *testing.T) {
@@ -210,6 +218,83 @@ func TestAtomicRelease_Reconcile(t *testing.T) {
 		g.Expect(err).ToNot(HaveOccurred())
 		g.Expect(endState).To(Equal(ReleaseState{Status: ReleaseStatusInSync}))
 	})
+
+	t.Run("records a span for the state and each action", func(t *testing.T) {
+		g := NewWithT(t)
+
+		exporter := tracetest.NewInMemoryExporter()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		tracing.Register(tp)
+		t.Cleanup(func() {
+			_ = tp.Shutdown(context.TODO())
+		})
+
+		namedNS, err := testEnv.CreateNamespace(context.TODO(), mockReleaseNamespace)
+		g.Expect(err).NotTo(HaveOccurred())
+		t.Cleanup(func() {
+			_ = testEnv.Delete(context.TODO(), namedNS)
+		})
+		releaseNamespace := namedNS.Name
+
+		obj := &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      mockReleaseName,
+				Namespace: releaseNamespace,
+			},
+			Spec: v2.HelmReleaseSpec{
+				ReleaseName:      mockReleaseName,
+				TargetNamespace:  releaseNamespace,
+				StorageNamespace: releaseNamespace,
+				Timeout:          &metav1.Duration{Duration: 100 * time.Millisecond},
+			},
+		}
+
+		getter, err := RESTClientGetterFromManager(testEnv.Manager, obj.GetReleaseNamespace())
+		g.Expect(err).ToNot(HaveOccurred())
+
+		cfg, err := action.NewConfigFactory(getter,
+			action.WithStorage(action.DefaultStorageDriver, obj.GetStorageNamespace()),
+		)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		client := fake.NewClientBuilder().
+			WithScheme(testEnv.Scheme()).
+			WithObjects(obj).
+			WithStatusSubresource(&v2.HelmRelease{}).
+			Build()
+		patchHelper := patch.NewSerialPatcher(obj, client)
+		recorder := new(record.FakeRecorder)
+
+		req := &Request{
+			Object: obj,
+			Chart:  testutil.BuildChart(),
+		}
+		g.Expect(NewAtomicRelease(patchHelper, cfg, recorder, testFieldManager).Reconcile(context.TODO(), req)).ToNot(HaveOccurred())
+
+		spans := exporter.GetSpans()
+		var names []string
+		for _, s := range spans {
+			names = append(names, s.Name)
+		}
+		g.Expect(names).To(Equal([]string{
+			"determine release state",
+			"helm install",
+			"determine release state",
+			"atomic release",
+		}))
+
+		root := spans[len(spans)-1]
+		g.Expect(root.Attributes).To(ContainElements(
+			tracing.AttrChartName.String(req.Chart.Name()),
+			tracing.AttrChartVersion.String(req.Chart.Metadata.Version),
+		))
+		for _, s := range spans[:len(spans)-1] {
+			g.Expect(s.Parent.SpanID()).To(Equal(root.SpanContext.SpanID()))
+		}
+		g.Expect(spans[0].Attributes).To(ContainElement(tracing.AttrReleaseStatus.String(ReleaseStatusAbsent.String())))
+		g.Expect(spans[1].Attributes).To(ContainElement(tracing.AttrAction.String("install")))
+		g.Expect(spans[2].Attributes).To(ContainElement(tracing.AttrReleaseStatus.String(ReleaseStatusInSync.String())))
+	})
 }
 
 func TestAtomicRelease_Reconcile_Scenarios(t *testing.T) {
@@ -1504,6 +1589,283 @@ func TestAtomicRelease_actionForState(t *testing.T) {
 				),
 			},
 		},
+		{
+			name:  "in-sync release with health monitoring marks release healthy",
+			state: ReleaseState{Status: ReleaseStatusInSync},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.HealthChecks = []v2.HealthCheck{{}}
+				spec.HealthMonitoring = &v2.HealthMonitoring{Enable: true}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						{
+							Name:         mockReleaseName,
+							Namespace:    mockReleaseNamespace,
+							Version:      1,
+							ChartName:    "podinfo",
+							ChartVersion: "1.0.0",
+						},
+					},
+					Conditions: []metav1.Condition{
+						*conditions.FalseCondition(meta.HealthyCondition, meta.HealthCheckFailedReason, "unhealthy"),
+					},
+				}
+			},
+			want: nil,
+			assertConditions: []metav1.Condition{
+				*conditions.TrueCondition(meta.HealthyCondition, meta.SucceededReason, fmtReleaseHealthy,
+					mockReleaseNamespace+"/"+mockReleaseName+".v1", "podinfo@1.0.0"),
+			},
+		},
+		{
+			name: "unhealthy release marks release unhealthy",
+			state: ReleaseState{
+				Status: ReleaseStatusUnhealthy,
+				Reason: "unhealthy objects: [Deployment/something/mock status: 'InProgress']",
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.HealthChecks = []v2.HealthCheck{{}}
+				spec.HealthMonitoring = &v2.HealthMonitoring{Enable: true}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						{
+							Name:         mockReleaseName,
+							Namespace:    mockReleaseNamespace,
+							Version:      1,
+							ChartName:    "podinfo",
+							ChartVersion: "1.0.0",
+						},
+					},
+				}
+			},
+			want: nil,
+			wantEvent: &corev1.Event{
+				Reason: meta.HealthCheckFailedReason,
+				Type:   corev1.EventTypeWarning,
+				Message: fmt.Sprintf(fmtReleaseUnhealthy, mockReleaseNamespace+"/"+mockReleaseName+".v1", "podinfo@1.0.0",
+					"unhealthy objects: [Deployment/something/mock status: 'InProgress']"),
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: eventMeta("1.0.0", ""),
+				},
+			},
+			assertConditions: []metav1.Condition{
+				*conditions.FalseCondition(meta.HealthyCondition, meta.HealthCheckFailedReason, fmtReleaseUnhealthy,
+					mockReleaseNamespace+"/"+mockReleaseName+".v1", "podinfo@1.0.0",
+					"unhealthy objects: [Deployment/something/mock status: 'InProgress']"),
+			},
+		},
+		{
+			name:  "unhealthy release within grace period triggers rollback",
+			state: ReleaseState{Status: ReleaseStatusUnhealthy},
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   1,
+					Status:    helmrelease.StatusSuperseded,
+					Chart:     testutil.BuildChart(),
+				}),
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   2,
+					Status:    helmrelease.StatusDeployed,
+					Chart:     testutil.BuildChart(),
+				}),
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.HealthChecks = []v2.HealthCheck{{}}
+				spec.HealthMonitoring = &v2.HealthMonitoring{Enable: true, Remediate: true}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[1]))
+				cur.LastDeployed = metav1.Now()
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						cur,
+						release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+					},
+					LastAttemptedReleaseAction: v2.ReleaseActionUpgrade,
+				}
+			},
+			want: &RollbackRemediation{},
+			wantEvent: &corev1.Event{
+				Reason: meta.HealthCheckFailedReason,
+				Type:   corev1.EventTypeWarning,
+				Message: fmt.Sprintf(fmtReleaseUnhealthy, mockReleaseNamespace+"/"+mockReleaseName+".v2",
+					"hello@0.1.0", ""),
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: eventMeta("0.1.0",
+						chartutil.DigestValues(digest.Canonical, map[string]interface{}{"name": "value"}).String(),
+						addAppVersion("1.2.3")),
+				},
+			},
+			assertConditions: []metav1.Condition{
+				*conditions.FalseCondition(meta.HealthyCondition, meta.HealthCheckFailedReason, fmtReleaseUnhealthy,
+					mockReleaseNamespace+"/"+mockReleaseName+".v2", "hello@0.1.0", ""),
+			},
+		},
+		{
+			name:  "unhealthy release without previous healthy release does not trigger rollback",
+			state: ReleaseState{Status: ReleaseStatusUnhealthy},
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   1,
+					Status:    helmrelease.StatusSuperseded,
+					Chart:     testutil.BuildChart(),
+				}),
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   2,
+					Status:    helmrelease.StatusDeployed,
+					Chart:     testutil.BuildChart(),
+				}),
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.HealthChecks = []v2.HealthCheck{{}}
+				spec.HealthMonitoring = &v2.HealthMonitoring{Enable: true, Remediate: true}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[1]))
+				cur.LastDeployed = metav1.Now()
+				prev := release.ObservedToSnapshot(release.ObserveRelease(releases[0]))
+				prev.Unhealthy = true
+				return v2.HelmReleaseStatus{
+					History:                    v2.Snapshots{cur, prev},
+					LastAttemptedReleaseAction: v2.ReleaseActionUpgrade,
+				}
+			},
+			want: nil,
+			wantEvent: &corev1.Event{
+				Reason: meta.HealthCheckFailedReason,
+				Type:   corev1.EventTypeWarning,
+				Message: fmt.Sprintf(fmtReleaseUnhealthy, mockReleaseNamespace+"/"+mockReleaseName+".v2",
+					"hello@0.1.0", ""),
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: eventMeta("0.1.0",
+						chartutil.DigestValues(digest.Canonical, map[string]interface{}{"name": "value"}).String(),
+						addAppVersion("1.2.3")),
+				},
+			},
+			assertConditions: []metav1.Condition{
+				*conditions.FalseCondition(meta.HealthyCondition, meta.HealthCheckFailedReason, fmtReleaseUnhealthy,
+					mockReleaseNamespace+"/"+mockReleaseName+".v2", "hello@0.1.0", ""),
+			},
+		},
+		{
+			name:  "unhealthy release outside grace period does not trigger rollback",
+			state: ReleaseState{Status: ReleaseStatusUnhealthy},
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   1,
+					Status:    helmrelease.StatusSuperseded,
+					Chart:     testutil.BuildChart(),
+				}),
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   2,
+					Status:    helmrelease.StatusDeployed,
+					Chart:     testutil.BuildChart(),
+				}),
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.HealthChecks = []v2.HealthCheck{{}}
+				spec.HealthMonitoring = &v2.HealthMonitoring{
+					Enable:      true,
+					Remediate:   true,
+					GracePeriod: &metav1.Duration{Duration: time.Minute},
+				}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[1]))
+				cur.LastDeployed = metav1.NewTime(time.Now().Add(-time.Hour))
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						cur,
+						release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+					},
+				}
+			},
+			want: nil,
+			wantEvent: &corev1.Event{
+				Reason: meta.HealthCheckFailedReason,
+				Type:   corev1.EventTypeWarning,
+				Message: fmt.Sprintf(fmtReleaseUnhealthy, mockReleaseNamespace+"/"+mockReleaseName+".v2",
+					"hello@0.1.0", ""),
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: eventMeta("0.1.0",
+						chartutil.DigestValues(digest.Canonical, map[string]interface{}{"name": "value"}).String(),
+						addAppVersion("1.2.3")),
+				},
+			},
+			assertConditions: []metav1.Condition{
+				*conditions.FalseCondition(meta.HealthyCondition, meta.HealthCheckFailedReason, fmtReleaseUnhealthy,
+					mockReleaseNamespace+"/"+mockReleaseName+".v2", "hello@0.1.0", ""),
+			},
+		},
+		{
+			name:  "unhealthy release resulting from remediation does not trigger rollback",
+			state: ReleaseState{Status: ReleaseStatusUnhealthy},
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   1,
+					Status:    helmrelease.StatusSuperseded,
+					Chart:     testutil.BuildChart(),
+				}),
+				testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: mockReleaseNamespace,
+					Version:   2,
+					Status:    helmrelease.StatusDeployed,
+					Chart:     testutil.BuildChart(),
+				}),
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.HealthChecks = []v2.HealthCheck{{}}
+				spec.HealthMonitoring = &v2.HealthMonitoring{Enable: true, Remediate: true}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[1]))
+				cur.LastDeployed = metav1.Now()
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						cur,
+						release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+					},
+					Conditions: []metav1.Condition{
+						*conditions.TrueCondition(v2.RemediatedCondition, v2.RollbackSucceededReason, "rolled back"),
+					},
+				}
+			},
+			want: nil,
+			wantEvent: &corev1.Event{
+				Reason: meta.HealthCheckFailedReason,
+				Type:   corev1.EventTypeWarning,
+				Message: fmt.Sprintf(fmtReleaseUnhealthy, mockReleaseNamespace+"/"+mockReleaseName+".v2",
+					"hello@0.1.0", ""),
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: eventMeta("0.1.0",
+						chartutil.DigestValues(digest.Canonical, map[string]interface{}{"name": "value"}).String(),
+						addAppVersion("1.2.3")),
+				},
+			},
+			assertConditions: []metav1.Condition{
+				*conditions.TrueCondition(v2.RemediatedCondition, v2.RollbackSucceededReason, "rolled back"),
+				*conditions.FalseCondition(meta.HealthyCondition, meta.HealthCheckFailedReason, fmtReleaseUnhealthy,
+					mockReleaseNamespace+"/"+mockReleaseName+".v2", "hello@0.1.0", ""),
+			},
+		},
 		{
 			name: "out-of-sync release triggers upgrade",
 			state: ReleaseState{
@@ -1826,6 +2188,15 @@ func TestAtomicRelease_actionForState(t *testing.T) {
 			}
 			g.Expect(got).To(want)
 
+			// Unhealthy releases are recorded as such, and rolled back to
+			// the last healthy release.
+			if tt.state.Status == ReleaseStatusUnhealthy {
+				g.Expect(obj.Status.History.Latest().Unhealthy).To(BeTrue())
+				if rollback, ok := got.(*RollbackRemediation); ok {
+					g.Expect(rollback.toHealthy).To(BeTrue())
+				}
+			}
+
 			if tt.wantEvent != nil {
 				g.Expect(recorder.GetEvents()).To(ConsistOf([]corev1.Event{*tt.wantEvent}))
 			} else {
@@ -1950,3 +2321,194 @@ func Test_replaceCondition(t *testing.T) {
 		})
 	}
 }
+
+func TestAtomicRelease_actionPrevented(t *testing.T) {
+	newObj := func() *v2.HelmRelease {
+		return &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "default",
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantErr    error
+		wantReason string
+	}{
+		{
+			name:       "manifest too large",
+			err:        fmt.Errorf("render failed: %w", &postrender.ManifestTooLargeError{Stage: postrender.StageRender, Size: 20, Limits: postrender.ManifestLimits{MaxSize: 10}}),
+			wantErr:    ErrManifestTooLarge,
+			wantReason: v2.ManifestTooLargeReason,
+		},
+		{
+			name:       "insufficient permissions",
+			err:        &action.InsufficientPermissionsError{Err: errors.New("review failed")},
+			wantErr:    ErrInsufficientPermissions,
+			wantReason: v2.InsufficientPermissionsReason,
+		},
+		{
+			name: "other error",
+			err:  errors.New("install failed"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			recorder := record.NewFakeRecorder(1)
+			r := &AtomicRelease{eventRecorder: recorder}
+			obj := newObj()
+
+			err := r.actionPrevented(&Request{Object: obj}, &Upgrade{}, tt.err)
+			if tt.wantErr == nil {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(obj.Status.Conditions).To(BeEmpty())
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(err).To(MatchError(tt.wantErr))
+
+			msg := "Cannot upgrade Helm release default/release: "
+			g.Expect(conditions.GetReason(obj, meta.ReadyCondition)).To(Equal(tt.wantReason))
+			g.Expect(conditions.GetMessage(obj, meta.ReadyCondition)).To(HavePrefix(msg))
+			g.Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeWarning + " " + tt.wantReason + " " + msg)))
+		})
+	}
+}
+
+func Test_releaseLockKey(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "release",
+			Namespace: "default",
+		},
+		Spec: v2.HelmReleaseSpec{
+			StorageNamespace: "storage",
+		},
+	}
+	key := releaseLockKey(obj, "flux-system")
+	g.Expect(key.Namespace).To(Equal("flux-system"))
+	g.Expect(key.Name).To(HavePrefix("helm-release-"))
+	g.Expect(key.Name).To(HaveLen(len("helm-release-") + 16))
+	g.Expect(releaseLockKey(obj.DeepCopy(), "flux-system")).To(Equal(key))
+
+	// Without a namespace, the Lease is held in the storage namespace.
+	g.Expect(releaseLockKey(obj, "")).To(Equal(types.NamespacedName{Namespace: "storage", Name: key.Name}))
+
+	// HelmReleases in different namespaces targeting the same storage
+	// namespace and release share the Lease.
+	otherNamespace := obj.DeepCopy()
+	otherNamespace.Namespace = "other"
+	g.Expect(releaseLockKey(otherNamespace, "flux-system")).To(Equal(key))
+
+	other := obj.DeepCopy()
+	other.Spec.StorageNamespace = "other"
+	g.Expect(releaseLockKey(other, "flux-system")).ToNot(Equal(key))
+
+	remote := obj.DeepCopy()
+	remote.Spec.KubeConfig = &meta.KubeConfigReference{SecretRef: meta.SecretKeyReference{Name: "remote"}}
+	g.Expect(releaseLockKey(remote, "flux-system")).ToNot(Equal(key))
+
+	// KubeConfig Secrets with the same name in different namespaces may
+	// target different clusters.
+	otherRemote := remote.DeepCopy()
+	otherRemote.Namespace = "other"
+	g.Expect(releaseLockKey(otherRemote, "flux-system")).ToNot(Equal(releaseLockKey(remote, "flux-system")))
+}
+
+func TestAtomicRelease_acquireLock(t *testing.T) {
+	newObj := func() *v2.HelmRelease {
+		return &v2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "release",
+				Namespace: "default",
+			},
+		}
+	}
+
+	t.Run("without locker", func(t *testing.T) {
+		g := NewWithT(t)
+
+		r := &AtomicRelease{}
+		g.Expect(r.acquireLock(context.TODO(), &Request{Object: newObj()}, &Unlock{})).To(Succeed())
+		g.Expect(r.lock).To(BeNil())
+	})
+
+	t.Run("acquires lock once", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := fake.NewClientBuilder().Build()
+		r := &AtomicRelease{locker: lease.NewLocker(c, "flux-system", "controller", time.Minute)}
+		req := &Request{Object: newObj()}
+		g.Expect(r.acquireLock(context.TODO(), req, &Upgrade{})).To(Succeed())
+		g.Expect(r.lock).ToNot(BeNil())
+		defer r.lock.Release(context.TODO())
+
+		lock := r.lock
+		unlock := &Unlock{}
+		g.Expect(r.acquireLock(context.TODO(), req, unlock)).To(Succeed())
+		g.Expect(r.lock).To(BeIdenticalTo(lock))
+		g.Expect(unlock.staleLock).To(BeFalse())
+		g.Expect(unlock.lockHolder).To(Equal("controller"))
+
+		holder, active, err := r.locker.Holder(context.TODO(), releaseLockKey(req.Object, "flux-system"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(holder).To(Equal("controller"))
+		g.Expect(active).To(BeTrue())
+	})
+
+	t.Run("locked by other holder", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := fake.NewClientBuilder().Build()
+		other := lease.NewLocker(c, "flux-system", "other", time.Minute)
+		lock, err := other.Acquire(context.TODO(), releaseLockKey(newObj(), "flux-system"))
+		g.Expect(err).ToNot(HaveOccurred())
+		defer lock.Release(context.TODO())
+
+		recorder := record.NewFakeRecorder(1)
+		r := &AtomicRelease{
+			locker:        lease.NewLocker(c, "flux-system", "controller", time.Minute),
+			eventRecorder: recorder,
+		}
+		req := &Request{Object: newObj()}
+		err = r.acquireLock(context.TODO(), req, &Upgrade{})
+		g.Expect(err).To(MatchError(ErrReleaseLocked))
+		g.Expect(r.lock).To(BeNil())
+		g.Expect(conditions.GetReason(req.Object, meta.ReadyCondition)).To(Equal(v2.LockedReason))
+		g.Expect(recorder.Events).To(HaveLen(1))
+	})
+
+	t.Run("lost lock", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := fake.NewClientBuilder().Build()
+		recorder := record.NewFakeRecorder(1)
+		r := &AtomicRelease{
+			locker:        lease.NewLocker(c, "flux-system", "controller", 300*time.Millisecond),
+			eventRecorder: recorder,
+		}
+		req := &Request{Object: newObj()}
+		g.Expect(r.acquireLock(context.TODO(), req, &Upgrade{})).To(Succeed())
+		defer r.lock.Release(context.TODO())
+
+		// Take over the Lease, as another holder would after it expired.
+		l := &coordinationv1.Lease{}
+		g.Expect(c.Get(context.TODO(), releaseLockKey(req.Object, "flux-system"), l)).To(Succeed())
+		l.Spec.HolderIdentity = ptr.To("other")
+		g.Expect(c.Update(context.TODO(), l)).To(Succeed())
+		g.Eventually(r.lock.Lost, time.Second, 50*time.Millisecond).Should(BeTrue())
+
+		err := r.acquireLock(context.TODO(), req, &Upgrade{})
+		g.Expect(err).To(MatchError(ErrReleaseLocked))
+		g.Expect(err).To(MatchError(lease.ErrLost))
+		g.Expect(conditions.GetReason(req.Object, meta.ReadyCondition)).To(Equal(v2.LockedReason))
+		g.Expect(recorder.Events).To(HaveLen(1))
+	})
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/helm-controller/internal/release"
+)
+
+// sensitiveValueKey matches the keys of values which are never disclosed in
+// a change summary.
+var sensitiveValueKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private|key|cert|auth)`)
+
+// changeSummary returns a summary of the changes made by the latest of the
+// observed releases, compared to the release observed before it. It returns
+// an empty string if no release was observed.
+func changeSummary(obj *v2.HelmRelease, observed observedReleases) string {
+	versions := observed.sortedVersions()
+	if len(versions) == 0 {
+		return ""
+	}
+
+	var prev *release.Observation
+	if len(versions) > 1 {
+		obs := observed[versions[1]]
+		prev = &obs
+	}
+	return release.SummarizeChanges(prev, observed[versions[0]], valuesRedactor(obj)).String()
+}
+
+// valuesRedactor returns a release.RedactFunc for the values of the given
+// HelmRelease. Values with a key which looks sensitive, nested under such a
+// key, or holding such a key in a map or list, are always redacted. Values
+// which are not set to the same value in its inline values are redacted as
+// well, as they may originate from a Secret. This includes the values of
+// the previous release, which may originate from a Secret which has since
+// been removed from the ValuesFrom of the HelmRelease.
+func valuesRedactor(obj *v2.HelmRelease) release.RedactFunc {
+	inline := obj.GetValues()
+
+	return func(path string, value interface{}) bool {
+		keys := splitValuesPath(path)
+		for _, k := range keys {
+			if !isValuesIndex(k) && sensitiveValueKey.MatchString(k) {
+				return true
+			}
+		}
+		if hasSensitiveKey(value) {
+			return true
+		}
+		inlineValue, ok := lookupValue(inline, keys)
+		return !ok || !reflect.DeepEqual(inlineValue, value)
+	}
+}
+
+// hasSensitiveKey returns true if the value is a map or list holding a map
+// with a key which looks sensitive.
+func hasSensitiveKey(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if sensitiveValueKey.MatchString(k) || hasSensitiveKey(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasSensitiveKey(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitValuesPath splits a path of a value as formatted by the release
+// package into its map keys and list indexes, e.g. "env[0].value" into
+// "env", "[0]" and "value".
+func splitValuesPath(path string) []string {
+	var keys []string
+	for _, k := range strings.Split(path, ".") {
+		i := strings.IndexByte(k, '[')
+		if i < 0 || !strings.HasSuffix(k, "]") {
+			keys = append(keys, k)
+			continue
+		}
+		if i > 0 {
+			keys = append(keys, k[:i])
+		}
+		for _, idx := range strings.SplitAfter(k[i:], "]") {
+			if idx != "" {
+				keys = append(keys, idx)
+			}
+		}
+	}
+	return keys
+}
+
+// isValuesIndex returns true if the key is a list index as returned by
+// splitValuesPath.
+func isValuesIndex(key string) bool {
+	return strings.HasPrefix(key, "[") && strings.HasSuffix(key, "]")
+}
+
+// lookupValue returns the value at the path of keys in the given values.
+func lookupValue(values map[string]interface{}, keys []string) (interface{}, bool) {
+	var cur interface{} = values
+	for _, k := range keys {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if cur, ok = v[k]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			if !isValuesIndex(k) {
+				return nil, false
+			}
+			i, err := strconv.Atoi(k[1 : len(k)-1])
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// eventMessageWithChanges returns an event message composed out of the
+// given message and change summary by appending it to the message.
+func eventMessageWithChanges(msg, summary string) string {
+	if summary != "" {
+		msg = msg + "\n\n" + summary
+	}
+	return msg
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+func Test_splitValuesPath(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(splitValuesPath("image.tag")).To(Equal([]string{"image", "tag"}))
+	g.Expect(splitValuesPath("env[0].value")).To(Equal([]string{"env", "[0]", "value"}))
+	g.Expect(splitValuesPath("matrix[1][2]")).To(Equal([]string{"matrix", "[1]", "[2]"}))
+}
+
+func Test_valuesRedactor(t *testing.T) {
+	tests := []struct {
+		name       string
+		valuesFrom []v2.ValuesReference
+		path       string
+		value      interface{}
+		want       bool
+	}{
+		{name: "plain value", path: "image.tag", value: "6.5.4", want: false},
+		{name: "sensitive key", path: "auth.adminPassword", value: "foo", want: true},
+		{name: "sensitive key in inline values", path: "apiToken", value: "foo", want: true},
+		{name: "nested under sensitive key", path: "auth.users[0].name", value: "admin", want: true},
+		{name: "inline list item", path: "hosts[1]", value: "b.example.com", want: false},
+		{
+			name:  "map with sensitive key",
+			path:  "env[0]",
+			value: map[string]interface{}{"name": "FOO", "password": "bar"},
+			want:  true,
+		},
+		{
+			name:  "list with sensitive key",
+			path:  "env",
+			value: []interface{}{map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "foo"}}},
+			want:  true,
+		},
+		{
+			name:       "inline list item with values from Secret",
+			valuesFrom: []v2.ValuesReference{{Kind: "Secret", Name: "values"}},
+			path:       "hosts[1]",
+			value:      "b.example.com",
+			want:       false,
+		},
+		{
+			name:       "list item not in inline values with values from Secret",
+			valuesFrom: []v2.ValuesReference{{Kind: "Secret", Name: "values"}},
+			path:       "hosts[2]",
+			value:      "c.example.com",
+			want:       true,
+		},
+		{
+			name:       "value from ConfigMap",
+			valuesFrom: []v2.ValuesReference{{Kind: "ConfigMap", Name: "values"}},
+			path:       "replicaCount",
+			value:      float64(3),
+			want:       true,
+		},
+		{
+			name:       "inline value with values from Secret",
+			valuesFrom: []v2.ValuesReference{{Kind: "Secret", Name: "values"}},
+			path:       "image.tag",
+			value:      "6.5.4",
+			want:       false,
+		},
+		{
+			name:       "overridden inline value with values from Secret",
+			valuesFrom: []v2.ValuesReference{{Kind: "Secret", Name: "values"}},
+			path:       "image.tag",
+			value:      "6.5.3",
+			want:       true,
+		},
+		{
+			name:       "value not in inline values with values from Secret",
+			valuesFrom: []v2.ValuesReference{{Kind: "Secret", Name: "values"}},
+			path:       "database.host",
+			value:      "db.example.com",
+			want:       true,
+		},
+		{
+			// The value may originate from a Secret which has been removed
+			// from the valuesFrom of the HelmRelease.
+			name:  "value not in inline values without values from Secret",
+			path:  "database.host",
+			value: "db.example.com",
+			want:  true,
+		},
+		{
+			name:  "previous inline value",
+			path:  "image.tag",
+			value: "6.5.3",
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &v2.HelmRelease{
+				Spec: v2.HelmReleaseSpec{
+					Values:     &apiextensionsv1.JSON{Raw: []byte(`{"image":{"tag":"6.5.4"},"apiToken":"foo","hosts":["a.example.com","b.example.com"]}`)},
+					ValuesFrom: tt.valuesFrom,
+				},
+			}
+			g.Expect(valuesRedactor(obj)(tt.path, tt.value)).To(Equal(tt.want))
+		})
+	}
+}
@@ -31,6 +31,7 @@ import (
 
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/helm-controller/internal/action"
+	"github.com/fluxcd/helm-controller/internal/features"
 )
 
 // CorrectClusterDrift is a reconciler that attempts to correct the cluster state
@@ -41,12 +42,17 @@ import (
 // release has drift detection enabled and the jsondiff.DiffSet is not empty.
 //
 // The reconciler will emit a Kubernetes event upon completion indicating
-// whether the cluster state was successfully corrected or not.
+// whether the cluster state was successfully corrected or not. When the
+// features.DriftCorrectionObjectEvents feature gate is enabled, the correction
+// is additionally recorded on each corrected object.
 type CorrectClusterDrift struct {
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
 	diff          jsondiff.DiffSet
 	fieldManager  string
+
+	// err is the error of the last attempt to correct the cluster state.
+	err error
 }
 
 func NewCorrectClusterDrift(configFactory *action.ConfigFactory, recorder record.EventRecorder, diff jsondiff.DiffSet, fieldManager string) *CorrectClusterDrift {
@@ -69,7 +75,13 @@ func (r *CorrectClusterDrift) Reconcile(ctx context.Context, req *Request) error
 	// Update condition to reflect the current status.
 	conditions.MarkUnknown(req.Object, meta.ReadyCondition, meta.ProgressingReason, "correcting cluster drift")
 
-	changeSet, err := action.ApplyDiff(ctx, r.configFactory.Build(nil), r.diff, r.fieldManager)
+	var opts []action.ApplyDiffOption
+	if recordOnObjects, _ := features.Enabled(features.DriftCorrectionObjectEvents); recordOnObjects {
+		opts = append(opts, action.WithCorrectionRecord(req.Object.Status.History.Latest().FullReleaseName()))
+	}
+
+	changeSet, err := action.ApplyDiff(ctx, r.configFactory.Build(nil), r.diff, r.fieldManager, opts...)
+	r.err = err
 	r.report(req.Object, changeSet, err)
 	return nil
 }
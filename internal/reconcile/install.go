@@ -18,6 +18,7 @@ package reconcile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -26,12 +27,14 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/conditions"
 
 	v2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/helm-controller/internal/action"
 	"github.com/fluxcd/helm-controller/internal/chartutil"
 	"github.com/fluxcd/helm-controller/internal/digest"
+	"github.com/fluxcd/helm-controller/internal/postrender"
 )
 
 // Install is an ActionReconciler which attempts to install a Helm release
@@ -59,6 +62,15 @@ import (
 type Install struct {
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
+
+	// permissionCheck enables the check of the permissions required by the
+	// release before the install modifies anything.
+	permissionCheck bool
+	// manifestLimits are the limits enforced on the manifest of the release.
+	manifestLimits postrender.ManifestLimits
+	// maxValuesDeltaChanges is the maximum number of changes of the values
+	// recorded in the snapshot of the release, 0 disables recording them.
+	maxValuesDeltaChanges int
 }
 
 // NewInstall returns a new Install reconciler configured with the provided
@@ -87,14 +99,31 @@ func (r *Install) Reconcile(ctx context.Context, req *Request) error {
 	// If we are installing, none of the previous conditions apply.
 	conditions.Delete(req.Object, v2.TestSuccessCondition)
 	conditions.Delete(req.Object, v2.RemediatedCondition)
+	conditions.Delete(req.Object, meta.HealthyCondition)
 
 	// Run the Helm install action.
-	_, err := action.Install(ctx, cfg, req.Object, req.Chart, req.Values)
+	opts := []action.InstallOption{action.WithInstallManifestLimits(r.manifestLimits)}
+	if r.permissionCheck {
+		reviews, err := selfSubjectAccessReviews(r.configFactory)
+		if err != nil {
+			// Without a client, the permissions cannot be confirmed.
+			return &action.InsufficientPermissionsError{Err: err}
+		}
+		opts = append(opts, action.WithInstallPermissionCheck(ctx, cfg, req.Object, reviews))
+	}
+	_, err := action.Install(ctx, cfg, req.Object, req.Chart, req.Values, opts...)
 
 	// Record the history of releases observed during the install.
-	obsReleases.recordOnObject(req.Object, mutateOCIDigest)
+	obsReleases.recordOnObject(req.Object, r.maxValuesDeltaChanges, mutateOCIDigest)
 
 	if err != nil {
+		// Missing permissions are reported by the caller, and do not count
+		// as a failure as the install did not modify anything.
+		var permErr *action.InsufficientPermissionsError
+		if errors.As(err, &permErr) {
+			return err
+		}
+
 		r.failure(req, logBuf, err)
 
 		// Return error if we did not store a release, as this does not
@@ -113,7 +142,7 @@ func (r *Install) Reconcile(ctx context.Context, req *Request) error {
 		return nil
 	}
 
-	r.success(req)
+	r.success(req, obsReleases)
 	return nil
 }
 
@@ -166,8 +195,9 @@ func (r *Install) failure(req *Request, buffer *action.LogBuffer, err error) {
 // the given Request.Object by marking ReleasedCondition=True and emitting an
 // event. In addition, it marks TestSuccessCondition=False when tests are
 // enabled to indicate we are awaiting test results after having made the
-// release.
-func (r *Install) success(req *Request) {
+// release. The event message includes a summary of the changes made by the
+// release, composed out of the observed releases.
+func (r *Install) success(req *Request, observed observedReleases) {
 	// Compose success message.
 	cur := req.Object.Status.History.Latest()
 	msg := fmt.Sprintf(fmtInstallSuccess, cur.FullReleaseName(), cur.VersionedChartName())
@@ -185,6 +215,6 @@ func (r *Install) success(req *Request) {
 		eventMeta(cur.ChartVersion, cur.ConfigDigest, addAppVersion(cur.AppVersion), addOCIDigest(cur.OCIDigest)),
 		corev1.EventTypeNormal,
 		v2.InstallSucceededReason,
-		msg,
+		eventMessageWithChanges(msg, changeSummary(req.Object, observed)),
 	)
 }
@@ -397,7 +397,8 @@ func TestInstall_success(t *testing.T) {
 		req := &Request{
 			Object: obj.DeepCopy(),
 		}
-		r.success(req)
+		observed := observedReleases{cur.Version: release.ObserveRelease(cur)}
+		r.success(req, observed)
 
 		expectMsg := fmt.Sprintf(fmtInstallSuccess,
 			fmt.Sprintf("%s/%s.v%d", mockReleaseNamespace, mockReleaseName, obj.Status.History.Latest().Version),
@@ -410,7 +411,7 @@ func TestInstall_success(t *testing.T) {
 			{
 				Type:    corev1.EventTypeNormal,
 				Reason:  v2.InstallSucceededReason,
-				Message: expectMsg,
+				Message: expectMsg + "\n\n" + release.SummarizeChanges(nil, observed[cur.Version], nil).String(),
 				ObjectMeta: metav1.ObjectMeta{
 					Annotations: map[string]string{
 						eventMetaGroupKey(eventv1.MetaRevisionKey): obj.Status.History.Latest().ChartVersion,
@@ -434,7 +435,7 @@ func TestInstall_success(t *testing.T) {
 		obj.Spec.Test = &v2.Test{Enable: true}
 
 		req := &Request{Object: obj}
-		r.success(req)
+		r.success(req, nil)
 
 		g.Expect(conditions.IsTrue(req.Object, v2.ReleasedCondition)).To(BeTrue())
 
@@ -62,7 +62,10 @@ func (r observedReleases) sortedVersions() (versions []int) {
 }
 
 // recordOnObject records the observed releases on the HelmRelease object.
-func (r observedReleases) recordOnObject(obj *v2.HelmRelease, mutators ...mutateObservedRelease) {
+// When more than one release was observed, the snapshot of the latest release
+// records at most maxValuesDeltaChanges changes of its values compared to the
+// release observed before it. A value of 0 disables recording the delta.
+func (r observedReleases) recordOnObject(obj *v2.HelmRelease, maxValuesDeltaChanges int, mutators ...mutateObservedRelease) {
 	switch len(r) {
 	case 0:
 		return
@@ -81,7 +84,11 @@ func (r observedReleases) recordOnObject(obj *v2.HelmRelease, mutators ...mutate
 		for _, mut := range mutators {
 			obs = mut(obj, obs)
 		}
-		obj.Status.History = append(v2.Snapshots{release.ObservedToSnapshot(obs)}, obj.Status.History...)
+		snap := release.ObservedToSnapshot(obs)
+		if maxValuesDeltaChanges > 0 {
+			snap.ValuesDelta = release.ValuesDelta(r[versions[1]], obs, valuesRedactor(obj), maxValuesDeltaChanges)
+		}
+		obj.Status.History = append(v2.Snapshots{snap}, obj.Status.History...)
 
 		for _, ver := range versions[1:] {
 			for i := range obj.Status.History {
@@ -91,6 +98,8 @@ func (r observedReleases) recordOnObject(obj *v2.HelmRelease, mutators ...mutate
 					obs.OCIDigest = snap.OCIDigest
 					newSnap := release.ObservedToSnapshot(obs)
 					newSnap.SetTestHooks(snap.GetTestHooks())
+					newSnap.ValuesDelta = snap.ValuesDelta
+					newSnap.Unhealthy = snap.Unhealthy
 					obj.Status.History[i] = newSnap
 					return
 				}
@@ -150,6 +159,16 @@ func summarize(req *Request) {
 		conditions.Delete(req.Object, v2.TestSuccessCondition)
 	}
 
+	// Remove any stale Healthy condition as soon as health monitoring is
+	// disabled. When the release is unhealthy, this takes precedence over the
+	// result of the last release action.
+	if !req.Object.MustMonitorHealth() {
+		conditions.Delete(req.Object, meta.HealthyCondition)
+	}
+	if conditions.IsFalse(req.Object, meta.HealthyCondition) {
+		sumConds = append([]string{v2.RemediatedCondition, meta.HealthyCondition}, sumConds[1:]...)
+	}
+
 	conds := req.Object.Status.Conditions
 	if len(conds) == 0 {
 		// Nothing to summarize if there are no conditions.
@@ -23,6 +23,7 @@ import (
 	"github.com/go-logr/logr"
 	. "github.com/onsi/gomega"
 	"helm.sh/helm/v3/pkg/chart"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/fluxcd/pkg/apis/kustomize"
@@ -703,9 +704,9 @@ func Test_RecordOnObject(t *testing.T) {
 			g := NewWithT(t)
 
 			if tt.mutate {
-				tt.r.recordOnObject(tt.obj, mutateOCIDigest)
+				tt.r.recordOnObject(tt.obj, 0, mutateOCIDigest)
 			} else {
-				tt.r.recordOnObject(tt.obj)
+				tt.r.recordOnObject(tt.obj, 0)
 			}
 			err := tt.testFunc(tt.obj)
 			g.Expect(err).ToNot(HaveOccurred())
@@ -713,3 +714,134 @@ func Test_RecordOnObject(t *testing.T) {
 	}
 
 }
+
+func Test_RecordOnObject_ValuesDelta(t *testing.T) {
+	observed := observedReleases{
+		1: {
+			Name:          mockReleaseName,
+			Version:       1,
+			ChartMetadata: chart.Metadata{Name: mockReleaseName, Version: "1.0.0"},
+			Config:        map[string]interface{}{"replicaCount": float64(1), "image": "foo:v1", "password": "foo"},
+		},
+		2: {
+			Name:          mockReleaseName,
+			Version:       2,
+			ChartMetadata: chart.Metadata{Name: mockReleaseName, Version: "1.0.0"},
+			Config:        map[string]interface{}{"replicaCount": float64(2), "image": "foo:v2", "password": "bar"},
+		},
+	}
+
+	t.Run("records the values delta when enabled", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				Values: &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":2}`)},
+			},
+		}
+		observed.recordOnObject(obj, 10)
+		// Only the inline values of the HelmRelease are disclosed, as the
+		// other values may originate from a Secret.
+		g.Expect(obj.Status.History.Latest().ValuesDelta).To(Equal(&v2.ValuesDelta{
+			PreviousVersion: 1,
+			Changes: []string{
+				"~ image: (redacted)",
+				"~ password: (redacted)",
+				"~ replicaCount: (redacted) -> 2",
+			},
+		}))
+	})
+
+	t.Run("redacts values of a Secret removed from valuesFrom", func(t *testing.T) {
+		g := NewWithT(t)
+
+		// The previous release composed the database values from a Secret,
+		// which is no longer referenced by the HelmRelease.
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				Values: &apiextensionsv1.JSON{Raw: []byte(`{"database":{"host":"db.example.com"}}`)},
+			},
+		}
+		observedReleases{
+			1: {
+				Name:    mockReleaseName,
+				Version: 1,
+				Config:  map[string]interface{}{"database": map[string]interface{}{"host": "10.0.0.1", "user": "admin"}},
+			},
+			2: {
+				Name:    mockReleaseName,
+				Version: 2,
+				Config:  map[string]interface{}{"database": map[string]interface{}{"host": "db.example.com"}},
+			},
+		}.recordOnObject(obj, 10)
+		g.Expect(obj.Status.History.Latest().ValuesDelta).To(Equal(&v2.ValuesDelta{
+			PreviousVersion: 1,
+			Changes: []string{
+				`~ database.host: (redacted) -> "db.example.com"`,
+				"- database.user: (redacted)",
+			},
+		}))
+	})
+
+	t.Run("redacts sensitive values in lists", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{
+			Spec: v2.HelmReleaseSpec{
+				Values: &apiextensionsv1.JSON{Raw: []byte(`{"env":[{"name":"FOO","value":"bar"},{"name":"TOKEN"}]}`)},
+			},
+		}
+		observedReleases{
+			1: {
+				Name:    mockReleaseName,
+				Version: 1,
+				Config: map[string]interface{}{"env": []interface{}{
+					map[string]interface{}{"name": "FOO", "value": "foo"},
+				}},
+			},
+			2: {
+				Name:    mockReleaseName,
+				Version: 2,
+				Config: map[string]interface{}{"env": []interface{}{
+					map[string]interface{}{"name": "FOO", "value": "bar"},
+					map[string]interface{}{"name": "TOKEN", "valueFrom": map[string]interface{}{"secretKeyRef": "token"}},
+				}},
+			},
+		}.recordOnObject(obj, 10)
+		g.Expect(obj.Status.History.Latest().ValuesDelta).To(Equal(&v2.ValuesDelta{
+			PreviousVersion: 1,
+			Changes: []string{
+				`~ env[0].value: (redacted) -> "bar"`,
+				`+ env[1].name: "TOKEN"`,
+				"+ env[1].valueFrom.secretKeyRef: (redacted)",
+			},
+		}))
+	})
+
+	t.Run("does not record the values delta when disabled", func(t *testing.T) {
+		g := NewWithT(t)
+
+		obj := &v2.HelmRelease{}
+		observed.recordOnObject(obj, 0)
+		g.Expect(obj.Status.History.Latest().ValuesDelta).To(BeNil())
+	})
+
+	t.Run("retains the values delta of updated snapshots", func(t *testing.T) {
+		g := NewWithT(t)
+
+		delta := &v2.ValuesDelta{PreviousVersion: 1, Changes: []string{"+ foo: true"}}
+		obj := &v2.HelmRelease{
+			Status: v2.HelmReleaseStatus{
+				History: v2.Snapshots{
+					{Name: mockReleaseName, Version: 2, ValuesDelta: delta},
+				},
+			},
+		}
+		observedReleases{
+			2: observed[2],
+			3: {Name: mockReleaseName, Version: 3, ChartMetadata: chart.Metadata{Name: mockReleaseName, Version: "1.0.0"}},
+		}.recordOnObject(obj, 0)
+		g.Expect(obj.Status.History).To(HaveLen(2))
+		g.Expect(obj.Status.History[1].ValuesDelta).To(Equal(delta))
+	})
+}
@@ -62,6 +62,10 @@ import (
 type RollbackRemediation struct {
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
+
+	// toHealthy rolls back to the most recent previous release which was not
+	// observed to be unhealthy, instead of the most recent previous release.
+	toHealthy bool
 }
 
 // NewRollbackRemediation returns a new RollbackRemediation reconciler
@@ -83,7 +87,11 @@ func (r *RollbackRemediation) Reconcile(ctx context.Context, req *Request) error
 	defer summarize(req)
 
 	// Previous is required to determine what version to roll back to.
-	prev := req.Object.Status.History.Previous(req.Object.GetUpgrade().GetRemediation().MustIgnoreTestFailures(req.Object.GetTest().IgnoreFailures))
+	ignoreTests := req.Object.GetUpgrade().GetRemediation().MustIgnoreTestFailures(req.Object.GetTest().IgnoreFailures)
+	prev := req.Object.Status.History.Previous(ignoreTests)
+	if r.toHealthy {
+		prev = req.Object.Status.History.PreviousHealthy(ignoreTests)
+	}
 	if prev == nil {
 		return fmt.Errorf("%w: required to rollback", ErrMissingRollbackTarget)
 	}
@@ -186,6 +194,8 @@ func observeRollback(obj *v2.HelmRelease) storage.ObserveFunc {
 			if snap.Targets(rls.Name, rls.Namespace, rls.Version) {
 				newSnap := release.ObservedToSnapshot(releaseToObservation(rls, snap))
 				newSnap.SetTestHooks(snap.GetTestHooks())
+				newSnap.ValuesDelta = snap.ValuesDelta
+				newSnap.Unhealthy = snap.Unhealthy
 				obj.Status.History[i] = newSnap
 				return
 			}
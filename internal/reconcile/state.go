@@ -20,6 +20,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/conditions"
@@ -32,6 +34,7 @@ import (
 	"github.com/fluxcd/helm-controller/internal/digest"
 	interrors "github.com/fluxcd/helm-controller/internal/errors"
 	"github.com/fluxcd/helm-controller/internal/postrender"
+	"github.com/fluxcd/helm-controller/internal/tracing"
 )
 
 // ReleaseStatus represents the status of a Helm release as determined by
@@ -66,12 +69,19 @@ const (
 	// ReleaseStatusUntested indicates that the release is present in the Helm
 	// storage, but has not been tested.
 	ReleaseStatusUntested ReleaseStatus = "Untested"
+	// ReleaseStatusTestDue indicates that the release is present in the Helm
+	// storage and has been tested, but the test interval has elapsed since.
+	ReleaseStatusTestDue ReleaseStatus = "TestDue"
 	// ReleaseStatusInSync indicates that the release is present in the Helm
 	// storage, and is in sync with the v2.HelmRelease object.
 	ReleaseStatusInSync ReleaseStatus = "InSync"
 	// ReleaseStatusFailed indicates that the release is present in the Helm
 	// storage, but has failed.
 	ReleaseStatusFailed ReleaseStatus = "Failed"
+	// ReleaseStatusUnhealthy indicates that the release is present in the Helm
+	// storage and in sync with the v2.HelmRelease object, but the objects
+	// selected by the health checks are not healthy.
+	ReleaseStatusUnhealthy ReleaseStatus = "Unhealthy"
 )
 
 // ReleaseState represents the state of a Helm release as determined by
@@ -172,16 +182,30 @@ func DetermineReleaseState(ctx context.Context, cfg *action.ConfigFactory, req *
 				return ReleaseState{Status: ReleaseStatusUntested}, nil
 			}
 
-			// Act on any observed test failure.
+			// Act on any observed test failure. Failures of tests re-run at
+			// the test interval are only acted on if configured.
 			remediation := req.Object.GetActiveRemediation()
-			if remediation != nil && !remediation.MustIgnoreTestFailures(testSpec.IgnoreFailures) && cur.HasTestInPhase(helmrelease.HookPhaseFailed.String()) {
+			if remediation != nil && !remediation.MustIgnoreTestFailures(testSpec.IgnoreFailures) &&
+				(!cur.HasBeenRetested() || testSpec.RemediateIntervalFailures) &&
+				cur.HasTestInPhase(helmrelease.HookPhaseFailed.String()) {
 				return ReleaseState{Status: ReleaseStatusFailed, Reason: "release has test in failed phase"}, nil
 			}
+
+			// Confirm the tests have been run within the test interval.
+			if interval := testSpec.GetInterval(); interval > 0 && len(cur.GetTestHooks()) > 0 {
+				if last := cur.LastTestCompleted(); !last.IsZero() && time.Since(last.Time) >= interval {
+					return ReleaseState{Status: ReleaseStatusTestDue, Reason: fmt.Sprintf("last tested at %s",
+						last.Format(time.RFC3339))}, nil
+				}
+			}
 		}
 
 		// Confirm the cluster state matches the desired config.
 		if diffOpts := req.Object.GetDriftDetection(); diffOpts.MustDetectChanges() {
-			diffSet, err := action.Diff(ctx, cfg.Build(nil), rls, kube.ManagedFieldsManager, req.Object.GetDriftDetection().Ignore...)
+			diffCtx, span := tracing.Start(ctx, "diff cluster state")
+			diffSet, err := action.Diff(diffCtx, cfg.Build(nil), rls, kube.ManagedFieldsManager, req.Object.GetDriftDetection().Ignore...)
+			span.SetAttributes(tracing.AttrDriftChanges.Int(len(diffSet)))
+			tracing.End(span, err)
 			hasChanges := diffSet.HasChanges()
 			if err != nil {
 				if !hasChanges {
@@ -194,6 +218,20 @@ func DetermineReleaseState(ctx context.Context, cfg *action.ConfigFactory, req *
 			}
 		}
 
+		// Confirm the objects of the release are healthy.
+		if req.Object.MustMonitorHealth() {
+			healthCtx, span := tracing.Start(ctx, "check health")
+			unhealthy, err := action.CheckHealth(healthCtx, cfg.Build(nil), req.Object, rls)
+			tracing.End(span, err)
+			if err != nil {
+				return ReleaseState{Status: ReleaseStatusUnknown}, fmt.Errorf("unable to determine health of release objects: %w", err)
+			}
+			if len(unhealthy) > 0 {
+				return ReleaseState{Status: ReleaseStatusUnhealthy, Reason: fmt.Sprintf("unhealthy objects: [%s]",
+					strings.Join(unhealthy, ", "))}, nil
+			}
+		}
+
 		return ReleaseState{Status: ReleaseStatusInSync}, nil
 	default:
 		return ReleaseState{Status: ReleaseStatusUnknown}, fmt.Errorf("unable to determine state for release with status '%s'", rls.Info.Status)
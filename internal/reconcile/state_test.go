@@ -20,6 +20,7 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	helmchart "helm.sh/helm/v3/pkg/chart"
@@ -264,6 +265,129 @@ func Test_DetermineReleaseState(t *testing.T) {
 				Status: ReleaseStatusFailed,
 			},
 		},
+		{
+			name: "test interval elapsed",
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(
+					&helmrelease.MockReleaseOptions{
+						Name:      mockReleaseName,
+						Namespace: mockReleaseNamespace,
+						Version:   1,
+						Status:    helmrelease.StatusDeployed,
+						Chart:     testutil.BuildChart(),
+					},
+					testutil.ReleaseWithConfig(map[string]interface{}{"foo": "bar"}),
+					testutil.ReleaseWithHookExecution("tests", []helmrelease.HookEvent{helmrelease.HookTest},
+						helmrelease.HookPhaseSucceeded),
+				),
+			},
+			chart:  testutil.BuildChart(),
+			values: map[string]interface{}{"foo": "bar"},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.Test = &v2.Test{
+					Enable:   true,
+					Interval: &metav1.Duration{Duration: time.Hour},
+				}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[0]))
+				hooks := release.TestHooksFromRelease(releases[0])
+				cur.SetTestHooks(hooks)
+
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						cur,
+					},
+					LastAttemptedReleaseAction: v2.ReleaseActionUpgrade,
+				}
+			},
+			want: ReleaseState{
+				Status: ReleaseStatusTestDue,
+			},
+		},
+		{
+			name: "failed retest is not remediated",
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(
+					&helmrelease.MockReleaseOptions{
+						Name:      mockReleaseName,
+						Namespace: mockReleaseNamespace,
+						Version:   1,
+						Status:    helmrelease.StatusDeployed,
+						Chart:     testutil.BuildChart(),
+					},
+					testutil.ReleaseWithConfig(map[string]interface{}{"foo": "bar"}),
+					testutil.ReleaseWithHookExecution("tests", []helmrelease.HookEvent{helmrelease.HookTest},
+						helmrelease.HookPhaseFailed),
+				),
+			},
+			chart:  testutil.BuildChart(),
+			values: map[string]interface{}{"foo": "bar"},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.Test = &v2.Test{
+					Enable:   true,
+					Interval: &metav1.Duration{Duration: time.Hour},
+				}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[0]))
+				hooks := release.TestHooksFromRelease(releases[0])
+				hooks["tests"].PreviousRuns = []v2.TestHookRun{{Phase: helmrelease.HookPhaseSucceeded.String()}}
+				cur.SetTestHooks(hooks)
+
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						cur,
+					},
+					LastAttemptedReleaseAction: v2.ReleaseActionUpgrade,
+				}
+			},
+			want: ReleaseState{
+				Status: ReleaseStatusTestDue,
+			},
+		},
+		{
+			name: "failed retest is remediated when configured",
+			releases: []*helmrelease.Release{
+				testutil.BuildRelease(
+					&helmrelease.MockReleaseOptions{
+						Name:      mockReleaseName,
+						Namespace: mockReleaseNamespace,
+						Version:   1,
+						Status:    helmrelease.StatusDeployed,
+						Chart:     testutil.BuildChart(),
+					},
+					testutil.ReleaseWithConfig(map[string]interface{}{"foo": "bar"}),
+					testutil.ReleaseWithHookExecution("tests", []helmrelease.HookEvent{helmrelease.HookTest},
+						helmrelease.HookPhaseFailed),
+				),
+			},
+			chart:  testutil.BuildChart(),
+			values: map[string]interface{}{"foo": "bar"},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.Test = &v2.Test{
+					Enable:                    true,
+					Interval:                  &metav1.Duration{Duration: time.Hour},
+					RemediateIntervalFailures: true,
+				}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				cur := release.ObservedToSnapshot(release.ObserveRelease(releases[0]))
+				hooks := release.TestHooksFromRelease(releases[0])
+				hooks["tests"].PreviousRuns = []v2.TestHookRun{{Phase: helmrelease.HookPhaseSucceeded.String()}}
+				cur.SetTestHooks(hooks)
+
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						cur,
+					},
+					LastAttemptedReleaseAction: v2.ReleaseActionUpgrade,
+				}
+			},
+			want: ReleaseState{
+				Status: ReleaseStatusFailed,
+			},
+		},
 		{
 			name: "failed test with ignore failures set",
 			releases: []*helmrelease.Release{
@@ -19,6 +19,7 @@ package reconcile
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/fluxcd/pkg/runtime/logger"
@@ -58,11 +59,19 @@ import (
 // At the end of the reconciliation, the Status.Conditions are summarized and
 // propagated to the Ready condition on the Request.Object.
 //
+// When the collection of test logs is enabled, the logs of the test hook Pods
+// are collected once they complete, and stored in an object in the storage
+// namespace referenced from the TestHooks of the latest Snapshot. Failing to
+// collect or store the logs does not fail the test.
+//
 // The caller is assumed to have verified the integrity of Request.Object using
 // e.g. action.VerifySnapshot before calling Reconcile.
 type Test struct {
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
+
+	// testLogs configures the collection of the logs of test hook Pods.
+	testLogs action.TestLogsOptions
 }
 
 // NewTest returns a new Test reconciler configured with the provided values.
@@ -83,6 +92,15 @@ func (r *Test) Reconcile(ctx context.Context, req *Request) error {
 		return fmt.Errorf("%w: required for test", ErrNoLatest)
 	}
 
+	// Collect the logs of the test hook Pods, if enabled.
+	var collector *action.TestLogsCollector
+	if r.testLogs.Enabled() {
+		var cErr error
+		if collector, cErr = action.CollectTestLogs(ctx, cfg, r.testLogs.MaxSize); cErr != nil {
+			ctrl.LoggerFrom(ctx).Error(cErr, "unable to collect test logs")
+		}
+	}
+
 	// Run the Helm test action.
 	rls, err := action.Test(ctx, cfg, req.Object)
 
@@ -92,15 +110,17 @@ func (r *Test) Reconcile(ctx context.Context, req *Request) error {
 	if rls != nil && !release.ObserveRelease(rls).Targets(cur.Name, cur.Namespace, cur.Version) {
 		err = fmt.Errorf("%w: tested release %s/%s.v%d != current release %s/%s.v%d",
 			ErrReleaseMismatch, rls.Namespace, rls.Name, rls.Version, cur.Namespace, cur.Name, cur.Version)
+	} else if collector != nil {
+		r.storeLogs(ctx, req, collector)
 	}
 
 	// Something went wrong.
 	if err != nil {
-		r.failure(req, err)
+		r.failure(req, cur, err)
 
 		// If we failed to observe anything happened at all, we want to retry
 		// and return the error to indicate this.
-		if !req.Object.Status.History.Latest().HasBeenTested() {
+		if !testRunObserved(cur, req.Object.Status.History.Latest()) {
 			return err
 		}
 		return nil
@@ -125,17 +145,25 @@ const (
 	fmtTestFailure = "Helm test failed for release %s with chart %s: %s"
 	// fmtTestSuccess is the message format for a successful test.
 	fmtTestSuccess = "Helm test succeeded for release %s with chart %s: %s"
+	// fmtTestFailureLogs is the message format appended to a test failure
+	// when the logs of the failed test hook have been stored.
+	fmtTestFailureLogs = " (logs of test hook '%s' stored in %s %s/%s)"
 )
 
 // failure records the failure of a Helm test action in the status of the given
 // Request.Object by marking TestSuccess=False and increasing the failure
 // counter. In addition, it emits a warning event for the Request.Object.
 // The active remediation failure count is only incremented if test failures
-// are not ignored.
-func (r *Test) failure(req *Request, err error) {
+// are not ignored, and for tests re-run at the test interval if configured.
+// The previous Snapshot is the latest Snapshot before the tests were run,
+// and may be nil.
+func (r *Test) failure(req *Request, prev *v2.Snapshot, err error) {
 	// Compose failure message.
 	cur := req.Object.Status.History.Latest()
 	msg := fmt.Sprintf(fmtTestFailure, cur.FullReleaseName(), cur.VersionedChartName(), strings.TrimSpace(err.Error()))
+	if name, ref := failedTestLogsRef(cur); ref != nil {
+		msg += fmt.Sprintf(fmtTestFailureLogs, name, ref.Kind, ref.Namespace, ref.Name)
+	}
 
 	// Mark test failure on object.
 	req.Object.Status.Failures++
@@ -151,7 +179,8 @@ func (r *Test) failure(req *Request, err error) {
 		msg,
 	)
 
-	if req.Object.Status.History.Latest().HasBeenTested() {
+	retest := prev.HasBeenTested()
+	if testRunObserved(prev, cur) && (!retest || req.Object.GetTest().RemediateIntervalFailures) {
 		// Count the failure of the test for the active remediation strategy if enabled.
 		remediation := req.Object.GetActiveRemediation()
 		if remediation != nil && !remediation.MustIgnoreTestFailures(req.Object.GetTest().IgnoreFailures) {
@@ -199,10 +228,119 @@ func observeTest(obj *v2.HelmRelease) storage.ObserveFunc {
 			return
 		}
 
-		// Update the latest snapshot with the test result.
+		// Update the latest snapshot with the test result, while retaining
+		// the previous runs of the test hooks.
 		latest := obj.Status.History.Latest()
 		tested := release.ObservedToSnapshot(releaseToObservation(rls, latest))
-		tested.SetTestHooks(release.TestHooksFromRelease(rls))
+		hooks := release.TestHooksFromRelease(rls)
+		mergeTestHookRuns(latest.GetTestHooks(), hooks)
+		tested.SetTestHooks(hooks)
+		tested.ValuesDelta = latest.ValuesDelta
+		tested.Unhealthy = latest.Unhealthy
 		obj.Status.History[0] = tested
 	}
 }
+
+// maxTestHookRuns is the maximum number of previous runs retained per test
+// hook.
+const maxTestHookRuns = 5
+
+// mergeTestHookRuns records the previous run of every test hook in prev which
+// has been run again in next in the PreviousRuns of next. It retains the
+// PreviousRuns and LogsRef of test hooks which have not been run again, as
+// action.StoreTestLogs retains their logs.
+func mergeTestHookRuns(prev, next map[string]*v2.TestHookStatus) {
+	for name, h := range next {
+		p, ok := prev[name]
+		if !ok || p == nil || h == nil {
+			continue
+		}
+		if p.LastStarted.Equal(&h.LastStarted) {
+			h.PreviousRuns = p.PreviousRuns
+			h.LogsRef = p.LogsRef
+			continue
+		}
+		if p.LastStarted.IsZero() {
+			continue
+		}
+		runs := append([]v2.TestHookRun{{
+			Started:   p.LastStarted,
+			Completed: p.LastCompleted,
+			Phase:     p.Phase,
+		}}, p.PreviousRuns...)
+		if len(runs) > maxTestHookRuns {
+			runs = runs[:maxTestHookRuns]
+		}
+		h.PreviousRuns = runs
+	}
+}
+
+// testRunObserved returns true if a run of the tests was observed in cur,
+// compared to the Snapshot prev from before the tests were run.
+func testRunObserved(prev, cur *v2.Snapshot) bool {
+	if !cur.HasBeenTested() {
+		return false
+	}
+	if !prev.HasBeenTested() {
+		return true
+	}
+	for name, h := range cur.GetTestHooks() {
+		p, ok := prev.GetTestHooks()[name]
+		if !ok || (p == nil) != (h == nil) {
+			return true
+		}
+		if h != nil && !p.LastStarted.Equal(&h.LastStarted) {
+			return true
+		}
+	}
+	return false
+}
+
+// storeLogs stores the logs collected by the TestLogsCollector, and sets the
+// references to the logs on the TestHooks of the latest Snapshot.
+func (r *Test) storeLogs(ctx context.Context, req *Request, collector *action.TestLogsCollector) {
+	log := ctrl.LoggerFrom(ctx)
+	if err := collector.Err(); err != nil {
+		log.Error(err, "failed to collect test logs")
+	}
+
+	latest := req.Object.Status.History.Latest()
+	hooks := latest.GetTestHooks()
+	if len(hooks) == 0 {
+		return
+	}
+
+	clientSet, err := r.configFactory.KubeClient.Factory.KubernetesClientSet()
+	if err != nil {
+		log.Error(err, "unable to get Kubernetes client to store test logs")
+		return
+	}
+	refs, err := action.StoreTestLogs(ctx, clientSet, r.testLogs, r.configFactory.Driver.Name(),
+		req.Object.GetStorageNamespace(), latest.Name, latest.Version, collector.Logs())
+	if err != nil {
+		log.Error(err, "failed to store test logs")
+		return
+	}
+	for name, ref := range refs {
+		if h, ok := hooks[name]; ok && h != nil {
+			h.LogsRef = ref
+		}
+	}
+}
+
+// failedTestLogsRef returns the name and logs reference of the first failed
+// test hook of the Snapshot which has its logs stored.
+func failedTestLogsRef(snapshot *v2.Snapshot) (string, *v2.TestLogsReference) {
+	hooks := snapshot.GetTestHooks()
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if h := hooks[name]; h != nil && h.LogsRef != nil && h.Phase == helmrelease.HookPhaseFailed.String() {
+			return name, h.LogsRef
+		}
+	}
+	return "", nil
+}
@@ -485,7 +485,7 @@ func TestTest_failure(t *testing.T) {
 		}
 
 		req := &Request{Object: obj.DeepCopy()}
-		r.failure(req, err)
+		r.failure(req, nil, err)
 
 		expectMsg := fmt.Sprintf(fmtTestFailure,
 			fmt.Sprintf("%s/%s.v%d", cur.Namespace, cur.Name, cur.Version),
@@ -526,7 +526,7 @@ func TestTest_failure(t *testing.T) {
 		obj.Status.LastAttemptedReleaseAction = v2.ReleaseActionInstall
 		obj.Status.History.Latest().SetTestHooks(map[string]*v2.TestHookStatus{})
 		req := &Request{Object: obj}
-		r.failure(req, err)
+		r.failure(req, nil, err)
 
 		g.Expect(req.Object.Status.InstallFailures).To(Equal(int64(1)))
 	})
@@ -543,7 +543,7 @@ func TestTest_failure(t *testing.T) {
 		obj.Spec.Test = &v2.Test{IgnoreFailures: true}
 		obj.Status.History.Latest().SetTestHooks(map[string]*v2.TestHookStatus{})
 		req := &Request{Object: obj}
-		r.failure(req, err)
+		r.failure(req, nil, err)
 
 		g.Expect(req.Object.Status.InstallFailures).To(BeZero())
 	})
@@ -625,3 +625,129 @@ func TestTest_success(t *testing.T) {
 		g.Expect(req.Object.Status.Conditions[0].Message).To(ContainSubstring("no test hooks"))
 	})
 }
+
+func Test_failedTestLogsRef(t *testing.T) {
+	g := NewWithT(t)
+
+	ref := &v2.TestLogsReference{Kind: action.TestLogsKindSecret, Namespace: "ns", Name: "release.v1.test-logs", Key: "b"}
+	snapshot := &v2.Snapshot{}
+	snapshot.SetTestHooks(map[string]*v2.TestHookStatus{
+		"a": {Phase: helmrelease.HookPhaseFailed.String()},
+		"b": {Phase: helmrelease.HookPhaseFailed.String(), LogsRef: ref},
+		"c": {Phase: helmrelease.HookPhaseSucceeded.String(), LogsRef: &v2.TestLogsReference{Key: "c"}},
+		"d": nil,
+	})
+
+	name, got := failedTestLogsRef(snapshot)
+	g.Expect(name).To(Equal("b"))
+	g.Expect(got).To(Equal(ref))
+
+	name, got = failedTestLogsRef(&v2.Snapshot{})
+	g.Expect(name).To(BeEmpty())
+	g.Expect(got).To(BeNil())
+}
+
+func Test_mergeTestHookRuns(t *testing.T) {
+	g := NewWithT(t)
+
+	t1 := metav1.NewTime(time.Now().Add(-2 * time.Hour).Truncate(time.Second))
+	t2 := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	t3 := metav1.NewTime(time.Now().Truncate(time.Second))
+	logsRef := &v2.TestLogsReference{Kind: action.TestLogsKindSecret, Name: "release.v1.test-logs", Key: "filtered"}
+
+	var previousRuns []v2.TestHookRun
+	for i := 0; i < maxTestHookRuns; i++ {
+		previousRuns = append(previousRuns, v2.TestHookRun{Phase: fmt.Sprintf("run-%d", i)})
+	}
+
+	prev := map[string]*v2.TestHookStatus{
+		"rerun": {
+			LastStarted:   t1,
+			LastCompleted: t2,
+			Phase:         helmrelease.HookPhaseSucceeded.String(),
+		},
+		"filtered": {
+			LastStarted:   t1,
+			LastCompleted: t2,
+			Phase:         helmrelease.HookPhaseSucceeded.String(),
+			LogsRef:       logsRef,
+			PreviousRuns:  []v2.TestHookRun{{Phase: helmrelease.HookPhaseFailed.String()}},
+		},
+		"truncated": {
+			LastStarted:  t1,
+			Phase:        helmrelease.HookPhaseFailed.String(),
+			PreviousRuns: previousRuns,
+		},
+		"never-run": {},
+	}
+	next := map[string]*v2.TestHookStatus{
+		"rerun":     {LastStarted: t3, Phase: helmrelease.HookPhaseFailed.String()},
+		"filtered":  {LastStarted: t1, LastCompleted: t2, Phase: helmrelease.HookPhaseSucceeded.String()},
+		"truncated": {LastStarted: t3, Phase: helmrelease.HookPhaseSucceeded.String()},
+		"never-run": {LastStarted: t3, Phase: helmrelease.HookPhaseSucceeded.String()},
+		"new":       {LastStarted: t3, Phase: helmrelease.HookPhaseSucceeded.String()},
+	}
+
+	mergeTestHookRuns(prev, next)
+
+	g.Expect(next["rerun"].PreviousRuns).To(Equal([]v2.TestHookRun{
+		{Started: t1, Completed: t2, Phase: helmrelease.HookPhaseSucceeded.String()},
+	}))
+	g.Expect(next["filtered"].PreviousRuns).To(Equal(prev["filtered"].PreviousRuns))
+	g.Expect(next["filtered"].LogsRef).To(Equal(logsRef))
+	g.Expect(next["truncated"].PreviousRuns).To(HaveLen(maxTestHookRuns))
+	g.Expect(next["truncated"].PreviousRuns[0].Phase).To(Equal(helmrelease.HookPhaseFailed.String()))
+	g.Expect(next["truncated"].PreviousRuns[maxTestHookRuns-1].Phase).To(Equal(fmt.Sprintf("run-%d", maxTestHookRuns-2)))
+	g.Expect(next["never-run"].PreviousRuns).To(BeEmpty())
+	g.Expect(next["new"].PreviousRuns).To(BeEmpty())
+}
+
+func Test_testRunObserved(t *testing.T) {
+	t1 := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	t2 := metav1.NewTime(time.Now().Truncate(time.Second))
+
+	tests := []struct {
+		name  string
+		prev  map[string]*v2.TestHookStatus
+		cur   map[string]*v2.TestHookStatus
+		unset bool
+		want  bool
+	}{
+		{name: "not tested", unset: true, want: false},
+		{name: "first run", cur: map[string]*v2.TestHookStatus{}, want: true},
+		{
+			name: "no new run",
+			prev: map[string]*v2.TestHookStatus{"test": {LastStarted: t1}},
+			cur:  map[string]*v2.TestHookStatus{"test": {LastStarted: t1}},
+			want: false,
+		},
+		{
+			name: "new run",
+			prev: map[string]*v2.TestHookStatus{"test": {LastStarted: t1}},
+			cur:  map[string]*v2.TestHookStatus{"test": {LastStarted: t2}},
+			want: true,
+		},
+		{
+			name: "new hook",
+			prev: map[string]*v2.TestHookStatus{"test": {LastStarted: t1}},
+			cur:  map[string]*v2.TestHookStatus{"test": {LastStarted: t1}, "other": {LastStarted: t2}},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var prev *v2.Snapshot
+			if tt.prev != nil {
+				prev = &v2.Snapshot{}
+				prev.SetTestHooks(tt.prev)
+			}
+			cur := &v2.Snapshot{}
+			if !tt.unset {
+				cur.SetTestHooks(tt.cur)
+			}
+			g.Expect(testRunObserved(prev, cur)).To(Equal(tt.want))
+		})
+	}
+}
@@ -226,6 +226,8 @@ func observeUninstall(obj *v2.HelmRelease) storage.ObserveFunc {
 			if snap.Targets(rls.Name, rls.Namespace, rls.Version) {
 				newSnap := release.ObservedToSnapshot(releaseToObservation(rls, snap))
 				newSnap.SetTestHooks(snap.GetTestHooks())
+				newSnap.ValuesDelta = snap.ValuesDelta
+				newSnap.Unhealthy = snap.Unhealthy
 				obj.Status.History[i] = newSnap
 				return
 			}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	helmrelease "helm.sh/helm/v3/pkg/release"
 	corev1 "k8s.io/api/core/v1"
@@ -41,6 +42,12 @@ import (
 // This write to the Helm storage is observed, and updates the Status.History
 // field if the persisted object targets the same release version.
 //
+// A release in a pending state is only unlocked if it was left behind by the
+// holder of a stale lock, or if it has been pending for longer than the
+// timeout of the pending action. Otherwise, the release may still be operated
+// on by e.g. another controller instance or a Helm user, and ErrReleaseLocked
+// is returned.
+//
 // Any pending state marks the v2.HelmRelease object with
 // ReleasedCondition=False, even if persisting the object to the Helm storage
 // fails.
@@ -50,6 +57,13 @@ import (
 type Unlock struct {
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
+
+	// staleLock signals the release lock was taken over from a holder which
+	// stopped operating on the release without releasing the lock.
+	staleLock bool
+	// lockHolder is the identity of the holder of the release lock, if a
+	// lock is held.
+	lockHolder string
 }
 
 // NewUnlock returns a new Unlock reconciler configured with the provided
@@ -79,6 +93,12 @@ func (r *Unlock) Reconcile(_ context.Context, req *Request) error {
 	// Ensure the release is in a pending state.
 	cur := processCurrentSnaphot(req.Object, rls)
 	if status := rls.Info.Status; status.IsPending() {
+		// Confirm the release is not actively being operated on.
+		if reason, ok := r.mayBeActive(req.Object, rls); ok {
+			r.locked(req, cur, status, reason)
+			return fmt.Errorf("%w: %s", ErrReleaseLocked, reason)
+		}
+
 		// Update pending status to failed and persist.
 		rls.SetStatus(helmrelease.StatusFailed, fmt.Sprintf("Release unlocked from stale '%s' state", status.String()))
 		if err = cfg.Releases.Update(rls); err != nil {
@@ -103,8 +123,66 @@ const (
 	fmtUnlockFailure = "Unlock of Helm release %s with chart %s in %s state failed: %s"
 	// fmtUnlockSuccess is the message format for a successful unlock.
 	fmtUnlockSuccess = "Unlocked Helm release %s with chart %s in %s state"
+	// fmtUnlockLocked is the message format for a pending release which
+	// may still be operated on.
+	fmtUnlockLocked = "Helm release %s with chart %s in %s state is locked: %s (release lock holder: %s)"
 )
 
+// mayBeActive returns true with a reason if the pending release may still be
+// operated on. This is the case if the release lock was not taken over from
+// a stale holder, and the release has been pending for less than the timeout
+// of the pending action.
+func (r *Unlock) mayBeActive(obj *v2.HelmRelease, rls *helmrelease.Release) (string, bool) {
+	if r.staleLock {
+		return "", false
+	}
+	timeout := pendingTimeout(obj, rls.Info.Status)
+	if age := time.Since(rls.Info.LastDeployed.Time); age < timeout {
+		return fmt.Sprintf("pending for %s, which is less than the timeout of %s",
+			age.Round(time.Second).String(), timeout.String()), true
+	}
+	return "", false
+}
+
+// pendingTimeout returns the timeout of the action for the given pending
+// release status.
+func pendingTimeout(obj *v2.HelmRelease, status helmrelease.Status) time.Duration {
+	switch status {
+	case helmrelease.StatusPendingInstall:
+		return obj.GetInstall().GetTimeout(obj.GetTimeout()).Duration
+	case helmrelease.StatusPendingUpgrade:
+		return obj.GetUpgrade().GetTimeout(obj.GetTimeout()).Duration
+	case helmrelease.StatusPendingRollback:
+		return obj.GetRollback().GetTimeout(obj.GetTimeout()).Duration
+	default:
+		return obj.GetTimeout().Duration
+	}
+}
+
+// locked records a pending release which may still be operated on in the
+// status of the given Request.Object by marking ReleasedCondition=False, and
+// emits an event. The failure counters are not increased.
+func (r *Unlock) locked(req *Request, cur *v2.Snapshot, status helmrelease.Status, reason string) {
+	// Compose locked message.
+	holder := "none"
+	if r.lockHolder != "" {
+		holder = fmt.Sprintf("'%s'", r.lockHolder)
+	}
+	msg := fmt.Sprintf(fmtUnlockLocked, cur.FullReleaseName(), cur.VersionedChartName(), status.String(), reason, holder)
+
+	// Mark locked on object.
+	conditions.MarkFalse(req.Object, v2.ReleasedCondition, v2.LockedReason, msg)
+
+	// Record event.
+	r.eventRecorder.AnnotatedEventf(
+		req.Object,
+		eventMeta(cur.ChartVersion, cur.ConfigDigest, addAppVersion(cur.AppVersion), addOCIDigest(cur.OCIDigest)),
+		corev1.EventTypeWarning,
+		v2.LockedReason,
+		msg,
+	)
+}
+
 // failure records the failure of an unlock action in the status of the given
 // Request.Object by marking ReleasedCondition=False and increasing the failure
 // counter. In addition, it emits a warning event for the Request.Object.
@@ -154,7 +232,10 @@ func observeUnlock(obj *v2.HelmRelease) storage.ObserveFunc {
 		for i := range obj.Status.History {
 			snap := obj.Status.History[i]
 			if snap.Targets(rls.Name, rls.Namespace, rls.Version) {
-				obj.Status.History[i] = release.ObservedToSnapshot(releaseToObservation(rls, snap))
+				newSnap := release.ObservedToSnapshot(releaseToObservation(rls, snap))
+				newSnap.ValuesDelta = snap.ValuesDelta
+				newSnap.Unhealthy = snap.Unhealthy
+				obj.Status.History[i] = newSnap
 				return
 			}
 		}
@@ -170,6 +251,8 @@ func processCurrentSnaphot(obj *v2.HelmRelease, rls *helmrelease.Release) *v2.Sn
 		snap := obj.Status.History[i]
 		if snap.Targets(rls.Name, rls.Namespace, rls.Version) {
 			cur.OCIDigest = snap.OCIDigest
+			cur.ValuesDelta = snap.ValuesDelta
+			cur.Unhealthy = snap.Unhealthy
 		}
 	}
 	return cur
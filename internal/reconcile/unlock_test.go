@@ -28,6 +28,7 @@ import (
 	helmreleaseutil "helm.sh/helm/v3/pkg/releaseutil"
 	helmstorage "helm.sh/helm/v3/pkg/storage"
 	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
@@ -78,6 +79,11 @@ func TestUnlock_Reconcile(t *testing.T) {
 		// expectUpgradeFailures is the expected UpgradeFailures count of the
 		// HelmRelease.
 		expectUpgradeFailures int64
+		// staleLock signals the release lock was taken over from a stale
+		// holder.
+		staleLock bool
+		// lockHolder is the identity of the holder of the release lock.
+		lockHolder string
 	}{
 		{
 			name: "unlock success",
@@ -109,6 +115,75 @@ func TestUnlock_Reconcile(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "pending release within timeout is locked",
+			releases: func(namespace string) []*helmrelease.Release {
+				rls := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: namespace,
+					Version:   1,
+					Chart:     testutil.BuildChart(),
+					Status:    helmrelease.StatusPendingUpgrade,
+				})
+				rls.Info.LastDeployed = helmtime.Now()
+				return []*helmrelease.Release{rls}
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.Timeout = &metav1.Duration{Duration: time.Hour}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+					},
+				}
+			},
+			lockHolder: "controller",
+			wantErr:    ErrReleaseLocked,
+			expectConditions: []metav1.Condition{
+				*conditions.FalseCondition(meta.ReadyCondition, v2.LockedReason, "is locked: pending for"),
+				*conditions.FalseCondition(v2.ReleasedCondition, v2.LockedReason, "(release lock holder: 'controller')"),
+			},
+			expectHistory: func(releases []*helmrelease.Release) v2.Snapshots {
+				return v2.Snapshots{
+					release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+				}
+			},
+		},
+		{
+			name: "pending release within timeout with stale lock is unlocked",
+			releases: func(namespace string) []*helmrelease.Release {
+				rls := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+					Name:      mockReleaseName,
+					Namespace: namespace,
+					Version:   1,
+					Chart:     testutil.BuildChart(),
+					Status:    helmrelease.StatusPendingUpgrade,
+				})
+				rls.Info.LastDeployed = helmtime.Now()
+				return []*helmrelease.Release{rls}
+			},
+			spec: func(spec *v2.HelmReleaseSpec) {
+				spec.Timeout = &metav1.Duration{Duration: time.Hour}
+			},
+			status: func(releases []*helmrelease.Release) v2.HelmReleaseStatus {
+				return v2.HelmReleaseStatus{
+					History: v2.Snapshots{
+						release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+					},
+				}
+			},
+			staleLock: true,
+			expectConditions: []metav1.Condition{
+				*conditions.FalseCondition(meta.ReadyCondition, "PendingRelease", "Unlocked Helm release"),
+				*conditions.FalseCondition(v2.ReleasedCondition, "PendingRelease", "Unlocked Helm release"),
+			},
+			expectHistory: func(releases []*helmrelease.Release) v2.Snapshots {
+				return v2.Snapshots{
+					release.ObservedToSnapshot(release.ObserveRelease(releases[0])),
+				}
+			},
+		},
 		{
 			name: "unlock failure",
 			driver: func(driver helmdriver.Driver) helmdriver.Driver {
@@ -337,7 +412,10 @@ func TestUnlock_Reconcile(t *testing.T) {
 			}
 
 			recorder := new(record.FakeRecorder)
-			got := NewUnlock(cfg, recorder).Reconcile(context.TODO(), &Request{
+			unlock := NewUnlock(cfg, recorder)
+			unlock.staleLock = tt.staleLock
+			unlock.lockHolder = tt.lockHolder
+			got := unlock.Reconcile(context.TODO(), &Request{
 				Object: obj,
 			})
 			if tt.wantErr != nil {
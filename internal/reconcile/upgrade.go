@@ -18,6 +18,7 @@ package reconcile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -25,6 +26,7 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/conditions"
 	"github.com/fluxcd/pkg/runtime/logger"
 
@@ -32,6 +34,7 @@ import (
 	"github.com/fluxcd/helm-controller/internal/action"
 	"github.com/fluxcd/helm-controller/internal/chartutil"
 	"github.com/fluxcd/helm-controller/internal/digest"
+	"github.com/fluxcd/helm-controller/internal/postrender"
 )
 
 // Upgrade is an ActionReconciler which attempts to upgrade a Helm release
@@ -55,6 +58,15 @@ import (
 type Upgrade struct {
 	configFactory *action.ConfigFactory
 	eventRecorder record.EventRecorder
+
+	// permissionCheck enables the check of the permissions required by the
+	// release before the upgrade modifies anything.
+	permissionCheck bool
+	// manifestLimits are the limits enforced on the manifest of the release.
+	manifestLimits postrender.ManifestLimits
+	// maxValuesDeltaChanges is the maximum number of changes of the values
+	// recorded in the snapshot of the release, 0 disables recording them.
+	maxValuesDeltaChanges int
 }
 
 // NewUpgrade returns a new Upgrade reconciler configured with the provided
@@ -78,14 +90,31 @@ func (r *Upgrade) Reconcile(ctx context.Context, req *Request) error {
 	// If we are upgrading, none of the previous conditions apply.
 	conditions.Delete(req.Object, v2.TestSuccessCondition)
 	conditions.Delete(req.Object, v2.RemediatedCondition)
+	conditions.Delete(req.Object, meta.HealthyCondition)
 
 	// Run the Helm upgrade action.
-	_, err := action.Upgrade(ctx, cfg, req.Object, req.Chart, req.Values)
+	opts := []action.UpgradeOption{action.WithUpgradeManifestLimits(r.manifestLimits)}
+	if r.permissionCheck {
+		reviews, err := selfSubjectAccessReviews(r.configFactory)
+		if err != nil {
+			// Without a client, the permissions cannot be confirmed.
+			return &action.InsufficientPermissionsError{Err: err}
+		}
+		opts = append(opts, action.WithUpgradePermissionCheck(ctx, cfg, req.Object, reviews))
+	}
+	_, err := action.Upgrade(ctx, cfg, req.Object, req.Chart, req.Values, opts...)
 
 	// Record the history of releases observed during the upgrade.
-	obsReleases.recordOnObject(req.Object, mutateOCIDigest)
+	obsReleases.recordOnObject(req.Object, r.maxValuesDeltaChanges, mutateOCIDigest)
 
 	if err != nil {
+		// Missing permissions are reported by the caller, and do not count
+		// as a failure as the upgrade did not modify anything.
+		var permErr *action.InsufficientPermissionsError
+		if errors.As(err, &permErr) {
+			return err
+		}
+
 		r.failure(req, logBuf, err)
 
 		// Return error if we did not store a release, as this does not
@@ -104,7 +133,7 @@ func (r *Upgrade) Reconcile(ctx context.Context, req *Request) error {
 		return nil
 	}
 
-	r.success(req)
+	r.success(req, obsReleases)
 	return nil
 }
 
@@ -156,8 +185,9 @@ func (r *Upgrade) failure(req *Request, buffer *action.LogBuffer, err error) {
 // given Request.Object by marking ReleasedCondition=True and emitting an
 // event. In addition, it marks TestSuccessCondition=False when tests are
 // enabled to indicate we are awaiting test results after having made the
-// release.
-func (r *Upgrade) success(req *Request) {
+// release. The event message includes a summary of the changes made by the
+// release, composed out of the observed releases.
+func (r *Upgrade) success(req *Request, observed observedReleases) {
 	// Compose success message.
 	cur := req.Object.Status.History.Latest()
 	msg := fmt.Sprintf(fmtUpgradeSuccess, cur.FullReleaseName(), cur.VersionedChartName())
@@ -175,6 +205,6 @@ func (r *Upgrade) success(req *Request) {
 		eventMeta(cur.ChartVersion, cur.ConfigDigest, addAppVersion(cur.AppVersion), addOCIDigest(cur.OCIDigest)),
 		corev1.EventTypeNormal,
 		v2.UpgradeSucceededReason,
-		msg,
+		eventMessageWithChanges(msg, changeSummary(req.Object, observed)),
 	)
 }
@@ -31,6 +31,7 @@ import (
 	helmstorage "helm.sh/helm/v3/pkg/storage"
 	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
 
@@ -528,7 +529,7 @@ func TestUpgrade_success(t *testing.T) {
 		req := &Request{
 			Object: obj.DeepCopy(),
 		}
-		r.success(req)
+		r.success(req, nil)
 
 		expectMsg := fmt.Sprintf(fmtUpgradeSuccess,
 			fmt.Sprintf("%s/%s.v%d", mockReleaseNamespace, mockReleaseName, obj.Status.History.Latest().Version),
@@ -553,6 +554,42 @@ func TestUpgrade_success(t *testing.T) {
 		}))
 	})
 
+	t.Run("records success with change summary", func(t *testing.T) {
+		g := NewWithT(t)
+
+		recorder := testutil.NewFakeRecorder(10, false)
+		r := &Upgrade{
+			eventRecorder: recorder,
+		}
+
+		prev := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+			Name:      mockReleaseName,
+			Namespace: mockReleaseNamespace,
+			Version:   1,
+			Chart:     testutil.BuildChart(),
+		}, testutil.ReleaseWithConfig(map[string]interface{}{"replicaCount": float64(1), "password": "foo"}))
+		cur := testutil.BuildRelease(&helmrelease.MockReleaseOptions{
+			Name:      mockReleaseName,
+			Namespace: mockReleaseNamespace,
+			Version:   2,
+			Chart:     testutil.BuildChart(testutil.ChartWithVersion("0.2.0")),
+		}, testutil.ReleaseWithConfig(map[string]interface{}{"replicaCount": float64(2), "password": "bar"}))
+
+		obj := obj.DeepCopy()
+		obj.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":2}`)}
+		obj.Status.History = v2.Snapshots{release.ObservedToSnapshot(release.ObserveRelease(cur))}
+
+		req := &Request{Object: obj}
+		r.success(req, observedReleases{
+			prev.Version: release.ObserveRelease(prev),
+			cur.Version:  release.ObserveRelease(cur),
+		})
+
+		events := recorder.GetEvents()
+		g.Expect(events).To(HaveLen(1))
+		g.Expect(events[0].Message).To(HaveSuffix("\n\nChart: hello 0.1.0 -> 0.2.0\nValues:\n  ~ password: (redacted)\n  ~ replicaCount: (redacted) -> 2"))
+	})
+
 	t.Run("records success with TestSuccess=False", func(t *testing.T) {
 		g := NewWithT(t)
 
@@ -565,7 +602,7 @@ func TestUpgrade_success(t *testing.T) {
 		obj.Spec.Test = &v2.Test{Enable: true}
 
 		req := &Request{Object: obj}
-		r.success(req)
+		r.success(req, nil)
 
 		g.Expect(conditions.IsTrue(req.Object, v2.ReleasedCondition)).To(BeTrue())
 
@@ -0,0 +1,344 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// maxChangeSummaryEntries is the maximum number of entries listed per section
+// of a ChangeSummary string, to keep it fit for events and notifications.
+const maxChangeSummaryEntries = 10
+
+// maxValuesDeltaChangeLength is the maximum length of a change recorded in
+// a v2.ValuesDelta, longer changes are truncated.
+const maxValuesDeltaChangeLength = 256
+
+// redactedValue is the placeholder for redacted values.
+const redactedValue = "(redacted)"
+
+// RedactFunc returns true if the value at the given path of the values of
+// a release must not be disclosed.
+type RedactFunc func(path string, value interface{}) bool
+
+// ChangeType is the type of a change.
+type ChangeType string
+
+const (
+	// ChangeTypeAdded is the type of an added value or resource.
+	ChangeTypeAdded ChangeType = "+"
+	// ChangeTypeRemoved is the type of a removed value or resource.
+	ChangeTypeRemoved ChangeType = "-"
+	// ChangeTypeChanged is the type of a changed value or resource.
+	ChangeTypeChanged ChangeType = "~"
+)
+
+// ValueChange is a change of a value at a path of the values of a release.
+type ValueChange struct {
+	Type ChangeType
+	// Path is the path of the value, with map keys separated by dots and
+	// list items indexed in brackets.
+	Path string
+	// Old is the formatted previous value, empty if added, or the redacted
+	// placeholder if it must not be disclosed.
+	Old string
+	// New is the formatted new value, empty if removed, or the redacted
+	// placeholder if it must not be disclosed.
+	New string
+	// Redacted is true if none of the values may be disclosed.
+	Redacted bool
+}
+
+// String returns the change formatted as e.g. `~ image.tag: "1.0" -> "1.1"`.
+func (c ValueChange) String() string {
+	switch {
+	case c.Redacted:
+		return fmt.Sprintf("%s %s: %s", c.Type, c.Path, redactedValue)
+	case c.Type == ChangeTypeAdded:
+		return fmt.Sprintf("%s %s: %s", c.Type, c.Path, c.New)
+	case c.Type == ChangeTypeRemoved:
+		return fmt.Sprintf("%s %s: %s", c.Type, c.Path, c.Old)
+	default:
+		return fmt.Sprintf("%s %s: %s -> %s", c.Type, c.Path, c.Old, c.New)
+	}
+}
+
+// ResourceChange is a change of a resource in the manifest of a release.
+type ResourceChange struct {
+	Type ChangeType
+	// Resource is the resource formatted as Kind/[namespace/]name.
+	Resource string
+}
+
+// String returns the change formatted as e.g. `+ Deployment/default/podinfo`.
+func (c ResourceChange) String() string {
+	return fmt.Sprintf("%s %s", c.Type, c.Resource)
+}
+
+// ChangeSummary summarizes the changes between two releases.
+type ChangeSummary struct {
+	// Chart is the name of the chart of the new release.
+	Chart string
+	// PreviousVersion is the chart version of the previous release, empty
+	// if there is no previous release.
+	PreviousVersion string
+	// Version is the chart version of the new release.
+	Version string
+	// Values are the changes of the values, sorted by path.
+	Values []ValueChange
+	// Resources are the changes of the resources, sorted by resource.
+	Resources []ResourceChange
+}
+
+// IsZero returns true if the summary holds no changes.
+func (s ChangeSummary) IsZero() bool {
+	return s.PreviousVersion == s.Version && len(s.Values) == 0 && len(s.Resources) == 0
+}
+
+// String returns the summary formatted for humans, with at most
+// maxChangeSummaryEntries entries per section.
+func (s ChangeSummary) String() string {
+	var b strings.Builder
+	switch {
+	case s.PreviousVersion == "":
+		fmt.Fprintf(&b, "Chart: %s %s", s.Chart, s.Version)
+	case s.PreviousVersion != s.Version:
+		fmt.Fprintf(&b, "Chart: %s %s -> %s", s.Chart, s.PreviousVersion, s.Version)
+	default:
+		fmt.Fprintf(&b, "Chart: %s %s (unchanged)", s.Chart, s.Version)
+	}
+
+	if len(s.Values) > 0 {
+		b.WriteString("\nValues:")
+		for i, c := range s.Values {
+			if i == maxChangeSummaryEntries {
+				fmt.Fprintf(&b, "\n  ... and %d more", len(s.Values)-i)
+				break
+			}
+			b.WriteString("\n  " + c.String())
+		}
+	}
+
+	if len(s.Resources) > 0 {
+		b.WriteString("\nResources:")
+		for i, c := range s.Resources {
+			if i == maxChangeSummaryEntries {
+				fmt.Fprintf(&b, "\n  ... and %d more", len(s.Resources)-i)
+				break
+			}
+			b.WriteString("\n  " + c.String())
+		}
+	}
+	return b.String()
+}
+
+// SummarizeChanges returns a summary of the changes of the chart version,
+// values and resources of the current release compared to the previous
+// release. The previous release may be nil, in which case all resources are
+// summarized as added and the values are not summarized. Values for which
+// redact returns true are not disclosed.
+func SummarizeChanges(prev *Observation, cur Observation, redact RedactFunc) ChangeSummary {
+	summary := ChangeSummary{
+		Chart:   cur.ChartMetadata.Name,
+		Version: cur.ChartMetadata.Version,
+	}
+
+	var prevManifest string
+	if prev != nil {
+		summary.PreviousVersion = prev.ChartMetadata.Version
+		summary.Values = diffValues(prev.Config, cur.Config, redact)
+		prevManifest = prev.Manifest
+	}
+	summary.Resources = diffManifests(prevManifest, cur.Manifest)
+	return summary
+}
+
+// ValuesDelta returns the changes of the values of the current release
+// compared to the previous release, recording at most maxChanges changes of
+// at most maxValuesDeltaChangeLength characters. Values for which redact
+// returns true are not disclosed.
+func ValuesDelta(prev, cur Observation, redact RedactFunc, maxChanges int) *v2.ValuesDelta {
+	delta := &v2.ValuesDelta{PreviousVersion: prev.Version}
+	for i, c := range diffValues(prev.Config, cur.Config, redact) {
+		if i == maxChanges {
+			delta.Truncated = true
+			break
+		}
+		change := c.String()
+		if r := []rune(change); len(r) > maxValuesDeltaChangeLength {
+			change = string(r[:maxValuesDeltaChangeLength-3]) + "..."
+			delta.Truncated = true
+		}
+		delta.Changes = append(delta.Changes, change)
+	}
+	return delta
+}
+
+// diffValues returns the changes between the flattened old and new values.
+func diffValues(old, new map[string]interface{}, redact RedactFunc) []ValueChange {
+	oldFlat, newFlat := flattenValues(old), flattenValues(new)
+
+	var changes []ValueChange
+	for path, n := range newFlat {
+		o, ok := oldFlat[path]
+		switch {
+		case !ok:
+			changes = append(changes, valueChange(ChangeTypeAdded, path, nil, n, redact))
+		case !reflect.DeepEqual(o, n):
+			changes = append(changes, valueChange(ChangeTypeChanged, path, o, n, redact))
+		}
+	}
+	for path, o := range oldFlat {
+		if _, ok := newFlat[path]; !ok {
+			changes = append(changes, valueChange(ChangeTypeRemoved, path, o, nil, redact))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// valueChange returns a ValueChange for the given values, of which each
+// value for which redact returns true is replaced by the redacted
+// placeholder. If all values are redacted, the change is Redacted.
+func valueChange(t ChangeType, path string, old, new interface{}, redact RedactFunc) ValueChange {
+	c := ValueChange{Type: t, Path: path}
+	format := func(v interface{}) (string, bool) {
+		if redact != nil && redact(path, v) {
+			return redactedValue, true
+		}
+		return formatValue(v), false
+	}
+	oldRedacted, newRedacted := old == nil, new == nil
+	if old != nil {
+		c.Old, oldRedacted = format(old)
+	}
+	if new != nil {
+		c.New, newRedacted = format(new)
+	}
+	if oldRedacted && newRedacted {
+		return ValueChange{Type: t, Path: path, Redacted: true}
+	}
+	return c
+}
+
+// formatValue returns the JSON representation of the value.
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// flattenValues returns the leaf values of the given values indexed by
+// their path. Map keys are separated by dots, and list items are indexed
+// with their position in brackets, e.g. "env[0].value". Empty maps and
+// lists are considered leaves.
+func flattenValues(values map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			if len(val) > 0 || path == "" {
+				for k, item := range val {
+					p := k
+					if path != "" {
+						p = path + "." + k
+					}
+					walk(p, item)
+				}
+				return
+			}
+		case []interface{}:
+			if len(val) > 0 {
+				for i, item := range val {
+					walk(fmt.Sprintf("%s[%d]", path, i), item)
+				}
+				return
+			}
+		}
+		flat[path] = v
+	}
+	walk("", values)
+	return flat
+}
+
+// manifestResource is the identifying part of a resource in a manifest.
+type manifestResource struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// diffManifests returns the resources added, removed or changed in the new
+// manifest compared to the old manifest.
+func diffManifests(old, new string) []ResourceChange {
+	oldRes, newRes := manifestResources(old), manifestResources(new)
+
+	var changes []ResourceChange
+	for id, n := range newRes {
+		o, ok := oldRes[id]
+		switch {
+		case !ok:
+			changes = append(changes, ResourceChange{Type: ChangeTypeAdded, Resource: id})
+		case o != n:
+			changes = append(changes, ResourceChange{Type: ChangeTypeChanged, Resource: id})
+		}
+	}
+	for id := range oldRes {
+		if _, ok := newRes[id]; !ok {
+			changes = append(changes, ResourceChange{Type: ChangeTypeRemoved, Resource: id})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Resource < changes[j].Resource
+	})
+	return changes
+}
+
+// manifestResources returns the documents of the manifest indexed by the
+// resource they describe, formatted as Kind/[namespace/]name. Documents which
+// do not describe a resource are ignored.
+func manifestResources(manifest string) map[string]string {
+	resources := make(map[string]string)
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		var res manifestResource
+		if err := yaml.Unmarshal([]byte(doc), &res); err != nil || res.Kind == "" || res.Metadata.Name == "" {
+			continue
+		}
+		id := res.Kind + "/" + res.Metadata.Name
+		if res.Metadata.Namespace != "" {
+			id = res.Kind + "/" + res.Metadata.Namespace + "/" + res.Metadata.Name
+		}
+		resources[id] = strings.TrimSpace(doc)
+	}
+	return resources
+}
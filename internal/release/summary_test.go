@@ -0,0 +1,266 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+const (
+	summaryManifestV1 = `---
+# Source: podinfo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: podinfo
+spec:
+  ports:
+  - port: 9898
+---
+# Source: podinfo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  replicas: 1
+---
+# Source: podinfo/templates/hpa.yaml
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: podinfo
+`
+	summaryManifestV2 = `---
+# Source: podinfo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: podinfo
+spec:
+  ports:
+  - port: 9898
+---
+# Source: podinfo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  replicas: 2
+---
+# Source: podinfo/templates/ingress.yaml
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: podinfo
+  namespace: apps
+`
+)
+
+func TestSummarizeChanges(t *testing.T) {
+	prev := &Observation{
+		ChartMetadata: chart.Metadata{Name: "podinfo", Version: "6.5.3"},
+		Config: map[string]interface{}{
+			"replicaCount": 1,
+			"image":        map[string]interface{}{"tag": "6.5.3"},
+			"auth":         map[string]interface{}{"password": "old"},
+			"hpa":          map[string]interface{}{"enabled": true},
+		},
+		Manifest: summaryManifestV1,
+	}
+	cur := Observation{
+		ChartMetadata: chart.Metadata{Name: "podinfo", Version: "6.5.4"},
+		Config: map[string]interface{}{
+			"replicaCount": 2,
+			"image":        map[string]interface{}{"tag": "6.5.4"},
+			"auth":         map[string]interface{}{"password": "new"},
+			"ingress":      map[string]interface{}{"hosts": []interface{}{"podinfo.example.com"}},
+		},
+		Manifest: summaryManifestV2,
+	}
+	redact := func(path string, _ interface{}) bool {
+		return strings.HasSuffix(path, "password")
+	}
+
+	t.Run("summarizes an upgrade", func(t *testing.T) {
+		g := NewWithT(t)
+
+		summary := SummarizeChanges(prev, cur, redact)
+		g.Expect(summary.IsZero()).To(BeFalse())
+		g.Expect(summary.Values).To(Equal([]ValueChange{
+			{Type: ChangeTypeChanged, Path: "auth.password", Redacted: true},
+			{Type: ChangeTypeRemoved, Path: "hpa.enabled", Old: "true"},
+			{Type: ChangeTypeChanged, Path: "image.tag", Old: `"6.5.3"`, New: `"6.5.4"`},
+			{Type: ChangeTypeAdded, Path: "ingress.hosts[0]", New: `"podinfo.example.com"`},
+			{Type: ChangeTypeChanged, Path: "replicaCount", Old: "1", New: "2"},
+		}))
+		g.Expect(summary.Resources).To(Equal([]ResourceChange{
+			{Type: ChangeTypeChanged, Resource: "Deployment/apps/podinfo"},
+			{Type: ChangeTypeRemoved, Resource: "HorizontalPodAutoscaler/podinfo"},
+			{Type: ChangeTypeAdded, Resource: "Ingress/apps/podinfo"},
+		}))
+		g.Expect(summary.String()).To(Equal(`Chart: podinfo 6.5.3 -> 6.5.4
+Values:
+  ~ auth.password: (redacted)
+  - hpa.enabled: true
+  ~ image.tag: "6.5.3" -> "6.5.4"
+  + ingress.hosts[0]: "podinfo.example.com"
+  ~ replicaCount: 1 -> 2
+Resources:
+  ~ Deployment/apps/podinfo
+  - HorizontalPodAutoscaler/podinfo
+  + Ingress/apps/podinfo`))
+	})
+
+	t.Run("summarizes an install", func(t *testing.T) {
+		g := NewWithT(t)
+
+		summary := SummarizeChanges(nil, cur, redact)
+		g.Expect(summary.Values).To(BeEmpty())
+		g.Expect(summary.String()).To(Equal(`Chart: podinfo 6.5.4
+Resources:
+  + Deployment/apps/podinfo
+  + Ingress/apps/podinfo
+  + Service/podinfo`))
+	})
+
+	t.Run("summarizes no changes", func(t *testing.T) {
+		g := NewWithT(t)
+
+		summary := SummarizeChanges(&cur, cur, redact)
+		g.Expect(summary.IsZero()).To(BeTrue())
+		g.Expect(summary.String()).To(Equal("Chart: podinfo 6.5.4 (unchanged)"))
+	})
+
+	t.Run("limits the number of entries", func(t *testing.T) {
+		g := NewWithT(t)
+
+		values := make(map[string]interface{})
+		for i := 0; i < maxChangeSummaryEntries+5; i++ {
+			values[fmt.Sprintf("key%02d", i)] = i
+		}
+		summary := SummarizeChanges(&Observation{ChartMetadata: cur.ChartMetadata},
+			Observation{ChartMetadata: cur.ChartMetadata, Config: values}, nil)
+		g.Expect(summary.Values).To(HaveLen(maxChangeSummaryEntries + 5))
+		g.Expect(summary.String()).To(HaveSuffix("\n  + key09: 9\n  ... and 5 more"))
+	})
+}
+
+func Test_flattenValues(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(flattenValues(map[string]interface{}{
+		"image": map[string]interface{}{"tag": "6.5.4"},
+		"env": []interface{}{
+			map[string]interface{}{"name": "FOO", "value": "bar"},
+			"plain",
+			[]interface{}{1, 2},
+		},
+		"empty":     map[string]interface{}{},
+		"emptyList": []interface{}{},
+	})).To(Equal(map[string]interface{}{
+		"image.tag":    "6.5.4",
+		"env[0].name":  "FOO",
+		"env[0].value": "bar",
+		"env[1]":       "plain",
+		"env[2][0]":    1,
+		"env[2][1]":    2,
+		"empty":        map[string]interface{}{},
+		"emptyList":    []interface{}{},
+	}))
+}
+
+func TestValuesDelta(t *testing.T) {
+	prev := Observation{
+		Version: 1,
+		Config:  map[string]interface{}{"image": map[string]interface{}{"tag": "6.5.3"}, "token": "foo"},
+	}
+
+	t.Run("records the changes", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cur := Observation{
+			Version: 2,
+			Config:  map[string]interface{}{"image": map[string]interface{}{"tag": "6.5.4"}, "token": "bar"},
+		}
+		redact := func(path string, _ interface{}) bool {
+			return path == "token"
+		}
+		g.Expect(ValuesDelta(prev, cur, redact, 10)).To(Equal(&v2.ValuesDelta{
+			PreviousVersion: 1,
+			Changes: []string{
+				`~ image.tag: "6.5.3" -> "6.5.4"`,
+				"~ token: (redacted)",
+			},
+		}))
+	})
+
+	t.Run("redacts the values separately", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cur := Observation{
+			Version: 2,
+			Config:  map[string]interface{}{"image": map[string]interface{}{"tag": "6.5.4"}},
+		}
+		redact := func(path string, value interface{}) bool {
+			return value != "6.5.4"
+		}
+		g.Expect(ValuesDelta(prev, cur, redact, 10)).To(Equal(&v2.ValuesDelta{
+			PreviousVersion: 1,
+			Changes: []string{
+				`~ image.tag: (redacted) -> "6.5.4"`,
+				"- token: (redacted)",
+			},
+		}))
+	})
+
+	t.Run("truncates the number of changes", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cur := Observation{Version: 2}
+		g.Expect(ValuesDelta(prev, cur, nil, 1)).To(Equal(&v2.ValuesDelta{
+			PreviousVersion: 1,
+			Changes:         []string{`- image.tag: "6.5.3"`},
+			Truncated:       true,
+		}))
+	})
+
+	t.Run("truncates long changes", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cur := Observation{
+			Version: 2,
+			Config:  map[string]interface{}{"image": map[string]interface{}{"tag": "6.5.3"}, "token": "foo", "long": strings.Repeat("ä", 300)},
+		}
+		delta := ValuesDelta(prev, cur, nil, 10)
+		g.Expect(delta.Truncated).To(BeTrue())
+		g.Expect(delta.Changes).To(HaveLen(1))
+		g.Expect([]rune(delta.Changes[0])).To(HaveLen(maxValuesDeltaChangeLength))
+		g.Expect(delta.Changes[0]).To(HavePrefix(`+ long: "ää`))
+		g.Expect(delta.Changes[0]).To(HaveSuffix("ä..."))
+	})
+}
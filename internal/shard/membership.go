@@ -0,0 +1,335 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shard
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// DefaultMembershipDuration is the default duration of the Lease of a
+// member of a shard group.
+const DefaultMembershipDuration = 30 * time.Second
+
+// GroupLabelKey is the label set on the Lease of a member of a shard group,
+// to the name of the group.
+var GroupLabelKey = LabelKey + "-group"
+
+// Membership is a Sharder which partitions objects dynamically between the
+// members of a shard group. Every member holds a Lease labeled with the name
+// of the group, which it renews at a third of the duration. Members which
+// fail to renew their Lease within the duration are considered to have left
+// the group, and the objects they owned are reassigned to the remaining
+// members using rendezvous hashing.
+//
+// To prevent two members from owning the same object while the group
+// changes, ownership is handed off: a member which joins the group only takes
+// ownership of objects once its Lease has been held for one full duration,
+// while the other members release the objects assigned to it as soon as
+// they observe its Lease. In addition, a member which has not synced the
+// group within the duration owns no objects, as the other members may
+// consider it to have left.
+//
+// Membership implements manager.Runnable, and must be run by every replica
+// of the controller, independent of leader election.
+type Membership struct {
+	client    client.Client
+	namespace string
+	group     string
+	identity  string
+	duration  time.Duration
+
+	// members are the members of the group, including members which are
+	// joining.
+	members []string
+	// active are the members which have held their Lease for at least one
+	// duration, and may take ownership of objects.
+	active []string
+	// synced is the time of the last successful sync of the group.
+	synced time.Time
+	// suspended indicates the member has left the group until resumed.
+	suspended bool
+	mu        sync.RWMutex
+
+	// nowFunc returns the current time, and can be overridden in tests.
+	nowFunc func() time.Time
+
+	changes chan event.GenericEvent
+}
+
+// NewMembership returns a new Membership of the given identity in the shard
+// group, with the member Leases stored in the given namespace. A duration of
+// 0 or less defaults to DefaultMembershipDuration.
+func NewMembership(c client.Client, namespace, group, identity string, duration time.Duration) *Membership {
+	if duration <= 0 {
+		duration = DefaultMembershipDuration
+	}
+	return &Membership{
+		client:    c,
+		namespace: namespace,
+		group:     group,
+		identity:  identity,
+		duration:  duration,
+		changes:   make(chan event.GenericEvent, 1),
+		nowFunc:   time.Now,
+	}
+}
+
+// Name returns the identity of the member.
+func (m *Membership) Name() string {
+	return m.identity
+}
+
+// Owns returns true if the object is owned by this member. An object is
+// owned if it is assigned to this member by both the members of the group
+// including and excluding the members which are joining. It returns false
+// until the member has held its Lease for one duration, and when the group
+// has not been synced within the duration.
+func (m *Membership) Owns(obj client.Object) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.suspended || m.synced.IsZero() || m.nowFunc().Sub(m.synced) >= m.duration {
+		return false
+	}
+	uid := string(obj.GetUID())
+	return Owner(uid, m.members) == m.identity && Owner(uid, m.active) == m.identity
+}
+
+// Members returns the current members of the group.
+func (m *Membership) Members() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.members)
+}
+
+// Suspend makes the member leave the group on the next sync when suspended
+// is true, for example while the replica is under memory pressure, so the
+// other members take over its objects. While suspended, the member owns no
+// objects and does not renew its Lease. When resumed, the member joins the
+// group again.
+func (m *Membership) Suspend(suspended bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.suspended = suspended
+}
+
+// Changes returns a channel which receives an event when the members of the
+// group change, to trigger the reconciliation of objects which may have been
+// reassigned. The channel is buffered, and consecutive changes which are not
+// consumed are coalesced.
+func (m *Membership) Changes() <-chan event.GenericEvent {
+	return m.changes
+}
+
+// NeedLeaderElection returns false, as every replica must be a member of the
+// group.
+func (m *Membership) NeedLeaderElection() bool {
+	return false
+}
+
+// Start joins the group, and keeps the Lease of the member renewed and the
+// members of the group up-to-date until the context is cancelled. The Lease
+// is deleted when leaving the group, to allow the remaining members to take
+// over immediately.
+func (m *Membership) Start(ctx context.Context) error {
+	log := ctrl.LoggerFrom(ctx).WithValues("group", m.group, "identity", m.identity)
+
+	ticker := time.NewTicker(m.duration / 3)
+	defer ticker.Stop()
+	for {
+		if err := m.Sync(ctx); err != nil {
+			log.Error(err, "failed to sync shard group membership")
+		}
+		select {
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := m.leave(leaveCtx); err != nil {
+				log.Error(err, "failed to leave shard group")
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync renews the Lease of the member, and updates the members of the group
+// from the Leases which have not expired. If the members changed, an event
+// is sent to the Changes channel. While suspended, it deletes the Lease of
+// the member instead.
+func (m *Membership) Sync(ctx context.Context) error {
+	m.mu.RLock()
+	suspended, left := m.suspended, m.members == nil && m.synced.IsZero()
+	m.mu.RUnlock()
+	if suspended {
+		if left {
+			return nil
+		}
+		return m.leave(ctx)
+	}
+
+	now := metav1.NewMicroTime(m.nowFunc())
+	renewErr := m.renew(ctx, now)
+
+	leases := &coordinationv1.LeaseList{}
+	if err := m.client.List(ctx, leases, client.InNamespace(m.namespace),
+		client.MatchingLabels{GroupLabelKey: m.group}); err != nil {
+		return fmt.Errorf("failed to list shard group Leases: %w", err)
+	}
+
+	var members, active []string
+	for _, l := range leases.Items {
+		holder := ptr.Deref(l.Spec.HolderIdentity, "")
+		if holder == "" || m.expired(&l, now.Time) {
+			continue
+		}
+		// Exclude ourselves if we failed to renew, as the other members
+		// may already consider us to have left.
+		if holder == m.identity && renewErr != nil {
+			continue
+		}
+		members = append(members, holder)
+		if !m.joining(&l, now.Time) {
+			active = append(active, holder)
+		}
+	}
+	slices.Sort(members)
+	members = slices.Compact(members)
+	slices.Sort(active)
+	active = slices.Compact(active)
+
+	m.mu.Lock()
+	changed := !slices.Equal(m.members, members) || !slices.Equal(m.active, active)
+	m.members, m.active = members, active
+	if renewErr == nil {
+		m.synced = now.Time
+	}
+	m.mu.Unlock()
+
+	if changed {
+		select {
+		case m.changes <- event.GenericEvent{Object: &coordinationv1.Lease{}}:
+		default:
+		}
+	}
+	return renewErr
+}
+
+// renew creates or renews the Lease of the member.
+func (m *Membership) renew(ctx context.Context, now metav1.MicroTime) error {
+	key := client.ObjectKey{Namespace: m.namespace, Name: m.leaseName()}
+	lease := &coordinationv1.Lease{}
+	if err := m.client.Get(ctx, key, lease); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get shard Lease '%s': %w", key, err)
+		}
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels:    map[string]string{GroupLabelKey: m.group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(m.identity),
+				LeaseDurationSeconds: ptr.To(m.durationSeconds()),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if err = m.client.Create(ctx, lease); err != nil {
+			return fmt.Errorf("failed to create shard Lease '%s': %w", key, err)
+		}
+		return nil
+	}
+
+	// Rejoin the group if the Lease expired, or was held by another
+	// identity.
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != m.identity || m.expired(lease, now.Time) {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = ptr.To(m.identity)
+	lease.Spec.LeaseDurationSeconds = ptr.To(m.durationSeconds())
+	lease.Spec.RenewTime = &now
+	if err := m.client.Update(ctx, lease); err != nil {
+		return fmt.Errorf("failed to renew shard Lease '%s': %w", key, err)
+	}
+	return nil
+}
+
+// leave deletes the Lease of the member.
+func (m *Membership) leave(ctx context.Context) error {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.leaseName(),
+			Namespace: m.namespace,
+		},
+	}
+	if err := m.client.Delete(ctx, lease); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete shard Lease: %w", err)
+	}
+
+	m.mu.Lock()
+	m.members, m.active = nil, nil
+	m.synced = time.Time{}
+	m.mu.Unlock()
+	return nil
+}
+
+// leaseName returns the name of the Lease of the member.
+func (m *Membership) leaseName() string {
+	return fmt.Sprintf("%s-shard-%s", m.group, m.identity)
+}
+
+// expired returns true if the Lease has not been renewed within its
+// duration.
+func (m *Membership) expired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil {
+		return true
+	}
+	duration := m.duration
+	if lease.Spec.LeaseDurationSeconds != nil && *lease.Spec.LeaseDurationSeconds > 0 {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
+
+// joining returns true if the Lease has been acquired less than one
+// duration ago, in which case its holder must not take ownership of objects
+// until all other members have observed it.
+func (m *Membership) joining(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.AcquireTime == nil {
+		return false
+	}
+	return lease.Spec.AcquireTime.Add(m.duration).After(now)
+}
+
+// durationSeconds returns the duration of the Lease in seconds, rounded up.
+func (m *Membership) durationSeconds() int32 {
+	return int32(math.Ceil(m.duration.Seconds()))
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestClient(objs ...client.Object) client.Client {
+	s := runtime.NewScheme()
+	_ = coordinationv1.AddToScheme(s)
+	return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+}
+
+func newMemberLease(group, holder string, renewTime time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      group + "-shard-" + holder,
+			Namespace: "flux-system",
+			Labels:    map[string]string{GroupLabelKey: group},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(holder),
+			LeaseDurationSeconds: ptr.To(int32(30)),
+			RenewTime:            &metav1.MicroTime{Time: renewTime},
+		},
+	}
+}
+
+func TestMembership_Sync(t *testing.T) {
+	g := NewWithT(t)
+
+	c := newTestClient(
+		newMemberLease("helm", "other", time.Now()),
+		newMemberLease("helm", "expired", time.Now().Add(-time.Minute)),
+		newMemberLease("other-group", "foreign", time.Now()),
+	)
+	m := NewMembership(c, "flux-system", "helm", "self", 0)
+	g.Expect(m.Owns(newObject("uid"))).To(BeFalse())
+
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+	g.Expect(m.Members()).To(Equal([]string{"other", "self"}))
+	g.Expect(m.Changes()).To(Receive())
+
+	lease := &coordinationv1.Lease{}
+	g.Expect(c.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "helm-shard-self"}, lease)).To(Succeed())
+	g.Expect(lease.Labels).To(HaveKeyWithValue(GroupLabelKey, "helm"))
+	g.Expect(ptr.Deref(lease.Spec.HolderIdentity, "")).To(Equal("self"))
+
+	// Objects are owned by exactly one member, once the joining member has
+	// held its Lease for one duration.
+	other := NewMembership(c, "flux-system", "helm", "other", 0)
+	g.Expect(other.Sync(context.TODO())).To(Succeed())
+	now := time.Now()
+	m.nowFunc = func() time.Time { return now }
+	other.nowFunc = m.nowFunc
+	for i := 0; i < 3; i++ {
+		now = now.Add(DefaultMembershipDuration / 2)
+		g.Expect(m.Sync(context.TODO())).To(Succeed())
+		g.Expect(other.Sync(context.TODO())).To(Succeed())
+	}
+	g.Expect(m.Changes()).To(Receive())
+	for _, uid := range []string{"a", "b", "c", "d"} {
+		obj := newObject(k8stypes.UID(uid))
+		g.Expect(m.Owns(obj)).ToNot(Equal(other.Owns(obj)))
+	}
+
+	// An unchanged group does not send an event.
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+	g.Expect(m.Changes()).ToNot(Receive())
+}
+
+func TestMembership_leave(t *testing.T) {
+	g := NewWithT(t)
+
+	c := newTestClient()
+	m := NewMembership(c, "flux-system", "helm", "self", 0)
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+	g.Expect(m.Owns(newObject("uid"))).To(BeFalse())
+	now := time.Now()
+	m.nowFunc = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		now = now.Add(DefaultMembershipDuration / 2)
+		g.Expect(m.Sync(context.TODO())).To(Succeed())
+	}
+	g.Expect(m.Owns(newObject("uid"))).To(BeTrue())
+
+	g.Expect(m.leave(context.TODO())).To(Succeed())
+	g.Expect(m.Members()).To(BeEmpty())
+	err := c.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "helm-shard-self"}, &coordinationv1.Lease{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+}
+
+func TestMembership_handoff(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now().Truncate(time.Second)
+	c := newTestClient()
+	a := NewMembership(c, "flux-system", "helm", "a", 0)
+	a.nowFunc = func() time.Time { return now }
+	b := NewMembership(c, "flux-system", "helm", "b", 0)
+	b.nowFunc = a.nowFunc
+
+	var objs []client.Object
+	for _, uid := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		objs = append(objs, newObject(k8stypes.UID(uid)))
+	}
+	owned := func(m *Membership) (n int) {
+		for _, obj := range objs {
+			if m.Owns(obj) {
+				n++
+			}
+		}
+		return
+	}
+	exclusive := func() {
+		for _, obj := range objs {
+			g.Expect(a.Owns(obj) && b.Owns(obj)).To(BeFalse(), string(obj.GetUID()))
+		}
+	}
+
+	// A is the only active member.
+	for i := 0; i < 3; i++ {
+		g.Expect(a.Sync(context.TODO())).To(Succeed())
+		now = now.Add(DefaultMembershipDuration / 2)
+	}
+	g.Expect(a.Sync(context.TODO())).To(Succeed())
+	g.Expect(owned(a)).To(Equal(len(objs)))
+
+	// B joins, but does not take ownership before A has observed it.
+	g.Expect(b.Sync(context.TODO())).To(Succeed())
+	g.Expect(owned(b)).To(BeZero())
+	exclusive()
+
+	// A releases the objects assigned to B as soon as it observes B.
+	now = now.Add(DefaultMembershipDuration / 3)
+	g.Expect(a.Sync(context.TODO())).To(Succeed())
+	g.Expect(owned(a)).To(BeNumerically("<", len(objs)))
+	g.Expect(b.Sync(context.TODO())).To(Succeed())
+	g.Expect(owned(b)).To(BeZero())
+	exclusive()
+
+	// B takes ownership after holding its Lease for one duration.
+	now = now.Add(DefaultMembershipDuration / 3)
+	g.Expect(a.Sync(context.TODO())).To(Succeed())
+	g.Expect(b.Sync(context.TODO())).To(Succeed())
+	g.Expect(owned(b)).To(BeZero())
+	now = now.Add(DefaultMembershipDuration / 3)
+	g.Expect(b.Sync(context.TODO())).To(Succeed())
+	exclusive()
+	g.Expect(a.Sync(context.TODO())).To(Succeed())
+	exclusive()
+	g.Expect(owned(a) + owned(b)).To(Equal(len(objs)))
+
+	// A member which has not synced within the duration owns no objects.
+	now = now.Add(DefaultMembershipDuration)
+	g.Expect(owned(a)).To(BeZero())
+	g.Expect(owned(b)).To(BeZero())
+}
+
+func TestMembership_Suspend(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now().Truncate(time.Second)
+	c := newTestClient()
+	m := NewMembership(c, "flux-system", "helm", "self", 0)
+	m.nowFunc = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		g.Expect(m.Sync(context.TODO())).To(Succeed())
+		now = now.Add(DefaultMembershipDuration / 2)
+	}
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+	g.Expect(m.Owns(newObject("uid"))).To(BeTrue())
+
+	// A suspended member owns no objects, and leaves the group.
+	m.Suspend(true)
+	g.Expect(m.Owns(newObject("uid"))).To(BeFalse())
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+	key := client.ObjectKey{Namespace: "flux-system", Name: "helm-shard-self"}
+	err := c.Get(context.TODO(), key, &coordinationv1.Lease{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+
+	// A resumed member joins the group again.
+	m.Suspend(false)
+	g.Expect(m.Sync(context.TODO())).To(Succeed())
+	g.Expect(c.Get(context.TODO(), key, &coordinationv1.Lease{})).To(Succeed())
+	g.Expect(m.Owns(newObject("uid"))).To(BeFalse())
+}
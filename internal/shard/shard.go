@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shard provides the partitioning of HelmRelease objects between
+// multiple replicas of the controller, either using a static number of
+// shards, or dynamically between the replicas which are members of a shard
+// group.
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// LabelKey is the label set on an object to the name of the shard which
+// owns it.
+var LabelKey = v2.GroupVersion.Group + "/shard"
+
+// Sharder determines whether an object is owned by the shard of this
+// replica of the controller.
+type Sharder interface {
+	// Name returns the name of the shard of this replica.
+	Name() string
+	// Owns returns true if the object is owned by the shard.
+	Owns(obj client.Object) bool
+}
+
+// Static is a Sharder which partitions objects between a fixed number of
+// shards, by consistently hashing the UID of the object.
+type Static struct {
+	id    int
+	count int
+}
+
+// NewStatic returns a new Static Sharder for the shard with the given ID,
+// out of the given number of shards. It returns an error if the count is
+// less than 1, or the ID is not in the range [0, count).
+func NewStatic(id, count int) (*Static, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("invalid shard count %d: must be at least 1", count)
+	}
+	if id < 0 || id >= count {
+		return nil, fmt.Errorf("invalid shard ID %d: must be in the range [0, %d)", id, count)
+	}
+	return &Static{id: id, count: count}, nil
+}
+
+// Name returns the ID of the shard.
+func (s *Static) Name() string {
+	return strconv.Itoa(s.id)
+}
+
+// Owns returns true if the hash of the UID of the object maps to the ID of
+// the shard.
+func (s *Static) Owns(obj client.Object) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(obj.GetUID()))
+	return int(h.Sum32()%uint32(s.count)) == s.id
+}
+
+// Owner returns the member which owns the object with the given UID, using
+// rendezvous hashing. This ensures that when a member joins or leaves, only
+// the objects owned by the member are moved. It returns an empty string if
+// there are no members.
+func Owner(uid string, members []string) string {
+	var (
+		owner string
+		max   uint64
+	)
+	for _, m := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(m))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(uid))
+		if w := mix(h.Sum64()); owner == "" || w > max || (w == max && m < owner) {
+			owner, max = m, w
+		}
+	}
+	return owner
+}
+
+// mix finalizes the given hash to improve the distribution of its bits, as
+// FNV hashes of inputs which only differ in a few bytes are similar.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
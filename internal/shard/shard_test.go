@@ -0,0 +1,115 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+func newObject(uid types.UID) *v2.HelmRelease {
+	return &v2.HelmRelease{ObjectMeta: metav1.ObjectMeta{UID: uid}}
+}
+
+func TestNewStatic(t *testing.T) {
+	tests := []struct {
+		id, count int
+		wantErr   bool
+	}{
+		{id: 0, count: 1},
+		{id: 2, count: 3},
+		{id: 0, count: 0, wantErr: true},
+		{id: 3, count: 3, wantErr: true},
+		{id: -1, count: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.id, tt.count), func(t *testing.T) {
+			g := NewWithT(t)
+
+			s, err := NewStatic(tt.id, tt.count)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(s.Name()).To(Equal(fmt.Sprint(tt.id)))
+		})
+	}
+}
+
+func TestStatic_Owns(t *testing.T) {
+	g := NewWithT(t)
+
+	const count = 3
+	var shards []*Static
+	for i := 0; i < count; i++ {
+		s, err := NewStatic(i, count)
+		g.Expect(err).ToNot(HaveOccurred())
+		shards = append(shards, s)
+	}
+
+	owned := make([]int, count)
+	for i := 0; i < 300; i++ {
+		obj := newObject(uuid.NewUUID())
+		var owners int
+		for j, s := range shards {
+			if s.Owns(obj) {
+				owners++
+				owned[j]++
+			}
+		}
+		g.Expect(owners).To(Equal(1))
+	}
+	for _, n := range owned {
+		g.Expect(n).To(BeNumerically(">", 50))
+	}
+}
+
+func TestOwner(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Owner("uid", nil)).To(BeEmpty())
+	g.Expect(Owner("uid", []string{"a"})).To(Equal("a"))
+
+	members := []string{"a", "b", "c"}
+	counts := map[string]int{}
+	var moved int
+	for i := 0; i < 300; i++ {
+		uid := string(uuid.NewUUID())
+		owner := Owner(uid, members)
+		counts[owner]++
+
+		// Removing a member only moves the objects it owned.
+		after := Owner(uid, []string{"a", "c"})
+		if owner != "b" {
+			g.Expect(after).To(Equal(owner))
+		} else {
+			moved++
+		}
+	}
+	g.Expect(moved).To(Equal(counts["b"]))
+	for _, m := range members {
+		g.Expect(counts[m]).To(BeNumerically(">", 50), m)
+	}
+}
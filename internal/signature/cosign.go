@@ -0,0 +1,111 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	sigstoresignature "github.com/sigstore/sigstore/pkg/signature"
+)
+
+// cosignPublicKeySuffix is the suffix of the keys holding Cosign public keys.
+const cosignPublicKeySuffix = ".pub"
+
+// CosignVerifier verifies a chart using a key-based Cosign signature, stored
+// in the OCI registry next to the chart.
+type CosignVerifier struct {
+	// checkOpts holds the Cosign verification options for each of the
+	// trusted keys.
+	checkOpts []cosign.CheckOpts
+	options   *options
+}
+
+// NewCosignVerifier returns a CosignVerifier trusting the PEM encoded public
+// keys in the values of the given data with a ".pub" key suffix.
+func NewCosignVerifier(keys map[string][]byte, o *options) (*CosignVerifier, error) {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		if strings.HasSuffix(name, cosignPublicKeySuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	v := &CosignVerifier{options: o}
+	for _, name := range names {
+		pub, err := cryptoutils.UnmarshalPEMToPublicKey(keys[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key '%s': %w", name, err)
+		}
+		verifier, err := sigstoresignature.LoadVerifier(pub, crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load public key '%s': %w", name, err)
+		}
+		v.checkOpts = append(v.checkOpts, cosign.CheckOpts{
+			SigVerifier:   verifier,
+			ClaimVerifier: cosign.SimpleClaimVerifier,
+			// Signatures made with a static key are verified offline, without
+			// requiring a transparency log entry.
+			Offline:    true,
+			IgnoreTlog: true,
+		})
+	}
+	if len(v.checkOpts) == 0 {
+		return nil, fmt.Errorf("no public keys with '%s' suffix found", cosignPublicKeySuffix)
+	}
+	return v, nil
+}
+
+// Verify verifies the artifact has at least one Cosign signature made with
+// one of the trusted keys.
+func (v *CosignVerifier) Verify(ctx context.Context, artifact *Artifact) error {
+	if artifact.Repository == "" || artifact.Digest == "" {
+		return errors.New("cosign verification requires a chart pulled from an OCI registry")
+	}
+	ref, err := artifact.reference(v.options.plainHTTP)
+	if err != nil {
+		return err
+	}
+
+	registryOpts := []ociremote.Option{
+		ociremote.WithRemoteOptions(
+			remote.WithContext(ctx),
+			remote.WithAuthFromKeychain(v.options.keychain),
+			remote.WithTransport(v.options.transport),
+		),
+	}
+
+	var errs []error
+	for _, opts := range v.checkOpts {
+		opts.RegistryClientOpts = registryOpts
+		if _, _, err = cosign.VerifyImageSignatures(ctx, ref, &opts); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no valid Cosign signature found for '%s': %w", ref, errors.Join(errs...))
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+	"github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+func TestCosignVerifier_Verify(t *testing.T) {
+	g := NewWithT(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repository := newTestRegistry(t) + "/charts/chart"
+	chart := pushTestArtifact(t, repository, "1.0.0")
+	unsigned := pushTestArtifact(t, repository, "2.0.0")
+	wrongKey := pushTestArtifact(t, repository, "3.0.0")
+	wrongDigest := pushTestArtifact(t, repository, "4.0.0")
+
+	pushCosignSignature(t, repository, chart, chart, key)
+	pushCosignSignature(t, repository, wrongKey, wrongKey, otherKey)
+	pushCosignSignature(t, repository, wrongDigest, chart, key)
+
+	v, err := NewVerifier(ProviderCosign, map[string][]byte{
+		"cosign.pub": publicKeyPEM(t, key.Public()),
+		"ignored":    []byte("not a key"),
+	}, WithPlainHTTP(true))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		wantErr  string
+	}{
+		{
+			name:     "valid signature",
+			artifact: &Artifact{Repository: repository, Digest: chart},
+		},
+		{
+			name:     "no signature",
+			artifact: &Artifact{Repository: repository, Digest: unsigned},
+			wantErr:  "no signatures found",
+		},
+		{
+			name:     "signed with untrusted key",
+			artifact: &Artifact{Repository: repository, Digest: wrongKey},
+			wantErr:  "no matching signatures",
+		},
+		{
+			name:     "signature for other digest",
+			artifact: &Artifact{Repository: repository, Digest: wrongDigest},
+			wantErr:  "no matching signatures",
+		},
+		{
+			name:     "not an OCI artifact",
+			artifact: &Artifact{Chart: []byte("chart")},
+			wantErr:  "requires a chart pulled from an OCI registry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := v.Verify(context.TODO(), tt.artifact)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestNewCosignVerifier(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewCosignVerifier(map[string][]byte{"key": []byte("ignored")}, &options{})
+	g.Expect(err).To(MatchError(ContainSubstring("no public keys with '.pub' suffix found")))
+
+	_, err = NewCosignVerifier(map[string][]byte{"cosign.pub": []byte("invalid")}, &options{})
+	g.Expect(err).To(MatchError(ContainSubstring("failed to parse public key 'cosign.pub'")))
+}
+
+// pushCosignSignature pushes a Cosign signature for the manifest with the
+// given digest, signing a payload for the given payload digest with the key.
+func pushCosignSignature(t *testing.T, repository, digest, payloadDigest string, key *ecdsa.PrivateKey) {
+	t.Helper()
+
+	ref, err := name.NewDigest(repository+"@"+digest, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadRef, err := name.NewDigest(repository+"@"+payloadDigest, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := payload.Cosign{Image: payloadRef}.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociSig, err := static.NewSignature(data, base64.StdEncoding.EncodeToString(sig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	se, err := ociremote.SignedEntity(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err = mutate.AttachSignatureToEntity(se, ociSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ociremote.WriteSignatures(ref.Context(), se); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publicKeyPEM returns the PEM encoded public key.
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // Helm provenance files use OpenPGP.
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// HelmVerifier verifies a chart using its Helm provenance file, against a
+// set of trusted OpenPGP keys.
+type HelmVerifier struct {
+	keyRing openpgp.EntityList
+}
+
+// NewHelmVerifier returns a HelmVerifier trusting the keys from the OpenPGP
+// keyrings in the given data. Keyrings may be armored or binary.
+func NewHelmVerifier(keys map[string][]byte) (*HelmVerifier, error) {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ring openpgp.EntityList
+	for _, name := range names {
+		data := keys[name]
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			if entities, err = openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("failed to read OpenPGP keyring '%s': %w", name, err)
+			}
+		}
+		ring = append(ring, entities...)
+	}
+	if len(ring) == 0 {
+		return nil, errors.New("no OpenPGP keys found")
+	}
+	return &HelmVerifier{keyRing: ring}, nil
+}
+
+// Verify verifies the signature of the provenance file of the artifact, and
+// that the chart archive matches the checksum recorded in it.
+func (v *HelmVerifier) Verify(_ context.Context, artifact *Artifact) error {
+	if len(artifact.Provenance) == 0 {
+		return errors.New("no provenance file available for chart")
+	}
+
+	// The provenance file records the checksum of the chart archive by its
+	// file name, which is derived from the chart metadata.
+	c, err := loader.LoadArchive(bytes.NewReader(artifact.Chart))
+	if err != nil {
+		return fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "chart-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	chartPath := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", c.Name(), c.Metadata.Version))
+	if err = os.WriteFile(chartPath, artifact.Chart, 0o600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(chartPath+".prov", artifact.Provenance, 0o600); err != nil {
+		return err
+	}
+
+	signatory := &provenance.Signatory{KeyRing: v.keyRing}
+	if _, err = signatory.Verify(chartPath, chartPath+".prov"); err != nil {
+		return fmt.Errorf("failed to verify provenance: %w", err)
+	}
+	return nil
+}
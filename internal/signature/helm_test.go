@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestHelmVerifier_Verify(t *testing.T) {
+	g := NewWithT(t)
+
+	keyring, err := os.ReadFile("testdata/helm-test-key.pub")
+	g.Expect(err).ToNot(HaveOccurred())
+	chart, err := os.ReadFile("testdata/hashtest-1.2.3.tgz")
+	g.Expect(err).ToNot(HaveOccurred())
+	prov, err := os.ReadFile("testdata/hashtest-1.2.3.tgz.prov")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	v, err := NewHelmVerifier(map[string][]byte{"pubring.gpg": keyring})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Repackage the chart with different values, but the same name and
+	// version.
+	c, err := loader.LoadArchive(bytes.NewReader(chart))
+	g.Expect(err).ToNot(HaveOccurred())
+	c.Values = map[string]interface{}{"tampered": true}
+	tamperedPath, err := chartutil.Save(c, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	tampered, err := os.ReadFile(tamperedPath)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		wantErr  string
+	}{
+		{
+			name:     "valid provenance",
+			artifact: &Artifact{Chart: chart, Provenance: prov},
+		},
+		{
+			name:     "missing provenance",
+			artifact: &Artifact{Chart: chart},
+			wantErr:  "no provenance file available",
+		},
+		{
+			name:     "invalid provenance",
+			artifact: &Artifact{Chart: chart, Provenance: []byte("invalid")},
+			wantErr:  "failed to verify provenance",
+		},
+		{
+			name:     "invalid chart",
+			artifact: &Artifact{Chart: []byte("invalid"), Provenance: prov},
+			wantErr:  "failed to load chart",
+		},
+		{
+			name:     "chart checksum mismatch",
+			artifact: &Artifact{Chart: tampered, Provenance: prov},
+			wantErr:  "sha256 sum does not match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := v.Verify(context.TODO(), tt.artifact)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestNewHelmVerifier(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewHelmVerifier(nil)
+	g.Expect(err).To(MatchError("no OpenPGP keys found"))
+
+	_, err = NewHelmVerifier(map[string][]byte{"invalid": []byte("invalid")})
+	g.Expect(err).To(MatchError(ContainSubstring("failed to read OpenPGP keyring 'invalid'")))
+}
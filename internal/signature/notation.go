@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/notaryproject/notation-go"
+	"github.com/notaryproject/notation-go/registry"
+	"github.com/notaryproject/notation-go/verifier"
+	"github.com/notaryproject/notation-go/verifier/trustpolicy"
+	"github.com/notaryproject/notation-go/verifier/truststore"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+const (
+	// notationTrustPolicyKey is the key of the Notation trust policy in the
+	// verification data.
+	notationTrustPolicyKey = "trustpolicy.json"
+	// notationMaxSignatureAttempts is the maximum number of signatures
+	// processed when verifying an artifact.
+	notationMaxSignatureAttempts = 3
+)
+
+// NotationVerifier verifies a chart using a Notation signature, stored in the
+// OCI registry as a referrer of the chart. The signature is verified against
+// the trust policy, using the trusted root certificates.
+type NotationVerifier struct {
+	verifier notation.Verifier
+	options  *options
+}
+
+// NewNotationVerifier returns a NotationVerifier using the Notation trust
+// policy in the "trustpolicy.json" value of the given data, and trusting the
+// PEM encoded root certificates in the values with a ".crt" or ".pem" key
+// suffix. The certificates are made available to every trust store named in
+// the trust policy.
+func NewNotationVerifier(keys map[string][]byte, o *options) (*NotationVerifier, error) {
+	data, ok := keys[notationTrustPolicyKey]
+	if !ok {
+		return nil, fmt.Errorf("no Notation trust policy found in '%s'", notationTrustPolicyKey)
+	}
+	var policy trustpolicy.Document
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to decode Notation trust policy: %w", err)
+	}
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		if strings.HasSuffix(name, ".crt") || strings.HasSuffix(name, ".pem") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	store := &trustStore{}
+	for _, name := range names {
+		certs, err := cryptoutils.UnmarshalCertificatesFromPEM(keys[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificates from '%s': %w", name, err)
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificates found in '%s'", name)
+		}
+		store.certs = append(store.certs, certs...)
+	}
+	if len(store.certs) == 0 {
+		return nil, errors.New("no certificates with '.crt' or '.pem' suffix found")
+	}
+
+	v, err := verifier.New(&policy, store, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Notation trust policy: %w", err)
+	}
+	return &NotationVerifier{verifier: v, options: o}, nil
+}
+
+// Verify verifies the artifact has at least one Notation signature which
+// satisfies the trust policy.
+func (v *NotationVerifier) Verify(ctx context.Context, artifact *Artifact) error {
+	if artifact.Repository == "" || artifact.Digest == "" {
+		return errors.New("notation verification requires a chart pulled from an OCI registry")
+	}
+	ref, err := artifact.reference(v.options.plainHTTP)
+	if err != nil {
+		return err
+	}
+
+	repo, err := remote.NewRepository(ref.Context().Name())
+	if err != nil {
+		return fmt.Errorf("invalid OCI repository '%s': %w", artifact.Repository, err)
+	}
+	repo.PlainHTTP = v.options.plainHTTP
+	repo.Client = &auth.Client{
+		Client:     &http.Client{Transport: v.options.transport},
+		Credential: keychainCredential(v.options.keychain),
+		Cache:      auth.NewCache(),
+	}
+
+	_, outcomes, err := notation.Verify(ctx, v.verifier, registry.NewRepository(repo), notation.VerifyOptions{
+		ArtifactReference:    ref.String(),
+		MaxSignatureAttempts: notationMaxSignatureAttempts,
+	})
+	if err != nil {
+		return fmt.Errorf("no valid Notation signature found for '%s': %w", ref, err)
+	}
+	for _, outcome := range outcomes {
+		if outcome.VerificationLevel == trustpolicy.LevelSkip {
+			return fmt.Errorf("signature verification of '%s' is skipped by the Notation trust policy", ref)
+		}
+	}
+	return nil
+}
+
+// trustStore is a truststore.X509TrustStore returning the same certificates
+// for all named stores.
+type trustStore struct {
+	certs []*x509.Certificate
+}
+
+// GetCertificates returns the trusted certificates.
+func (s *trustStore) GetCertificates(_ context.Context, _ truststore.Type, _ string) ([]*x509.Certificate, error) {
+	return s.certs, nil
+}
+
+// keychainCredential returns an auth.CredentialFunc resolving the credentials
+// for a registry using the given keychain.
+func keychainCredential(keychain authn.Keychain) auth.CredentialFunc {
+	return func(_ context.Context, hostport string) (auth.Credential, error) {
+		reg, err := name.NewRegistry(hostport)
+		if err != nil {
+			return auth.EmptyCredential, err
+		}
+		authenticator, err := keychain.Resolve(reg)
+		if err != nil {
+			return auth.EmptyCredential, err
+		}
+		cfg, err := authenticator.Authorization()
+		if err != nil {
+			return auth.EmptyCredential, err
+		}
+		return auth.Credential{
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			RefreshToken: cfg.IdentityToken,
+			AccessToken:  cfg.RegistryToken,
+		}, nil
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/notaryproject/notation-core-go/signature/jws"
+	"github.com/notaryproject/notation-go"
+	"github.com/notaryproject/notation-go/registry"
+	"github.com/notaryproject/notation-go/signer"
+	. "github.com/onsi/gomega"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+func TestNotationVerifier_Verify(t *testing.T) {
+	g := NewWithT(t)
+
+	root, rootKey := newTestCertificate(t, "root", nil, nil)
+	leaf, leafKey := newTestCertificate(t, "leaf", root, rootKey)
+	otherRoot, otherRootKey := newTestCertificate(t, "other", nil, nil)
+	otherLeaf, otherLeafKey := newTestCertificate(t, "other-leaf", otherRoot, otherRootKey)
+
+	host := newTestRegistry(t)
+	repository := host + "/charts/chart"
+	chart := pushTestArtifact(t, repository, "1.0.0")
+	unsigned := pushTestArtifact(t, repository, "2.0.0")
+	untrusted := pushTestArtifact(t, repository, "3.0.0")
+	otherRepository := host + "/charts/other"
+	outOfScope := pushTestArtifact(t, otherRepository, "1.0.0")
+
+	pushNotationSignature(t, repository, chart, []*x509.Certificate{leaf, root}, leafKey)
+	pushNotationSignature(t, repository, untrusted, []*x509.Certificate{otherLeaf, otherRoot}, otherLeafKey)
+	pushNotationSignature(t, otherRepository, outOfScope, []*x509.Certificate{leaf, root}, leafKey)
+
+	v, err := NewVerifier(ProviderNotation, map[string][]byte{
+		"ca.crt":           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+		"trustpolicy.json": testTrustPolicy(t, "strict", repository),
+	}, WithPlainHTTP(true))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		wantErr  string
+	}{
+		{
+			name:     "valid signature",
+			artifact: &Artifact{Repository: repository, Digest: chart},
+		},
+		{
+			name:     "no signature",
+			artifact: &Artifact{Repository: repository, Digest: unsigned},
+			wantErr:  "no signature is associated",
+		},
+		{
+			name:     "signed with untrusted certificate",
+			artifact: &Artifact{Repository: repository, Digest: untrusted},
+			wantErr:  "no valid Notation signature found",
+		},
+		{
+			name:     "repository not in trust policy scope",
+			artifact: &Artifact{Repository: otherRepository, Digest: outOfScope},
+			wantErr:  "no applicable trust policy",
+		},
+		{
+			name:     "not an OCI artifact",
+			artifact: &Artifact{Chart: []byte("chart")},
+			wantErr:  "requires a chart pulled from an OCI registry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := v.Verify(context.TODO(), tt.artifact)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+
+	t.Run("skipped verification", func(t *testing.T) {
+		g := NewWithT(t)
+
+		v, err := NewVerifier(ProviderNotation, map[string][]byte{
+			"ca.crt":           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+			"trustpolicy.json": testTrustPolicy(t, "skip", repository),
+		}, WithPlainHTTP(true))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		err = v.Verify(context.TODO(), &Artifact{Repository: repository, Digest: unsigned})
+		g.Expect(err).To(MatchError(ContainSubstring("skipped by the Notation trust policy")))
+	})
+}
+
+func TestNewNotationVerifier(t *testing.T) {
+	g := NewWithT(t)
+
+	root, _ := newTestCertificate(t, "root", nil, nil)
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+
+	_, err := NewNotationVerifier(map[string][]byte{"ca.crt": rootPEM}, &options{})
+	g.Expect(err).To(MatchError(ContainSubstring("no Notation trust policy found")))
+
+	_, err = NewNotationVerifier(map[string][]byte{"trustpolicy.json": testTrustPolicy(t, "strict", "example.com/chart")}, &options{})
+	g.Expect(err).To(MatchError(ContainSubstring("no certificates")))
+
+	_, err = NewNotationVerifier(map[string][]byte{
+		"trustpolicy.json": testTrustPolicy(t, "strict", "example.com/chart"),
+		"ca.crt":           []byte("invalid"),
+	}, &options{})
+	g.Expect(err).To(MatchError(ContainSubstring("'ca.crt'")))
+
+	_, err = NewNotationVerifier(map[string][]byte{
+		"trustpolicy.json": []byte(`{"version":"1.0","trustPolicies":[]}`),
+		"ca.crt":           rootPEM,
+	}, &options{})
+	g.Expect(err).To(MatchError(ContainSubstring("invalid Notation trust policy")))
+}
+
+// testTrustPolicy returns a Notation trust policy with the given signature
+// verification level, scoped to the given repository.
+func testTrustPolicy(t *testing.T, level, repository string) []byte {
+	t.Helper()
+
+	policy := map[string]interface{}{
+		"name":                  "test",
+		"registryScopes":        []string{repository},
+		"signatureVerification": map[string]string{"level": level},
+	}
+	if level != "skip" {
+		policy["trustStores"] = []string{"ca:test"}
+		policy["trustedIdentities"] = []string{"*"}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"version":       "1.0",
+		"trustPolicies": []map[string]interface{}{policy},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newTestCertificate returns a new certificate and key. When no parent is
+// given, a self-signed CA certificate is returned. Otherwise, a code signing
+// certificate issued by the parent is returned.
+func newTestCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Flux"}, Country: []string{"NL"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// pushNotationSignature signs the manifest with the given digest using the
+// certificate chain and key, and pushes the signature as a referrer of it.
+func pushNotationSignature(t *testing.T, repository, digest string, chain []*x509.Certificate, key *ecdsa.PrivateKey) {
+	t.Helper()
+
+	s, err := signer.New(key, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := remote.NewRepository(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.PlainHTTP = true
+
+	if _, err = notation.Sign(context.TODO(), s, registry.NewRepository(repo), notation.SignOptions{
+		SignerSignOptions: notation.SignerSignOptions{SignatureMediaType: jws.MediaTypeEnvelope},
+		ArtifactReference: repository + "@" + digest,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	digestlib "github.com/opencontainers/go-digest"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+const (
+	// ProviderHelm verifies a chart using its Helm provenance file.
+	ProviderHelm = "helm"
+	// ProviderCosign verifies a chart using a Cosign signature stored in the
+	// OCI registry next to the chart.
+	ProviderCosign = "cosign"
+	// ProviderNotation verifies a chart using a Notation signature stored in
+	// the OCI registry as a referrer of the chart.
+	ProviderNotation = "notation"
+)
+
+// Artifact holds the information about a chart artifact required to verify
+// its authenticity.
+type Artifact struct {
+	// Chart is the chart archive data.
+	Chart []byte
+	// Provenance is the Helm provenance file data of the chart, if
+	// available.
+	Provenance []byte
+	// Repository is the OCI repository the chart was pulled from, without
+	// the scheme, tag or digest. For example,
+	// "ghcr.io/stefanprodan/charts/podinfo". Empty if the chart was not
+	// pulled from an OCI registry.
+	Repository string
+	// Digest is the digest of the OCI manifest of the chart. Empty if the
+	// chart was not pulled from an OCI registry.
+	Digest string
+}
+
+// Verifier verifies the authenticity of a chart artifact.
+type Verifier interface {
+	// Verify returns an error if the authenticity of the artifact can not
+	// be verified.
+	Verify(ctx context.Context, artifact *Artifact) error
+}
+
+// Option is a function that configures a Verifier.
+type Option func(*options)
+
+// options holds the options for a Verifier.
+type options struct {
+	keychain  authn.Keychain
+	transport http.RoundTripper
+	plainHTTP bool
+}
+
+// WithKeychain configures the keychain used to authenticate to the OCI
+// registry when fetching signatures.
+func WithKeychain(keychain authn.Keychain) Option {
+	return func(o *options) {
+		o.keychain = keychain
+	}
+}
+
+// WithTransport configures the HTTP transport used to fetch signatures from
+// the OCI registry, for example to trust a custom certificate authority.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithPlainHTTP configures the use of plain HTTP instead of HTTPS to fetch
+// signatures from the OCI registry.
+func WithPlainHTTP(plainHTTP bool) Option {
+	return func(o *options) {
+		o.plainHTTP = plainHTTP
+	}
+}
+
+// NewVerifier returns a Verifier for the given provider, trusting the keys
+// found in the given data (typically the data of a Kubernetes Secret).
+//
+// For the "helm" provider, each value is expected to be an (armored) OpenPGP
+// keyring. For the "cosign" provider, values with a ".pub" key suffix are
+// expected to be PEM encoded public keys. For the "notation" provider, the
+// "trustpolicy.json" value is expected to be a Notation trust policy, and
+// values with a ".crt" or ".pem" key suffix are expected to be PEM encoded
+// root certificates.
+func NewVerifier(provider string, keys map[string][]byte, opts ...Option) (Verifier, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.keychain == nil {
+		o.keychain = authn.NewMultiKeychain()
+	}
+	if o.transport == nil {
+		o.transport = http.DefaultTransport
+	}
+
+	switch provider {
+	case ProviderHelm:
+		return NewHelmVerifier(keys)
+	case ProviderCosign:
+		return NewCosignVerifier(keys, o)
+	case ProviderNotation:
+		return NewNotationVerifier(keys, o)
+	default:
+		return nil, fmt.Errorf("unsupported verification provider '%s'", provider)
+	}
+}
+
+// VerifyChartLayer verifies the chart data of the artifact is the Helm chart
+// layer of the OCI manifest with the digest of the artifact. This binds a
+// chart which was not pulled from the registry by the caller, for example
+// an artifact produced by source-controller, to the manifest of which the
+// signature was verified.
+func VerifyChartLayer(ctx context.Context, artifact *Artifact, opts ...Option) error {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.keychain == nil {
+		o.keychain = authn.NewMultiKeychain()
+	}
+	if o.transport == nil {
+		o.transport = http.DefaultTransport
+	}
+
+	ref, err := artifact.reference(o.plainHTTP)
+	if err != nil {
+		return err
+	}
+	img, err := remote.Image(ref, remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(o.keychain), remote.WithTransport(o.transport))
+	if err != nil {
+		return fmt.Errorf("failed to get OCI manifest '%s': %w", ref, err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get OCI manifest '%s': %w", ref, err)
+	}
+
+	for _, l := range manifest.Layers {
+		if l.MediaType != registry.ChartLayerMediaType && l.MediaType != registry.LegacyChartLayerMediaType {
+			continue
+		}
+		layerDigest, err := digestlib.Parse(l.Digest.String())
+		if err != nil {
+			return fmt.Errorf("invalid chart layer digest '%s' in OCI manifest '%s': %w", l.Digest, ref, err)
+		}
+		if got := layerDigest.Algorithm().FromBytes(artifact.Chart); got != layerDigest {
+			return fmt.Errorf("chart digest '%s' does not match the chart layer digest '%s' of OCI manifest '%s'",
+				got, layerDigest, ref)
+		}
+		return nil
+	}
+	return fmt.Errorf("OCI manifest '%s' does not contain a layer with media type '%s'", ref, registry.ChartLayerMediaType)
+}
+
+// reference returns the digest reference of the artifact in the OCI
+// registry.
+func (a *Artifact) reference(plainHTTP bool) (name.Digest, error) {
+	var opts []name.Option
+	if plainHTTP {
+		opts = append(opts, name.Insecure)
+	}
+	ref, err := name.NewDigest(a.Repository+"@"+a.Digest, opts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("invalid OCI reference '%s@%s': %w", a.Repository, a.Digest, err)
+	}
+	return ref, nil
+}
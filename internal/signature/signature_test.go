@@ -0,0 +1,200 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	helmregistry "helm.sh/helm/v3/pkg/registry"
+)
+
+func TestNewVerifier(t *testing.T) {
+	keyring, err := os.ReadFile("testdata/helm-test-key.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		keys     map[string][]byte
+		opts     []Option
+		want     interface{}
+		wantErr  string
+	}{
+		{
+			name:     "helm",
+			provider: ProviderHelm,
+			keys:     map[string][]byte{"pubring.gpg": keyring},
+			want:     &HelmVerifier{},
+		},
+		{
+			name:     "cosign",
+			provider: ProviderCosign,
+			keys:     map[string][]byte{"cosign.pub": publicKeyPEM(t, key.Public())},
+			want:     &CosignVerifier{},
+		},
+		{
+			name:     "notation without trust policy",
+			provider: ProviderNotation,
+			keys:     map[string][]byte{"ca.crt": []byte("ignored")},
+			wantErr:  "no Notation trust policy found",
+		},
+		{
+			name:     "unsupported provider",
+			provider: "unknown",
+			wantErr:  "unsupported verification provider 'unknown'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := NewVerifier(tt.provider, tt.keys, tt.opts...)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(BeAssignableToTypeOf(tt.want))
+		})
+	}
+}
+
+func TestVerifyChartLayer(t *testing.T) {
+	repository := newTestRegistry(t) + "/charts/chart"
+	chart := pushTestChart(t, repository, "1.0.0", []byte("chart"))
+	other := pushTestArtifact(t, repository, "2.0.0")
+
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		wantErr  string
+	}{
+		{
+			name:     "chart layer matches",
+			artifact: &Artifact{Chart: []byte("chart"), Repository: repository, Digest: chart},
+		},
+		{
+			name:     "chart layer mismatch",
+			artifact: &Artifact{Chart: []byte("tampered"), Repository: repository, Digest: chart},
+			wantErr:  "does not match the chart layer digest",
+		},
+		{
+			name:     "no chart layer",
+			artifact: &Artifact{Chart: []byte("chart"), Repository: repository, Digest: other},
+			wantErr:  "does not contain a layer with media type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := VerifyChartLayer(context.TODO(), tt.artifact, WithPlainHTTP(true))
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+// newTestRegistry starts an in-memory OCI registry with support for the
+// referrers API, and returns its host.
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true)))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// pushTestArtifact pushes a random image to the repository with the given
+// tag, and returns the digest of its manifest.
+func pushTestArtifact(t *testing.T, repository, tag string) string {
+	t.Helper()
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewTag(repository+":"+tag, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	d, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.String()
+}
+
+// pushTestChart pushes an image with the given data as Helm chart layer to
+// the repository with the given tag, and returns the digest of its manifest.
+func pushTestChart(t *testing.T, repository, tag string, data []byte) string {
+	t.Helper()
+
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer(data, types.MediaType(helmregistry.ChartLayerMediaType)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewTag(repository+":"+tag, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	d, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.String()
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing provides OpenTelemetry tracing of the reconciliation of
+// HelmReleases, with spans for the steps of a reconciliation and the trace
+// context propagated into requests to the Kubernetes API.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// TracerName is the name of the tracer used to create spans.
+const TracerName = "github.com/fluxcd/helm-controller"
+
+const (
+	// ExporterOTLPGRPC exports spans using OTLP over gRPC.
+	ExporterOTLPGRPC = "otlp-grpc"
+	// ExporterOTLPHTTP exports spans using OTLP over HTTP.
+	ExporterOTLPHTTP = "otlp-http"
+)
+
+const (
+	// AttrNamespace is the namespace of the HelmRelease.
+	AttrNamespace = attribute.Key("helmrelease.namespace")
+	// AttrName is the name of the HelmRelease.
+	AttrName = attribute.Key("helmrelease.name")
+	// AttrReleaseNamespace is the namespace of the Helm release.
+	AttrReleaseNamespace = attribute.Key("helm.release.namespace")
+	// AttrReleaseName is the name of the Helm release.
+	AttrReleaseName = attribute.Key("helm.release.name")
+	// AttrCluster is the name of the cluster released to.
+	AttrCluster = attribute.Key("helmrelease.cluster")
+	// AttrChartName is the name of the chart.
+	AttrChartName = attribute.Key("helm.chart.name")
+	// AttrChartVersion is the version of the chart.
+	AttrChartVersion = attribute.Key("helm.chart.version")
+	// AttrAction is the name of the Helm action.
+	AttrAction = attribute.Key("helm.action")
+	// AttrActionType is the type of the Helm action.
+	AttrActionType = attribute.Key("helm.action.type")
+	// AttrReleaseStatus is the determined status of the Helm release.
+	AttrReleaseStatus = attribute.Key("helm.release.status")
+	// AttrDriftChanges is the number of objects which drifted from the
+	// desired state.
+	AttrDriftChanges = attribute.Key("helm.drift.changes")
+)
+
+// Options configures the export of spans.
+type Options struct {
+	// Exporter is the exporter to use, either ExporterOTLPGRPC or
+	// ExporterOTLPHTTP. When empty, tracing is disabled.
+	Exporter string
+	// Endpoint is the endpoint of the collector to export to. When empty,
+	// the endpoint is configured by the OTEL_EXPORTER_OTLP_ENDPOINT
+	// environment variable, or defaults to localhost.
+	Endpoint string
+	// Insecure disables TLS for the connection to the collector.
+	Insecure bool
+	// SampleRatio is the ratio of traces to sample, between 0 and 1.
+	SampleRatio float64
+}
+
+// Enabled returns true if an exporter is configured.
+func (o Options) Enabled() bool {
+	return o.Exporter != ""
+}
+
+// Validate returns an error if the options are invalid.
+func (o Options) Validate() error {
+	switch o.Exporter {
+	case "", ExporterOTLPGRPC, ExporterOTLPHTTP:
+	default:
+		return fmt.Errorf("unsupported tracing exporter '%s', must be one of '%s' or '%s'",
+			o.Exporter, ExporterOTLPGRPC, ExporterOTLPHTTP)
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio %v, must be between 0 and 1", o.SampleRatio)
+	}
+	return nil
+}
+
+// Setup constructs the configured exporter and registers a TracerProvider
+// exporting to it as the global provider. It returns a function to flush
+// and shut down the provider. When tracing is disabled, it is a no-op.
+func Setup(ctx context.Context, opts Options, serviceName string) (func(context.Context) error, error) {
+	if !opts.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch opts.Exporter {
+	case ExporterOTLPGRPC:
+		var clientOpts []otlptracegrpc.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, clientOpts...)
+	case ExporterOTLPHTTP:
+		var clientOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOpts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct %s exporter: %w", opts.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	Register(tp)
+	return tp.Shutdown, nil
+}
+
+// Register registers the given TracerProvider as the global provider, and
+// configures the propagation of the W3C trace context. Tests can use it to
+// register a provider exporting to an in-memory exporter.
+func Register(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Start starts a span with the given name and attributes, as a child of any
+// span in the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ObjectAttributes returns the attributes identifying the HelmRelease and
+// its Helm release.
+func ObjectAttributes(obj *v2.HelmRelease) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		AttrNamespace.String(obj.GetNamespace()),
+		AttrName.String(obj.GetName()),
+		AttrReleaseNamespace.String(obj.GetReleaseNamespace()),
+		AttrReleaseName.String(obj.GetReleaseName()),
+	}
+}
+
+// WrapTransport wraps the given http.RoundTripper to create a span for
+// requests made as part of a trace, and to propagate the trace context
+// to the server. Requests made outside of a trace, e.g. by informers, are
+// not traced.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(rt,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanContextFromContext(r.Context()).IsValid()
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "HTTP " + r.Method
+		}),
+	)
+}
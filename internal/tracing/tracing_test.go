@@ -0,0 +1,152 @@
+/*
+Copyright 2024 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+func registerInMemory(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	Register(tp)
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{name: "disabled", opts: Options{}},
+		{name: "otlp-grpc", opts: Options{Exporter: ExporterOTLPGRPC, SampleRatio: 1}},
+		{name: "otlp-http", opts: Options{Exporter: ExporterOTLPHTTP, SampleRatio: 0.5}},
+		{name: "unsupported exporter", opts: Options{Exporter: "zipkin"}, wantErr: "unsupported tracing exporter"},
+		{name: "invalid sample ratio", opts: Options{Exporter: ExporterOTLPGRPC, SampleRatio: 2}, wantErr: "invalid tracing sample ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := tt.opts.Validate()
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	g := NewWithT(t)
+
+	shutdown, err := Setup(context.Background(), Options{}, "helm-controller")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(shutdown(context.Background())).To(Succeed())
+
+	_, err = Setup(context.Background(), Options{Exporter: "zipkin"}, "helm-controller")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestStartEnd(t *testing.T) {
+	g := NewWithT(t)
+	exporter := registerInMemory(t)
+
+	obj := &v2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "podinfo"},
+		Spec:       v2.HelmReleaseSpec{TargetNamespace: "apps"},
+	}
+
+	ctx, parent := Start(context.Background(), "reconcile", ObjectAttributes(obj)...)
+	_, child := Start(ctx, "helm upgrade", AttrAction.String("upgrade"))
+	End(child, errors.New("upgrade failed"))
+	End(parent, nil)
+
+	spans := exporter.GetSpans()
+	g.Expect(spans).To(HaveLen(2))
+
+	g.Expect(spans[0].Name).To(Equal("helm upgrade"))
+	g.Expect(spans[0].Parent.SpanID()).To(Equal(spans[1].SpanContext.SpanID()))
+	g.Expect(spans[0].Status.Code).To(Equal(codes.Error))
+	g.Expect(spans[0].Status.Description).To(Equal("upgrade failed"))
+	g.Expect(spans[0].Events).To(HaveLen(1))
+	g.Expect(spans[0].Attributes).To(ContainElement(AttrAction.String("upgrade")))
+
+	g.Expect(spans[1].Name).To(Equal("reconcile"))
+	g.Expect(spans[1].Status.Code).To(Equal(codes.Unset))
+	g.Expect(spans[1].Attributes).To(ContainElements(
+		AttrNamespace.String("flux-system"),
+		AttrName.String("podinfo"),
+		AttrReleaseNamespace.String("apps"),
+		AttrReleaseName.String("apps-podinfo"),
+	))
+}
+
+func TestWrapTransport(t *testing.T) {
+	g := NewWithT(t)
+	exporter := registerInMemory(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: WrapTransport(http.DefaultTransport)}
+	do := func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp, err := client.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Body.Close()).To(Succeed())
+	}
+
+	// Requests outside a trace are not traced.
+	do(context.Background())
+	g.Expect(traceparent).To(BeEmpty())
+	g.Expect(exporter.GetSpans()).To(BeEmpty())
+
+	// Requests within a trace propagate the trace context.
+	ctx, span := Start(context.Background(), "reconcile")
+	do(ctx)
+	span.End()
+
+	spans := exporter.GetSpans()
+	g.Expect(spans).To(HaveLen(2))
+	g.Expect(spans[0].Name).To(Equal("HTTP GET"))
+	g.Expect(spans[0].Parent.SpanID()).To(Equal(spans[1].SpanContext.SpanID()))
+	g.Expect(traceparent).To(ContainSubstring(spans[0].SpanContext.TraceID().String()))
+}
@@ -17,12 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"runtime/debug"
 	"time"
 
 	flag "github.com/spf13/pflag"
 	"helm.sh/helm/v3/pkg/kube"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -55,10 +59,18 @@ import (
 	// +kubebuilder:scaffold:imports
 
 	intacl "github.com/fluxcd/helm-controller/internal/acl"
+	intaction "github.com/fluxcd/helm-controller/internal/action"
 	"github.com/fluxcd/helm-controller/internal/controller"
+	"github.com/fluxcd/helm-controller/internal/fairqueue"
 	"github.com/fluxcd/helm-controller/internal/features"
 	intkube "github.com/fluxcd/helm-controller/internal/kube"
+	"github.com/fluxcd/helm-controller/internal/lease"
+	"github.com/fluxcd/helm-controller/internal/loader"
+	intmetrics "github.com/fluxcd/helm-controller/internal/metrics"
 	"github.com/fluxcd/helm-controller/internal/oomwatch"
+	"github.com/fluxcd/helm-controller/internal/postrender"
+	"github.com/fluxcd/helm-controller/internal/shard"
+	"github.com/fluxcd/helm-controller/internal/tracing"
 )
 
 const controllerName = "helm-controller"
@@ -99,7 +111,28 @@ func main() {
 		oomWatchMemoryThreshold   uint8
 		oomWatchMaxMemoryPath     string
 		oomWatchCurrentMemoryPath string
+		oomWatchSoftThreshold     uint8
 		snapshotDigestAlgo        string
+		chartCacheMaxMemorySize   int64
+		chartCachePath            string
+		chartCacheMaxDiskSize     int64
+		maxChartSize              int64
+		releaseLockDuration       time.Duration
+		discoveryCacheMaxAge      time.Duration
+		shardID                   int
+		shardCount                int
+		shardGroup                string
+		shardLeaseDuration        time.Duration
+		fairQueue                 bool
+		fairQueueTenantLabel      string
+		fairQueueDefaultLimits    string
+		fairQueueTenantLimits     []string
+		fairQueueCriticalPriority int32
+		manifestLimits            postrender.ManifestLimits
+		valuesDeltaMaxChanges     int
+		namespaceManifestLimits   []string
+		testLogsOptions           intaction.TestLogsOptions
+		tracingOptions            tracing.Options
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080",
@@ -120,6 +153,8 @@ func main() {
 		"Default service account used for impersonation.")
 	flag.Uint8Var(&oomWatchMemoryThreshold, "oom-watch-memory-threshold", 95,
 		"The memory threshold in percentage at which the OOM watcher will trigger a graceful shutdown. Requires feature gate 'OOMWatch' to be enabled.")
+	flag.Uint8Var(&oomWatchSoftThreshold, "oom-watch-soft-memory-threshold", 0,
+		"The memory threshold in percentage at which the OOM watcher will pause new reconciliations, leave the shard group, clear caches and fail the readiness probe, until the memory usage drops 5 percentage points below it. Must be lower than --oom-watch-memory-threshold. A value of 0 disables it. Requires feature gate 'OOMWatch' to be enabled.")
 	flag.DurationVar(&oomWatchInterval, "oom-watch-interval", 500*time.Millisecond,
 		"The interval at which the OOM watcher will check for memory usage. Requires feature gate 'OOMWatch' to be enabled.")
 	flag.StringVar(&oomWatchMaxMemoryPath, "oom-watch-max-memory-path", "",
@@ -128,6 +163,70 @@ func main() {
 		"The path to the cgroup current memory usage file. Requires feature gate 'OOMWatch' to be enabled. If not set, the path will be automatically detected.")
 	flag.StringVar(&snapshotDigestAlgo, "snapshot-digest-algo", intdigest.Canonical.String(),
 		"The algorithm to use to calculate the digest of Helm release storage snapshots.")
+	flag.IntVar(&valuesDeltaMaxChanges, "snapshot-values-delta-max-changes", 0,
+		"The maximum number of changes of the values recorded in the snapshot of an upgraded release. A value of 0 disables recording the values delta.")
+	flag.Int64Var(&chartCacheMaxMemorySize, "chart-cache-max-memory-size", 0,
+		"The maximum size in bytes of the chart artifacts cached in memory. A value of 0 disables the in-memory cache.")
+	flag.StringVar(&chartCachePath, "chart-cache-path", "",
+		"The directory to persist cached chart artifacts to, e.g. an emptyDir volume. Requires --chart-cache-max-disk-size to be set.")
+	flag.Int64Var(&chartCacheMaxDiskSize, "chart-cache-max-disk-size", 0,
+		"The maximum size in bytes of the chart artifacts persisted to --chart-cache-path. A value of 0 disables persistence.")
+	flag.Int64Var(&maxChartSize, "max-chart-size", 0,
+		"The maximum size in bytes of a chart archive to download. A value of 0 disables the limit.")
+	flag.Int64Var(&manifestLimits.MaxSize, "max-manifest-size", 0,
+		"The maximum size in bytes of the manifest of a Helm release, before and after post-rendering. Hooks are not post-rendered by Helm, and are not included. A value of 0 disables the limit.")
+	flag.IntVar(&manifestLimits.MaxObjects, "max-manifest-objects", 0,
+		"The maximum number of objects in the manifest of a Helm release, before and after post-rendering. Hooks are not post-rendered by Helm, and are not included. A value of 0 disables the limit.")
+	flag.StringSliceVar(&namespaceManifestLimits, "namespace-manifest-limits", nil,
+		"The manifest limits of HelmReleases in specific namespaces, overriding --max-manifest-size and --max-manifest-objects, in the format '<namespace>=<maxSize>[:<maxObjects>]'. Can be specified multiple times.")
+	flag.DurationVar(&releaseLockDuration, "release-lock-lease-duration", lease.DefaultDuration,
+		"The duration of the Lease held for a Helm release while running actions. A value of 0 disables release locking.")
+	flag.DurationVar(&discoveryCacheMaxAge, "discovery-cache-max-age", intkube.DefaultDiscoveryCacheMaxAge,
+		"The maximum age of the discovery clients shared between HelmReleases with a persistent client targeting the same cluster. The clients are invalidated on CRD changes in the cluster the controller runs in, and rediscover the API resources of other clusters when a kind can not be found. A value of 0 disables sharing.")
+	flag.IntVar(&shardID, "shard-id", 0,
+		"The ID of the shard of this replica, in the range [0, --shard-count). Requires --shard-count to be set.")
+	flag.IntVar(&shardCount, "shard-count", 0,
+		"The number of shards to partition HelmReleases between by consistently hashing their UID. A value of 0 disables static sharding.")
+	flag.StringVar(&shardGroup, "shard-group", "",
+		"The name of the shard group to join, to partition HelmReleases dynamically between the replicas in the group. Disables leader election.")
+	flag.DurationVar(&shardLeaseDuration, "shard-lease-duration", shard.DefaultMembershipDuration,
+		"The duration of the Lease held by a replica in the shard group, after which it is considered to have left the group when not renewed.")
+	flag.Int64Var(&testLogsOptions.MaxSize, "test-logs-max-size", 0,
+		"The maximum size in bytes of the logs of a Helm test hook Pod to store, only the tail of larger logs is stored. A value of 0 disables the collection of test logs.")
+	flag.StringVar(&testLogsOptions.Kind, "test-logs-kind", intaction.TestLogsKindSecret,
+		"The kind of object to store the logs of Helm test hook Pods in, either Secret or ConfigMap.")
+	flag.StringVar(&tracingOptions.Exporter, "tracing-exporter", "",
+		"The exporter to export OpenTelemetry traces of reconciliations with, either 'otlp-grpc' or 'otlp-http'. Tracing is disabled when empty.")
+	flag.StringVar(&tracingOptions.Endpoint, "tracing-endpoint", "",
+		"The host:port of the OpenTelemetry collector to export traces to. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable, or localhost.")
+	flag.BoolVar(&tracingOptions.Insecure, "tracing-insecure", false,
+		"Disable TLS for the connection to the OpenTelemetry collector.")
+	flag.Float64Var(&tracingOptions.SampleRatio, "tracing-sample-ratio", 1,
+		"The ratio of reconciliations to trace, between 0 and 1.")
+	flag.BoolVar(&fairQueue, "fair-queue", false,
+		"Enable fair queuing of HelmReleases between tenants, to prevent a tenant from monopolizing the --concurrent workers.")
+	flag.StringVar(&fairQueueTenantLabel, "fair-queue-tenant-label", "",
+		"The label of which the value is the tenant of a HelmRelease for fair queuing. Defaults to the namespace of the HelmRelease.")
+	flag.StringVar(&fairQueueDefaultLimits, "fair-queue-default-limits", "0:1",
+		"The limits of tenants for fair queuing, in the format '<maxConcurrency>[:<weight>]'. A maximum concurrency of 0 means no limit.")
+	flag.StringSliceVar(&fairQueueTenantLimits, "fair-queue-tenant-limits", nil,
+		"The limits of specific tenants for fair queuing, in the format '<tenant>=<maxConcurrency>[:<weight>]'.")
+	flag.Int32Var(&fairQueueCriticalPriority, "fair-queue-critical-priority", 0,
+		"The spec.priority from which HelmReleases are reconciled before the HelmReleases of all tenants with fair queuing. When 0, priorities are only compared between the HelmReleases of a tenant.")
+	flag.StringSliceVar(&intacl.AllowedImpersonationUsers, "allowed-impersonation-users", nil,
+		"The patterns of users a HelmRelease is allowed to impersonate using spec.impersonate. '{namespace}' is replaced with the namespace of the HelmRelease.")
+	flag.StringSliceVar(&intacl.AllowedImpersonationGroups, "allowed-impersonation-groups", nil,
+		"The patterns of groups a HelmRelease is allowed to impersonate using spec.impersonate. '{namespace}' is replaced with the namespace of the HelmRelease.")
+	flag.StringSliceVar(&intacl.AllowedImpersonationExtraKeys, "allowed-impersonation-extra-keys", nil,
+		"The patterns of extra fields a HelmRelease is allowed to impersonate using spec.impersonate, in the format '<keyPattern>[=<valuePattern>]'. Without a value pattern, any value is allowed for the matching keys. '{namespace}' is replaced with the namespace of the HelmRelease.")
+	flag.StringSliceVar(&intacl.AllowedKubeConfigProviders, "allowed-kubeconfig-providers", nil,
+		"The KubeConfig providers a HelmRelease is allowed to use with spec.kubeConfigProvider to obtain credentials of the controller, one of 'aws', 'azure', 'gcp', 'exec' or 'serviceaccount'. Providers are disabled by default.")
+	flag.StringSliceVar(&intacl.AllowedKubeConfigAddresses, "allowed-kubeconfig-addresses", nil,
+		"The patterns of API server addresses a HelmRelease is allowed to obtain KubeConfig provider credentials for. '{namespace}' is replaced with the namespace of the HelmRelease.")
+	flag.StringSliceVar(&intacl.AllowedKubeConfigClusters, "allowed-kubeconfig-clusters", nil,
+		"The patterns of provider specific cluster identifiers a HelmRelease is allowed to obtain KubeConfig provider credentials for. '{namespace}' is replaced with the namespace of the HelmRelease.")
+	flag.StringSliceVar(&intacl.AllowedKubeConfigAudiences, "allowed-kubeconfig-audiences", nil,
+		"The patterns of audiences a HelmRelease is allowed to request ServiceAccount tokens for with the 'serviceaccount' KubeConfig provider. '{namespace}' is replaced with the namespace of the HelmRelease.")
 
 	clientOptions.BindFlags(flag.CommandLine)
 	logOptions.BindFlags(flag.CommandLine)
@@ -175,11 +274,33 @@ func main() {
 	if !shouldCache {
 		disableCacheFor = append(disableCacheFor, &corev1.Secret{}, &corev1.ConfigMap{})
 	}
+	// Release locks must always be read from the API server.
+	disableCacheFor = append(disableCacheFor, &coordinationv1.Lease{})
+
+	var staticSharder *shard.Static
+	switch {
+	case shardCount > 0 && shardGroup != "":
+		setupLog.Error(errors.New("--shard-count and --shard-group are mutually exclusive"), "unable to configure sharding")
+		os.Exit(1)
+	case shardCount > 0:
+		if staticSharder, err = shard.NewStatic(shardID, shardCount); err != nil {
+			setupLog.Error(err, "unable to configure sharding")
+			os.Exit(1)
+		}
+	case shardGroup != "":
+		if leaderElectionOptions.Enable {
+			setupLog.Info("disabling leader election, as replicas of a shard group run concurrently")
+			leaderElectionOptions.Enable = false
+		}
+	}
 
 	leaderElectionId := fmt.Sprintf("%s-%s", controllerName, "leader-election")
 	if watchOptions.LabelSelector != "" {
 		leaderElectionId = leaderelection.GenerateID(leaderElectionId, watchOptions.LabelSelector)
 	}
+	if staticSharder != nil {
+		leaderElectionId = fmt.Sprintf("%s-shard-%s", leaderElectionId, staticSharder.Name())
+	}
 
 	// Set the managedFields owner for resources reconciled from Helm charts.
 	kube.ManagedFieldsManager = controllerName
@@ -197,7 +318,24 @@ func main() {
 		intdigest.Canonical = algo
 	}
 
+	chartCache, err := loader.NewChartCache(
+		loader.WithMaxMemorySize(chartCacheMaxMemorySize),
+		loader.WithPersistence(chartCachePath, chartCacheMaxDiskSize),
+	)
+	if err != nil {
+		setupLog.Error(err, "unable to configure chart cache")
+		os.Exit(1)
+	}
+
 	restConfig := client.GetConfigOrDie(clientOptions)
+	if tracingOptions.Enabled() {
+		restConfig.Wrap(tracing.WrapTransport)
+	}
+
+	// Never request ServiceAccount tokens for the controller itself.
+	if serviceAccount, ok := intkube.ServiceAccountFromConfig(restConfig); ok {
+		intacl.ControllerServiceAccount = serviceAccount
+	}
 
 	mgrConfig := ctrl.Options{
 		Scheme:                        scheme,
@@ -252,9 +390,24 @@ func main() {
 	}
 
 	ctx := ctrl.SetupSignalHandler()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracingOptions, controllerName)
+	if err != nil {
+		setupLog.Error(err, "unable to configure tracing")
+		os.Exit(1)
+	}
+	flushTraces := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			setupLog.Error(err, "failed to flush traces")
+		}
+	}
+	defer flushTraces()
+	var ow *oomwatch.Watcher
 	if ok, _ := features.Enabled(features.OOMWatch); ok {
 		setupLog.Info("setting up OOM watcher")
-		ow, err := oomwatch.New(
+		ow, err = oomwatch.New(
 			oomWatchMaxMemoryPath,
 			oomWatchCurrentMemoryPath,
 			oomWatchMemoryThreshold,
@@ -265,10 +418,94 @@ func main() {
 			setupLog.Error(err, "unable to setup OOM watcher")
 			os.Exit(1)
 		}
+		if err = ow.SetSoftThreshold(oomWatchSoftThreshold); err != nil {
+			setupLog.Error(err, "unable to setup OOM watcher")
+			os.Exit(1)
+		}
+		if err = mgr.AddReadyzCheck("oom-watch", ow.ReadyzCheck); err != nil {
+			setupLog.Error(err, "unable to setup OOM watcher readiness check")
+			os.Exit(1)
+		}
 		ctx = ow.Watch(ctx)
 	}
 
-	if err = (&controller.HelmReleaseReconciler{
+	var releaseLocker *lease.Locker
+	if releaseLockDuration > 0 {
+		identity, err := os.Hostname()
+		if err != nil {
+			setupLog.Error(err, "unable to determine release lock identity")
+			os.Exit(1)
+		}
+		// The Leases of all releases are held in the namespace of the
+		// controller, which is known to exist on the cluster the controller
+		// runs in, and not in the namespace of the HelmRelease. Without it,
+		// the Leases are held in the storage namespace of the release.
+		releaseLocker = lease.NewLocker(mgr.GetClient(), os.Getenv("RUNTIME_NAMESPACE"), identity, releaseLockDuration)
+	}
+
+	var (
+		sharder    shard.Sharder
+		membership *shard.Membership
+	)
+	if staticSharder != nil {
+		sharder = staticSharder
+	}
+	if shardGroup != "" {
+		namespace := os.Getenv("RUNTIME_NAMESPACE")
+		if namespace == "" {
+			setupLog.Error(errors.New("RUNTIME_NAMESPACE is not set"), "unable to configure shard group membership")
+			os.Exit(1)
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			setupLog.Error(err, "unable to determine shard group identity")
+			os.Exit(1)
+		}
+		membership = shard.NewMembership(mgr.GetClient(), namespace, shardGroup, identity, shardLeaseDuration)
+		if err = mgr.Add(membership); err != nil {
+			setupLog.Error(err, "unable to configure shard group membership")
+			os.Exit(1)
+		}
+		sharder = membership
+	}
+
+	var fairQueueOptions *controller.FairQueueOptions
+	if fairQueue {
+		defaultLimits, err := fairqueue.ParseLimits(fairQueueDefaultLimits)
+		if err != nil {
+			setupLog.Error(err, "unable to configure fair queue default limits")
+			os.Exit(1)
+		}
+		tenantLimits, err := fairqueue.ParseTenantLimits(fairQueueTenantLimits)
+		if err != nil {
+			setupLog.Error(err, "unable to configure fair queue tenant limits")
+			os.Exit(1)
+		}
+		fairQueueOptions = &controller.FairQueueOptions{
+			TenantLabel:      fairQueueTenantLabel,
+			DefaultLimits:    defaultLimits,
+			TenantLimits:     tenantLimits,
+			CriticalPriority: fairQueueCriticalPriority,
+		}
+	}
+
+	if err := testLogsOptions.Validate(); err != nil {
+		setupLog.Error(err, "unable to configure test logs")
+		os.Exit(1)
+	}
+
+	namespaceLimits, err := postrender.ParseNamespaceManifestLimits(namespaceManifestLimits)
+	if err != nil {
+		setupLog.Error(err, "unable to configure manifest limits")
+		os.Exit(1)
+	}
+
+	var discoveryCache *intkube.DiscoveryCache
+	if discoveryCacheMaxAge > 0 {
+		discoveryCache = intkube.NewDiscoveryCache(discoveryCacheMaxAge)
+	}
+
+	reconciler := &controller.HelmReleaseReconciler{
 		Client:           mgr.GetClient(),
 		EventRecorder:    eventRecorder,
 		Metrics:          metricsH,
@@ -276,19 +513,64 @@ func main() {
 		ClientOpts:       clientOptions,
 		KubeConfigOpts:   kubeConfigOpts,
 		FieldManager:     controllerName,
-	}).SetupWithManager(ctx, mgr, controller.HelmReleaseReconcilerOptions{
+	}
+	if err = reconciler.SetupWithManager(ctx, mgr, controller.HelmReleaseReconcilerOptions{
 		DependencyRequeueInterval: requeueDependency,
 		HTTPRetry:                 httpRetry,
 		RateLimiter:               helper.GetRateLimiter(rateLimiterOptions),
+		ChartCache:                chartCache,
+		MaxChartSize:              maxChartSize,
+		ReleaseLocker:             releaseLocker,
+		DiscoveryCache:            discoveryCache,
+		TokenSourceCache:          intkube.NewTokenSourceCache(intkube.DefaultTokenSourceCacheMaxIdle),
+		EventRecorderCache:        intkube.NewEventRecorderCache(intkube.DefaultEventRecorderCacheMaxIdle),
+		Sharder:                   sharder,
+		FairQueue:                 fairQueueOptions,
+		TestLogs:                  testLogsOptions,
+		ManifestLimits: controller.ManifestLimitsOptions{
+			Default:    manifestLimits,
+			Namespaces: namespaceLimits,
+		},
+		MaxValuesDeltaChanges: valuesDeltaMaxChanges,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", v2.HelmReleaseKind)
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	if ow != nil {
+		ow.OnTierChange(func(tier oomwatch.Tier) {
+			intmetrics.SetMemoryPressureTier(int(tier))
+			switch tier {
+			case oomwatch.TierSoft:
+				// Stop picking up new reconciliations, and release the memory
+				// held by caches which can be repopulated.
+				setupLog.Info("memory pressure: pausing reconciliations and clearing caches")
+				reconciler.PauseReconciles(true)
+				// Leave the shard group, so the other replicas take over.
+				if membership != nil {
+					membership.Suspend(true)
+				}
+				chartCache.Clear()
+				if discoveryCache != nil {
+					discoveryCache.Clear()
+				}
+				debug.FreeOSMemory()
+			case oomwatch.TierNormal:
+				setupLog.Info("memory pressure relieved: resuming reconciliations")
+				if membership != nil {
+					membership.Suspend(false)
+				}
+				reconciler.PauseReconciles(false)
+			}
+		})
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
+		// os.Exit does not run deferred functions.
+		flushTraces()
 		os.Exit(1)
 	}
 }